@@ -2,16 +2,95 @@ package main
 
 import (
 	"flag"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/example/nfs-shared-csi/pkg/nfs"
+	"google.golang.org/grpc/keepalive"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 )
 
 var (
-	endpoint   = flag.String("endpoint", "unix:///csi/csi.sock", "CSI endpoint")
-	nodeID     = flag.String("nodeid", "", "Node ID")
-	driverName = flag.String("drivername", nfs.DefaultDriverName, "CSI driver name")
+	endpoint          = flag.String("endpoint", "unix:///csi/csi.sock", "CSI endpoint: unix:///path/to/socket or tcp://host:port")
+	nodeID            = flag.String("nodeid", "", "Node ID")
+	driverName        = flag.String("drivername", nfs.DefaultDriverName, "CSI driver name")
+	annotationPrefix  = flag.String("annotation-prefix", "", "Prefix of the PVC annotation keys read by the driver, such as <prefix>/subPath (empty uses --drivername; keys under the driver name are still accepted)")
+	logLevels         = flag.String("log-levels", "", "Comma-separated subsystem=level pairs overriding -v for the identity, controller and node services, e.g. node=5,controller=2")
+	mode              = flag.String("mode", nfs.ModeAll, "CSI services to serve: all, node or controller")
+	targetPathMode    = flag.String("target-path-mode", "0750", "Octal permissions of the target directories created for pods, e.g. 0700")
+	socketMode        = flag.String("socket-mode", "", "Octal permissions for a unix socket endpoint, e.g. 0660 (empty keeps the default; 0000 is rejected)")
+	registrationPath  = flag.String("registration-path", "", "Node-driver-registrar socket that must exist before the node service reports ready, e.g. /registration/<drivername>-reg.sock (empty skips the check)")
+	maxVolumesPerNode = flag.Int64("max-volumes-per-node", 0, "Maximum number of volumes the scheduler may place on a node (0 is unlimited)")
+
+	mounterKind        = flag.String("mounter", nfs.MounterDefault, "How the node plugin mounts: default (mount(8) in the driver container) or proxy (requests to a host mount proxy over --mounter-proxy-socket)")
+	mounterProxySocket = flag.String("mounter-proxy-socket", "", "Unix socket of the host mount proxy (with --mounter=proxy)")
+
+	shutdownTimeout = flag.Duration("shutdown-timeout", 30*time.Second, "Time to wait for in-flight RPCs on SIGTERM/SIGINT before forcing shutdown")
+
+	keepaliveTime        = flag.Duration("grpc-keepalive-time", 0, "Ping a client after this long without activity on its connection (0 keeps the gRPC default of 2h)")
+	keepaliveTimeout     = flag.Duration("grpc-keepalive-timeout", 0, "Close a connection whose keepalive ping is not answered within this time (0 keeps the gRPC default of 20s)")
+	keepaliveMaxIdle     = flag.Duration("grpc-keepalive-max-connection-idle", 0, "Close a connection without RPCs for this long (0 never closes idle connections)")
+	keepaliveMinPingTime = flag.Duration("grpc-keepalive-min-time", 0, "Shortest interval between client pings the server accepts (0 keeps the gRPC default of 5m)")
+
+	allowedMountOptions = flag.String("allowed-mount-options", "", "Comma-separated mount option names users may set (empty allows all)")
+	allowedParameters   = flag.String("allowed-parameters", "", "Comma-separated StorageClass parameter keys CreateVolume accepts (empty allows all; csi.storage.k8s.io/ keys are always allowed)")
+	deniedMountOptions  = flag.String("denied-mount-options", "", "Comma-separated mount option names users may not set")
+
+	mountProfilesConfigMap = flag.String("mount-profiles-configmap", "", "ConfigMap (namespace/name) mapping mountProfile names to comma-separated mount options, read once at startup")
+	namespaceServerMap     = flag.String("namespace-server-map", "", "ConfigMap (namespace/name) mapping namespaces to the comma-separated NFS servers their volumes may use, read once at startup; \"*\" applies to namespaces not listed")
+
+	enableVolumeExpansion = flag.Bool("enable-volume-expansion", false, "Advertise online volume expansion so the external-resizer resizes PVCs")
+
+	ephemeralProvisioning = flag.Bool("ephemeral-provisioning", false, "Track created volumes in memory so ListVolumes works and CreateVolume is idempotent per name (for conformance tests only; the state is lost on restart)")
+
+	enableVolumeCondition = flag.Bool("enable-volume-condition", false, "Report in ListVolumes whether a server of each volume accepts a TCP connection on the NFS port (with --ephemeral-provisioning)")
+	volumeConditionTTL    = flag.Duration("volume-condition-cache-ttl", time.Minute, "How long the reachability of a server list is cached for volume conditions")
+	volumeConditionWait   = flag.Duration("volume-condition-timeout", 5*time.Second, "How long a volume condition check waits for each server to accept the connection")
+
+	volumeIDFormat = flag.String("volume-id-format", nfs.VolumeIDFormatName, "How CreateVolume builds volume IDs: name (the PV name) or structured (server#share#subPath#name)")
+
+	resolveServer   = flag.Bool("resolve-server", false, "Fail CreateVolume when the server name does not resolve in DNS")
+	forceServer     = flag.String("force-server", "", "NFS server every volume is mounted from, overriding the server in StorageClasses and PVs (empty uses the requested server)")
+	precheckServer  = flag.Bool("precheck-server", false, "Fail CreateVolume with Unavailable, so the provisioner retries, while no server of the volume accepts a TCP connection on the NFS port")
+	precheckTimeout = flag.Duration("precheck-timeout", 5*time.Second, "How long --precheck-server waits for each server to accept the connection")
+
+	enableCapacity   = flag.Bool("enable-capacity", false, "Implement GetCapacity by mounting the share and reporting its free space")
+	capacityCacheTTL = flag.Duration("capacity-cache-ttl", 30*time.Second, "How long GetCapacity results are cached per share")
+
+	enableSnapshots = flag.Bool("enable-snapshots", false, "Implement CreateSnapshot, DeleteSnapshot and ListSnapshots by copying the volume directory to .snapshots/<name> on its share (slow and not atomic; needs --volume-id-format=structured)")
+
+	healthAddress = flag.String("health-address", "", "Address to serve HTTP /healthz and /readyz on, e.g. :9808 (empty disables)")
+	debugAddress  = flag.String("debug-address", "", "Address to serve a JSON dump of the driver's volumes, mounts and configuration on, at /debug/state, e.g. :9809; a port alone listens on localhost only (empty disables)")
+
+	enableVolumeStats = flag.Bool("enable-volume-stats", false, "Implement NodeGetVolumeStats, reporting the capacity requested for the volume as its size")
+	volumeStatsDU     = flag.Bool("volume-stats-du", false, "Compute the used bytes of each volume by walking it, like du (expensive on large volumes; with --enable-volume-stats)")
+
+	enableEvents = flag.Bool("enable-events", false, "Record Kubernetes events on mount failures (requires in-cluster config)")
+
+	enableRemount   = flag.Bool("enable-remount", false, "Periodically remount published volumes whose mount went stale (ESTALE)")
+	remountInterval = flag.Duration("remount-interval", time.Minute, "How often to check published volumes for stale mounts (with --enable-remount)")
+	remountStale    = flag.Bool("remount-stale-on-publish", true, "Unmount and mount again a target NodePublishVolume finds already mounted but stale (ESTALE), e.g. after a node crash")
+
+	unmountRetries = flag.Int("unmount-retries", 0, "How often NodeUnpublishVolume retries a failed unmount before returning an error")
+	unmountBackoff = flag.Duration("unmount-backoff", 500*time.Millisecond, "Wait before the first unmount retry, doubled for each further retry (with --unmount-retries)")
+
+	mountRate  = flag.Float64("mount-rate", 0, "NFS mounts per second the node plugin starts at most, e.g. 5 (0 is unlimited)")
+	mountBurst = flag.Int("mount-burst", 10, "Mounts allowed at once before --mount-rate applies")
+
+	keepTargetOnUnpublish = flag.Bool("keep-target-on-unpublish", false, "Unmount but do not remove the target directory in NodeUnpublishVolume")
+
+	enableStaging  = flag.Bool("enable-staging", false, "Mount each share once per node in NodeStageVolume and bind-mount subPaths into pods")
+	sharedMountDir = flag.String("shared-mount-dir", "", "Directory for node-wide NFS mounts shared by staged volumes (default <kubelet-dir>/plugins/<drivername>/shared)")
+	kubeletDir     = flag.String("kubelet-dir", nfs.DefaultKubeletDir, "Kubelet root directory, where staging paths and pod targets are found after a restart")
 )
 
 func main() {
@@ -28,12 +107,219 @@ func main() {
 
 	klog.Infof("Starting NFS CSI driver: %s, nodeID: %s, endpoint: %s", *driverName, *nodeID, *endpoint)
 
-	driver, err := nfs.NewDriver(*driverName, *nodeID, *endpoint)
+	opts := []nfs.DriverOption{
+		nfs.WithMode(*mode),
+		nfs.WithAllowedMountOptions(splitList(*allowedMountOptions)),
+		nfs.WithDeniedMountOptions(splitList(*deniedMountOptions)),
+		nfs.WithAllowedParameters(splitList(*allowedParameters)),
+		nfs.WithStaging(*enableStaging),
+		nfs.WithResolveServer(*resolveServer),
+		nfs.WithForceServer(*forceServer),
+		nfs.WithRegistrationPath(*registrationPath),
+		nfs.WithMaxVolumesPerNode(*maxVolumesPerNode),
+		nfs.WithUnmountRetry(*unmountRetries, *unmountBackoff),
+		nfs.WithKeepTargetOnUnpublish(*keepTargetOnUnpublish),
+		nfs.WithMountRateLimit(*mountRate, *mountBurst),
+		nfs.WithVolumeExpansion(*enableVolumeExpansion),
+		nfs.WithVolumeIDFormat(*volumeIDFormat),
+		nfs.WithRemountStaleOnPublish(*remountStale),
+	}
+
+	if *ephemeralProvisioning {
+		klog.Warning("Ephemeral provisioning is enabled: volumes are tracked in memory only, do not use this in production")
+		opts = append(opts, nfs.WithEphemeralProvisioning(true))
+	}
+
+	if *enableVolumeCondition {
+		if !*ephemeralProvisioning {
+			klog.Warning("Volume conditions are enabled, but only volumes tracked with --ephemeral-provisioning are listed")
+		}
+		opts = append(opts, nfs.WithVolumeCondition(*volumeConditionTTL, *volumeConditionWait))
+	}
+
+	if *enableCapacity {
+		opts = append(opts, nfs.WithCapacity(*capacityCacheTTL))
+	}
+
+	if *enableSnapshots {
+		if *volumeIDFormat != nfs.VolumeIDFormatStructured {
+			klog.Warningf("Snapshots are enabled, but only volumes with structured IDs (--volume-id-format=%s) can be snapshotted", nfs.VolumeIDFormatStructured)
+		}
+		opts = append(opts, nfs.WithSnapshots(true))
+	}
+
+	if *mountProfilesConfigMap != "" && *mode != nfs.ModeNode {
+		profiles, err := loadConfigMap(*mountProfilesConfigMap)
+		if err != nil {
+			klog.Fatalf("Failed to load mount profiles: %v", err)
+		}
+		klog.Infof("Loaded %d mount profile(s) from %s", len(profiles), *mountProfilesConfigMap)
+		opts = append(opts, nfs.WithMountProfiles(profiles))
+	}
+
+	// The node plugin checks inline volumes, so this is loaded in every mode
+	if *namespaceServerMap != "" {
+		servers, err := loadConfigMap(*namespaceServerMap)
+		if err != nil {
+			klog.Fatalf("Failed to load the namespace server map: %v", err)
+		}
+		klog.Infof("Restricting NFS servers for %d namespace(s) from %s", len(servers), *namespaceServerMap)
+		opts = append(opts, nfs.WithNamespaceServers(servers))
+	}
+
+	if *enableVolumeStats {
+		opts = append(opts, nfs.WithVolumeStats(*volumeStatsDU))
+	}
+
+	if *enableRemount {
+		opts = append(opts, nfs.WithRemountInterval(*remountInterval))
+	}
+
+	if *enableStaging {
+		dir := *sharedMountDir
+		if dir == "" {
+			dir = filepath.Join(*kubeletDir, "plugins", *driverName, "shared")
+		}
+		opts = append(opts, nfs.WithSharedMountDir(dir), nfs.WithKubeletDir(*kubeletDir))
+	}
+
+	mounter, err := nfs.NewMounter(*mounterKind, *mounterProxySocket)
+	if err != nil {
+		klog.Fatalf("Invalid --mounter: %v", err)
+	}
+	if *mounterKind == nfs.MounterProxy {
+		klog.Infof("Mounting through the mount proxy at %s", *mounterProxySocket)
+	}
+	opts = append(opts, nfs.WithMounter(mounter))
+
+	if *logLevels != "" {
+		levels, err := nfs.ParseLogLevels(*logLevels)
+		if err != nil {
+			klog.Fatalf("Invalid --log-levels: %v", err)
+		}
+		opts = append(opts, nfs.WithLogLevels(levels))
+	}
+
+	if *annotationPrefix != "" {
+		opts = append(opts, nfs.WithAnnotationPrefix(*annotationPrefix))
+	}
+
+	mode, err := nfs.ParseFileMode(*targetPathMode)
+	if err != nil {
+		klog.Fatalf("Invalid --target-path-mode: %v", err)
+	}
+	opts = append(opts, nfs.WithTargetPathMode(mode))
+
+	if *socketMode != "" {
+		mode, err := nfs.ParseFileMode(*socketMode)
+		if err != nil {
+			klog.Fatalf("Invalid --socket-mode: %v", err)
+		}
+		opts = append(opts, nfs.WithSocketMode(mode))
+	}
+
+	if *precheckServer {
+		opts = append(opts, nfs.WithPrecheckServer(*precheckTimeout))
+	}
+
+	opts = append(opts, nfs.WithKeepalive(
+		keepalive.ServerParameters{MaxConnectionIdle: *keepaliveMaxIdle, Time: *keepaliveTime, Timeout: *keepaliveTimeout},
+		keepalive.EnforcementPolicy{MinTime: *keepaliveMinPingTime},
+	))
+
+	if *enableEvents {
+		if recorder, err := newEventRecorder(*driverName, *nodeID); err != nil {
+			klog.Warningf("Events disabled: %v", err)
+		} else {
+			opts = append(opts, nfs.WithEventRecorder(recorder))
+		}
+	}
+
+	driver, err := nfs.NewDriver(*driverName, *nodeID, *endpoint, opts...)
 	if err != nil {
 		klog.Fatalf("Failed to create driver: %v", err)
 	}
 
+	if *testMount {
+		code := runTestMount(driver)
+		klog.Flush()
+		os.Exit(code)
+	}
+
+	if *healthAddress != "" {
+		go func() {
+			klog.Infof("Serving health checks on %s", *healthAddress)
+			if err := http.ListenAndServe(*healthAddress, driver.HealthHandler()); err != nil {
+				klog.Fatalf("Failed to serve health checks: %v", err)
+			}
+		}()
+	}
+
+	if *debugAddress != "" {
+		addr := localAddress(*debugAddress)
+		go func() {
+			klog.Infof("Serving debug state on %s", addr)
+			if err := http.ListenAndServe(addr, driver.DebugHandler()); err != nil {
+				klog.Fatalf("Failed to serve debug state: %v", err)
+			}
+		}()
+	}
+
+	// Log the volume counts on SIGUSR1, for a quick look without --health-address
+	usr1Ch := make(chan os.Signal, 1)
+	signal.Notify(usr1Ch, syscall.SIGUSR1)
+	go func() {
+		for range usr1Ch {
+			counts := driver.VolumeCounts()
+			klog.Infof("Volume counts: created=%d deleted=%d provisioned=%d", counts.Created, counts.Deleted, counts.Provisioned)
+		}
+	}()
+
+	// Drain in-flight RPCs on termination so mounts are not cut off mid-way
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-sigCh
+		klog.Infof("Received %v, shutting down (timeout %v)", sig, *shutdownTimeout)
+		driver.Shutdown(*shutdownTimeout)
+	}()
+
 	if err := driver.Run(); err != nil {
 		klog.Fatalf("Failed to run driver: %v", err)
 	}
+	klog.Infof("Driver stopped")
+}
+
+// newEventRecorder builds an event recorder from the in-cluster config
+func newEventRecorder(driverName, nodeID string) (record.EventRecorder, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return nfs.NewEventRecorder(clientset, driverName, nodeID), nil
+}
+
+// localAddress makes a port-only address such as :9809 listen on localhost
+// instead of every interface
+func localAddress(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host != "" {
+		return addr
+	}
+	return net.JoinHostPort("127.0.0.1", port)
+}
+
+// splitList parses a comma-separated flag value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/example/nfs-shared-csi/pkg/nfs"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	testMount        = flag.Bool("test-mount", false, "Mount the export given by the --test-* flags into a temporary directory, unmount it and exit instead of serving CSI")
+	testServer       = flag.String("test-server", "", "NFS server (or comma-separated servers) for --test-mount")
+	testShare        = flag.String("test-share", "", "NFS share for --test-mount")
+	testSubPath      = flag.String("test-subpath", "", "subPath under the share for --test-mount")
+	testMountOptions = flag.String("test-mount-options", "", "Comma-separated mount options for --test-mount, as in a StorageClass mountOptions")
+)
+
+// runTestMount mounts and unmounts the export given on the command line with
+// the same logic as NodePublishVolume and returns the process exit code
+func runTestMount(driver *nfs.Driver) int {
+	volumeContext := map[string]string{
+		nfs.ParamServer: *testServer,
+		nfs.ParamShare:  *testShare,
+	}
+	if *testSubPath != "" {
+		volumeContext[nfs.ParamSubPath] = *testSubPath
+	}
+	source := fmt.Sprintf("%s:%s", *testServer, *testShare)
+
+	if err := driver.TestMount(context.Background(), volumeContext, splitList(*testMountOptions)); err != nil {
+		st := status.Convert(err)
+		fmt.Fprintf(os.Stderr, "Test mount of %s failed (%s): %s\n", source, st.Code(), st.Message())
+		return 1
+	}
+
+	fmt.Printf("Test mount of %s succeeded\n", source)
+	return 0
+}
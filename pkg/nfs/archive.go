@@ -0,0 +1,172 @@
+package nfs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// ParamOnDelete selects what DeleteVolume does with the volume's
+	// directory: OnDeleteRetain leaves it, OnDeleteArchive renames it
+	ParamOnDelete = "onDelete"
+
+	// onDelete modes
+	OnDeleteRetain  = "retain"
+	OnDeleteArchive = "archive"
+
+	// pvcNameKey carries the name of the PVC (external-provisioner --extra-create-metadata)
+	pvcNameKey = "csi.storage.k8s.io/pvc/name"
+
+	// archivePrefix starts the name of an archived volume directory
+	archivePrefix = "archived-"
+
+	// archiveTimeFormat is the UTC timestamp in the name of an archive
+	archiveTimeFormat = "20060102-150405"
+
+	// maxArchiveSuffix bounds the numbered names tried when an archive
+	// name is taken
+	maxArchiveSuffix = 100
+)
+
+// archiveNow returns the time an archive is named after. It is replaced in tests.
+var archiveNow = time.Now
+
+// archiveMountParameters change how a share is mounted in ways the default
+// mount options of the controller cannot make up for
+var archiveMountParameters = []string{ParamPort, ParamSecurity, ParamTransport}
+
+// getOnDelete returns the onDelete mode, OnDeleteRetain when unset
+func getOnDelete(params map[string]string) (string, error) {
+	switch value := params[ParamOnDelete]; value {
+	case "":
+		return OnDeleteRetain, nil
+	case OnDeleteRetain, OnDeleteArchive:
+		return value, nil
+	default:
+		return "", fmt.Errorf("invalid %s %q: must be %s or %s", ParamOnDelete, value, OnDeleteRetain, OnDeleteArchive)
+	}
+}
+
+// checkArchive checks that a volume with parameters can be archived by
+// DeleteVolume: its directory must be recorded in a structured ID, be its
+// own and be reachable with the default mount options
+func (d *Driver) checkArchive(params map[string]string, subPath string, dedupe bool) error {
+	if d.volumeIDFormat != VolumeIDFormatStructured {
+		return fmt.Errorf("%s %q needs --volume-id-format=%s, since the ID is the only record of the volume's directory", ParamOnDelete, OnDeleteArchive, VolumeIDFormatStructured)
+	}
+	if params[ParamShares] != "" {
+		return fmt.Errorf("%s %q cannot be used with %s", ParamOnDelete, OnDeleteArchive, ParamShares)
+	}
+	if dedupe {
+		return fmt.Errorf("%s %q cannot be used with %s: the directory is shared by other volumes", ParamOnDelete, OnDeleteArchive, ParamDedupeByPath)
+	}
+	if err := checkArchivePath(subPath); err != nil {
+		return err
+	}
+	for _, key := range archiveMountParameters {
+		if params[key] != "" {
+			return fmt.Errorf("%s %q cannot be used with %s: the controller mounts the share with the default mount options to archive it", ParamOnDelete, OnDeleteArchive, key)
+		}
+	}
+	return nil
+}
+
+// checkArchivePath checks that subPath names a directory below the root of
+// the share that may be archived
+func checkArchivePath(subPath string) error {
+	if err := validateSubPath(subPath); err != nil {
+		return err
+	}
+	cleaned := strings.Trim(filepath.Clean("/"+subPath), "/")
+	if cleaned == "" {
+		return fmt.Errorf("%s %q needs a subPath: the root of the share is not archived", ParamOnDelete, OnDeleteArchive)
+	}
+	if cleaned == snapshotsDir || strings.HasPrefix(cleaned, snapshotsDir+"/") {
+		return fmt.Errorf("subPath %q is inside %s and is not archived", subPath, snapshotsDir)
+	}
+	return nil
+}
+
+// checkArchiveLabel checks that the PVC name can be part of the name of an
+// archive directory
+func checkArchiveLabel(name string) error {
+	if err := checkPathCharacters("PVC name", name); err != nil {
+		return err
+	}
+	if name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+		return fmt.Errorf("invalid PVC name %q: must be a single path component", name)
+	}
+	return nil
+}
+
+// archiveName returns the name of the archive of the PVC's directory made at now
+func archiveName(pvcName string, now time.Time) string {
+	return archivePrefix + pvcName + "-" + now.UTC().Format(archiveTimeFormat)
+}
+
+// uniqueArchivePath returns dir/name, or the first of dir/name-2, dir/name-3
+// and so on that does not exist yet
+func (d *Driver) uniqueArchivePath(dir, name string) (string, error) {
+	for i := 1; i <= maxArchiveSuffix; i++ {
+		candidate := name
+		if i > 1 {
+			candidate = fmt.Sprintf("%s-%d", name, i)
+		}
+		path := filepath.Join(dir, candidate)
+		if _, err := d.filesystem.Stat(path); os.IsNotExist(err) {
+			return path, nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("%s and %d numbered names after it already exist in %s", name, maxArchiveSuffix-1, dir)
+}
+
+// archiveVolume renames the directory of the volume at parts to
+// archived-<pvc>-<timestamp> next to it on the share. A directory that no
+// longer exists was archived by an earlier request, or never created.
+func (d *Driver) archiveVolume(ctx context.Context, volumeID string, parts volumeIDParts) error {
+	if err := checkArchivePath(parts.subPath); err != nil {
+		return status.Errorf(codes.InvalidArgument, "cannot archive volume %s: %v", volumeID, err)
+	}
+	if err := checkArchiveLabel(parts.pvcName); err != nil {
+		return status.Errorf(codes.InvalidArgument, "cannot archive volume %s: %v", volumeID, err)
+	}
+	if strings.Contains(parts.share, ",") {
+		return status.Errorf(codes.InvalidArgument, "cannot archive volume %s: it has several shares", volumeID)
+	}
+
+	source := shareSource(parts)
+	root, unmount, err := mountShare(ctx, d, source, defaultMountOptions)
+	if err != nil {
+		return defaultMountFailedError(ctx, err, source)
+	}
+	defer unmount()
+
+	volumeDir := filepath.Join(root, parts.subPath)
+	if _, err := d.filesystem.Stat(volumeDir); err != nil {
+		if os.IsNotExist(err) {
+			d.logV(ctx, subsystemController, 2).Infof("DeleteVolume: %s does not exist on %s, nothing to archive", parts.subPath, source)
+			return nil
+		}
+		return status.Errorf(codes.Internal, "failed to stat %s: %v", volumeDir, err)
+	}
+
+	archiveDir, err := d.uniqueArchivePath(filepath.Dir(volumeDir), archiveName(parts.pvcName, archiveNow()))
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to name the archive of volume %s: %v", volumeID, err)
+	}
+	if err := d.filesystem.Rename(volumeDir, archiveDir); err != nil {
+		return status.Errorf(codes.Internal, "failed to archive volume %s: %v", volumeID, err)
+	}
+	rel, _ := filepath.Rel(root, archiveDir)
+	d.logV(ctx, subsystemController, 2).Infof("DeleteVolume: archived %s on %s as %s", parts.subPath, source, rel)
+	return nil
+}
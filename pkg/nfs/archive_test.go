@@ -0,0 +1,176 @@
+package nfs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// stubArchiveNow fixes the time archives are named after
+func stubArchiveNow(t *testing.T, now time.Time) {
+	t.Helper()
+	orig := archiveNow
+	archiveNow = func() time.Time { return now }
+	t.Cleanup(func() { archiveNow = orig })
+}
+
+func archiveRequest(subPath string) *csi.CreateVolumeRequest {
+	req := createVolumeRequest("pvc-1234", 0)
+	req.Parameters = map[string]string{
+		"server":      "nfs.example.com",
+		"share":       "/exports",
+		"subPath":     subPath,
+		ParamOnDelete: OnDeleteArchive,
+		pvcNameKey:    "data",
+	}
+	return req
+}
+
+func TestDeleteVolume_Archive(t *testing.T) {
+	stubArchiveNow(t, time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC))
+	calls := stubMountShare(t, "/mnt/share")
+	fakeFS := newFakeFilesystem()
+	if err := fakeFS.MkdirAll("/mnt/share/tenants/a/nested", 0750); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	// An archive of an earlier PVC with the same name in the same second
+	if err := fakeFS.MkdirAll("/mnt/share/tenants/archived-data-20261018-093000", 0750); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock",
+		WithVolumeIDFormat(VolumeIDFormatStructured), WithFS(fakeFS))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+	resp, err := driver.CreateVolume(context.Background(), archiveRequest("tenants/a"))
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+	volumeID := resp.GetVolume().GetVolumeId()
+	if parts, err := decodeVolumeID(volumeID); err != nil || parts.onDelete != OnDeleteArchive || parts.pvcName != "data" {
+		t.Fatalf("Expected the onDelete mode and PVC name in the volume ID, got %+v (%v)", parts, err)
+	}
+
+	if _, err := driver.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: volumeID}); err != nil {
+		t.Fatalf("DeleteVolume failed: %v", err)
+	}
+	if len(*calls) != 1 || (*calls)[0].source != "nfs.example.com:/exports" {
+		t.Errorf("Expected the share to be mounted once, got %v", *calls)
+	}
+	if _, err := fakeFS.Stat("/mnt/share/tenants/a"); err == nil {
+		t.Error("Expected the volume directory to be renamed")
+	}
+	for _, path := range []string{
+		"/mnt/share/tenants/archived-data-20261018-093000",
+		"/mnt/share/tenants/archived-data-20261018-093000-2",
+		"/mnt/share/tenants/archived-data-20261018-093000-2/nested",
+	} {
+		if _, err := fakeFS.Stat(path); err != nil {
+			t.Errorf("Expected %s to exist: %v", path, err)
+		}
+	}
+
+	// A retry finds nothing left to archive
+	if _, err := driver.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: volumeID}); err != nil {
+		t.Fatalf("Retried DeleteVolume failed: %v", err)
+	}
+	if _, err := fakeFS.Stat("/mnt/share/tenants/archived-data-20261018-093000-3"); err == nil {
+		t.Error("Expected no second archive")
+	}
+}
+
+func TestDeleteVolume_Retain(t *testing.T) {
+	calls := stubMountShare(t, "/mnt/share")
+	fakeFS := newFakeFilesystem()
+	if err := fakeFS.MkdirAll("/mnt/share/tenants/a", 0750); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock",
+		WithVolumeIDFormat(VolumeIDFormatStructured), WithFS(fakeFS))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	req := archiveRequest("tenants/a")
+	req.Parameters[ParamOnDelete] = OnDeleteRetain
+	resp, err := driver.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+	if _, err := driver.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: resp.GetVolume().GetVolumeId()}); err != nil {
+		t.Fatalf("DeleteVolume failed: %v", err)
+	}
+	if len(*calls) != 0 {
+		t.Errorf("Expected no mount, got %v", *calls)
+	}
+	if _, err := fakeFS.Stat("/mnt/share/tenants/a"); err != nil {
+		t.Errorf("Expected the volume directory to be kept: %v", err)
+	}
+}
+
+func TestCreateVolume_ArchiveErrors(t *testing.T) {
+	structured := []DriverOption{WithVolumeIDFormat(VolumeIDFormatStructured)}
+
+	tests := []struct {
+		name   string
+		opts   []DriverOption
+		modify func(params map[string]string)
+	}{
+		{name: "unknown mode", opts: structured, modify: func(p map[string]string) { p[ParamOnDelete] = "delete" }},
+		{name: "name volume IDs"},
+		{name: "share root", opts: structured, modify: func(p map[string]string) { delete(p, ParamSubPath) }},
+		{name: "inside .snapshots", opts: structured, modify: func(p map[string]string) { p[ParamSubPath] = ".snapshots/a" }},
+		{name: "dedupeByPath", opts: structured, modify: func(p map[string]string) { p[ParamDedupeByPath] = "true" }},
+		{name: "security", opts: structured, modify: func(p map[string]string) { p[ParamSecurity] = "krb5" }},
+		{name: "port", opts: structured, modify: func(p map[string]string) { p[ParamPort] = "2050" }},
+		{name: "PVC name with a slash", opts: structured, modify: func(p map[string]string) { p[pvcNameKey] = "a/b" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", tt.opts...)
+			if err != nil {
+				t.Fatalf("Failed to create driver: %v", err)
+			}
+			req := archiveRequest("tenants/a")
+			if tt.modify != nil {
+				tt.modify(req.Parameters)
+			}
+			if _, err := driver.CreateVolume(context.Background(), req); status.Code(err) != codes.InvalidArgument {
+				t.Errorf("Expected InvalidArgument, got %v", err)
+			}
+		})
+	}
+}
+
+func TestDeleteVolume_ArchiveInvalidID(t *testing.T) {
+	calls := stubMountShare(t, "/mnt/share")
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithFS(newFakeFilesystem()))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	for _, parts := range []volumeIDParts{
+		{server: "nfs.example.com", share: "/exports", subPath: "", name: "pvc-1"},
+		{server: "nfs.example.com", share: "/exports", subPath: "a/../..", name: "pvc-1"},
+		{server: "nfs.example.com", share: "/exports", subPath: ".snapshots", name: "pvc-1"},
+		{server: "nfs.example.com", share: "/exports", subPath: "a", name: "pvc-1", pvcName: ".."},
+	} {
+		parts.onDelete = OnDeleteArchive
+		if parts.pvcName == "" {
+			parts.pvcName = "data"
+		}
+		volumeID := encodeVolumeID(parts)
+		if _, err := driver.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: volumeID}); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("DeleteVolume(%q): expected InvalidArgument, got %v", volumeID, err)
+		}
+	}
+	if len(*calls) != 0 {
+		t.Errorf("Expected no mount for invalid IDs, got %v", *calls)
+	}
+}
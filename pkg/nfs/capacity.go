@@ -0,0 +1,119 @@
+package nfs
+
+import (
+	"context"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"k8s.io/klog/v2"
+)
+
+// capacityCache remembers the available capacity of each share for a short
+// time so schedulers calling GetCapacity do not mount the share every time
+type capacityCache struct {
+	mu      sync.Mutex
+	entries map[string]capacityEntry
+}
+
+type capacityEntry struct {
+	available int64
+	expires   time.Time
+}
+
+// statfsAvailable returns the bytes available to unprivileged users at path.
+// It is replaced in tests.
+var statfsAvailable = func(path string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
+
+// WithCapacity enables GetCapacity, caching each result for ttl
+func WithCapacity(ttl time.Duration) DriverOption {
+	return func(d *Driver) {
+		d.capacityEnabled = true
+		d.capacityTTL = ttl
+	}
+}
+
+// get returns the cached capacity for key if it has not expired
+func (c *capacityCache) get(key string, now time.Time) (int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || now.After(entry.expires) {
+		return 0, false
+	}
+	return entry.available, true
+}
+
+// set caches the capacity for key until now+ttl
+func (c *capacityCache) set(key string, available int64, now time.Time, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]capacityEntry)
+	}
+	c.entries[key] = capacityEntry{available: available, expires: now.Add(ttl)}
+}
+
+// capacityCapability is the capability shares are mounted with to measure
+// them. A read-only access mode accepts every parameter a volume may set.
+var capacityCapability = &csi.VolumeCapability{
+	AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+	AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY},
+}
+
+// shareMountOptions returns the options NodePublishVolume would use for a
+// volume with parameters, ending in access, "ro" or "rw". The local cache is
+// left out: the controller only mounts a share briefly, and Probe would
+// start checking cachefilesd.
+func (d *Driver) shareMountOptions(parameters map[string]string, access string) ([]string, error) {
+	params := make(map[string]string, len(parameters))
+	for key, value := range parameters {
+		if key != ParamEnableFscache && key != ParamForceFscache {
+			params[key] = value
+		}
+	}
+	mountOptions, err := d.buildMountOptions(capacityCapability, params)
+	if err != nil {
+		return nil, err
+	}
+	return normalizeMountOptions(append(mountOptions, access)), nil
+}
+
+// capacityMountOptions returns the read-only options a share is measured with
+func (d *Driver) capacityMountOptions(parameters map[string]string) ([]string, error) {
+	return d.shareMountOptions(parameters, "ro")
+}
+
+// measureCapacity mounts source read-only in a temporary directory and
+// returns the available bytes on the export
+func (d *Driver) measureCapacity(ctx context.Context, fsType, source string, mountOptions []string) (int64, error) {
+	dir, err := d.filesystem.MkdirTemp("", "nfs-capacity-")
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := d.filesystem.Remove(dir); err != nil {
+			klog.Warningf("Failed to remove %s: %v", dir, err)
+		}
+	}()
+
+	if err := d.mountContext(ctx, source, dir, fsType, mountOptions); err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := d.mounter.Unmount(dir); err != nil {
+			klog.Warningf("Failed to unmount %s: %v", dir, err)
+		}
+	}()
+
+	return statfsAvailable(dir)
+}
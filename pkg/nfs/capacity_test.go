@@ -0,0 +1,175 @@
+package nfs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/mount-utils"
+)
+
+// stubStatfs makes statfsAvailable return available and counts the calls
+func stubStatfs(t *testing.T, available int64) *int {
+	orig := statfsAvailable
+	t.Cleanup(func() { statfsAvailable = orig })
+
+	calls := 0
+	statfsAvailable = func(path string) (int64, error) {
+		calls++
+		return available, nil
+	}
+	return &calls
+}
+
+func TestGetCapacity_Disabled(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock")
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	_, err = driver.GetCapacity(context.Background(), &csi.GetCapacityRequest{})
+	if status.Code(err) != codes.Unimplemented {
+		t.Errorf("Expected Unimplemented, got %v", err)
+	}
+}
+
+func TestGetCapacity(t *testing.T) {
+	fakeMounter := mount.NewFakeMounter([]mount.MountPoint{})
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock",
+		WithMounter(fakeMounter), WithCapacity(time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+	calls := stubStatfs(t, 42<<30)
+
+	capResp, err := driver.ControllerGetCapabilities(context.Background(), &csi.ControllerGetCapabilitiesRequest{})
+	if err != nil {
+		t.Fatalf("ControllerGetCapabilities failed: %v", err)
+	}
+	found := false
+	for _, cap := range capResp.GetCapabilities() {
+		if cap.GetRpc().GetType() == csi.ControllerServiceCapability_RPC_GET_CAPACITY {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected GET_CAPACITY capability")
+	}
+
+	req := &csi.GetCapacityRequest{
+		Parameters: map[string]string{
+			"server": "192.168.1.1",
+			"share":  "/data",
+		},
+	}
+	for i := 0; i < 2; i++ {
+		resp, err := driver.GetCapacity(context.Background(), req)
+		if err != nil {
+			t.Fatalf("GetCapacity failed: %v", err)
+		}
+		if resp.GetAvailableCapacity() != 42<<30 {
+			t.Errorf("Expected %d bytes, got %d", int64(42<<30), resp.GetAvailableCapacity())
+		}
+	}
+
+	if *calls != 1 {
+		t.Errorf("Expected the second call to be cached, statfs ran %d times", *calls)
+	}
+	if len(fakeMounter.MountPoints) != 0 {
+		t.Errorf("Expected the share to be unmounted, got %+v", fakeMounter.MountPoints)
+	}
+}
+
+func TestGetCapacity_MountFailure(t *testing.T) {
+	mounter := &errorMounter{FakeMounter: mount.NewFakeMounter(nil), err: errors.New("mount.nfs: Connection timed out")}
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock",
+		WithMounter(mounter), WithCapacity(time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+	stubStatfs(t, 1)
+
+	resp, err := driver.GetCapacity(context.Background(), &csi.GetCapacityRequest{
+		Parameters: map[string]string{
+			"server": "192.168.1.1",
+			"share":  "/data",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error on mount failure, got %v", err)
+	}
+	if resp.GetAvailableCapacity() != 0 {
+		t.Errorf("Expected 0 bytes, got %d", resp.GetAvailableCapacity())
+	}
+}
+
+// contextMounter records the context and options of MountContext calls
+type contextMounter struct {
+	*mount.FakeMounter
+	ctx     context.Context
+	options []string
+}
+
+func (m *contextMounter) MountContext(ctx context.Context, source, target, fstype string, options []string) error {
+	m.ctx = ctx
+	m.options = options
+	return m.Mount(source, target, fstype, options)
+}
+
+func TestGetCapacity_MountOptions(t *testing.T) {
+	mounter := &contextMounter{FakeMounter: mount.NewFakeMounter(nil)}
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock",
+		WithMounter(mounter), WithCapacity(time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+	stubStatfs(t, 1)
+
+	type ctxKey struct{}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "request")
+	_, err = driver.GetCapacity(ctx, &csi.GetCapacityRequest{
+		Parameters: map[string]string{
+			"server":        "192.168.1.1",
+			"share":         "/data",
+			"port":          "2050",
+			"transport":     "tcp",
+			"mountOptions":  "nfsvers=4.1,rw",
+			"enableFscache": "true",
+		},
+	})
+	if err != nil {
+		t.Fatalf("GetCapacity failed: %v", err)
+	}
+
+	if mounter.ctx == nil || mounter.ctx.Value(ctxKey{}) != "request" {
+		t.Errorf("Expected the request context to be passed to the mount")
+	}
+	for _, want := range []string{"nolock", "nfsvers=4.1", "port=2050", "proto=tcp", "ro"} {
+		if !containsString(mounter.options, want) {
+			t.Errorf("Expected option %q in %v", want, mounter.options)
+		}
+	}
+	for _, unwanted := range []string{"rw", "fsc"} {
+		if containsString(mounter.options, unwanted) {
+			t.Errorf("Expected no option %q in %v", unwanted, mounter.options)
+		}
+	}
+}
+
+func TestGetCapacity_MissingParameters(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithCapacity(time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	_, err = driver.GetCapacity(context.Background(), &csi.GetCapacityRequest{
+		Parameters: map[string]string{"server": "192.168.1.1"},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument, got %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package nfs
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+// reachabilityCache remembers for a short time whether the servers of a
+// volume were reachable, so listing many volumes on the same server dials it
+// once instead of once per volume
+type reachabilityCache struct {
+	mu      sync.Mutex
+	entries map[string]reachabilityEntry
+}
+
+// reachabilityEntry holds why no server was reachable, or "" if one was
+type reachabilityEntry struct {
+	failure string
+	expires time.Time
+}
+
+// WithVolumeCondition reports a VolumeCondition for every volume returned by
+// ListVolumes, from whether one of its servers accepts a TCP connection on
+// the NFS port within timeout. Results are cached per server list for ttl.
+func WithVolumeCondition(ttl, timeout time.Duration) DriverOption {
+	return func(d *Driver) {
+		d.volumeCondition = true
+		d.conditionTTL = ttl
+		d.conditionTimeout = timeout
+	}
+}
+
+// get returns the cached result for key, if it has not expired
+func (c *reachabilityCache) get(key string, now time.Time) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || now.After(entry.expires) {
+		return "", false
+	}
+	return entry.failure, true
+}
+
+// set caches the result for key until now+ttl
+func (c *reachabilityCache) set(key, failure string, now time.Time, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]reachabilityEntry)
+	}
+	c.entries[key] = reachabilityEntry{failure: failure, expires: now.Add(ttl)}
+}
+
+// checkVolumeCondition reports whether the servers in volumeContext are
+// reachable. A volume is only abnormal when none of its servers answers, as
+// nodes fail over to any of them.
+func (d *Driver) checkVolumeCondition(ctx context.Context, volumeContext map[string]string) (*csi.VolumeCondition, error) {
+	servers := getServers(volumeContext)
+	port, _ := parsePort(volumeContext[ParamPort])
+	key := strings.Join(servers, ",") + "|" + strconv.Itoa(port)
+
+	now := time.Now()
+	failure, ok := d.reachability.get(key, now)
+	if !ok {
+		if err := dialServers(ctx, servers, port, d.conditionTimeout); err != nil {
+			// A canceled request says nothing about the server
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			failure = err.Error()
+		}
+		d.reachability.set(key, failure, now, d.conditionTTL)
+	}
+
+	if failure != "" {
+		return &csi.VolumeCondition{Abnormal: true, Message: "NFS server not reachable: " + failure}, nil
+	}
+	return &csi.VolumeCondition{Message: "NFS server reachable"}, nil
+}
@@ -0,0 +1,162 @@
+package nfs
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestListVolumes_VolumeCondition(t *testing.T) {
+	dialed := stubDialServer(t, []string{"192.168.1.100:2049"}, nil)
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock",
+		WithEphemeralProvisioning(true), WithVolumeCondition(time.Minute, 100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+	ctx := context.Background()
+
+	for name, server := range map[string]string{"pvc-a": "192.168.1.100", "pvc-b": "192.168.1.100", "pvc-c": "192.168.1.200"} {
+		req := createVolumeRequest(name, 0)
+		req.Parameters["server"] = server
+		if _, err := driver.CreateVolume(ctx, req); err != nil {
+			t.Fatalf("CreateVolume(%s) failed: %v", name, err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		resp, err := driver.ListVolumes(ctx, &csi.ListVolumesRequest{})
+		if err != nil {
+			t.Fatalf("ListVolumes failed: %v", err)
+		}
+		if len(resp.Entries) != 3 {
+			t.Fatalf("Expected 3 volumes, got %d", len(resp.Entries))
+		}
+		for _, entry := range resp.Entries {
+			condition := entry.GetStatus().GetVolumeCondition()
+			if condition == nil {
+				t.Fatalf("Expected a condition for %s", entry.Volume.VolumeId)
+			}
+			wantAbnormal := entry.Volume.VolumeId == "pvc-c"
+			if condition.Abnormal != wantAbnormal {
+				t.Errorf("Expected %s abnormal=%v, got %+v", entry.Volume.VolumeId, wantAbnormal, condition)
+			}
+			if wantAbnormal && !strings.Contains(condition.Message, "192.168.1.200:2049") {
+				t.Errorf("Expected the unreachable server in the message, got %q", condition.Message)
+			}
+		}
+	}
+
+	// Each server once: the second volume and the second list hit the cache
+	if len(*dialed) != 2 {
+		t.Errorf("Expected 2 dials, got %v", *dialed)
+	}
+
+	resp, err := driver.ControllerGetCapabilities(ctx, &csi.ControllerGetCapabilitiesRequest{})
+	if err != nil {
+		t.Fatalf("ControllerGetCapabilities failed: %v", err)
+	}
+	found := false
+	for _, cap := range resp.Capabilities {
+		if cap.GetRpc().GetType() == csi.ControllerServiceCapability_RPC_VOLUME_CONDITION {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("Expected VOLUME_CONDITION to be advertised")
+	}
+}
+
+func TestListVolumes_NoVolumeCondition(t *testing.T) {
+	dialed := stubDialServer(t, nil, nil)
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithEphemeralProvisioning(true))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+	ctx := context.Background()
+	if _, err := driver.CreateVolume(ctx, createVolumeRequest("pvc-a", 0)); err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+
+	resp, err := driver.ListVolumes(ctx, &csi.ListVolumesRequest{})
+	if err != nil {
+		t.Fatalf("ListVolumes failed: %v", err)
+	}
+	if len(resp.Entries) != 1 || resp.Entries[0].Status != nil {
+		t.Errorf("Expected one volume without status, got %+v", resp.Entries)
+	}
+	if len(*dialed) != 0 {
+		t.Errorf("Expected no dials, got %v", *dialed)
+	}
+}
+
+func TestNewDriver_InvalidVolumeCondition(t *testing.T) {
+	for _, opt := range []DriverOption{WithVolumeCondition(time.Minute, 0), WithVolumeCondition(-time.Second, time.Second)} {
+		if _, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", opt); err == nil {
+			t.Error("Expected an invalid volume condition setting to be rejected")
+		}
+	}
+}
+
+func TestControllerGetVolume(t *testing.T) {
+	stubDialServer(t, []string{"192.168.1.100:2049"}, nil)
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock",
+		WithEphemeralProvisioning(true), WithVolumeCondition(time.Minute, 100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+	ctx := context.Background()
+	for name, server := range map[string]string{"pvc-a": "192.168.1.100", "pvc-b": "192.168.1.200"} {
+		req := createVolumeRequest(name, 0)
+		req.Parameters["server"] = server
+		if _, err := driver.CreateVolume(ctx, req); err != nil {
+			t.Fatalf("CreateVolume(%s) failed: %v", name, err)
+		}
+	}
+
+	tests := []struct {
+		name         string
+		volumeID     string
+		wantCode     codes.Code
+		wantAbnormal bool
+	}{
+		{name: "healthy", volumeID: "pvc-a"},
+		{name: "abnormal", volumeID: "pvc-b", wantAbnormal: true},
+		{name: "not found", volumeID: "pvc-missing", wantCode: codes.NotFound},
+		{name: "missing ID", wantCode: codes.InvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := driver.ControllerGetVolume(ctx, &csi.ControllerGetVolumeRequest{VolumeId: tt.volumeID})
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("Expected %v, got %v", tt.wantCode, err)
+			}
+			if tt.wantCode != codes.OK {
+				return
+			}
+			if resp.Volume.VolumeId != tt.volumeID || resp.Volume.VolumeContext["share"] != "/exports/data" {
+				t.Errorf("Unexpected volume %+v", resp.Volume)
+			}
+			condition := resp.GetStatus().GetVolumeCondition()
+			if condition == nil || condition.Abnormal != tt.wantAbnormal {
+				t.Errorf("Expected abnormal=%v, got %+v", tt.wantAbnormal, condition)
+			}
+		})
+	}
+}
+
+func TestControllerGetVolume_Disabled(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock")
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+	_, err = driver.ControllerGetVolume(context.Background(), &csi.ControllerGetVolumeRequest{VolumeId: "pvc-a"})
+	if status.Code(err) != codes.Unimplemented {
+		t.Errorf("Expected Unimplemented, got %v", err)
+	}
+}
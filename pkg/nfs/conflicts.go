@@ -0,0 +1,103 @@
+package nfs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+// parameterConflict describes a combination of parameters, mount options and
+// access mode that cannot be honoured together, or returns "" if there is none
+type parameterConflict func(params map[string]string, mountFlags []string, mode csi.VolumeCapability_AccessMode_Mode) string
+
+// parameterConflicts are the known-incompatible combinations. Add new rules
+// here so CreateVolume and the node service reject them alike.
+var parameterConflicts = []parameterConflict{
+	func(params map[string]string, _ []string, _ csi.VolumeCapability_AccessMode_Mode) string {
+		if raw, _ := strconv.ParseBool(params[ParamRawShare]); !raw {
+			return ""
+		}
+		for _, key := range []string{ParamSubPath, ParamSubPathPrefix} {
+			if params[key] != "" {
+				return fmt.Sprintf("%s passes share to mount exactly as written and cannot be combined with %s", ParamRawShare, key)
+			}
+		}
+		return ""
+	},
+	func(params map[string]string, mountFlags []string, mode csi.VolumeCapability_AccessMode_Mode) string {
+		if mode != csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER || params[ParamForceSoftMount] == "true" {
+			return ""
+		}
+		if option := findMountOption(mountFlags, "soft", "softreval"); option != "" {
+			return fmt.Sprintf("mount option %s risks silent data loss on ReadWriteMany volumes; set %s=\"true\" to use it anyway", option, ParamForceSoftMount)
+		}
+		return ""
+	},
+	func(params map[string]string, mountFlags []string, _ csi.VolumeCapability_AccessMode_Mode) string {
+		var contradicting string
+		switch params[ParamMountRecovery] {
+		case MountRecoveryHard:
+			contradicting = findMountOption(mountFlags, "soft", "softreval")
+		case MountRecoverySoft, MountRecoverySoftReval:
+			contradicting = findMountOption(mountFlags, "hard")
+		}
+		if contradicting != "" {
+			return fmt.Sprintf("%s=%s contradicts mount option %s", ParamMountRecovery, params[ParamMountRecovery], contradicting)
+		}
+		return ""
+	},
+	func(params map[string]string, mountFlags []string, _ csi.VolumeCapability_AccessMode_Mode) string {
+		if params[ParamFSType] != FSTypeNFS4 {
+			return ""
+		}
+		for _, option := range mountFlags {
+			name, version, _ := strings.Cut(strings.TrimSpace(option), "=")
+			if (name == "nfsvers" || name == "vers") && !strings.HasPrefix(version, "4") {
+				return fmt.Sprintf("%s=%s cannot mount with %s", ParamFSType, FSTypeNFS4, option)
+			}
+		}
+		return ""
+	},
+	func(params map[string]string, _ []string, mode csi.VolumeCapability_AccessMode_Mode) string {
+		if params[ParamWritableSubPath] == "" {
+			return ""
+		}
+		if !isReadOnlyMode(mode) {
+			return fmt.Sprintf("%s is only supported for read-only access modes, got %v", ParamWritableSubPath, mode)
+		}
+		if readOnly, _ := strconv.ParseBool(params[ParamReadOnly]); readOnly {
+			return fmt.Sprintf("%s cannot be combined with %s=true", ParamWritableSubPath, ParamReadOnly)
+		}
+		if fscache, _ := strconv.ParseBool(params[ParamEnableFscache]); fscache && params[ParamForceFscache] != "true" {
+			return fmt.Sprintf("%s writes through a mount that %s would cache; set %s=\"true\" to use it anyway", ParamWritableSubPath, ParamEnableFscache, ParamForceFscache)
+		}
+		return ""
+	},
+}
+
+// checkParameterConflicts returns an error describing every known-incompatible
+// combination in params and mountFlags for the access mode
+func checkParameterConflicts(params map[string]string, mountFlags []string, mode csi.VolumeCapability_AccessMode_Mode) error {
+	var problems []string
+	for _, conflict := range parameterConflicts {
+		if problem := conflict(params, mountFlags, mode); problem != "" {
+			problems = append(problems, problem)
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("incompatible parameters: %s", strings.Join(problems, "; "))
+}
+
+// findMountOption returns the first option in options named one of names
+func findMountOption(options []string, names ...string) string {
+	for _, option := range options {
+		if containsString(names, mountOptionName(option)) {
+			return strings.TrimSpace(option)
+		}
+	}
+	return ""
+}
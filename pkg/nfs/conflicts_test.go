@@ -0,0 +1,85 @@
+package nfs
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCheckParameterConflicts(t *testing.T) {
+	rwx := csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER
+	rox := csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY
+
+	tests := []struct {
+		name       string
+		params     map[string]string
+		mountFlags []string
+		mode       csi.VolumeCapability_AccessMode_Mode
+		wantErr    string
+	}{
+		{name: "no conflicts", params: map[string]string{"subPath": "a", "mountRecovery": "hard"}, mountFlags: []string{"hard", "nfsvers=4.1"}, mode: rwx},
+		{name: "rawShare with subPath", params: map[string]string{"rawShare": "true", "subPath": "a"}, mode: rwx, wantErr: "cannot be combined with subPath"},
+		{name: "rawShare with subPathPrefix", params: map[string]string{"rawShare": "1", "subPathPrefix": "/tenants"}, mode: rwx, wantErr: "cannot be combined with subPathPrefix"},
+		{name: "rawShare false with subPath", params: map[string]string{"rawShare": "false", "subPath": "a"}, mode: rwx},
+		{name: "soft mount option on RWX", mountFlags: []string{"soft"}, mode: rwx, wantErr: "mount option soft risks silent data loss"},
+		{name: "softreval mount option on RWX", mountFlags: []string{"softreval"}, mode: rwx, wantErr: "mount option softreval"},
+		{name: "soft mount option on RWX forced", params: map[string]string{"forceSoftMount": "true"}, mountFlags: []string{"soft"}, mode: rwx},
+		{name: "soft mount option on ROX", mountFlags: []string{"soft"}, mode: rox},
+		{name: "hard recovery with soft option", params: map[string]string{"mountRecovery": "hard"}, mountFlags: []string{"soft"}, mode: rox, wantErr: "mountRecovery=hard contradicts mount option soft"},
+		{name: "soft recovery with hard option", params: map[string]string{"mountRecovery": "softreval"}, mountFlags: []string{"hard"}, mode: rox, wantErr: "mountRecovery=softreval contradicts mount option hard"},
+		{name: "nfs4 with nfsvers=3", params: map[string]string{"fsType": "nfs4"}, mountFlags: []string{"nfsvers=3"}, mode: rwx, wantErr: "fsType=nfs4 cannot mount with nfsvers=3"},
+		{name: "nfs4 with vers=4.2", params: map[string]string{"fsType": "nfs4"}, mountFlags: []string{"vers=4.2"}, mode: rwx},
+		{name: "every conflict is reported", params: map[string]string{"rawShare": "true", "subPath": "a", "fsType": "nfs4"}, mountFlags: []string{"vers=3"}, mode: rwx, wantErr: "subPath; fsType=nfs4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkParameterConflicts(tt.params, tt.mountFlags, tt.mode)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestCreateVolume_ParameterConflicts(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock")
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	// The subPath comes from the PVC annotation, so it only meets rawShare
+	// once CreateVolume has resolved it
+	_, err = driver.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name: "test-volume",
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{},
+				},
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+				},
+			},
+		},
+		Parameters: map[string]string{
+			"server":                             "192.168.1.100",
+			"share":                              "vol0",
+			"rawShare":                           "true",
+			"csi.storage.k8s.io/pvc/annotations": `{"nfs.csi.takutakahashi.dev/subPath":"app1"}`,
+		},
+	})
+	if status.Code(err) != codes.InvalidArgument || !strings.Contains(err.Error(), "rawShare") {
+		t.Errorf("Expected InvalidArgument about rawShare, got %v", err)
+	}
+}
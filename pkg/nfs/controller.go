@@ -2,6 +2,10 @@ package nfs
 
 import (
 	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc/codes"
@@ -9,21 +13,118 @@ import (
 	"k8s.io/klog/v2"
 )
 
+// volumeContextParameters are optional StorageClass parameters passed through
+// to the volume context for the node plugin to use at mount time. Keys under
+// csi.storage.k8s.io/ are never copied: they describe the PVC, not the volume.
+var volumeContextParameters = []string{
+	ParamFSGroupPolicy,
+	ParamFSType,
+	ParamMountRecovery,
+	ParamRetrans,
+	ParamTimeo,
+	ParamForceSoftMount,
+	ParamReadOnly,
+	ParamSubPathPrefix,
+	ParamNoatime,
+	ParamNodiratime,
+	ParamSync,
+	ParamPort,
+	ParamServers,
+	ParamShares,
+	ParamMountOptions,
+	ParamMountOptionsRWX,
+	ParamMountOptionsROX,
+	ParamSELinuxContext,
+	ParamRawShare,
+	ParamTransport,
+	ParamSecurity,
+	ParamEnableFscache,
+	ParamForceFscache,
+	ParamMountProfile,
+	ParamTargetMode,
+	ParamCreateTarget,
+	ParamMountOptionsMode,
+	ParamWritableSubPath,
+	ParamLocalLock,
+	ParamAttributeCache,
+	ParamClientAddr,
+	ParamNconnect,
+	ParamReservedPort,
+	ParamMountPropagation,
+}
+
 // ControllerGetCapabilities returns the capabilities of the controller service
 func (d *Driver) ControllerGetCapabilities(ctx context.Context, req *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
-	klog.V(4).Infof("ControllerGetCapabilities called")
+	d.logV(ctx, subsystemController, 4).Infof("ControllerGetCapabilities called")
 
 	// Support dynamic provisioning
-	return &csi.ControllerGetCapabilitiesResponse{
-		Capabilities: []*csi.ControllerServiceCapability{
-			{
+	capabilities := []*csi.ControllerServiceCapability{
+		{
+			Type: &csi.ControllerServiceCapability_Rpc{
+				Rpc: &csi.ControllerServiceCapability_RPC{
+					Type: csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+				},
+			},
+		},
+	}
+	if d.volumeExpansion {
+		capabilities = append(capabilities, &csi.ControllerServiceCapability{
+			Type: &csi.ControllerServiceCapability_Rpc{
+				Rpc: &csi.ControllerServiceCapability_RPC{
+					Type: csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+				},
+			},
+		})
+	}
+	if d.snapshotsEnabled {
+		for _, capability := range []csi.ControllerServiceCapability_RPC_Type{
+			csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+			csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+		} {
+			capabilities = append(capabilities, &csi.ControllerServiceCapability{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{Type: capability},
+				},
+			})
+		}
+	}
+	if d.ephemeralProvisioning {
+		capabilities = append(capabilities, &csi.ControllerServiceCapability{
+			Type: &csi.ControllerServiceCapability_Rpc{
+				Rpc: &csi.ControllerServiceCapability_RPC{
+					Type: csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+				},
+			},
+		})
+		capabilities = append(capabilities, &csi.ControllerServiceCapability{
+			Type: &csi.ControllerServiceCapability_Rpc{
+				Rpc: &csi.ControllerServiceCapability_RPC{
+					Type: csi.ControllerServiceCapability_RPC_GET_VOLUME,
+				},
+			},
+		})
+		if d.volumeCondition {
+			capabilities = append(capabilities, &csi.ControllerServiceCapability{
 				Type: &csi.ControllerServiceCapability_Rpc{
 					Rpc: &csi.ControllerServiceCapability_RPC{
-						Type: csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+						Type: csi.ControllerServiceCapability_RPC_VOLUME_CONDITION,
 					},
 				},
+			})
+		}
+	}
+	if d.capacityEnabled {
+		capabilities = append(capabilities, &csi.ControllerServiceCapability{
+			Type: &csi.ControllerServiceCapability_Rpc{
+				Rpc: &csi.ControllerServiceCapability_RPC{
+					Type: csi.ControllerServiceCapability_RPC_GET_CAPACITY,
+				},
 			},
-		},
+		})
+	}
+
+	return &csi.ControllerGetCapabilitiesResponse{
+		Capabilities: capabilities,
 	}, nil
 }
 
@@ -32,7 +133,7 @@ func (d *Driver) ValidateVolumeCapabilities(ctx context.Context, req *csi.Valida
 	volumeID := req.GetVolumeId()
 	capabilities := req.GetVolumeCapabilities()
 
-	klog.V(4).Infof("ValidateVolumeCapabilities: volumeID=%s", volumeID)
+	d.logV(ctx, subsystemController, 4).Infof("ValidateVolumeCapabilities: volumeID=%s", volumeID)
 
 	if volumeID == "" {
 		return nil, status.Error(codes.InvalidArgument, "volume ID is required")
@@ -40,6 +141,11 @@ func (d *Driver) ValidateVolumeCapabilities(ctx context.Context, req *csi.Valida
 	if len(capabilities) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "volume capabilities are required")
 	}
+	if d.ephemeralProvisioning {
+		if _, ok := d.volumes.lookup(volumeID); !ok {
+			return nil, status.Errorf(codes.NotFound, "volume %s does not exist", volumeID)
+		}
+	}
 
 	// Validate each capability
 	for _, cap := range capabilities {
@@ -48,6 +154,19 @@ func (d *Driver) ValidateVolumeCapabilities(ctx context.Context, req *csi.Valida
 				Message: err.Error(),
 			}, nil
 		}
+		if err := d.validateMountOptions(cap.GetMount().GetMountFlags()); err != nil {
+			return &csi.ValidateVolumeCapabilitiesResponse{
+				Message: err.Error(),
+			}, nil
+		}
+	}
+
+	// Check that the volume context still describes a mountable source
+	// matching the parameters it was provisioned with
+	if err := validateVolumeSourceMatch(req.GetVolumeContext(), req.GetParameters(), d.subPathAnnotationKeys()...); err != nil {
+		return &csi.ValidateVolumeCapabilitiesResponse{
+			Message: err.Error(),
+		}, nil
 	}
 
 	return &csi.ValidateVolumeCapabilitiesResponse{
@@ -58,9 +177,16 @@ func (d *Driver) ValidateVolumeCapabilities(ctx context.Context, req *csi.Valida
 }
 
 // CreateVolume creates a volume for dynamic provisioning
-// Note: This does not create any directories on the NFS server.
-// The NFS share must already exist and be properly configured.
+// Note: Apart from restoring a snapshot, this does not create any
+// directories on the NFS server. The NFS share must already exist and be
+// properly configured. Errors carry their failure category, see
+// withErrorDetails.
 func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+	resp, err := d.createVolume(ctx, req)
+	return resp, d.withErrorDetails(err)
+}
+
+func (d *Driver) createVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
 	volumeName := req.GetName()
 	if volumeName == "" {
 		return nil, status.Error(codes.InvalidArgument, "volume name is required")
@@ -82,7 +208,12 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 	parameters := req.GetParameters()
 
 	// Debug: Log all parameters
-	klog.V(2).Infof("CreateVolume: received parameters: %+v", parameters)
+	d.logV(ctx, subsystemController, 2).Infof("CreateVolume: received parameters: %+v", redactParameters(parameters))
+
+	if err := d.validateParameterKeys(parameters); err != nil {
+		return nil, err
+	}
+	parameters = d.withForcedServer(parameters, volumeName)
 
 	server := parameters[ParamServer]
 	share := parameters[ParamShare]
@@ -92,32 +223,220 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 	subPath := parameters[ParamSubPath]
 	if subPath == "" {
 		// Try to get from PVC annotations (requires external-provisioner with --extra-create-metadata)
-		if annotations := parameters["csi.storage.k8s.io/pvc/annotations"]; annotations != "" {
-			subPath = parseAnnotationSubPath(annotations)
+		if annotations := parameters[pvcAnnotationsKey]; annotations != "" {
+			subPath = parseAnnotationSubPath(annotations, d.subPathAnnotationKeys()...)
 			if subPath != "" {
-				klog.V(2).Infof("CreateVolume: subPath from PVC annotation: %s", subPath)
+				d.logV(ctx, subsystemController, 2).Infof("CreateVolume: subPath from PVC annotation: %s", subPath)
+			}
+			// Annotations are user-controlled, so absolute paths need operator opt-in
+			if strings.HasPrefix(subPath, "/") {
+				allowAbsolute := false
+				if value := parameters[ParamAllowAbsoluteSubPath]; value != "" {
+					var err error
+					if allowAbsolute, err = strconv.ParseBool(value); err != nil {
+						return nil, status.Errorf(codes.InvalidArgument, "invalid %s %q: must be true or false", ParamAllowAbsoluteSubPath, value)
+					}
+				}
+				if !allowAbsolute {
+					return nil, status.Errorf(codes.InvalidArgument, "absolute subPath %q from PVC annotation is not allowed (set %s: \"true\" to permit)", subPath, ParamAllowAbsoluteSubPath)
+				}
 			}
 		}
 	}
 
-	if server == "" {
-		return nil, status.Error(codes.InvalidArgument, "server parameter is required")
+	// Check server, share, subPath, port and mount options together so every
+	// problem is reported at once
+	sourceParams := map[string]string{
+		ParamServer:         server,
+		ParamServers:        parameters[ParamServers],
+		ParamShare:          share,
+		ParamShares:         parameters[ParamShares],
+		ParamRawShare:       parameters[ParamRawShare],
+		ParamSubPath:        subPath,
+		ParamSubPathPrefix:  parameters[ParamSubPathPrefix],
+		ParamPort:           parameters[ParamPort],
+		ParamSELinuxContext: parameters[ParamSELinuxContext],
+	}
+	var mountFlags []string
+	for _, cap := range capabilities {
+		mountFlags = append(mountFlags, cap.GetMount().GetMountFlags()...)
 	}
-	if share == "" {
-		return nil, status.Error(codes.InvalidArgument, "share parameter is required")
+	for _, key := range []string{ParamMountOptions, ParamMountOptionsRWX, ParamMountOptionsROX} {
+		mountFlags = append(mountFlags, splitMountOptions(parameters[key])...)
 	}
 
-	// Validate subPath if provided
-	if subPath != "" {
-		if err := validateSubPath(subPath); err != nil {
-			return nil, status.Errorf(codes.InvalidArgument, "invalid subPath: %v", err)
+	// Mount options from the PVC annotation go through the same allowlist
+	var annotationMountOptions []string
+	if annotations := parameters[pvcAnnotationsKey]; annotations != "" {
+		annotationMountOptions = parseAnnotationMountOptions(annotations, d.mountOptionsAnnotationKeys()...)
+		mountFlags = append(mountFlags, annotationMountOptions...)
+	}
+
+	// Options from the mount profile are checked the same way
+	var profileMountOptions []string
+	if profile := parameters[ParamMountProfile]; profile != "" {
+		options, ok := d.mountProfiles[profile]
+		if !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown %s %q", ParamMountProfile, profile)
 		}
+		profileMountOptions = splitMountOptions(options)
+		mountFlags = append(mountFlags, profileMountOptions...)
+	}
+	if err := d.validateVolumeParameters(sourceParams, mountFlags); err != nil {
+		return nil, err
 	}
 
-	klog.V(2).Infof("CreateVolume: name=%s, server=%s, share=%s, subPath=%s", volumeName, server, share, subPath)
+	if err := d.checkNamespaceServers(parameters[pvcNamespaceKey], getServers(sourceParams)); err != nil {
+		return nil, err
+	}
 
-	// Generate volume ID
-	volumeID := volumeName
+	if d.resolveServer {
+		for _, server := range getServers(sourceParams) {
+			if err := resolveServer(ctx, server); err != nil {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
+		}
+	}
+
+	if err := validateFSGroupPolicy(parameters[ParamFSGroupPolicy]); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if _, err := flagMountOptions(parameters, nil); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if _, err := transportMountOptions(parameters, mountFlags); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if _, err := securityMountOptions(parameters, mountFlags); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if _, err := localLockMountOptions(parameters, mountFlags); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if _, err := attributeCacheMountOptions(parameters, mountFlags); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if _, err := nconnectMountOptions(parameters, mountFlags); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if _, err := reservedPortMountOptions(parameters, mountFlags); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// An auto clientAddr is resolved on the node, where it is mounted
+	if _, err := getClientAddr(parameters); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if _, err := getFSType(parameters); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if _, err := getTargetMode(parameters, d.targetPathMode); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if _, err := getCreateTarget(parameters); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if _, err := getMountOptionsMode(parameters); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if _, err := getWritableSubPath(parameters); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if _, err := getMountPropagation(parameters); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	dedupe, err := getDedupeByPath(parameters)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	// A restore would copy into the directory other volumes already use
+	if dedupe && req.GetVolumeContentSource() != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%s cannot be used with a volume content source", ParamDedupeByPath)
+	}
+
+	onDelete, err := getOnDelete(parameters)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	for _, cap := range capabilities {
+		if _, err := recoveryMountOptions(parameters, cap.GetAccessMode().GetMode()); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if _, err := fscacheMountOptions(parameters, cap.GetAccessMode().GetMode()); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	// The parameters are valid; only now is the server worth a dial
+	if d.precheckServer {
+		if err := d.precheckServers(ctx, getServers(sourceParams), sourceParams); err != nil {
+			return nil, err
+		}
+	}
+
+	d.logV(ctx, subsystemController, 2).Infof("CreateVolume: name=%s, server=%s, share=%s, subPath=%s", volumeName, server, share, subPath)
+
+	// The capacity is recorded for NodeGetVolumeStats. It is only returned
+	// as the volume's size in ephemeral provisioning mode, since without the
+	// volume store a repeated request with another size could not be told
+	// apart.
+	capacity, err := requestedCapacity(req.GetCapacityRange())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// Generate volume ID. validateVolumeParameters has checked the share and
+	// subPath already.
+	baseShare, _ := getShare(sourceParams)
+	if shares, _ := getShares(sourceParams); shares != nil {
+		baseShare = strings.Join(shares, ",")
+	}
+	fullSubPath, _ := resolveSubPath(sourceParams, d.subPathAnnotationKeys()...)
+	idName := volumeName
+	if dedupe {
+		idName = dedupeVolumeName(getServers(sourceParams), baseShare, fullSubPath)
+		d.logV(ctx, subsystemController, 2).Infof("CreateVolume: %s shares the volume ID of its path as %s", volumeName, idName)
+	}
+	idParts := volumeIDParts{
+		server:  strings.Join(getServers(sourceParams), ","),
+		share:   baseShare,
+		subPath: fullSubPath,
+		name:    idName,
+	}
+	if onDelete == OnDeleteArchive {
+		if err := d.checkArchive(parameters, fullSubPath, dedupe); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		// Without --extra-create-metadata the archive is named after the volume
+		pvcName := parameters[pvcNameKey]
+		if pvcName == "" {
+			pvcName = volumeName
+		}
+		if err := checkArchiveLabel(pvcName); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		idParts.onDelete, idParts.pvcName = onDelete, pvcName
+	}
+	volumeID := d.volumeID(idParts)
+	// Structured IDs grow with the servers and paths they encode
+	if len(volumeID) > maxVolumeIDLength {
+		return nil, status.Errorf(codes.InvalidArgument, "volume ID %q is %d bytes, longer than the %d allowed by CSI; use shorter server names, share or subPath", volumeID, len(volumeID), maxVolumeIDLength)
+	}
 
 	// Build volume context
 	volumeContext := map[string]string{
@@ -127,31 +446,87 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 	if subPath != "" {
 		volumeContext[ParamSubPath] = subPath
 	}
+	for _, key := range volumeContextParameters {
+		if value := parameters[key]; value != "" {
+			volumeContext[key] = value
+		}
+	}
+	if len(annotationMountOptions) > 0 {
+		volumeContext[ParamAnnotationMountOptions] = strings.Join(annotationMountOptions, ",")
+	}
+	if len(profileMountOptions) > 0 {
+		volumeContext[ParamProfileMountOptions] = strings.Join(profileMountOptions, ",")
+	}
+	if capacity > 0 {
+		volumeContext[ParamCapacityBytes] = strconv.FormatInt(capacity, 10)
+	}
 
-	// Note: We do not create any directories on the NFS server.
-	// The NFS share must already exist and be accessible.
+	// Check the combinations the node will see, with the subPath from the
+	// annotation and the resolved mount options
+	for _, cap := range capabilities {
+		if err := checkParameterConflicts(volumeContext, volumeMountFlags(cap, volumeContext), cap.GetAccessMode().GetMode()); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
 
-	return &csi.CreateVolumeResponse{
-		Volume: &csi.Volume{
-			VolumeId:      volumeID,
-			VolumeContext: volumeContext,
-		},
-	}, nil
+	// Note: We do not create any directories on the NFS server, except
+	// when restoring a snapshot. The NFS share must already exist and be
+	// accessible.
+	volume := &csi.Volume{
+		VolumeId:      volumeID,
+		VolumeContext: volumeContext,
+	}
+	if snapshot := req.GetVolumeContentSource().GetSnapshot(); snapshot != nil {
+		if err := d.restoreSnapshot(ctx, snapshot.GetSnapshotId(), getServers(sourceParams), baseShare, fullSubPath, volumeContext); err != nil {
+			return nil, err
+		}
+		volume.ContentSource = req.GetVolumeContentSource()
+	}
+	if d.ephemeralProvisioning {
+		volume.CapacityBytes = capacity
+		if volume, err = d.volumes.create(volumeName, volume); err != nil {
+			return nil, err
+		}
+	}
+	d.volumeCounts.create(volumeName, volume.GetVolumeId())
+
+	return &csi.CreateVolumeResponse{Volume: volume}, nil
 }
 
 // DeleteVolume deletes a volume
-// Note: This does not delete any data on the NFS server.
-// The NFS share and its contents remain unchanged.
+// Note: This does not delete any data on the NFS server. Volumes created
+// with onDelete "archive" have their directory renamed to an archive; the
+// rest of the share is left unchanged.
 func (d *Driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
 	volumeID := req.GetVolumeId()
 	if volumeID == "" {
 		return nil, status.Error(codes.InvalidArgument, "volume ID is required")
 	}
 
-	klog.V(2).Infof("DeleteVolume: volumeID=%s", volumeID)
+	d.logV(ctx, subsystemController, 2).Infof("DeleteVolume: volumeID=%s", volumeID)
+
+	// Structured IDs name the directory on the server; IDs in the name
+	// format are left alone, whatever the current --volume-id-format
+	if strings.Contains(volumeID, volumeIDSeparator) {
+		parts, err := decodeVolumeID(volumeID)
+		if err != nil {
+			klog.Warningf("DeleteVolume: %v", err)
+		} else {
+			d.logV(ctx, subsystemController, 2).Infof("DeleteVolume: volume %s is %s:%s subPath=%q", parts.name, parts.server, parts.share, parts.subPath)
+			if parts.onDelete == OnDeleteArchive {
+				if err := d.archiveVolume(ctx, volumeID, parts); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
 
 	// Note: We do not delete any directories or data on the NFS server.
 	// The NFS share and its contents are managed externally.
+	if d.ephemeralProvisioning {
+		d.volumes.delete(volumeID)
+	}
+	d.volumeCounts.delete(volumeID)
 
 	return &csi.DeleteVolumeResponse{}, nil
 }
@@ -166,32 +541,154 @@ func (d *Driver) ControllerUnpublishVolume(ctx context.Context, req *csi.Control
 	return nil, status.Error(codes.Unimplemented, "ControllerUnpublishVolume is not implemented")
 }
 
-// GetCapacity is not implemented
+// GetCapacity returns the space available on the share named in the parameters.
+// The share is mounted briefly to statfs it; results are cached for a short time.
+// A share that cannot be mounted reports 0 rather than failing the call.
 func (d *Driver) GetCapacity(ctx context.Context, req *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "GetCapacity is not implemented")
+	if !d.capacityEnabled {
+		return nil, status.Error(codes.Unimplemented, "GetCapacity is not implemented")
+	}
+
+	parameters := req.GetParameters()
+	source, err := getBaseSource(parameters)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	for _, server := range getServers(parameters) {
+		if err := validateServer(server); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	fsType, err := getFSType(parameters)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	mountOptions, err := d.capacityMountOptions(parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	key := fsType + ":" + source + ":" + strings.Join(mountOptions, ",")
+	now := time.Now()
+	if available, ok := d.capacity.get(key, now); ok {
+		d.logV(ctx, subsystemController, 4).Infof("GetCapacity: %s has %d bytes available (cached)", source, available)
+		return &csi.GetCapacityResponse{AvailableCapacity: available}, nil
+	}
+
+	available, err := d.measureCapacity(ctx, fsType, source, mountOptions)
+	if err != nil {
+		klog.Warningf("GetCapacity: failed to measure %s, reporting 0: %v", source, err)
+		available = 0
+	}
+	// Failures are cached too, so an unreachable server is not retried on every call
+	d.capacity.set(key, available, now, d.capacityTTL)
+
+	d.logV(ctx, subsystemController, 4).Infof("GetCapacity: %s has %d bytes available", source, available)
+	return &csi.GetCapacityResponse{AvailableCapacity: available}, nil
 }
 
-// ListVolumes is not implemented
+// ListVolumes lists the volumes created in ephemeral provisioning mode. It is
+// not implemented otherwise, since volumes are not tracked.
 func (d *Driver) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "ListVolumes is not implemented")
-}
+	if !d.ephemeralProvisioning {
+		return nil, status.Error(codes.Unimplemented, "ListVolumes is not implemented")
+	}
 
-// CreateSnapshot is not implemented
-func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "CreateSnapshot is not implemented")
+	volumes, nextToken, err := d.volumes.list(req.GetMaxEntries(), req.GetStartingToken())
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*csi.ListVolumesResponse_Entry, 0, len(volumes))
+	for _, volume := range volumes {
+		entry := &csi.ListVolumesResponse_Entry{Volume: volume}
+		if d.volumeCondition {
+			condition, err := d.checkVolumeCondition(ctx, volume.GetVolumeContext())
+			if err != nil {
+				return nil, status.FromContextError(err).Err()
+			}
+			entry.Status = &csi.ListVolumesResponse_VolumeStatus{VolumeCondition: condition}
+		}
+		entries = append(entries, entry)
+	}
+	return &csi.ListVolumesResponse{Entries: entries, NextToken: nextToken}, nil
 }
 
-// DeleteSnapshot is not implemented
-func (d *Driver) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "DeleteSnapshot is not implemented")
-}
+// ControllerGetVolume returns a volume created in ephemeral provisioning
+// mode, with its condition when volume conditions are enabled. It is not
+// implemented otherwise, since volumes are not tracked.
+func (d *Driver) ControllerGetVolume(ctx context.Context, req *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
+	if !d.ephemeralProvisioning {
+		return nil, status.Error(codes.Unimplemented, "ControllerGetVolume is not implemented")
+	}
+
+	volumeID := req.GetVolumeId()
+	if volumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume ID is required")
+	}
+	volume, ok := d.volumes.lookup(volumeID)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "volume %s not found", volumeID)
+	}
 
-// ListSnapshots is not implemented
-func (d *Driver) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "ListSnapshots is not implemented")
+	resp := &csi.ControllerGetVolumeResponse{Volume: volume}
+	if d.volumeCondition {
+		condition, err := d.checkVolumeCondition(ctx, volume.GetVolumeContext())
+		if err != nil {
+			return nil, status.FromContextError(err).Err()
+		}
+		resp.Status = &csi.ControllerGetVolumeResponse_VolumeStatus{VolumeCondition: condition}
+	}
+	return resp, nil
 }
 
-// ControllerExpandVolume is not implemented
+// ControllerExpandVolume expands a volume
+// Note: Volumes are plain directories on a shared export without quota
+// enforcement, so the new capacity is advisory and nothing changes on the
+// NFS server. No node-side resize is needed for NFS.
 func (d *Driver) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "ControllerExpandVolume is not implemented")
+	volumeID := req.GetVolumeId()
+	if volumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume ID is required")
+	}
+
+	capacityRange := req.GetCapacityRange()
+	if capacityRange == nil {
+		return nil, status.Error(codes.InvalidArgument, "capacity range is required")
+	}
+
+	// The current size is not tracked for advisory volumes, so any
+	// well-formed request is treated as a growth to the new size.
+	capacity, err := requestedCapacity(capacityRange)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if d.ephemeralProvisioning && !d.volumes.resize(volumeID, capacity) {
+		return nil, status.Errorf(codes.NotFound, "volume %s does not exist", volumeID)
+	}
+
+	d.logV(ctx, subsystemController, 2).Infof("ControllerExpandVolume: volumeID=%s, capacity=%d (advisory)", volumeID, capacity)
+
+	return &csi.ControllerExpandVolumeResponse{
+		CapacityBytes:         capacity,
+		NodeExpansionRequired: false,
+	}, nil
+}
+
+// requestedCapacity returns the size asked for in capacityRange: the required
+// bytes, or the limit if only that is set. Zero means no size was requested.
+func requestedCapacity(capacityRange *csi.CapacityRange) (int64, error) {
+	requiredBytes := capacityRange.GetRequiredBytes()
+	limitBytes := capacityRange.GetLimitBytes()
+	if requiredBytes < 0 || limitBytes < 0 {
+		return 0, fmt.Errorf("capacity range must not be negative")
+	}
+	if limitBytes > 0 && requiredBytes > limitBytes {
+		return 0, fmt.Errorf("required bytes %d exceeds limit bytes %d", requiredBytes, limitBytes)
+	}
+	if requiredBytes == 0 {
+		return limitBytes, nil
+	}
+	return requiredBytes, nil
 }
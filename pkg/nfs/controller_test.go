@@ -2,6 +2,7 @@ package nfs
 
 import (
 	"context"
+	"strings"
 	"testing"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
@@ -20,15 +21,35 @@ func TestControllerGetCapabilities(t *testing.T) {
 		t.Fatalf("ControllerGetCapabilities failed: %v", err)
 	}
 
-	// Dynamic provisioning - should have CREATE_DELETE_VOLUME capability
+	// Dynamic provisioning only
 	if len(resp.Capabilities) != 1 {
-		t.Errorf("Expected 1 capability for dynamic provisioning, got %d", len(resp.Capabilities))
+		t.Errorf("Expected 1 capability, got %d", len(resp.Capabilities))
 	}
+	if len(resp.Capabilities) > 0 && resp.Capabilities[0].GetRpc().GetType() != csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME {
+		t.Errorf("Expected CREATE_DELETE_VOLUME capability, got %v", resp.Capabilities[0].GetRpc().GetType())
+	}
+}
+
+func TestControllerGetCapabilities_VolumeExpansion(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithVolumeExpansion(enabled))
+		if err != nil {
+			t.Fatalf("Failed to create driver: %v", err)
+		}
 
-	if len(resp.Capabilities) > 0 {
-		cap := resp.Capabilities[0]
-		if cap.GetRpc().GetType() != csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME {
-			t.Errorf("Expected CREATE_DELETE_VOLUME capability, got %v", cap.GetRpc().GetType())
+		resp, err := driver.ControllerGetCapabilities(context.Background(), &csi.ControllerGetCapabilitiesRequest{})
+		if err != nil {
+			t.Fatalf("ControllerGetCapabilities failed: %v", err)
+		}
+
+		found := false
+		for _, cap := range resp.Capabilities {
+			if cap.GetRpc().GetType() == csi.ControllerServiceCapability_RPC_EXPAND_VOLUME {
+				found = true
+			}
+		}
+		if found != enabled {
+			t.Errorf("With expansion enabled=%v, EXPAND_VOLUME advertised=%v", enabled, found)
 		}
 	}
 }
@@ -114,6 +135,79 @@ func TestValidateVolumeCapabilities(t *testing.T) {
 			wantErr:   false,
 			confirmed: true,
 		},
+		{
+			name: "valid volume context",
+			req: &csi.ValidateVolumeCapabilitiesRequest{
+				VolumeId: "test-volume",
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+						},
+					},
+				},
+				VolumeContext: map[string]string{
+					"server": "192.168.1.100",
+					"share":  "/exports/data",
+				},
+				Parameters: map[string]string{
+					"server": "192.168.1.100",
+					"share":  "/exports/data",
+				},
+			},
+			wantErr:   false,
+			confirmed: true,
+		},
+		{
+			name: "volume context missing share",
+			req: &csi.ValidateVolumeCapabilitiesRequest{
+				VolumeId: "test-volume",
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+						},
+					},
+				},
+				VolumeContext: map[string]string{
+					"server": "192.168.1.100",
+				},
+			},
+			wantErr:   false,
+			confirmed: false,
+		},
+		{
+			name: "volume context server differs from parameters",
+			req: &csi.ValidateVolumeCapabilitiesRequest{
+				VolumeId: "test-volume",
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+						},
+					},
+				},
+				VolumeContext: map[string]string{
+					"server": "192.168.1.200",
+					"share":  "/exports/data",
+				},
+				Parameters: map[string]string{
+					"server": "192.168.1.100",
+					"share":  "/exports/data",
+				},
+			},
+			wantErr:   false,
+			confirmed: false,
+		},
 		{
 			name: "block access type not supported",
 			req: &csi.ValidateVolumeCapabilitiesRequest{
@@ -328,6 +422,29 @@ func TestCreateVolume(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "soft mount rejected for RWX",
+			req: &csi.CreateVolumeRequest{
+				Name: "test-volume",
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+						},
+					},
+				},
+				Parameters: map[string]string{
+					"server":        "192.168.1.100",
+					"share":         "/exports/data",
+					"mountRecovery": "soft",
+				},
+			},
+			wantErr:  true,
+			wantCode: codes.InvalidArgument,
+		},
 		{
 			name: "invalid subPath from PVC annotation",
 			req: &csi.CreateVolumeRequest{
@@ -351,6 +468,74 @@ func TestCreateVolume(t *testing.T) {
 			wantErr:  true,
 			wantCode: codes.InvalidArgument,
 		},
+		{
+			name: "absolute subPath from PVC annotation is rejected by default",
+			req: &csi.CreateVolumeRequest{
+				Name: "test-volume",
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+						},
+					},
+				},
+				Parameters: map[string]string{
+					"server":                             "192.168.1.100",
+					"share":                              "/exports/data",
+					"csi.storage.k8s.io/pvc/annotations": `{"nfs.csi.takutakahashi.dev/subPath":"/etc"}`,
+				},
+			},
+			wantErr:  true,
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "absolute subPath from PVC annotation with allowAbsoluteSubPath",
+			req: &csi.CreateVolumeRequest{
+				Name: "test-volume",
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+						},
+					},
+				},
+				Parameters: map[string]string{
+					"server":                             "192.168.1.100",
+					"share":                              "/exports/data",
+					"csi.storage.k8s.io/pvc/annotations": `{"nfs.csi.takutakahashi.dev/subPath":"/etc"}`,
+					"allowAbsoluteSubPath":               "true",
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "absolute subPath from StorageClass is allowed",
+			req: &csi.CreateVolumeRequest{
+				Name: "test-volume",
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+						},
+					},
+				},
+				Parameters: map[string]string{
+					"server":  "192.168.1.100",
+					"share":   "/exports/data",
+					"subPath": "/apps/web",
+				},
+			},
+			wantErr: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -472,3 +657,575 @@ func TestDeleteVolume(t *testing.T) {
 		})
 	}
 }
+
+func TestControllerExpandVolume(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock")
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		req          *csi.ControllerExpandVolumeRequest
+		wantErr      bool
+		wantCode     codes.Code
+		wantCapacity int64
+	}{
+		{
+			name:     "missing volume ID",
+			req:      &csi.ControllerExpandVolumeRequest{},
+			wantErr:  true,
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "missing capacity range",
+			req: &csi.ControllerExpandVolumeRequest{
+				VolumeId: "test-volume",
+			},
+			wantErr:  true,
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "required exceeds limit",
+			req: &csi.ControllerExpandVolumeRequest{
+				VolumeId: "test-volume",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 20 << 30,
+					LimitBytes:    10 << 30,
+				},
+			},
+			wantErr:  true,
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "advisory expansion",
+			req: &csi.ControllerExpandVolumeRequest{
+				VolumeId: "test-volume",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 20 << 30,
+				},
+			},
+			wantCapacity: 20 << 30,
+		},
+		{
+			name: "limit only",
+			req: &csi.ControllerExpandVolumeRequest{
+				VolumeId: "test-volume",
+				CapacityRange: &csi.CapacityRange{
+					LimitBytes: 5 << 30,
+				},
+			},
+			wantCapacity: 5 << 30,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := driver.ControllerExpandVolume(context.Background(), tt.req)
+
+			if tt.wantErr {
+				if status.Code(err) != tt.wantCode {
+					t.Errorf("Expected error code %v, got %v", tt.wantCode, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if resp.CapacityBytes != tt.wantCapacity {
+				t.Errorf("Expected capacity %d, got %d", tt.wantCapacity, resp.CapacityBytes)
+			}
+			if resp.NodeExpansionRequired {
+				t.Error("Expected node expansion to not be required")
+			}
+		})
+	}
+}
+
+func TestCreateVolume_PassesMountParameters(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock")
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	params := map[string]string{
+		"server":        "192.168.1.100",
+		"share":         "/exports/data",
+		"mountRecovery": "softreval",
+		"retrans":       "2",
+		"timeo":         "150",
+	}
+	resp, err := driver.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name: "test-volume",
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{},
+				},
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+				},
+			},
+		},
+		Parameters: params,
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+
+	for _, key := range []string{"mountRecovery", "retrans", "timeo"} {
+		if got := resp.Volume.VolumeContext[key]; got != params[key] {
+			t.Errorf("Expected volume context %s=%s, got %q", key, params[key], got)
+		}
+	}
+}
+
+func TestCreateVolume_EchoesParameters(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock")
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	params := map[string]string{
+		"server":                             "192.168.1.100",
+		"share":                              "/exports/data",
+		"mountOptions":                       "hard,noatime",
+		"port":                               "2049",
+		"fsType":                             "nfs4",
+		"csi.storage.k8s.io/pvc/name":        "data",
+		"csi.storage.k8s.io/pvc/namespace":   "default",
+		"csi.storage.k8s.io/pv/name":         "pvc-0123",
+		"csi.storage.k8s.io/pvc/annotations": "{}",
+	}
+	resp, err := driver.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name: "test-volume",
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{},
+				},
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+				},
+			},
+		},
+		Parameters: params,
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+
+	volumeContext := resp.Volume.VolumeContext
+	for _, key := range []string{"server", "share", "mountOptions", "port", "fsType"} {
+		if got := volumeContext[key]; got != params[key] {
+			t.Errorf("Expected volume context %s=%s, got %q", key, params[key], got)
+		}
+	}
+	for key := range volumeContext {
+		if strings.HasPrefix(key, "csi.storage.k8s.io/") {
+			t.Errorf("Expected provisioner key %s to be left out of the volume context", key)
+		}
+	}
+}
+
+func TestCreateVolume_DeniedMountOptionsParameter(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithDeniedMountOptions([]string{"nosuid"}))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	_, err = driver.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name: "test-volume",
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{},
+				},
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+				},
+			},
+		},
+		Parameters: map[string]string{"server": "192.168.1.100", "share": "/exports/data", "mountOptions": "nosuid"},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument for a denied option in the mountOptions parameter, got %v", err)
+	}
+}
+
+func TestValidateVolumeCapabilities_DeniedMountOption(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock",
+		WithDeniedMountOptions([]string{"suid"}),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	resp, err := driver.ValidateVolumeCapabilities(context.Background(), &csi.ValidateVolumeCapabilitiesRequest{
+		VolumeId: "test-volume",
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{
+						MountFlags: []string{"suid"},
+					},
+				},
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("ValidateVolumeCapabilities failed: %v", err)
+	}
+	if resp.Confirmed != nil {
+		t.Error("Expected capabilities with a denied mount option to not be confirmed")
+	}
+	if resp.Message == "" {
+		t.Error("Expected a message explaining the rejection")
+	}
+}
+
+func TestCreateVolume_SubPathPrefix(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock")
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		params  map[string]string
+		wantErr bool
+	}{
+		{
+			name: "annotation subPath under prefix",
+			params: map[string]string{
+				"subPathPrefix":                      "/tenants/acme",
+				"csi.storage.k8s.io/pvc/annotations": `{"nfs.csi.takutakahashi.dev/subPath":"app"}`,
+			},
+		},
+		{
+			name: "annotation subPath escaping prefix",
+			params: map[string]string{
+				"subPathPrefix":                      "/tenants/acme",
+				"csi.storage.k8s.io/pvc/annotations": `{"nfs.csi.takutakahashi.dev/subPath":"../../etc"}`,
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]string{
+				"server": "192.168.1.100",
+				"share":  "/exports/data",
+			}
+			for k, v := range tt.params {
+				params[k] = v
+			}
+
+			resp, err := driver.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name: "test-volume",
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+						},
+					},
+				},
+				Parameters: params,
+			})
+			if tt.wantErr {
+				if status.Code(err) != codes.InvalidArgument {
+					t.Fatalf("Expected InvalidArgument, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CreateVolume failed: %v", err)
+			}
+
+			_, share, err := getVolumeSource(resp.Volume.VolumeContext, AnnotationSubPath)
+			if err != nil {
+				t.Fatalf("getVolumeSource failed: %v", err)
+			}
+			if share != "/exports/data/tenants/acme/app" {
+				t.Errorf("Expected share under prefix, got %s", share)
+			}
+		})
+	}
+}
+
+func TestCreateVolume_InvalidServer(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithResolveServer(true))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	// .invalid is reserved and never resolves
+	for _, server := range []string{"nfs_server", "10.0.0.256", "nfs.example.invalid"} {
+		t.Run(server, func(t *testing.T) {
+			_, err := driver.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name: "test-volume",
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+						},
+					},
+				},
+				Parameters: map[string]string{
+					"server": server,
+					"share":  "/exports/data",
+				},
+			})
+			if status.Code(err) != codes.InvalidArgument {
+				t.Errorf("Expected InvalidArgument, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCreateVolume_CustomDriverNameAnnotation(t *testing.T) {
+	driver, err := NewDriver("nfs.example.org", "test-node", "unix:///tmp/test.sock")
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	resp, err := driver.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name: "test-volume",
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{},
+				},
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+				},
+			},
+		},
+		Parameters: map[string]string{
+			"server":                             "192.168.1.100",
+			"share":                              "/exports/data",
+			"csi.storage.k8s.io/pvc/annotations": `{"nfs.example.org/subPath":"music"}`,
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+	if got := resp.Volume.VolumeContext["subPath"]; got != "music" {
+		t.Errorf("Expected subPath 'music' from the driver name annotation, got %q", got)
+	}
+}
+
+func TestCreateVolume_AllowedParameters(t *testing.T) {
+	tests := []struct {
+		name     string
+		allowed  []string
+		params   map[string]string
+		wantCode codes.Code
+	}{
+		{
+			name:   "no allowlist accepts everything",
+			params: map[string]string{"server": "192.168.1.100", "share": "/exports/data", "subPath": "app"},
+		},
+		{
+			name:    "allowed keys",
+			allowed: []string{"server", "share", "subPath"},
+			params:  map[string]string{"server": "192.168.1.100", "share": "/exports/data", "subPath": "app"},
+		},
+		{
+			name:    "provisioner keys are always allowed",
+			allowed: []string{"server", "share"},
+			params: map[string]string{
+				"server":                           "192.168.1.100",
+				"share":                            "/exports/data",
+				"csi.storage.k8s.io/pvc/name":      "data",
+				"csi.storage.k8s.io/pvc/namespace": "default",
+			},
+		},
+		{
+			name:     "disallowed key",
+			allowed:  []string{"subPath"},
+			params:   map[string]string{"server": "192.168.1.100", "share": "/exports/data", "subPath": "app"},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithAllowedParameters(tt.allowed))
+			if err != nil {
+				t.Fatalf("Failed to create driver: %v", err)
+			}
+
+			_, err = driver.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name: "test-volume",
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+						},
+					},
+				},
+				Parameters: tt.params,
+			})
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("Expected code %v, got %v", tt.wantCode, err)
+			}
+			if tt.wantCode != codes.OK && !strings.Contains(err.Error(), "server, share") {
+				t.Errorf("Expected the disallowed keys in the error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCreateVolume_ForceServer(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithForceServer("10.0.0.1"))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	for _, params := range []map[string]string{
+		{"server": "203.0.113.7", "share": "/exports/data"},
+		{"servers": "203.0.113.7,203.0.113.8", "share": "/exports/data"},
+		{"share": "/exports/data"},
+	} {
+		resp, err := driver.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+			Name: "test-volume",
+			VolumeCapabilities: []*csi.VolumeCapability{
+				{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{},
+					},
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+					},
+				},
+			},
+			Parameters: params,
+		})
+		if err != nil {
+			t.Fatalf("CreateVolume with %v failed: %v", params, err)
+		}
+		volumeContext := resp.Volume.VolumeContext
+		if volumeContext["server"] != "10.0.0.1" || volumeContext["servers"] != "" {
+			t.Errorf("Expected forced server in volume context for %v, got %v", params, volumeContext)
+		}
+	}
+}
+
+func TestCreateVolume_AnnotationMountOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		denied   []string
+		want     string
+		wantCode codes.Code
+	}{
+		{name: "passed to the node", want: "noatime,rsize=1048576"},
+		{name: "checked against the mount option policy", denied: []string{"rsize"}, wantCode: codes.InvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithDeniedMountOptions(tt.denied))
+			if err != nil {
+				t.Fatalf("Failed to create driver: %v", err)
+			}
+
+			resp, err := driver.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name: "test-volume",
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+						},
+					},
+				},
+				Parameters: map[string]string{
+					"server":                             "192.168.1.100",
+					"share":                              "/exports/data",
+					"csi.storage.k8s.io/pvc/annotations": `{"nfs.csi.takutakahashi.dev/mountOptions":"noatime, rsize=1048576"}`,
+				},
+			})
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("Expected code %v, got %v", tt.wantCode, err)
+			}
+			if tt.wantCode != codes.OK {
+				return
+			}
+			if got := resp.Volume.VolumeContext["annotationMountOptions"]; got != tt.want {
+				t.Errorf("Expected annotation mount options %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCreateVolume_MountProfile(t *testing.T) {
+	profiles := map[string]string{"bulk": "nfsvers=4.1, hard,rsize=1048576"}
+	tests := []struct {
+		name     string
+		profile  string
+		denied   []string
+		want     string
+		wantCode codes.Code
+	}{
+		{name: "resolved into the volume context", profile: "bulk", want: "nfsvers=4.1,hard,rsize=1048576"},
+		{name: "unknown profile", profile: "fast", wantCode: codes.InvalidArgument},
+		{name: "checked against the mount option policy", profile: "bulk", denied: []string{"rsize"}, wantCode: codes.InvalidArgument},
+		{name: "no profile"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock",
+				WithMountProfiles(profiles), WithDeniedMountOptions(tt.denied))
+			if err != nil {
+				t.Fatalf("Failed to create driver: %v", err)
+			}
+
+			params := map[string]string{"server": "192.168.1.100", "share": "/exports/data"}
+			if tt.profile != "" {
+				params["mountProfile"] = tt.profile
+			}
+			resp, err := driver.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name: "test-volume",
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+						},
+					},
+				},
+				Parameters: params,
+			})
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("Expected code %v, got %v", tt.wantCode, err)
+			}
+			if tt.wantCode != codes.OK {
+				return
+			}
+			if got := resp.Volume.VolumeContext["profileMountOptions"]; got != tt.want {
+				t.Errorf("Expected profile mount options %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
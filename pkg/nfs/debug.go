@@ -0,0 +1,186 @@
+package nfs
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// debugState is the driver's view of its volumes and mounts, served as JSON
+// by DebugHandler. Values that may carry credentials are redacted.
+type debugState struct {
+	Config debugConfig `json:"config"`
+	// Volumes are the volumes known from ephemeral provisioning
+	Volumes   []debugVolume `json:"volumes"`
+	Published []debugTarget `json:"published"`
+	// Staged lists the pod targets bind-mounted from each staging path, and
+	// Shared the staging paths and targets using each shared mount
+	Staged []debugRefs  `json:"staged"`
+	Shared []debugRefs  `json:"shared"`
+	Counts VolumeCounts `json:"counts"`
+}
+
+// debugConfig is the configuration the driver was started with
+type debugConfig struct {
+	Name                  string   `json:"name"`
+	NodeID                string   `json:"nodeID"`
+	Version               string   `json:"version"`
+	Mode                  string   `json:"mode"`
+	Endpoint              string   `json:"endpoint"`
+	VolumeIDFormat        string   `json:"volumeIDFormat"`
+	ForceServer           string   `json:"forceServer,omitempty"`
+	ResolveServer         bool     `json:"resolveServer"`
+	PrecheckServer        bool     `json:"precheckServer"`
+	AllowedMountOptions   []string `json:"allowedMountOptions,omitempty"`
+	DeniedMountOptions    []string `json:"deniedMountOptions,omitempty"`
+	AllowedParameters     []string `json:"allowedParameters,omitempty"`
+	MountProfiles         []string `json:"mountProfiles,omitempty"`
+	EphemeralProvisioning bool     `json:"ephemeralProvisioning"`
+	Snapshots             bool     `json:"snapshots"`
+	Capacity              bool     `json:"capacity"`
+	VolumeExpansion       bool     `json:"volumeExpansion"`
+	VolumeStats           bool     `json:"volumeStats"`
+	VolumeStatsDU         bool     `json:"volumeStatsDU"`
+	Staging               bool     `json:"staging"`
+	SharedMountDir        string   `json:"sharedMountDir,omitempty"`
+	RemountInterval       string   `json:"remountInterval,omitempty"`
+	UnmountRetries        int      `json:"unmountRetries"`
+	MountRate             float64  `json:"mountRate"`
+	MountBurst            int      `json:"mountBurst"`
+	KeepTargetPath        bool     `json:"keepTargetPath"`
+	MaxVolumesPerNode     int64    `json:"maxVolumesPerNode"`
+}
+
+// debugVolume is a volume created by CreateVolume
+type debugVolume struct {
+	Name          string            `json:"name"`
+	VolumeID      string            `json:"volumeID"`
+	CapacityBytes int64             `json:"capacityBytes"`
+	Context       map[string]string `json:"context,omitempty"`
+}
+
+// debugTarget is an NFS mount made directly at a pod target path
+type debugTarget struct {
+	Target   string   `json:"target"`
+	VolumeID string   `json:"volumeID"`
+	Sources  []string `json:"sources"`
+	FSType   string   `json:"fsType"`
+	Options  []string `json:"options"`
+}
+
+// debugRefs is a mount and the paths holding a reference to it
+type debugRefs struct {
+	Path  string   `json:"path"`
+	Count int      `json:"count"`
+	Users []string `json:"users"`
+}
+
+// DebugHandler serves the driver's state as JSON on /debug/state, for
+// inspecting a node plugin without a shell in its pod
+func (d *Driver) DebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/state", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(d.snapshotState())
+	})
+	return mux
+}
+
+// snapshotState returns a snapshot of the driver's configuration and state
+func (d *Driver) snapshotState() debugState {
+	state := debugState{
+		Config:    d.configState(),
+		Volumes:   []debugVolume{},
+		Published: []debugTarget{},
+		Counts:    d.VolumeCounts(),
+	}
+
+	d.volumes.mu.Lock()
+	for name, volume := range d.volumes.volumes {
+		state.Volumes = append(state.Volumes, debugVolume{
+			Name:          name,
+			VolumeID:      volume.GetVolumeId(),
+			CapacityBytes: volume.GetCapacityBytes(),
+			Context:       redactParameters(volume.GetVolumeContext()),
+		})
+	}
+	d.volumes.mu.Unlock()
+	sort.Slice(state.Volumes, func(i, j int) bool { return state.Volumes[i].Name < state.Volumes[j].Name })
+
+	d.published.mu.Lock()
+	for target, vol := range d.published.targets {
+		state.Published = append(state.Published, debugTarget{
+			Target:   target,
+			VolumeID: vol.volumeID,
+			Sources:  vol.sources,
+			FSType:   vol.fsType,
+			Options:  redactMountOptions(vol.options),
+		})
+	}
+	d.published.mu.Unlock()
+	sort.Slice(state.Published, func(i, j int) bool { return state.Published[i].Target < state.Published[j].Target })
+
+	d.staged.mu.Lock()
+	state.Staged = refState(d.staged.refs)
+	state.Shared = refState(d.staged.shared.refs)
+	d.staged.mu.Unlock()
+
+	return state
+}
+
+// configState collects the driver's configuration
+func (d *Driver) configState() debugConfig {
+	config := debugConfig{
+		Name:                  d.name,
+		NodeID:                d.nodeID,
+		Version:               d.version,
+		Mode:                  d.mode,
+		Endpoint:              d.endpoint,
+		VolumeIDFormat:        d.volumeIDFormat,
+		ForceServer:           d.forceServer,
+		ResolveServer:         d.resolveServer,
+		PrecheckServer:        d.precheckServer,
+		AllowedMountOptions:   d.allowedMountOptions,
+		DeniedMountOptions:    d.deniedMountOptions,
+		AllowedParameters:     d.allowedParameters,
+		EphemeralProvisioning: d.ephemeralProvisioning,
+		Snapshots:             d.snapshotsEnabled,
+		Capacity:              d.capacityEnabled,
+		VolumeExpansion:       d.volumeExpansion,
+		VolumeStats:           d.volumeStats,
+		VolumeStatsDU:         d.volumeStatsDU,
+		Staging:               d.staging,
+		SharedMountDir:        d.sharedMountDir,
+		UnmountRetries:        d.unmountRetries,
+		MountRate:             d.mountRate,
+		MountBurst:            d.mountBurst,
+		KeepTargetPath:        d.keepTargetPath,
+		MaxVolumesPerNode:     d.maxVolumesPerNode,
+	}
+	if d.remountInterval > 0 {
+		config.RemountInterval = d.remountInterval.String()
+	}
+	// Only the profile names: their options are in the ConfigMap
+	for name := range d.mountProfiles {
+		config.MountProfiles = append(config.MountProfiles, name)
+	}
+	sort.Strings(config.MountProfiles)
+	return config
+}
+
+// refState lists reference sets sorted by path
+func refState(refs map[string]map[string]struct{}) []debugRefs {
+	list := []debugRefs{}
+	for path, users := range refs {
+		entry := debugRefs{Path: path, Count: len(users), Users: make([]string, 0, len(users))}
+		for user := range users {
+			entry.Users = append(entry.Users, user)
+		}
+		sort.Strings(entry.Users)
+		list = append(list, entry)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	return list
+}
@@ -0,0 +1,83 @@
+package nfs
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+func TestDebugHandler(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock",
+		WithEphemeralProvisioning(true), WithStaging(true), WithRemountInterval(time.Minute),
+		WithMountProfiles(map[string]string{"fast": "nconnect=8"}))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	if _, err := driver.volumes.create("pvc-1", &csi.Volume{
+		VolumeId:      "pvc-1",
+		CapacityBytes: 1 << 30,
+		VolumeContext: map[string]string{
+			"server":          "192.168.1.1",
+			pvcAnnotationsKey: `{"vault.example.com/secret":"hunter2"}`,
+		},
+	}); err != nil {
+		t.Fatalf("Failed to store volume: %v", err)
+	}
+	driver.published.add("/pods/a/volume", publishedVolume{
+		volumeID: "pvc-1",
+		sources:  []string{"192.168.1.1:/data"},
+		fsType:   "nfs",
+		options:  []string{"vers=4.1", "password=hunter2"},
+	})
+	driver.staged.mu.Lock()
+	driver.staged.addLocked("/staging/pvc-1", "/pods/b/volume")
+	driver.staged.addLocked("/staging/pvc-1", "/pods/c/volume")
+	driver.staged.mu.Unlock()
+
+	rec := httptest.NewRecorder()
+	driver.DebugHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/state", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), "hunter2") {
+		t.Errorf("Expected secrets to be redacted, got %s", rec.Body.String())
+	}
+
+	var got debugState
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Failed to decode %q: %v", rec.Body.String(), err)
+	}
+	if got.Config.NodeID != "test-node" || !got.Config.Staging || got.Config.RemountInterval != "1m0s" {
+		t.Errorf("Unexpected config %+v", got.Config)
+	}
+	if !reflect.DeepEqual(got.Config.MountProfiles, []string{"fast"}) {
+		t.Errorf("Expected only the profile names, got %v", got.Config.MountProfiles)
+	}
+	if len(got.Volumes) != 1 || got.Volumes[0].VolumeID != "pvc-1" || got.Volumes[0].Context["server"] != "192.168.1.1" {
+		t.Errorf("Unexpected volumes %+v", got.Volumes)
+	}
+	wantPublished := []debugTarget{{
+		Target:   "/pods/a/volume",
+		VolumeID: "pvc-1",
+		Sources:  []string{"192.168.1.1:/data"},
+		FSType:   "nfs",
+		Options:  []string{"vers=4.1", "password=" + redactedValue},
+	}}
+	if !reflect.DeepEqual(got.Published, wantPublished) {
+		t.Errorf("Expected published %+v, got %+v", wantPublished, got.Published)
+	}
+	wantStaged := []debugRefs{{Path: "/staging/pvc-1", Count: 2, Users: []string{"/pods/b/volume", "/pods/c/volume"}}}
+	if !reflect.DeepEqual(got.Staged, wantStaged) {
+		t.Errorf("Expected staged %+v, got %+v", wantStaged, got.Staged)
+	}
+	if len(got.Shared) != 0 {
+		t.Errorf("Expected no shared mounts, got %+v", got.Shared)
+	}
+}
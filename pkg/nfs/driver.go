@@ -1,13 +1,23 @@
 package nfs
 
 import (
+	"errors"
+	"fmt"
 	"net"
 	"net/url"
 	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/time/rate"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 	"k8s.io/mount-utils"
 )
@@ -16,13 +26,173 @@ const (
 	DefaultDriverName = "nfs.csi.takutakahashi.dev"
 	DriverVersion     = "1.0.0"
 
+	// DefaultTargetPathMode is the permission of target directories created for pods
+	DefaultTargetPathMode os.FileMode = 0750
+
 	// Volume context keys
 	ParamServer  = "server"
 	ParamShare   = "share"
 	ParamSubPath = "subPath"
 
-	// PVC annotation key for subPath
+	// ParamRawShare passes share to mount verbatim, without adding a leading slash
+	ParamRawShare = "rawShare"
+
+	// ParamServers lists NFS servers exporting identical data, tried in order
+	ParamServers = "servers"
+
+	// ParamShares lists several exports, each mounted in its own directory
+	// of the target, instead of a single share
+	ParamShares = "shares"
+
+	// ParamSubPathPrefix is a StorageClass-controlled prefix every subPath is placed under
+	ParamSubPathPrefix = "subPathPrefix"
+
+	// ParamAllowAbsoluteSubPath permits absolute subPaths from PVC annotations
+	ParamAllowAbsoluteSubPath = "allowAbsoluteSubPath"
+
+	// ParamFSGroupPolicy selects how the pod fsGroup is applied (File or None)
+	ParamFSGroupPolicy = "fsGroupPolicy"
+
+	// Mount recovery behavior when the server becomes unreachable
+	ParamMountRecovery  = "mountRecovery"
+	ParamRetrans        = "retrans"
+	ParamTimeo          = "timeo"
+	ParamForceSoftMount = "forceSoftMount"
+
+	// ParamEnableFscache adds the fsc mount option to cache reads on local
+	// disk through cachefilesd. Refused for writable access modes unless
+	// ParamForceFscache is set.
+	ParamEnableFscache = "enableFscache"
+	ParamForceFscache  = "forceFscache"
+
+	// ParamPort is the NFS server port, passed as the port= mount option
+	ParamPort = "port"
+
+	// ParamFSType selects the filesystem type passed to mount (nfs or nfs4)
+	ParamFSType = "fsType"
+
+	// Boolean convenience parameters for common mount options
+	ParamNoatime    = "noatime"
+	ParamNodiratime = "nodiratime"
+	ParamSync       = "sync"
+
+	// ParamTransport selects the network transport, passed as proto=
+	ParamTransport = "transport"
+
+	// ParamSecurity pins the security flavor, passed as sec=
+	ParamSecurity = "security"
+
+	// ParamLocalLock selects which locks stay local to the client, passed as
+	// local_lock=. Setting it drops the default nolock.
+	ParamLocalLock = "localLock"
+
+	// ParamAttributeCache selects a preset for the attribute cache timeouts
+	ParamAttributeCache = "attributeCache"
+
+	// ParamClientAddr is the address the NFS server sends NFSv4 callbacks to,
+	// passed as clientaddr=; ClientAddrAuto uses the node's address towards
+	// the server
+	ParamClientAddr = "clientAddr"
+
+	// ParamNconnect is the number of TCP connections to the server, passed
+	// as nconnect=
+	ParamNconnect = "nconnect"
+
+	// ParamReservedPort makes the client use a privileged source port
+	// (resvport) or any port (noresvport); unset keeps the kernel default
+	ParamReservedPort = "reservedPort"
+
+	// ParamReadOnly forces a read-only mount regardless of the pod spec
+	ParamReadOnly = "readOnly"
+
+	// ParamWritableSubPath names a directory of a read-only volume that is
+	// bind-mounted read-write over the read-only view (requires staging)
+	ParamWritableSubPath = "writableSubPath"
+
+	// ParamMountOptions holds comma-separated mount options added after the
+	// StorageClass mountOptions, for StorageClasses that carry them as a
+	// parameter
+	ParamMountOptions = "mountOptions"
+
+	// Comma-separated mount options used instead of the StorageClass
+	// mountOptions for ReadWriteMany and ReadOnlyMany volumes
+	ParamMountOptionsRWX = "mountOptionsRWX"
+	ParamMountOptionsROX = "mountOptionsROX"
+
+	// ParamSELinuxContext is the SELinux label the export is mounted with
+	// (context= mount option) when kubelet does not pass one
+	ParamSELinuxContext = "selinuxContext"
+
+	// ParamTargetMode overrides --target-path-mode for the target
+	// directories created for this volume
+	ParamTargetMode = "targetMode"
+
+	// ParamCreateTarget set to false makes NodePublishVolume expect the CO
+	// to have created the target directory instead of creating it
+	ParamCreateTarget = "createTarget"
+
+	// ParamDedupeByPath set to true gives volumes on the same servers, share
+	// and subPath the same volume ID, derived from the path instead of the
+	// volume name
+	ParamDedupeByPath = "dedupeByPath"
+
+	// ParamMountPropagation sets the propagation of the target mount after
+	// mounting, e.g. rshared for sidecars that mount below it
+	ParamMountPropagation = "mountPropagation"
+
+	// ParamMountOptionsMode selects whether the volume's mount options are
+	// added to the driver defaults (append) or used instead of them (replace)
+	ParamMountOptionsMode = "mountOptionsMode"
+
+	// ParamValidateOnly makes NodePublishVolume validate the request without mounting
+	ParamValidateOnly = "validateOnly"
+
+	// fsGroup policies
+	FSGroupPolicyFile = "File"
+	FSGroupPolicyNone = "None"
+
+	// Filesystem types
+	FSTypeNFS  = "nfs"
+	FSTypeNFS4 = "nfs4"
+
+	// Mount options modes
+	MountOptionsModeAppend  = "append"
+	MountOptionsModeReplace = "replace"
+
+	// Attribute cache presets
+	AttributeCacheDefault    = "default"
+	AttributeCacheAggressive = "aggressive"
+	AttributeCacheDisabled   = "disabled"
+
+	// ClientAddrAuto makes the node pick its clientaddr itself
+	ClientAddrAuto = "auto"
+
+	// Mount recovery modes
+	MountRecoveryHard      = "hard"
+	MountRecoverySoft      = "soft"
+	MountRecoverySoftReval = "softreval"
+
+	// Driver modes select which CSI services are served
+	ModeAll        = "all"
+	ModeNode       = "node"
+	ModeController = "controller"
+
+	// Legacy PVC annotation key for subPath. The driver also accepts
+	// <drivername>/subPath, which differs when --drivername is overridden.
 	AnnotationSubPath = "nfs.csi.takutakahashi.dev/subPath"
+
+	// ParamMountProfile names a set of mount options from the mount profiles
+	// ConfigMap; CreateVolume resolves it into ParamProfileMountOptions
+	ParamMountProfile        = "mountProfile"
+	ParamProfileMountOptions = "profileMountOptions"
+
+	// ParamCapacityBytes carries the capacity requested in CreateVolume, so
+	// NodeGetVolumeStats can report it as the volume's total size
+	ParamCapacityBytes = "capacityBytes"
+
+	// ParamAnnotationMountOptions carries the mount options from the
+	// <drivername>/mountOptions PVC annotation in the volume context
+	ParamAnnotationMountOptions = "annotationMountOptions"
 )
 
 type Driver struct {
@@ -34,9 +204,114 @@ type Driver struct {
 	nodeID   string
 	endpoint string
 	version  string
+	mode     string
+	// logLevels overrides -v per subsystem, see WithLogLevels
+	logLevels map[string]klog.Level
+
+	srv        *grpc.Server
+	mounter    mount.Interface
+	filesystem Filesystem
+
+	// socketMode is applied to a unix socket endpoint when socketModeSet
+	socketMode    os.FileMode
+	socketModeSet bool
+	// keepaliveParams and keepalivePolicy tune gRPC keepalive; zero fields
+	// keep the gRPC defaults
+	keepaliveParams keepalive.ServerParameters
+	keepalivePolicy keepalive.EnforcementPolicy
+	// maxVolumesPerNode is reported to the scheduler; 0 means unlimited
+	maxVolumesPerNode int64
+	// targetPathMode is the permission of target directories created for pods
+	targetPathMode os.FileMode
+
+	allowedMountOptions []string
+	deniedMountOptions  []string
+	allowedParameters   []string
+
+	resolveServer bool
+	// precheckServer makes CreateVolume dial the server, for at most
+	// precheckTimeout per server
+	precheckServer  bool
+	precheckTimeout time.Duration
+	forceServer     string
+	volumeExpansion bool
+	volumeIDFormat  string
+
+	// annotationPrefix replaces the driver name in PVC annotation keys
+	annotationPrefix string
+
+	// ephemeralProvisioning tracks created volumes in memory, for tests
+	ephemeralProvisioning bool
+	volumes               volumeStore
+	volumeCounts          volumeCounts
+
+	// snapshotsEnabled turns on directory-copy snapshots
+	snapshotsEnabled bool
+	snapshots        snapshotStore
+
+	// mountProfiles maps mountProfile names to comma-separated mount options
+	mountProfiles map[string]string
+	// namespaceServers maps namespaces to the servers they may use; nil
+	// means unrestricted
+	namespaceServers map[string]string
+
+	capacityEnabled bool
+	capacityTTL     time.Duration
+	capacity        capacityCache
+
+	// volumeCondition reports server reachability in ListVolumes, cached
+	// for conditionTTL and dialing each server for at most conditionTimeout
+	volumeCondition  bool
+	conditionTTL     time.Duration
+	conditionTimeout time.Duration
+	reachability     reachabilityCache
+
+	recorder record.EventRecorder
+	events   eventDeduper
+
+	staging        bool
+	staged         stagedMounts
+	sharedMountDir string
+	kubeletDir     string
+
+	published       publishedTargets
+	remountInterval time.Duration
+	// remountStaleOnPublish unmounts a stale target in NodePublishVolume
+	// so it is mounted again instead of reported as published
+	remountStaleOnPublish bool
+
+	unmountRetries int
+	unmountBackoff time.Duration
+
+	// mountLimiter paces NFS mounts on this node; nil means unlimited
+	mountRate    float64
+	mountBurst   int
+	mountLimiter *rate.Limiter
+
+	volumeStats   bool
+	volumeStatsDU bool
+	capacities    volumeCapacities
+
+	// keepTargetPath leaves the target directory in place on unpublish
+	keepTargetPath bool
 
-	srv     *grpc.Server
-	mounter mount.Interface
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	mountHelperOnce sync.Once
+	mountHelperErr  error
+
+	// fscacheUsed is set once a volume is mounted with fsc, so Probe only
+	// checks for cachefilesd on nodes that need it
+	fscacheUsed   atomic.Bool
+	fscacheWarned atomic.Bool
+	// nconnectWarned is set once nconnect was found unsupported by the kernel
+	nconnectWarned atomic.Bool
+
+	// serving is set once the gRPC server accepts connections and cleared on shutdown
+	serving          atomic.Bool
+	registrationPath string
+	registered       atomic.Bool
 
 	mu sync.Mutex
 }
@@ -51,6 +326,126 @@ func WithMounter(m mount.Interface) DriverOption {
 	}
 }
 
+// WithMode selects which CSI services the driver serves (all, node or controller)
+func WithMode(mode string) DriverOption {
+	return func(d *Driver) {
+		d.mode = mode
+	}
+}
+
+// WithSocketMode sets the permissions applied to a unix socket endpoint.
+// Without it the socket keeps the mode the umask gives it.
+func WithSocketMode(mode os.FileMode) DriverOption {
+	return func(d *Driver) {
+		d.socketMode = mode
+		d.socketModeSet = true
+	}
+}
+
+// WithKeepalive sets the gRPC server keepalive parameters and the policy for
+// client pings. Zero fields keep the gRPC defaults.
+func WithKeepalive(params keepalive.ServerParameters, policy keepalive.EnforcementPolicy) DriverOption {
+	return func(d *Driver) {
+		d.keepaliveParams = params
+		d.keepalivePolicy = policy
+	}
+}
+
+// WithTargetPathMode sets the permissions of target directories created for pods
+func WithTargetPathMode(mode os.FileMode) DriverOption {
+	return func(d *Driver) {
+		d.targetPathMode = mode
+	}
+}
+
+// WithUnmountRetry retries a failed unmount in NodeUnpublishVolume up to
+// retries times, waiting backoff (doubled each time, plus jitter) in between
+func WithUnmountRetry(retries int, backoff time.Duration) DriverOption {
+	return func(d *Driver) {
+		d.unmountRetries = retries
+		d.unmountBackoff = backoff
+	}
+}
+
+// WithMountProfiles sets the mount option profiles a StorageClass can name
+// in its mountProfile parameter
+func WithMountProfiles(profiles map[string]string) DriverOption {
+	return func(d *Driver) {
+		d.mountProfiles = profiles
+	}
+}
+
+// WithMountRateLimit limits this node to mountsPerSecond NFS mounts, with
+// bursts of up to burst mounts. Zero disables the limit.
+func WithMountRateLimit(mountsPerSecond float64, burst int) DriverOption {
+	return func(d *Driver) {
+		d.mountRate = mountsPerSecond
+		d.mountBurst = burst
+	}
+}
+
+// WithKeepTargetOnUnpublish makes NodeUnpublishVolume unmount the target but
+// leave its directory in place for the next publish
+func WithKeepTargetOnUnpublish(keep bool) DriverOption {
+	return func(d *Driver) {
+		d.keepTargetPath = keep
+	}
+}
+
+// WithMaxVolumesPerNode sets the number of volumes the scheduler may place on
+// this node (0 means unlimited)
+func WithMaxVolumesPerNode(max int64) DriverOption {
+	return func(d *Driver) {
+		d.maxVolumesPerNode = max
+	}
+}
+
+// WithAllowedMountOptions restricts user-supplied mount options to the given names
+func WithAllowedMountOptions(options []string) DriverOption {
+	return func(d *Driver) {
+		d.allowedMountOptions = options
+	}
+}
+
+// WithDeniedMountOptions rejects user-supplied mount options with the given names
+func WithDeniedMountOptions(options []string) DriverOption {
+	return func(d *Driver) {
+		d.deniedMountOptions = options
+	}
+}
+
+// WithAllowedParameters restricts the StorageClass parameters CreateVolume
+// accepts to the given keys. Empty allows every parameter.
+func WithAllowedParameters(keys []string) DriverOption {
+	return func(d *Driver) {
+		d.allowedParameters = keys
+	}
+}
+
+// WithForceServer makes every volume use the given NFS server, whatever the
+// StorageClass or a hand-made PV asks for. Empty keeps the requested server.
+func WithForceServer(server string) DriverOption {
+	return func(d *Driver) {
+		d.forceServer = server
+	}
+}
+
+// WithAnnotationPrefix reads PVC annotations such as subPath under
+// prefix + "/" instead of the driver name. Annotations under the driver name
+// are still accepted. Empty keeps the driver name.
+func WithAnnotationPrefix(prefix string) DriverOption {
+	return func(d *Driver) {
+		d.annotationPrefix = prefix
+	}
+}
+
+// WithVolumeExpansion advertises online volume expansion so the external-resizer engages
+func WithVolumeExpansion(enabled bool) DriverOption {
+	return func(d *Driver) {
+		d.volumeExpansion = enabled
+	}
+}
+
 func NewDriver(name, nodeID, endpoint string, opts ...DriverOption) (*Driver, error) {
 	klog.Infof("Creating new NFS CSI driver: name=%s, nodeID=%s", name, nodeID)
 
@@ -59,48 +454,255 @@ func NewDriver(name, nodeID, endpoint string, opts ...DriverOption) (*Driver, er
 		nodeID:   nodeID,
 		endpoint: endpoint,
 		version:  DriverVersion,
-		mounter:  mount.New(""),
+		mode:     ModeAll,
+		mounter:  newExecMounter(mount.New("")),
+		stop:     make(chan struct{}),
+
+		filesystem:     osFilesystem{},
+		targetPathMode: DefaultTargetPathMode,
+		volumeIDFormat: VolumeIDFormatName,
+		kubeletDir:     DefaultKubeletDir,
+
+		remountStaleOnPublish: true,
 	}
 
 	for _, opt := range opts {
 		opt(d)
 	}
 
+	if _, _, err := parseEndpoint(d.endpoint); err != nil {
+		return nil, err
+	}
+
+	switch d.mode {
+	case ModeAll, ModeNode, ModeController:
+	default:
+		return nil, fmt.Errorf("invalid driver mode %q: must be %s, %s or %s", d.mode, ModeAll, ModeNode, ModeController)
+	}
+
+	if d.forceServer != "" {
+		if err := validateServer(d.forceServer); err != nil {
+			return nil, fmt.Errorf("invalid forced server: %w", err)
+		}
+	}
+
+	// Mode 0000 would lock kubelet and the sidecars out of the socket
+	if d.socketModeSet && d.socketMode.Perm() == 0 {
+		return nil, fmt.Errorf("invalid socket mode %#o: no one could connect to the socket", d.socketMode)
+	}
+
+	if d.annotationPrefix != "" {
+		if errs := validation.IsDNS1123Subdomain(d.annotationPrefix); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid annotation prefix %q: %s", d.annotationPrefix, strings.Join(errs, ", "))
+		}
+	}
+
+	switch d.volumeIDFormat {
+	case VolumeIDFormatName, VolumeIDFormatStructured:
+	default:
+		return nil, fmt.Errorf("invalid volume ID format %q: must be %s or %s", d.volumeIDFormat, VolumeIDFormatName, VolumeIDFormatStructured)
+	}
+
+	if d.unmountRetries < 0 || (d.unmountRetries > 0 && d.unmountBackoff <= 0) {
+		return nil, fmt.Errorf("invalid unmount retry: %d retries with backoff %v", d.unmountRetries, d.unmountBackoff)
+	}
+
+	if d.mountRate < 0 || (d.mountRate > 0 && d.mountBurst < 1) {
+		return nil, fmt.Errorf("invalid mount rate limit %v/s with burst %d: the rate must not be negative and the burst must be at least 1", d.mountRate, d.mountBurst)
+	}
+	if d.mountRate > 0 {
+		d.mountLimiter = rate.NewLimiter(rate.Limit(d.mountRate), d.mountBurst)
+	}
+
+	if d.maxVolumesPerNode < 0 {
+		return nil, fmt.Errorf("invalid max volumes per node %d: must not be negative", d.maxVolumesPerNode)
+	}
+
+	if d.precheckServer && d.precheckTimeout <= 0 {
+		return nil, fmt.Errorf("invalid server precheck timeout %v: must be positive", d.precheckTimeout)
+	}
+	if d.volumeCondition {
+		if d.conditionTimeout <= 0 {
+			return nil, fmt.Errorf("invalid volume condition timeout %v: must be positive", d.conditionTimeout)
+		}
+		if d.conditionTTL < 0 {
+			return nil, fmt.Errorf("invalid volume condition cache TTL %v: must not be negative", d.conditionTTL)
+		}
+	}
+
+	for _, v := range []time.Duration{d.keepaliveParams.MaxConnectionIdle, d.keepaliveParams.Time, d.keepaliveParams.Timeout, d.keepalivePolicy.MinTime} {
+		if v < 0 {
+			return nil, fmt.Errorf("invalid keepalive settings %+v, %+v: durations must not be negative", d.keepaliveParams, d.keepalivePolicy)
+		}
+	}
+
+	// kubelet must still be able to enter and list the target directory
+	if d.targetPathMode&0700 != 0700 {
+		return nil, fmt.Errorf("invalid target path mode %#o: the owner needs read, write and execute permission", d.targetPathMode)
+	}
+
 	return d, nil
 }
 
+// serverOptions returns the options of the gRPC server, leaving keepalive to
+// the gRPC defaults unless it was configured
+func (d *Driver) serverOptions() []grpc.ServerOption {
+	opts := []grpc.ServerOption{grpc.UnaryInterceptor(d.logGRPC)}
+	if d.keepaliveParams != (keepalive.ServerParameters{}) {
+		opts = append(opts, grpc.KeepaliveParams(d.keepaliveParams))
+	}
+	if d.keepalivePolicy != (keepalive.EnforcementPolicy{}) {
+		opts = append(opts, grpc.KeepaliveEnforcementPolicy(d.keepalivePolicy))
+	}
+	return opts
+}
+
+// parseEndpoint splits a unix:///path/to/socket or tcp://host:port endpoint
+// into the network and address to listen on
+func parseEndpoint(endpoint string) (string, string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid endpoint %q: %w", endpoint, err)
+	}
+
+	switch u.Scheme {
+	case "unix":
+		// unix://csi.sock would silently take "csi.sock" as a host
+		if u.Host != "" {
+			return "", "", fmt.Errorf("invalid endpoint %q: unix endpoints take an absolute path, e.g. unix:///csi/csi.sock", endpoint)
+		}
+		if u.Path == "" || u.Path == "/" {
+			return "", "", fmt.Errorf("invalid endpoint %q: the socket path is empty", endpoint)
+		}
+		return u.Scheme, u.Path, nil
+	case "tcp":
+		if u.Path != "" && u.Path != "/" {
+			return "", "", fmt.Errorf("invalid endpoint %q: tcp endpoints take no path, e.g. tcp://0.0.0.0:10000", endpoint)
+		}
+		if _, port, err := net.SplitHostPort(u.Host); err != nil || port == "" {
+			return "", "", fmt.Errorf("invalid endpoint %q: tcp endpoints need host:port, e.g. tcp://0.0.0.0:10000", endpoint)
+		}
+		return u.Scheme, u.Host, nil
+	default:
+		return "", "", fmt.Errorf("invalid endpoint %q: the scheme must be unix or tcp", endpoint)
+	}
+}
+
+// WithResolveServer makes CreateVolume check that the server name resolves
+func WithResolveServer(enabled bool) DriverOption {
+	return func(d *Driver) {
+		d.resolveServer = enabled
+	}
+}
+
 func (d *Driver) Run() error {
-	u, err := url.Parse(d.endpoint)
+	scheme, addr, err := parseEndpoint(d.endpoint)
+	if err != nil {
+		return err
+	}
+	listener, err := listenEndpoint(scheme, addr)
 	if err != nil {
 		return err
 	}
 
-	var addr string
-	if u.Scheme == "unix" {
-		addr = u.Path
-		if err := os.Remove(addr); err != nil && !os.IsNotExist(err) {
+	if d.socketModeSet {
+		if scheme == "unix" {
+			if err := os.Chmod(addr, d.socketMode); err != nil {
+				_ = listener.Close()
+				return fmt.Errorf("failed to set socket mode %#o on %s: %w", d.socketMode, addr, err)
+			}
+		} else {
+			klog.Warningf("Ignoring socket mode %#o for non-unix endpoint %s", d.socketMode, d.endpoint)
+		}
+	}
+
+	if d.servesNode() && d.staging && d.sharedMountDir != "" {
+		if err := d.rebuildSharedMounts(); err != nil {
+			_ = listener.Close()
 			return err
 		}
-	} else {
-		addr = u.Host
 	}
 
-	listener, err := net.Listen(u.Scheme, addr)
-	if err != nil {
-		return err
+	if d.servesNode() && d.remountInterval > 0 {
+		go d.runRemountLoop(d.stop)
+	}
+
+	srv := grpc.NewServer(d.serverOptions()...)
+
+	csi.RegisterIdentityServer(srv, d)
+	if d.servesNode() {
+		csi.RegisterNodeServer(srv, d)
+	}
+	if d.servesController() {
+		csi.RegisterControllerServer(srv, d)
+	}
+
+	d.mu.Lock()
+	d.srv = srv
+	d.mu.Unlock()
+
+	klog.Infof("Listening on %s (mode: %s)", d.endpoint, d.mode)
+	d.serving.Store(true)
+	return srv.Serve(listener)
+}
+
+// listen is net.Listen, replaced in tests
+var listen = net.Listen
+
+// listenEndpoint listens on addr. A unix socket left behind by a crashed
+// process is removed first, and removed and listened on once more if the
+// address is still in use, so an unclean restart does not crash loop.
+func listenEndpoint(scheme, addr string) (net.Listener, error) {
+	if scheme != "unix" {
+		return listen(scheme, addr)
+	}
+
+	if err := removeStaleSocket(addr); err != nil {
+		return nil, err
+	}
+	listener, err := listen(scheme, addr)
+	if err == nil || !errors.Is(err, syscall.EADDRINUSE) {
+		return listener, err
 	}
 
-	d.srv = grpc.NewServer(grpc.UnaryInterceptor(logGRPC))
+	klog.Warningf("Socket %s is still in use after cleanup, removing it again: %v", addr, err)
+	if err := removeStaleSocket(addr); err != nil {
+		return nil, err
+	}
+	listener, err = listen(scheme, addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s after removing the leftover socket: %w", addr, err)
+	}
+	return listener, nil
+}
 
-	csi.RegisterIdentityServer(d.srv, d)
-	csi.RegisterNodeServer(d.srv, d)
-	csi.RegisterControllerServer(d.srv, d)
+// removeStaleSocket removes whatever a previous run left at path and checks
+// that it is gone. A directory is never removed.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check socket %s: %w", path, err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("socket path %s is a directory", path)
+	}
 
-	klog.Infof("Listening on %s", d.endpoint)
-	return d.srv.Serve(listener)
+	klog.Infof("Removing leftover socket %s", path)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove leftover socket %s: %w", path, err)
+	}
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		return fmt.Errorf("leftover socket %s is still present after removing it", path)
+	}
+	return nil
 }
 
 func (d *Driver) Stop() {
+	d.stopBackground()
+
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
@@ -108,3 +710,70 @@ func (d *Driver) Stop() {
 		d.srv.GracefulStop()
 	}
 }
+
+// Shutdown stops accepting new RPCs and waits up to timeout for in-flight
+// RPCs (such as a mount in progress) to finish, then closes any remaining
+// connections
+func (d *Driver) Shutdown(timeout time.Duration) {
+	d.stopBackground()
+
+	d.mu.Lock()
+	srv := d.srv
+	d.mu.Unlock()
+
+	if srv == nil {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		klog.Infof("All in-flight RPCs finished, server stopped")
+	case <-time.After(timeout):
+		klog.Warningf("In-flight RPCs did not finish within %v, forcing server stop", timeout)
+		srv.Stop()
+	}
+}
+
+// annotationKeys returns the PVC annotation keys for name, in order of
+// precedence: under the annotation prefix, then under the driver name
+func (d *Driver) annotationKeys(name string) []string {
+	keys := []string{d.name + "/" + name}
+	if d.annotationPrefix != "" && d.annotationPrefix != d.name {
+		keys = append([]string{d.annotationPrefix + "/" + name}, keys...)
+	}
+	return keys
+}
+
+// subPathAnnotationKeys returns the PVC annotation keys for subPath
+func (d *Driver) subPathAnnotationKeys() []string {
+	return d.annotationKeys("subPath")
+}
+
+// mountOptionsAnnotationKeys returns the PVC annotation keys for mount options
+func (d *Driver) mountOptionsAnnotationKeys() []string {
+	return d.annotationKeys("mountOptions")
+}
+
+// stopBackground stops background loops such as the remount scan
+func (d *Driver) stopBackground() {
+	d.serving.Store(false)
+	d.stopOnce.Do(func() {
+		close(d.stop)
+	})
+}
+
+// servesNode reports whether the node service is registered
+func (d *Driver) servesNode() bool {
+	return d.mode == ModeAll || d.mode == ModeNode
+}
+
+// servesController reports whether the controller service is registered
+func (d *Driver) servesController() bool {
+	return d.mode == ModeAll || d.mode == ModeController
+}
@@ -0,0 +1,417 @@
+package nfs
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+	"k8s.io/mount-utils"
+)
+
+func TestNewDriver_Mode(t *testing.T) {
+	tests := []struct {
+		mode           string
+		wantErr        bool
+		wantNode       bool
+		wantController bool
+	}{
+		{mode: ModeAll, wantNode: true, wantController: true},
+		{mode: ModeNode, wantNode: true},
+		{mode: ModeController, wantController: true},
+		{mode: "both", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithMode(tt.mode))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewDriver() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if driver.servesNode() != tt.wantNode {
+				t.Errorf("servesNode() = %v, want %v", driver.servesNode(), tt.wantNode)
+			}
+			if driver.servesController() != tt.wantController {
+				t.Errorf("servesController() = %v, want %v", driver.servesController(), tt.wantController)
+			}
+		})
+	}
+}
+
+func TestParseEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint   string
+		wantScheme string
+		wantAddr   string
+		wantErr    bool
+	}{
+		{endpoint: "unix:///csi/csi.sock", wantScheme: "unix", wantAddr: "/csi/csi.sock"},
+		{endpoint: "unix:/csi/csi.sock", wantScheme: "unix", wantAddr: "/csi/csi.sock"},
+		{endpoint: "tcp://127.0.0.1:10000", wantScheme: "tcp", wantAddr: "127.0.0.1:10000"},
+		{endpoint: "tcp://:10000", wantScheme: "tcp", wantAddr: ":10000"},
+		{endpoint: "tcp://[::1]:10000/", wantScheme: "tcp", wantAddr: "[::1]:10000"},
+		{endpoint: "", wantErr: true},
+		{endpoint: "/csi/csi.sock", wantErr: true},
+		{endpoint: "unix://", wantErr: true},
+		{endpoint: "unix://csi.sock", wantErr: true},
+		{endpoint: "tcp://", wantErr: true},
+		{endpoint: "tcp://127.0.0.1", wantErr: true},
+		{endpoint: "tcp://127.0.0.1:10000/csi", wantErr: true},
+		{endpoint: "http://127.0.0.1:10000", wantErr: true},
+		{endpoint: "udp://127.0.0.1:10000", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		scheme, addr, err := parseEndpoint(tt.endpoint)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseEndpoint(%q) error = %v, wantErr %v", tt.endpoint, err, tt.wantErr)
+			continue
+		}
+		if scheme != tt.wantScheme || addr != tt.wantAddr {
+			t.Errorf("parseEndpoint(%q) = %q, %q, want %q, %q", tt.endpoint, scheme, addr, tt.wantScheme, tt.wantAddr)
+		}
+	}
+}
+
+func TestNewDriver_InvalidEndpoint(t *testing.T) {
+	if _, err := NewDriver(DefaultDriverName, "test-node", "http://localhost:10000"); err == nil {
+		t.Error("Expected an endpoint with an unsupported scheme to be rejected")
+	}
+}
+
+func TestNewDriver_TargetPathMode(t *testing.T) {
+	tests := []struct {
+		mode    os.FileMode
+		wantErr bool
+	}{
+		{mode: 0700},
+		{mode: 0750},
+		{mode: 0755},
+		{mode: 0500, wantErr: true},
+		{mode: 0, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		_, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithTargetPathMode(tt.mode))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NewDriver() with mode %#o error = %v, wantErr %v", tt.mode, err, tt.wantErr)
+		}
+	}
+}
+
+func TestNewDriver_InvalidForceServer(t *testing.T) {
+	if _, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithForceServer("nfs_server")); err == nil {
+		t.Error("Expected an invalid forced server to be rejected")
+	}
+}
+
+func TestNewDriver_InvalidMountRateLimit(t *testing.T) {
+	for _, opt := range []DriverOption{WithMountRateLimit(-1, 1), WithMountRateLimit(5, 0)} {
+		if _, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", opt); err == nil {
+			t.Error("Expected an invalid mount rate limit to be rejected")
+		}
+	}
+}
+
+func TestNewDriver_InvalidKeepalive(t *testing.T) {
+	for _, opt := range []DriverOption{
+		WithKeepalive(keepalive.ServerParameters{Time: -time.Second}, keepalive.EnforcementPolicy{}),
+		WithKeepalive(keepalive.ServerParameters{}, keepalive.EnforcementPolicy{MinTime: -time.Second}),
+	} {
+		if _, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", opt); err == nil {
+			t.Error("Expected a negative keepalive duration to be rejected")
+		}
+	}
+}
+
+// blockingMounter is a fake mounter whose Mount calls block until release is closed
+type blockingMounter struct {
+	*mount.FakeMounter
+	started chan struct{}
+	release chan struct{}
+}
+
+func (m *blockingMounter) Mount(source, target, fstype string, options []string) error {
+	close(m.started)
+	<-m.release
+	return nil
+}
+
+// startDriver runs the driver on a temporary unix socket and returns a channel
+// that receives Run's result
+func startDriver(t *testing.T, driver *Driver, socket string) <-chan error {
+	t.Helper()
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- driver.Run()
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(socket); err == nil {
+			return runErr
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Driver did not start listening on %s", socket)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestShutdown_Idle(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "csi.sock")
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix://"+socket)
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+	runErr := startDriver(t, driver, socket)
+
+	driver.Shutdown(time.Second)
+
+	select {
+	case err := <-runErr:
+		if err != nil {
+			t.Errorf("Run() returned error after shutdown: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() did not return after shutdown")
+	}
+}
+
+func TestShutdown_ForcesAfterTimeout(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "csi.sock")
+	mounter := &blockingMounter{
+		FakeMounter: mount.NewFakeMounter([]mount.MountPoint{}),
+		started:     make(chan struct{}),
+		release:     make(chan struct{}),
+	}
+	defer close(mounter.release)
+
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix://"+socket, WithMounter(mounter))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+	runErr := startDriver(t, driver, socket)
+
+	conn, err := grpc.NewClient("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	// Start a mount that never finishes on its own
+	go func() {
+		_, _ = csi.NewNodeClient(conn).NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+			VolumeId:   "test-volume",
+			TargetPath: filepath.Join(t.TempDir(), "target"),
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{},
+				},
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+				},
+			},
+			VolumeContext: map[string]string{
+				"server": "192.168.1.1",
+				"share":  "/data",
+			},
+		})
+	}()
+
+	select {
+	case <-mounter.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Mount was not started")
+	}
+
+	start := time.Now()
+	driver.Shutdown(100 * time.Millisecond)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Shutdown took %v, expected it to force stop after the timeout", elapsed)
+	}
+
+	select {
+	case <-runErr:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() did not return after forced shutdown")
+	}
+}
+
+func TestRun_SocketMode(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "csi.sock")
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix://"+socket, WithSocketMode(0600))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+	startDriver(t, driver, socket)
+	defer driver.Stop()
+
+	// The socket file appears at Listen, the chmod follows right after
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		info, err := os.Stat(socket)
+		if err != nil {
+			t.Fatalf("Failed to stat socket: %v", err)
+		}
+		if info.Mode().Perm() == 0600 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected socket mode 0600, got %#o", info.Mode().Perm())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNewDriver_SocketModeNone(t *testing.T) {
+	if _, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithSocketMode(0)); err == nil {
+		t.Error("Expected socket mode 0000 to be rejected")
+	}
+}
+
+func TestListenEndpoint_LeftoverSocket(t *testing.T) {
+	tests := []struct {
+		name     string
+		leftover func(t *testing.T, path string)
+		wantErr  bool
+	}{
+		{name: "no leftover", leftover: func(*testing.T, string) {}},
+		{
+			name: "socket of a crashed process",
+			leftover: func(t *testing.T, path string) {
+				listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+				if err != nil {
+					t.Fatalf("Failed to create socket: %v", err)
+				}
+				// A crash leaves the socket file without anyone listening
+				listener.SetUnlinkOnClose(false)
+				_ = listener.Close()
+			},
+		},
+		{
+			name: "regular file",
+			leftover: func(t *testing.T, path string) {
+				if err := os.WriteFile(path, []byte("stale"), 0600); err != nil {
+					t.Fatalf("Failed to create file: %v", err)
+				}
+			},
+		},
+		{
+			name: "directory",
+			leftover: func(t *testing.T, path string) {
+				if err := os.Mkdir(path, 0750); err != nil {
+					t.Fatalf("Failed to create directory: %v", err)
+				}
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			socket := filepath.Join(t.TempDir(), "csi.sock")
+			tt.leftover(t, socket)
+
+			listener, err := listenEndpoint("unix", socket)
+			if tt.wantErr {
+				if err == nil {
+					_ = listener.Close()
+					t.Fatal("Expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("listenEndpoint() failed: %v", err)
+			}
+			defer listener.Close()
+
+			conn, err := net.Dial("unix", socket)
+			if err != nil {
+				t.Fatalf("Failed to connect to %s: %v", socket, err)
+			}
+			_ = conn.Close()
+		})
+	}
+}
+
+func TestListenEndpoint_RetriesAddressInUse(t *testing.T) {
+	origListen := listen
+	defer func() { listen = origListen }()
+
+	socket := filepath.Join(t.TempDir(), "csi.sock")
+	attempts := 0
+	listen = func(network, address string) (net.Listener, error) {
+		attempts++
+		if attempts == 1 {
+			// Another leftover appeared between the cleanup and listening
+			if err := os.WriteFile(address, nil, 0600); err != nil {
+				t.Fatalf("Failed to create file: %v", err)
+			}
+			return nil, &net.OpError{Op: "listen", Net: network, Err: os.NewSyscallError("bind", syscall.EADDRINUSE)}
+		}
+		return origListen(network, address)
+	}
+
+	listener, err := listenEndpoint("unix", socket)
+	if err != nil {
+		t.Fatalf("listenEndpoint() failed: %v", err)
+	}
+	defer listener.Close()
+	if attempts != 2 {
+		t.Errorf("Expected 2 listen attempts, got %d", attempts)
+	}
+
+	// Other errors are not retried
+	attempts = 0
+	listen = func(string, string) (net.Listener, error) {
+		attempts++
+		return nil, os.ErrPermission
+	}
+	if _, err := listenEndpoint("unix", filepath.Join(t.TempDir(), "csi.sock")); err == nil || attempts != 1 {
+		t.Errorf("Expected one failed attempt, got %d attempts and error %v", attempts, err)
+	}
+}
+
+func TestRun_KeepaliveClosesIdleConnections(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "csi.sock")
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix://"+socket,
+		WithKeepalive(keepalive.ServerParameters{MaxConnectionIdle: 200 * time.Millisecond}, keepalive.EnforcementPolicy{}))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+	startDriver(t, driver, socket)
+	defer driver.Stop()
+
+	conn, err := grpc.NewClient("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if _, err := csi.NewIdentityClient(conn).GetPluginInfo(context.Background(), &csi.GetPluginInfoRequest{}); err != nil {
+		t.Fatalf("GetPluginInfo failed: %v", err)
+	}
+
+	// The server sends GOAWAY once the connection has been idle, which
+	// takes the client connection out of READY
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for state := conn.GetState(); state == connectivity.Ready; state = conn.GetState() {
+		if !conn.WaitForStateChange(ctx, state) {
+			t.Fatal("Expected the idle connection to be closed by the server")
+		}
+	}
+}
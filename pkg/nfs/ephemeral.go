@@ -0,0 +1,58 @@
+package nfs
+
+import (
+	"context"
+	"strconv"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ephemeralKey is set by kubelet in the volume context of CSI ephemeral
+// inline volumes, when the CSIDriver has podInfoOnMount: true
+const ephemeralKey = "csi.storage.k8s.io/ephemeral"
+
+// controllerContextKeys are volume context keys that CreateVolume resolves
+// from StorageClass parameters and PVC annotations. Inline volumes never see
+// the controller, so a pod spec may not set them.
+var controllerContextKeys = []string{
+	ParamMountProfile,
+	ParamProfileMountOptions,
+	ParamAnnotationMountOptions,
+}
+
+// isEphemeralVolume reports whether volumeContext belongs to an inline volume
+// declared in a pod spec rather than a PersistentVolume
+func isEphemeralVolume(volumeContext map[string]string) bool {
+	ephemeral, _ := strconv.ParseBool(volumeContext[ephemeralKey])
+	return ephemeral
+}
+
+// validateEphemeralContext applies the checks CreateVolume makes on
+// StorageClass parameters to the volume attributes of an inline volume
+func (d *Driver) validateEphemeralContext(ctx context.Context, volumeContext map[string]string) error {
+	if err := d.validateParameterKeys(volumeContext); err != nil {
+		return err
+	}
+	for _, key := range controllerContextKeys {
+		if volumeContext[key] != "" {
+			return status.Errorf(codes.InvalidArgument, "%s is not supported for inline volumes", key)
+		}
+	}
+	// Inline volumes are checked against the namespace of the pod. Without a
+	// namespace server map any pod could mount any export the node reaches.
+	if d.namespaceServers == nil {
+		return status.Error(codes.FailedPrecondition, "inline volumes require a namespace server map (--namespace-server-map) on the node plugin")
+	}
+	if err := d.checkNamespaceServers(volumeContext[podNamespaceKey], getServers(volumeContext)); err != nil {
+		return err
+	}
+	if d.resolveServer {
+		for _, server := range getServers(volumeContext) {
+			if err := resolveServer(ctx, server); err != nil {
+				return status.Error(codes.InvalidArgument, err.Error())
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package nfs
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Failure categories, reported as the reason of the ErrorInfo detail of
+// NodePublishVolume and CreateVolume errors
+const (
+	ErrorReasonNetwork    = "NETWORK"
+	ErrorReasonPermission = "PERMISSION"
+	ErrorReasonNotFound   = "NOT_FOUND"
+	ErrorReasonConfig     = "CONFIG"
+)
+
+// errorReasons maps gRPC codes to failure categories. Codes without one,
+// such as Internal, get no detail.
+var errorReasons = map[codes.Code]string{
+	codes.Unavailable:        ErrorReasonNetwork,
+	codes.DeadlineExceeded:   ErrorReasonNetwork,
+	codes.PermissionDenied:   ErrorReasonPermission,
+	codes.NotFound:           ErrorReasonNotFound,
+	codes.InvalidArgument:    ErrorReasonConfig,
+	codes.FailedPrecondition: ErrorReasonConfig,
+}
+
+// nfsVersionHint is added to mount errors that point at an NFS version the
+// server does not serve, e.g. a v3 mount against an NFSv4-only server
+const nfsVersionHint = "the server may not support the requested NFS version, set nfsvers (e.g. nfsvers=4.1) in the mount options"
+
+// mountErrorPatterns maps substrings of mount helper output to gRPC codes and
+// an optional hint for the operator. Matching is case-insensitive and the
+// first match wins.
+var mountErrorPatterns = []struct {
+	pattern string
+	code    codes.Code
+	hint    string
+}{
+	{"requested nfs version or transport protocol is not supported", codes.FailedPrecondition, nfsVersionHint},
+	{"protocol not supported", codes.FailedPrecondition, nfsVersionHint},
+	{"program version wrong", codes.FailedPrecondition, nfsVersionHint},
+	{"access denied", codes.PermissionDenied, ""},
+	{"permission denied", codes.PermissionDenied, ""},
+	{"operation not permitted", codes.PermissionDenied, ""},
+	{"no such file or directory", codes.NotFound, ""},
+	{"does not exist", codes.NotFound, ""},
+	{"connection refused", codes.Unavailable, ""},
+	{"timed out", codes.Unavailable, ""},
+	{"no route to host", codes.Unavailable, ""},
+	{"network is unreachable", codes.Unavailable, ""},
+	{"name or service not known", codes.Unavailable, ""},
+	{"temporary failure in name resolution", codes.Unavailable, ""},
+}
+
+// classifyMountError maps a mount failure to a gRPC code so the CO can tell
+// permanent errors (bad export, no access) from transient ones (server down).
+// A mount stopped because the request ended keeps the context's code.
+// Unrecognized errors are reported as Internal.
+func classifyMountError(err error) codes.Code {
+	switch {
+	case err == nil:
+		return codes.OK
+	case errors.Is(err, context.Canceled):
+		return codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return codes.DeadlineExceeded
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, p := range mountErrorPatterns {
+		if strings.Contains(msg, p.pattern) {
+			return p.code
+		}
+	}
+	return codes.Internal
+}
+
+// mountErrorHint returns the hint for a mount failure, or "" if there is none
+func mountErrorHint(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, p := range mountErrorPatterns {
+		if strings.Contains(msg, p.pattern) {
+			return p.hint
+		}
+	}
+	return ""
+}
+
+// mountFailedError wraps a failure to mount source at target in a status
+// error with the classified code and any hint
+func mountFailedError(err error, source, target string) error {
+	if hint := mountErrorHint(err); hint != "" {
+		return status.Errorf(classifyMountError(err), "failed to mount NFS %s at %s: %v (%s)", source, target, err, hint)
+	}
+	return status.Errorf(classifyMountError(err), "failed to mount NFS %s at %s: %v", source, target, err)
+}
+
+// withErrorDetails attaches an ErrorInfo with the failure category of err,
+// taken from its code, so dashboards can group failures without matching on
+// messages. The message and code are kept, as is an error that already has
+// details.
+func (d *Driver) withErrorDetails(err error) error {
+	st, ok := status.FromError(err)
+	if err == nil || !ok || len(st.Details()) > 0 {
+		return err
+	}
+	reason, ok := errorReasons[st.Code()]
+	if !ok {
+		return err
+	}
+	withDetails, detailErr := st.WithDetails(&errdetails.ErrorInfo{Reason: reason, Domain: d.name})
+	if detailErr != nil {
+		return err
+	}
+	return withDetails.Err()
+}
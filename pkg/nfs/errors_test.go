@@ -0,0 +1,227 @@
+package nfs
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestClassifyMountError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want codes.Code
+	}{
+		{
+			name: "nil error",
+			err:  nil,
+			want: codes.OK,
+		},
+		{
+			name: "access denied by server",
+			err:  errors.New("mount failed: exit status 32\nOutput: mount.nfs: access denied by server while mounting 192.168.1.1:/data"),
+			want: codes.PermissionDenied,
+		},
+		{
+			name: "permission denied",
+			err:  errors.New("mount failed: exit status 32\nOutput: mount.nfs: Permission denied"),
+			want: codes.PermissionDenied,
+		},
+		{
+			name: "no such export",
+			err:  errors.New("mount failed: exit status 32\nOutput: mount.nfs: mounting 192.168.1.1:/missing failed, reason given by server: No such file or directory"),
+			want: codes.NotFound,
+		},
+		{
+			name: "mount point does not exist on server",
+			err:  errors.New("mount failed: exit status 32\nOutput: mount.nfs: mount point /exports/missing does not exist"),
+			want: codes.NotFound,
+		},
+		{
+			name: "connection refused",
+			err:  errors.New("mount failed: exit status 32\nOutput: mount.nfs: Connection refused"),
+			want: codes.Unavailable,
+		},
+		{
+			name: "connection timed out",
+			err:  errors.New("mount failed: exit status 32\nOutput: mount.nfs: Connection timed out"),
+			want: codes.Unavailable,
+		},
+		{
+			name: "no route to host",
+			err:  errors.New("mount failed: exit status 32\nOutput: mount.nfs: No route to host"),
+			want: codes.Unavailable,
+		},
+		{
+			name: "NFSv3 against an NFSv4-only server",
+			err:  errors.New("mount failed: exit status 32\nOutput: mount.nfs: Protocol not supported"),
+			want: codes.FailedPrecondition,
+		},
+		{
+			name: "unsupported version or transport",
+			err:  errors.New("mount failed: exit status 32\nOutput: mount.nfs: requested NFS version or transport protocol is not supported"),
+			want: codes.FailedPrecondition,
+		},
+		{
+			name: "RPC version mismatch",
+			err:  errors.New("mount failed: exit status 32\nOutput: mount.nfs: mount(2): RPC: Program version wrong"),
+			want: codes.FailedPrecondition,
+		},
+		{
+			name: "unknown error",
+			err:  errors.New("mount failed: exit status 1\nOutput: something unexpected"),
+			want: codes.Internal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyMountError(tt.err); got != tt.want {
+				t.Errorf("classifyMountError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMountFailedError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantCode codes.Code
+		wantHint bool
+	}{
+		{
+			name:     "version mismatch",
+			err:      errors.New("mount failed: exit status 32\nOutput: mount.nfs: Protocol not supported"),
+			wantCode: codes.FailedPrecondition,
+			wantHint: true,
+		},
+		{
+			name:     "access denied",
+			err:      errors.New("mount failed: exit status 32\nOutput: mount.nfs: access denied by server"),
+			wantCode: codes.PermissionDenied,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := mountFailedError(tt.err, "192.168.1.1:/data", "/target")
+			if code := status.Code(err); code != tt.wantCode {
+				t.Errorf("Expected %v, got %v", tt.wantCode, code)
+			}
+			if got := strings.Contains(err.Error(), "set nfsvers"); got != tt.wantHint {
+				t.Errorf("Expected hint %v, got %q", tt.wantHint, err.Error())
+			}
+		})
+	}
+}
+
+// errorReason returns the reason and domain of the ErrorInfo detail of err
+func errorReason(t *testing.T, err error) (string, string) {
+	t.Helper()
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			return info.GetReason(), info.GetDomain()
+		}
+	}
+	return "", ""
+}
+
+func TestNodePublishVolume_ErrorDetails(t *testing.T) {
+	tests := []struct {
+		name       string
+		output     string
+		wantCode   codes.Code
+		wantReason string
+	}{
+		{name: "access denied", output: "mount.nfs: access denied by server while mounting 192.168.1.1:/data", wantCode: codes.PermissionDenied, wantReason: ErrorReasonPermission},
+		{name: "server down", output: "mount.nfs: Connection refused", wantCode: codes.Unavailable, wantReason: ErrorReasonNetwork},
+		{name: "export missing", output: "mount.nfs: mounting 192.168.1.1:/data failed, reason given by server: No such file or directory", wantCode: codes.NotFound, wantReason: ErrorReasonNotFound},
+		{name: "version mismatch", output: "mount.nfs: Protocol not supported", wantCode: codes.FailedPrecondition, wantReason: ErrorReasonConfig},
+		{name: "unrecognized", output: "mount.nfs: something odd happened", wantCode: codes.Internal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mounter := scriptMounter("echo '"+tt.output+"'; exit 32", nil)
+			driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithMounter(mounter))
+			if err != nil {
+				t.Fatalf("Failed to create driver: %v", err)
+			}
+
+			_, err = driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:   "test-volume",
+				TargetPath: filepath.Join(t.TempDir(), "target"),
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{},
+					},
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+					},
+				},
+				VolumeContext: map[string]string{"server": "192.168.1.1", "share": "/data"},
+			})
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("Expected %v, got %v", tt.wantCode, err)
+			}
+			// The detail comes on top of the message, which stays as it was
+			if msg := status.Convert(err).Message(); !strings.HasPrefix(msg, "failed to mount NFS 192.168.1.1:/data") {
+				t.Errorf("Expected the mount failure message, got %q", msg)
+			}
+			reason, domain := errorReason(t, err)
+			if reason != tt.wantReason {
+				t.Errorf("Expected reason %q, got %q", tt.wantReason, reason)
+			}
+			if reason != "" && domain != DefaultDriverName {
+				t.Errorf("Expected domain %q, got %q", DefaultDriverName, domain)
+			}
+		})
+	}
+}
+
+func TestCreateVolume_ErrorDetails(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock")
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	req := createVolumeRequest("pvc-1", 0)
+	req.Parameters = map[string]string{"share": "/exports/data"}
+	_, err = driver.CreateVolume(context.Background(), req)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument, got %v", err)
+	}
+	if reason, _ := errorReason(t, err); reason != ErrorReasonConfig {
+		t.Errorf("Expected reason %q, got %q", ErrorReasonConfig, reason)
+	}
+}
+
+func TestWithErrorDetails_KeepsExistingDetails(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock")
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+	if err := driver.withErrorDetails(nil); err != nil {
+		t.Errorf("Expected nil, got %v", err)
+	}
+
+	st, err := status.New(codes.Unavailable, "server down").WithDetails(&errdetails.ErrorInfo{Reason: "CUSTOM"})
+	if err != nil {
+		t.Fatalf("WithDetails failed: %v", err)
+	}
+	got := driver.withErrorDetails(st.Err())
+	if reason, _ := errorReason(t, got); reason != "CUSTOM" {
+		t.Errorf("Expected the existing detail to be kept, got %q", reason)
+	}
+	if details := status.Convert(got).Details(); len(details) != 1 {
+		t.Errorf("Expected one detail, got %v", details)
+	}
+}
@@ -0,0 +1,97 @@
+package nfs
+
+import (
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Pod information passed in the volume context when the CSIDriver has podInfoOnMount: true
+	podNameKey      = "csi.storage.k8s.io/pod.name"
+	podNamespaceKey = "csi.storage.k8s.io/pod.namespace"
+	podUIDKey       = "csi.storage.k8s.io/pod.uid"
+
+	// EventReasonMountFailed is the reason used for mount failure events
+	EventReasonMountFailed = "NFSMountFailed"
+
+	// Identical events for the same pod are suppressed for this long
+	eventDedupeInterval = time.Minute
+)
+
+// eventDeduper suppresses repeated identical events so kubelet retries
+// don't flood the API server
+type eventDeduper struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// shouldEmit reports whether an event with the given key has not been emitted recently
+func (e *eventDeduper) shouldEmit(key string, now time.Time) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.seen == nil {
+		e.seen = make(map[string]time.Time)
+	}
+	for k, last := range e.seen {
+		if now.Sub(last) >= eventDedupeInterval {
+			delete(e.seen, k)
+		}
+	}
+	if _, ok := e.seen[key]; ok {
+		return false
+	}
+	e.seen[key] = now
+	return true
+}
+
+// WithEventRecorder enables Kubernetes events for mount failures
+func WithEventRecorder(recorder record.EventRecorder) DriverOption {
+	return func(d *Driver) {
+		d.recorder = recorder
+	}
+}
+
+// NewEventRecorder creates an event recorder that posts events through the given clientset
+func NewEventRecorder(clientset kubernetes.Interface, driverName, nodeID string) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartStructuredLogging(4)
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: driverName, Host: nodeID})
+}
+
+// recordMountFailure emits a warning event on the pod that requested the volume.
+// Pod information is only available when the CSIDriver sets podInfoOnMount.
+func (d *Driver) recordMountFailure(volumeID string, volumeContext map[string]string, err error) {
+	if d.recorder == nil {
+		return
+	}
+
+	podName := volumeContext[podNameKey]
+	podNamespace := volumeContext[podNamespaceKey]
+	if podName == "" || podNamespace == "" {
+		klog.V(4).Infof("No pod information for volume %s, skipping mount failure event", volumeID)
+		return
+	}
+
+	message := "Failed to mount NFS volume " + volumeID + ": " + err.Error()
+	if !d.events.shouldEmit(podNamespace+"/"+podName+"/"+message, time.Now()) {
+		return
+	}
+
+	pod := &v1.ObjectReference{
+		Kind:      "Pod",
+		Namespace: podNamespace,
+		Name:      podName,
+		UID:       types.UID(volumeContext[podUIDKey]),
+	}
+	d.recorder.Event(pod, v1.EventTypeWarning, EventReasonMountFailed, message)
+}
@@ -0,0 +1,102 @@
+package nfs
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/mount-utils"
+)
+
+func TestNodePublishVolume_MountFailureEvent(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock",
+		WithMounter(&errorMounter{
+			FakeMounter: mount.NewFakeMounter([]mount.MountPoint{}),
+			err:         errors.New("mount.nfs: access denied by server"),
+		}),
+		WithEventRecorder(recorder),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:   "test-volume",
+		TargetPath: t.TempDir(),
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+			},
+		},
+		VolumeContext: map[string]string{
+			"server":                           "192.168.1.1",
+			"share":                            "/data",
+			"csi.storage.k8s.io/pod.name":      "app",
+			"csi.storage.k8s.io/pod.namespace": "default",
+		},
+	}
+
+	// Retries with the same failure should only produce one event
+	for i := 0; i < 3; i++ {
+		if _, err := driver.NodePublishVolume(context.Background(), req); err == nil {
+			t.Fatal("Expected mount error, got nil")
+		}
+	}
+
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, EventReasonMountFailed) || !strings.Contains(event, "access denied") {
+			t.Errorf("Unexpected event: %s", event)
+		}
+	default:
+		t.Fatal("Expected a mount failure event")
+	}
+
+	select {
+	case event := <-recorder.Events:
+		t.Errorf("Expected duplicate events to be suppressed, got %s", event)
+	default:
+	}
+}
+
+func TestRecordMountFailure_NoPodInfo(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock",
+		WithEventRecorder(recorder),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	driver.recordMountFailure("test-volume", map[string]string{}, errors.New("boom"))
+
+	if len(recorder.Events) != 0 {
+		t.Errorf("Expected no events without pod information, got %d", len(recorder.Events))
+	}
+}
+
+func TestEventDeduper(t *testing.T) {
+	var deduper eventDeduper
+	now := time.Now()
+
+	if !deduper.shouldEmit("a", now) {
+		t.Error("Expected first event to be emitted")
+	}
+	if deduper.shouldEmit("a", now.Add(time.Second)) {
+		t.Error("Expected duplicate event to be suppressed")
+	}
+	if !deduper.shouldEmit("b", now.Add(time.Second)) {
+		t.Error("Expected different event to be emitted")
+	}
+	if !deduper.shouldEmit("a", now.Add(eventDedupeInterval)) {
+		t.Error("Expected event to be emitted again after the interval")
+	}
+}
@@ -0,0 +1,117 @@
+package nfs
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Filesystem is the set of filesystem operations the driver performs on the
+// directories it manages, so tests can replace them with a fake
+type Filesystem interface {
+	Mkdir(path string, perm os.FileMode) error
+	MkdirAll(path string, perm os.FileMode) error
+	MkdirTemp(dir, pattern string) (string, error)
+	Remove(path string) error
+	RemoveAll(path string) error
+	Rename(oldPath, newPath string) error
+	Chmod(path string, mode os.FileMode) error
+	Chown(path string, uid, gid int) error
+	Lchown(path string, uid, gid int) error
+	Readlink(path string) (string, error)
+	Symlink(oldPath, newPath string) error
+	Stat(path string) (os.FileInfo, error)
+	Lstat(path string) (os.FileInfo, error)
+	ReadDir(path string) ([]os.DirEntry, error)
+	// Open opens path for reading
+	Open(path string) (io.ReadCloser, error)
+	// OpenFile opens path for writing with flag, creating it with perm
+	OpenFile(path string, flag int, perm os.FileMode) (io.WriteCloser, error)
+}
+
+// osFilesystem implements Filesystem with the os package
+type osFilesystem struct{}
+
+func (osFilesystem) Mkdir(path string, perm os.FileMode) error     { return os.Mkdir(path, perm) }
+func (osFilesystem) MkdirAll(path string, perm os.FileMode) error  { return os.MkdirAll(path, perm) }
+func (osFilesystem) MkdirTemp(dir, pattern string) (string, error) { return os.MkdirTemp(dir, pattern) }
+func (osFilesystem) Remove(path string) error                      { return os.Remove(path) }
+func (osFilesystem) RemoveAll(path string) error                   { return os.RemoveAll(path) }
+func (osFilesystem) Rename(oldPath, newPath string) error          { return os.Rename(oldPath, newPath) }
+func (osFilesystem) Chmod(path string, mode os.FileMode) error     { return os.Chmod(path, mode) }
+func (osFilesystem) Chown(path string, uid, gid int) error         { return os.Chown(path, uid, gid) }
+func (osFilesystem) Lchown(path string, uid, gid int) error        { return os.Lchown(path, uid, gid) }
+func (osFilesystem) Readlink(path string) (string, error)          { return os.Readlink(path) }
+func (osFilesystem) Symlink(oldPath, newPath string) error         { return os.Symlink(oldPath, newPath) }
+func (osFilesystem) Stat(path string) (os.FileInfo, error)         { return os.Stat(path) }
+func (osFilesystem) Lstat(path string) (os.FileInfo, error)        { return os.Lstat(path) }
+func (osFilesystem) ReadDir(path string) ([]os.DirEntry, error)    { return os.ReadDir(path) }
+
+func (osFilesystem) Open(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (osFilesystem) OpenFile(path string, flag int, perm os.FileMode) (io.WriteCloser, error) {
+	file, err := os.OpenFile(path, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// walkDir walks the tree at root like filepath.WalkDir, in lexical order and
+// without following symlinks, but reads it through filesystem
+func walkDir(filesystem Filesystem, root string, fn fs.WalkDirFunc) error {
+	info, err := filesystem.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkDirEntry(filesystem, root, fs.FileInfoToDirEntry(info), fn)
+	}
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+// walkDirEntry calls fn for path and, if it is a directory, everything below it
+func walkDirEntry(filesystem Filesystem, path string, entry fs.DirEntry, fn fs.WalkDirFunc) error {
+	if err := fn(path, entry, nil); err != nil || !entry.IsDir() {
+		if err == filepath.SkipDir && entry.IsDir() {
+			err = nil
+		}
+		return err
+	}
+
+	entries, err := filesystem.ReadDir(path)
+	if err != nil {
+		// Let fn decide whether a directory that cannot be read ends the walk
+		if err = fn(path, entry, err); err != nil {
+			if err == filepath.SkipDir {
+				err = nil
+			}
+			return err
+		}
+	}
+	for _, child := range entries {
+		if err := walkDirEntry(filesystem, filepath.Join(path, child.Name()), child, fn); err != nil {
+			if err == filepath.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// WithFS sets a custom filesystem implementation (useful for testing)
+func WithFS(filesystem Filesystem) DriverOption {
+	return func(d *Driver) {
+		d.filesystem = filesystem
+	}
+}
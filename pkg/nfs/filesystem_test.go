@@ -0,0 +1,487 @@
+package nfs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"k8s.io/mount-utils"
+)
+
+// fakeFilesystem is an in-memory Filesystem of directories and regular files
+type fakeFilesystem struct {
+	mu    sync.Mutex
+	dirs  map[string]os.FileMode
+	files map[string]*fakeFile
+	// gids holds the group set by Chown and Lchown
+	gids map[string]int
+	// temps numbers the directories made by MkdirTemp
+	temps int
+}
+
+// fakeFile is a regular file in fakeFilesystem
+type fakeFile struct {
+	data []byte
+	mode os.FileMode
+}
+
+func newFakeFilesystem() *fakeFilesystem {
+	return &fakeFilesystem{dirs: map[string]os.FileMode{}, files: map[string]*fakeFile{}, gids: map[string]int{}}
+}
+
+// exists reports whether path is a directory or file; f.mu must be held
+func (f *fakeFilesystem) exists(path string) bool {
+	_, isDir := f.dirs[path]
+	_, isFile := f.files[path]
+	return isDir || isFile
+}
+
+// below reports whether path is p or below it
+func below(p, path string) bool {
+	return p == path || strings.HasPrefix(p, path+"/")
+}
+
+// WriteFile creates the file path with data, and its parent directories
+func (f *fakeFilesystem) WriteFile(path string, data []byte, perm os.FileMode) error {
+	if err := f.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.files[path] = &fakeFile{data: append([]byte(nil), data...), mode: perm}
+	return nil
+}
+
+func (f *fakeFilesystem) MkdirAll(path string, perm os.FileMode) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for p := filepath.Clean(path); p != "/" && p != "."; p = filepath.Dir(p) {
+		if _, ok := f.dirs[p]; !ok {
+			f.dirs[p] = perm
+		}
+	}
+	return nil
+}
+
+func (f *fakeFilesystem) Mkdir(path string, perm os.FileMode) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.exists(path) {
+		return &os.PathError{Op: "mkdir", Path: path, Err: os.ErrExist}
+	}
+	f.dirs[path] = perm
+	return nil
+}
+
+func (f *fakeFilesystem) MkdirTemp(dir, pattern string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	f.temps++
+	path := filepath.Join(dir, fmt.Sprintf("%s%d", pattern, f.temps))
+	f.dirs[path] = 0700
+	return path, nil
+}
+
+func (f *fakeFilesystem) Remove(path string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.files[path]; ok {
+		delete(f.files, path)
+		return nil
+	}
+	if _, ok := f.dirs[path]; !ok {
+		return &os.PathError{Op: "remove", Path: path, Err: os.ErrNotExist}
+	}
+	for p := range f.dirs {
+		if strings.HasPrefix(p, path+"/") {
+			return &os.PathError{Op: "remove", Path: path, Err: os.ErrExist}
+		}
+	}
+	for p := range f.files {
+		if strings.HasPrefix(p, path+"/") {
+			return &os.PathError{Op: "remove", Path: path, Err: os.ErrExist}
+		}
+	}
+	delete(f.dirs, path)
+	return nil
+}
+
+func (f *fakeFilesystem) RemoveAll(path string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for p := range f.dirs {
+		if below(p, path) {
+			delete(f.dirs, p)
+		}
+	}
+	for p := range f.files {
+		if below(p, path) {
+			delete(f.files, p)
+		}
+	}
+	return nil
+}
+
+func (f *fakeFilesystem) Rename(oldPath, newPath string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.exists(oldPath) {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: os.ErrNotExist}
+	}
+	if f.exists(newPath) {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: os.ErrExist}
+	}
+	for p, mode := range f.dirs {
+		if below(p, oldPath) {
+			delete(f.dirs, p)
+			f.dirs[newPath+strings.TrimPrefix(p, oldPath)] = mode
+		}
+	}
+	for p, file := range f.files {
+		if below(p, oldPath) {
+			delete(f.files, p)
+			f.files[newPath+strings.TrimPrefix(p, oldPath)] = file
+		}
+	}
+	return nil
+}
+
+func (f *fakeFilesystem) Chmod(path string, mode os.FileMode) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if file, ok := f.files[path]; ok {
+		file.mode = mode
+		return nil
+	}
+	if _, ok := f.dirs[path]; !ok {
+		return &os.PathError{Op: "chmod", Path: path, Err: os.ErrNotExist}
+	}
+	f.dirs[path] = mode
+	return nil
+}
+
+func (f *fakeFilesystem) Chown(path string, uid, gid int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.exists(path) {
+		return &os.PathError{Op: "chown", Path: path, Err: os.ErrNotExist}
+	}
+	if gid != -1 {
+		f.gids[path] = gid
+	}
+	return nil
+}
+
+func (f *fakeFilesystem) Lchown(path string, uid, gid int) error {
+	return f.Chown(path, uid, gid)
+}
+
+func (f *fakeFilesystem) Readlink(path string) (string, error) {
+	return "", &os.PathError{Op: "readlink", Path: path, Err: os.ErrInvalid}
+}
+
+func (f *fakeFilesystem) Symlink(oldPath, newPath string) error {
+	return &os.LinkError{Op: "symlink", Old: oldPath, New: newPath, Err: os.ErrInvalid}
+}
+
+func (f *fakeFilesystem) Stat(path string) (os.FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if file, ok := f.files[path]; ok {
+		return fakeFileInfo{name: filepath.Base(path), mode: file.mode, size: int64(len(file.data))}, nil
+	}
+	mode, ok := f.dirs[path]
+	if !ok {
+		return nil, &os.PathError{Op: "stat", Path: path, Err: os.ErrNotExist}
+	}
+	return fakeFileInfo{name: filepath.Base(path), mode: os.ModeDir | mode}, nil
+}
+
+// Lstat is Stat, since the fake has no symlinks
+func (f *fakeFilesystem) Lstat(path string) (os.FileInfo, error) {
+	return f.Stat(path)
+}
+
+func (f *fakeFilesystem) ReadDir(path string) ([]os.DirEntry, error) {
+	f.mu.Lock()
+	var names []string
+	if _, ok := f.dirs[path]; !ok {
+		f.mu.Unlock()
+		return nil, &os.PathError{Op: "readdirent", Path: path, Err: os.ErrNotExist}
+	}
+	for p := range f.dirs {
+		if filepath.Dir(p) == path && p != path {
+			names = append(names, p)
+		}
+	}
+	for p := range f.files {
+		if filepath.Dir(p) == path {
+			names = append(names, p)
+		}
+	}
+	f.mu.Unlock()
+	sort.Strings(names)
+
+	entries := make([]os.DirEntry, 0, len(names))
+	for _, name := range names {
+		info, err := f.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	return entries, nil
+}
+
+func (f *fakeFilesystem) Open(path string) (io.ReadCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, ok := f.files[path]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+	return io.NopCloser(bytes.NewReader(append([]byte(nil), file.data...))), nil
+}
+
+func (f *fakeFilesystem) OpenFile(path string, flag int, perm os.FileMode) (io.WriteCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.files[path]; !ok {
+		if flag&os.O_CREATE == 0 {
+			return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+		}
+		if f.exists(path) {
+			return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrExist}
+		}
+		f.files[path] = &fakeFile{mode: perm}
+	} else if flag&os.O_EXCL != 0 {
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrExist}
+	}
+	return &fakeFileWriter{fs: f, path: path}, nil
+}
+
+// fakeFileWriter appends to a file in fakeFilesystem
+type fakeFileWriter struct {
+	fs   *fakeFilesystem
+	path string
+}
+
+func (w *fakeFileWriter) Write(p []byte) (int, error) {
+	w.fs.mu.Lock()
+	defer w.fs.mu.Unlock()
+
+	file, ok := w.fs.files[w.path]
+	if !ok {
+		return 0, &os.PathError{Op: "write", Path: w.path, Err: os.ErrNotExist}
+	}
+	file.data = append(file.data, p...)
+	return len(p), nil
+}
+
+func (w *fakeFileWriter) Close() error { return nil }
+
+// fakeFileInfo describes a directory or file in fakeFilesystem
+type fakeFileInfo struct {
+	name string
+	mode os.FileMode
+	size int64
+}
+
+func (i fakeFileInfo) Name() string       { return i.name }
+func (i fakeFileInfo) Size() int64        { return i.size }
+func (i fakeFileInfo) Mode() os.FileMode  { return i.mode }
+func (i fakeFileInfo) ModTime() time.Time { return time.Time{} }
+func (i fakeFileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i fakeFileInfo) Sys() interface{}   { return nil }
+
+func TestNodePublishVolume_UsesFilesystem(t *testing.T) {
+	fakeFS := newFakeFilesystem()
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock",
+		WithMounter(mount.NewFakeMounter([]mount.MountPoint{})), WithFS(fakeFS), WithTargetPathMode(0700))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	target := filepath.Join(t.TempDir(), "target")
+	_, err = driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:   "test-volume",
+		TargetPath: target,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+			},
+		},
+		VolumeContext: map[string]string{
+			"server": "192.168.1.1",
+			"share":  "/data",
+		},
+	})
+	if err != nil {
+		t.Fatalf("NodePublishVolume failed: %v", err)
+	}
+
+	info, err := fakeFS.Stat(target)
+	if err != nil {
+		t.Fatalf("Expected target created through the filesystem: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0700 {
+		t.Errorf("Expected target mode 0700, got %#o", perm)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Errorf("Expected no target on disk, got %v", err)
+	}
+}
+
+func TestNodeUnpublishVolume_UsesFilesystem(t *testing.T) {
+	fakeFS := newFakeFilesystem()
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock",
+		WithMounter(mount.NewFakeMounter([]mount.MountPoint{})), WithFS(fakeFS))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	// The fake mounter checks the real path, so it has to exist on disk too
+	target := t.TempDir()
+	if err := fakeFS.MkdirAll(target, 0750); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+
+	if _, err := driver.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{VolumeId: "test-volume", TargetPath: target}); err != nil {
+		t.Fatalf("NodeUnpublishVolume failed: %v", err)
+	}
+
+	if _, err := fakeFS.Stat(target); !os.IsNotExist(err) {
+		t.Errorf("Expected target removed through the filesystem, got %v", err)
+	}
+	if _, err := os.Stat(target); err != nil {
+		t.Errorf("Expected target left on disk, got %v", err)
+	}
+}
+
+func TestGetCapacity_UsesFilesystem(t *testing.T) {
+	fakeFS := newFakeFilesystem()
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock",
+		WithMounter(mount.NewFakeMounter([]mount.MountPoint{})), WithFS(fakeFS), WithCapacity(time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+	stubStatfs(t, 1)
+
+	if _, err := driver.GetCapacity(context.Background(), &csi.GetCapacityRequest{
+		Parameters: map[string]string{"server": "192.168.1.1", "share": "/data"},
+	}); err != nil {
+		t.Fatalf("GetCapacity failed: %v", err)
+	}
+
+	if fakeFS.temps != 1 {
+		t.Errorf("Expected the mount directory created through the filesystem, got %d", fakeFS.temps)
+	}
+	if len(fakeFS.dirs) != 0 {
+		t.Errorf("Expected the mount directory removed through the filesystem, got %v", fakeFS.dirs)
+	}
+}
+
+func TestApplyVolumeMountGroup_UsesFilesystem(t *testing.T) {
+	fakeFS := newFakeFilesystem()
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithFS(fakeFS))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	// Only in the fake: the walk must not read the real disk
+	target := "/var/lib/kubelet/pods/uid/volumes/vol/mount"
+	if err := fakeFS.WriteFile(filepath.Join(target, "dir/file"), []byte("data"), 0600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := driver.applyVolumeMountGroup(target, 1000, csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER); err != nil {
+		t.Fatalf("applyVolumeMountGroup failed: %v", err)
+	}
+
+	want := map[string]int{target: 1000, filepath.Join(target, "dir"): 1000, filepath.Join(target, "dir/file"): 1000}
+	if !reflect.DeepEqual(fakeFS.gids, want) {
+		t.Errorf("Expected groups %v, got %v", want, fakeFS.gids)
+	}
+	if info, err := fakeFS.Stat(filepath.Join(target, "dir/file")); err != nil || info.Mode().Perm() != 0660 {
+		t.Errorf("Expected the file to be made group writable, got %v (%v)", info, err)
+	}
+	if info, err := fakeFS.Stat(filepath.Join(target, "dir")); err != nil || info.Mode()&os.ModeSetgid == 0 {
+		t.Errorf("Expected setgid on the directory, got %v (%v)", info, err)
+	}
+}
+
+func TestCopyTree_UsesFilesystem(t *testing.T) {
+	fakeFS := newFakeFilesystem()
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithFS(fakeFS))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	src := "/mnt/share/app"
+	files := map[string]string{"data.txt": "hello", "nested/deep/file": "world", "skip/file": "excluded"}
+	for path, content := range files {
+		if err := fakeFS.WriteFile(filepath.Join(src, path), []byte(content), 0640); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+	if err := fakeFS.Chmod(filepath.Join(src, "nested"), 0500); err != nil {
+		t.Fatalf("Chmod failed: %v", err)
+	}
+
+	size, err := driver.copyAside(context.Background(), src, "/mnt/share/copy", filepath.Join(src, "skip"))
+	if err != nil {
+		t.Fatalf("copyAside failed: %v", err)
+	}
+	if size != 10 {
+		t.Errorf("Expected 10 bytes copied, got %d", size)
+	}
+	for _, path := range []string{"data.txt", "nested/deep/file"} {
+		r, err := fakeFS.Open(filepath.Join("/mnt/share/copy", path))
+		if err != nil {
+			t.Errorf("Expected %s to be copied: %v", path, err)
+			continue
+		}
+		got, _ := io.ReadAll(r)
+		if string(got) != files[path] {
+			t.Errorf("Expected %s to contain %q, got %q", path, files[path], got)
+		}
+		if info, _ := fakeFS.Stat(filepath.Join("/mnt/share/copy", path)); info.Mode().Perm() != 0640 {
+			t.Errorf("Expected %s to keep mode 0640, got %v", path, info.Mode())
+		}
+	}
+	if info, err := fakeFS.Stat("/mnt/share/copy/nested"); err != nil || info.Mode().Perm() != 0500 {
+		t.Errorf("Expected the directory mode to be kept, got %v (%v)", info, err)
+	}
+	for _, path := range []string{"/mnt/share/copy/skip", "/mnt/share/copy.partial"} {
+		if _, err := fakeFS.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s not to exist, got %v", path, err)
+		}
+	}
+}
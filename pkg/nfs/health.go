@@ -0,0 +1,28 @@
+package nfs
+
+import (
+	"fmt"
+	"net/http"
+
+	"k8s.io/klog/v2"
+)
+
+// HealthHandler serves HTTP liveness and readiness checks for pod probes:
+// /healthz answers as long as the process runs, /readyz reports the same
+// readiness as Probe. /debug/volumes reports the volume counts as JSON.
+func (d *Driver) HealthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if err := d.checkReady(r.Context()); err != nil {
+			klog.V(4).Infof("Readiness check failed: %v", err)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/debug/volumes", d.serveVolumeCounts)
+	return mux
+}
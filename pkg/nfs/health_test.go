@@ -0,0 +1,51 @@
+package nfs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHealthHandler(t *testing.T) {
+	get := func(h http.Handler, path string) (int, string) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code, rec.Body.String()
+	}
+
+	tests := []struct {
+		name      string
+		helpers   []string
+		serving   bool
+		wantReady int
+		wantBody  string
+	}{
+		{name: "ready", helpers: []string{"mount.nfs"}, serving: true, wantReady: http.StatusOK, wantBody: "ok"},
+		{name: "not serving", helpers: []string{"mount.nfs"}, wantReady: http.StatusServiceUnavailable, wantBody: "not serving"},
+		{name: "no mount helper", serving: true, wantReady: http.StatusServiceUnavailable, wantBody: "no NFS mount helper"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubLookPath(t, tt.helpers...)
+			driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock")
+			if err != nil {
+				t.Fatalf("Failed to create driver: %v", err)
+			}
+			driver.serving.Store(tt.serving)
+			h := driver.HealthHandler()
+
+			if code, _ := get(h, "/healthz"); code != http.StatusOK {
+				t.Errorf("Expected /healthz to return 200, got %d", code)
+			}
+			code, body := get(h, "/readyz")
+			if code != tt.wantReady {
+				t.Errorf("Expected /readyz to return %d, got %d", tt.wantReady, code)
+			}
+			if !strings.Contains(body, tt.wantBody) {
+				t.Errorf("Expected /readyz body to contain %q, got %q", tt.wantBody, body)
+			}
+		})
+	}
+}
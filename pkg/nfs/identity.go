@@ -2,6 +2,11 @@ package nfs
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/protobuf/types/known/wrapperspb"
@@ -10,7 +15,7 @@ import (
 
 // GetPluginInfo returns metadata about the plugin
 func (d *Driver) GetPluginInfo(ctx context.Context, req *csi.GetPluginInfoRequest) (*csi.GetPluginInfoResponse, error) {
-	klog.V(4).Infof("GetPluginInfo called")
+	d.logV(ctx, subsystemIdentity, 4).Infof("GetPluginInfo called")
 
 	return &csi.GetPluginInfoResponse{
 		Name:          d.name,
@@ -20,26 +25,139 @@ func (d *Driver) GetPluginInfo(ctx context.Context, req *csi.GetPluginInfoReques
 
 // GetPluginCapabilities returns the capabilities of the plugin
 func (d *Driver) GetPluginCapabilities(ctx context.Context, req *csi.GetPluginCapabilitiesRequest) (*csi.GetPluginCapabilitiesResponse, error) {
-	klog.V(4).Infof("GetPluginCapabilities called")
+	d.logV(ctx, subsystemIdentity, 4).Infof("GetPluginCapabilities called")
 
-	return &csi.GetPluginCapabilitiesResponse{
-		Capabilities: []*csi.PluginCapability{
-			{
-				Type: &csi.PluginCapability_Service_{
-					Service: &csi.PluginCapability_Service{
-						Type: csi.PluginCapability_Service_CONTROLLER_SERVICE,
-					},
+	capabilities := []*csi.PluginCapability{}
+	if d.servesController() {
+		capabilities = append(capabilities, &csi.PluginCapability{
+			Type: &csi.PluginCapability_Service_{
+				Service: &csi.PluginCapability_Service{
+					Type: csi.PluginCapability_Service_CONTROLLER_SERVICE,
+				},
+			},
+		})
+	}
+
+	// Expansion only records the new size, so volumes can grow while in use
+	if d.volumeExpansion {
+		capabilities = append(capabilities, &csi.PluginCapability{
+			Type: &csi.PluginCapability_VolumeExpansion_{
+				VolumeExpansion: &csi.PluginCapability_VolumeExpansion{
+					Type: csi.PluginCapability_VolumeExpansion_ONLINE,
 				},
 			},
-		},
+		})
+	}
+
+	return &csi.GetPluginCapabilitiesResponse{
+		Capabilities: capabilities,
 	}, nil
 }
 
-// Probe checks if the plugin is healthy
+// mountHelpers are the binaries mount(8) runs for NFS; either one is enough
+var mountHelpers = []string{"mount.nfs", "mount.nfs4"}
+
+// lookPath is replaced in tests
+var lookPath = exec.LookPath
+
+// WithRegistrationPath makes the node service report ready only once the
+// node-driver-registrar socket at path exists. Empty skips the check.
+func WithRegistrationPath(path string) DriverOption {
+	return func(d *Driver) {
+		d.registrationPath = path
+	}
+}
+
+// Probe checks if the plugin is healthy. The driver is ready once the gRPC
+// server is serving; the node service additionally needs an NFS mount helper,
+// since every mount fails without it, unless mounts go through the mount
+// proxy, and the registrar socket if configured.
 func (d *Driver) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
-	klog.V(4).Infof("Probe called")
+	d.logV(ctx, subsystemIdentity, 4).Infof("Probe called")
+
+	if err := d.checkReady(ctx); err != nil {
+		klog.Errorf("Probe: %v", err)
+		return &csi.ProbeResponse{
+			Ready: wrapperspb.Bool(false),
+		}, nil
+	}
+	d.checkFscache()
 
 	return &csi.ProbeResponse{
 		Ready: wrapperspb.Bool(true),
 	}, nil
 }
+
+// checkReady returns why the driver is not ready, or nil
+func (d *Driver) checkReady(ctx context.Context) error {
+	if !d.serving.Load() {
+		return fmt.Errorf("gRPC server is not serving")
+	}
+	if !d.servesNode() {
+		return nil
+	}
+	// The mount proxy runs mount.nfs on the host, not in this container
+	if _, proxy := d.mounter.(*proxyMounter); !proxy {
+		if err := d.checkMountHelper(ctx); err != nil {
+			return err
+		}
+	}
+	return d.checkRegistration(ctx)
+}
+
+// checkRegistration waits for the node-driver-registrar socket to appear.
+// Once it has, the driver stays registered for the rest of its lifetime.
+func (d *Driver) checkRegistration(ctx context.Context) error {
+	if d.registrationPath == "" || d.registered.Load() {
+		return nil
+	}
+	if _, err := os.Stat(d.registrationPath); err != nil {
+		return fmt.Errorf("kubelet plugin registration is not complete: %w", err)
+	}
+	d.logV(ctx, subsystemIdentity, 2).Infof("Found registration socket %s", d.registrationPath)
+	d.registered.Store(true)
+	return nil
+}
+
+// cachefilesdRunning reports whether the cachefilesd daemon runs on the
+// node. It needs the host PID namespace; replaced in tests.
+var cachefilesdRunning = func() bool {
+	comms, _ := filepath.Glob("/proc/[0-9]*/comm")
+	for _, comm := range comms {
+		if name, err := os.ReadFile(comm); err == nil && strings.TrimSpace(string(name)) == "cachefilesd" {
+			return true
+		}
+	}
+	return false
+}
+
+// checkFscache warns once volumes use fsc but cachefilesd is not running, in
+// which case the kernel silently skips the cache. This does not affect
+// readiness, since the mounts themselves still work.
+func (d *Driver) checkFscache() {
+	if !d.fscacheUsed.Load() {
+		return
+	}
+	if cachefilesdRunning() {
+		d.fscacheWarned.Store(false)
+		return
+	}
+	if !d.fscacheWarned.Swap(true) {
+		klog.Warningf("Volumes are mounted with fsc, but cachefilesd is not running; reads are not cached")
+	}
+}
+
+// checkMountHelper looks for an NFS mount helper once; the container image
+// does not change while the driver runs, so the result is cached
+func (d *Driver) checkMountHelper(ctx context.Context) error {
+	d.mountHelperOnce.Do(func() {
+		for _, helper := range mountHelpers {
+			if path, err := lookPath(helper); err == nil {
+				d.logV(ctx, subsystemIdentity, 2).Infof("Found NFS mount helper %s", path)
+				return
+			}
+		}
+		d.mountHelperErr = fmt.Errorf("no NFS mount helper found (looked for %s); install nfs-utils/nfs-common in the image", strings.Join(mountHelpers, ", "))
+	})
+	return d.mountHelperErr
+}
@@ -2,9 +2,13 @@ package nfs
 
 import (
 	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"testing"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"k8s.io/mount-utils"
 )
 
 func TestGetPluginInfo(t *testing.T) {
@@ -54,11 +58,77 @@ func TestGetPluginCapabilities(t *testing.T) {
 	}
 }
 
+func TestGetPluginCapabilities_NodeMode(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithMode(ModeNode))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	resp, err := driver.GetPluginCapabilities(context.Background(), &csi.GetPluginCapabilitiesRequest{})
+	if err != nil {
+		t.Fatalf("GetPluginCapabilities failed: %v", err)
+	}
+
+	for _, cap := range resp.Capabilities {
+		if cap.GetService().GetType() == csi.PluginCapability_Service_CONTROLLER_SERVICE {
+			t.Error("Expected no CONTROLLER_SERVICE capability in node mode")
+		}
+	}
+}
+
+func TestGetPluginCapabilities_VolumeExpansion(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithVolumeExpansion(enabled))
+		if err != nil {
+			t.Fatalf("Failed to create driver: %v", err)
+		}
+
+		resp, err := driver.GetPluginCapabilities(context.Background(), &csi.GetPluginCapabilitiesRequest{})
+		if err != nil {
+			t.Fatalf("GetPluginCapabilities failed: %v", err)
+		}
+
+		found := false
+		for _, cap := range resp.Capabilities {
+			if expansion := cap.GetVolumeExpansion(); expansion != nil {
+				found = true
+				if expansion.Type != csi.PluginCapability_VolumeExpansion_ONLINE {
+					t.Errorf("Expected ONLINE volume expansion, got %v", expansion.Type)
+				}
+			}
+		}
+		if found != enabled {
+			t.Errorf("With expansion enabled=%v, VOLUME_EXPANSION advertised=%v", enabled, found)
+		}
+	}
+}
+
+// stubLookPath makes lookPath find only the given binaries and counts the calls
+func stubLookPath(t *testing.T, found ...string) *int {
+	orig := lookPath
+	t.Cleanup(func() { lookPath = orig })
+
+	calls := 0
+	lookPath = func(file string) (string, error) {
+		calls++
+		for _, f := range found {
+			if f == file {
+				return "/sbin/" + file, nil
+			}
+		}
+		return "", exec.ErrNotFound
+	}
+	return &calls
+}
+
 func TestProbe(t *testing.T) {
+	stubLookPath(t, "mount.nfs")
+
 	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock")
 	if err != nil {
 		t.Fatalf("Failed to create driver: %v", err)
 	}
+	driver.serving.Store(true)
 
 	resp, err := driver.Probe(context.Background(), &csi.ProbeRequest{})
 	if err != nil {
@@ -69,3 +139,131 @@ func TestProbe(t *testing.T) {
 		t.Error("Expected driver to be ready")
 	}
 }
+
+func TestProbe_MountHelper(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      string
+		opts      []DriverOption
+		found     []string
+		wantReady bool
+	}{
+		{name: "mount.nfs installed", mode: ModeNode, found: []string{"mount.nfs"}, wantReady: true},
+		{name: "only mount.nfs4 installed", mode: ModeNode, found: []string{"mount.nfs4"}, wantReady: true},
+		{name: "no mount helper", mode: ModeNode, wantReady: false},
+		{name: "controller does not need a mount helper", mode: ModeController, wantReady: true},
+		{name: "proxy mounter does not need a mount helper", mode: ModeNode,
+			opts: []DriverOption{WithMounter(newProxyMounter("/run/nfs-mount-proxy.sock", mount.NewFakeMounter(nil)))}, wantReady: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := stubLookPath(t, tt.found...)
+
+			driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", append([]DriverOption{WithMode(tt.mode)}, tt.opts...)...)
+			if err != nil {
+				t.Fatalf("Failed to create driver: %v", err)
+			}
+			driver.serving.Store(true)
+
+			for i := 0; i < 2; i++ {
+				resp, err := driver.Probe(context.Background(), &csi.ProbeRequest{})
+				if err != nil {
+					t.Fatalf("Probe failed: %v", err)
+				}
+				if resp.GetReady().GetValue() != tt.wantReady {
+					t.Errorf("Expected ready=%v, got %v", tt.wantReady, resp.GetReady().GetValue())
+				}
+			}
+
+			// The lookup is cached after the first probe
+			if *calls > len(mountHelpers) {
+				t.Errorf("Expected the lookup to be cached, got %d lookups", *calls)
+			}
+		})
+	}
+}
+
+func TestProbe_Readiness(t *testing.T) {
+	stubLookPath(t, "mount.nfs")
+
+	registration := filepath.Join(t.TempDir(), "nfs.csi.takutakahashi.dev-reg.sock")
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithRegistrationPath(registration))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	probe := func() bool {
+		resp, err := driver.Probe(context.Background(), &csi.ProbeRequest{})
+		if err != nil {
+			t.Fatalf("Probe failed: %v", err)
+		}
+		return resp.GetReady().GetValue()
+	}
+
+	if probe() {
+		t.Error("Expected not ready before the server is serving")
+	}
+
+	driver.serving.Store(true)
+	if probe() {
+		t.Error("Expected not ready before the registrar socket exists")
+	}
+
+	if err := os.WriteFile(registration, nil, 0600); err != nil {
+		t.Fatalf("Failed to create registration socket: %v", err)
+	}
+	if !probe() {
+		t.Error("Expected ready once serving and registered")
+	}
+
+	driver.Stop()
+	if probe() {
+		t.Error("Expected not ready after stop")
+	}
+}
+
+func TestProbe_Fscache(t *testing.T) {
+	stubLookPath(t, "mount.nfs")
+	orig := cachefilesdRunning
+	t.Cleanup(func() { cachefilesdRunning = orig })
+	running := false
+	checks := 0
+	cachefilesdRunning = func() bool {
+		checks++
+		return running
+	}
+
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock")
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+	driver.serving.Store(true)
+
+	probe := func() {
+		resp, err := driver.Probe(context.Background(), &csi.ProbeRequest{})
+		if err != nil {
+			t.Fatalf("Probe failed: %v", err)
+		}
+		if !resp.GetReady().GetValue() {
+			t.Error("Expected a missing cachefilesd not to affect readiness")
+		}
+	}
+
+	probe()
+	if checks != 0 {
+		t.Errorf("Expected no cachefilesd check before fsc is used, got %d", checks)
+	}
+
+	driver.fscacheUsed.Store(true)
+	probe()
+	if !driver.fscacheWarned.Load() {
+		t.Error("Expected a warning while cachefilesd is not running")
+	}
+
+	running = true
+	probe()
+	if driver.fscacheWarned.Load() {
+		t.Error("Expected the warning to be reset once cachefilesd runs")
+	}
+}
@@ -0,0 +1,104 @@
+package nfs
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// Subsystems whose log verbosity can be set with --log-levels, one per CSI service
+const (
+	subsystemIdentity   = "identity"
+	subsystemController = "controller"
+	subsystemNode       = "node"
+)
+
+// WithLogLevels overrides the global -v verbosity for the given subsystems.
+// Subsystems not in levels keep logging at -v.
+func WithLogLevels(levels map[string]klog.Level) DriverOption {
+	return func(d *Driver) {
+		d.logLevels = levels
+	}
+}
+
+// ParseLogLevels parses a comma-separated list of subsystem=level pairs such
+// as "node=5,controller=2". The subsystems are identity, controller and node.
+func ParseLogLevels(value string) (map[string]klog.Level, error) {
+	levels := make(map[string]klog.Level)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, level, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid log level %q: must be subsystem=level", entry)
+		}
+		name = strings.TrimSpace(name)
+		switch name {
+		case subsystemIdentity, subsystemController, subsystemNode:
+		default:
+			return nil, fmt.Errorf("invalid log level %q: subsystem must be %s, %s or %s", entry, subsystemIdentity, subsystemController, subsystemNode)
+		}
+		if _, ok := levels[name]; ok {
+			return nil, fmt.Errorf("log level for %s set more than once", name)
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(level), 10, 32)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid log level %q: level must be a non-negative integer", entry)
+		}
+		levels[name] = klog.Level(n)
+	}
+	return levels, nil
+}
+
+// requestIDKey is the context key logGRPC stores the request ID under
+type requestIDKey struct{}
+
+// verbose is a klog.Verbose that appends the request ID of the gRPC call it
+// was created for to each line
+type verbose struct {
+	klog.Verbose
+	requestID string
+}
+
+// Infof logs like klog.Verbose.Infof, followed by the request ID if any
+func (v verbose) Infof(format string, args ...interface{}) {
+	if v.requestID != "" {
+		format += " (request ID %s)"
+		args = append(args, v.requestID)
+	}
+	v.Verbose.InfofDepth(1, format, args...)
+}
+
+// logV is klog.V for a subsystem, using its level from --log-levels instead
+// of -v when one is set. Lines carry the request ID of ctx.
+func (d *Driver) logV(ctx context.Context, subsystem string, level klog.Level) verbose {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	max, ok := d.logLevels[subsystem]
+	if !ok {
+		return verbose{Verbose: klog.V(level), requestID: id}
+	}
+	if level > max {
+		// The zero Verbose is disabled
+		return verbose{requestID: id}
+	}
+	return verbose{Verbose: klog.V(0), requestID: id}
+}
+
+// methodSubsystem returns the subsystem of a gRPC method such as
+// "/csi.v1.Node/NodePublishVolume", or "" for a method outside the CSI services
+func methodSubsystem(fullMethod string) string {
+	service, _, _ := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	if i := strings.LastIndex(service, "."); i >= 0 {
+		service = service[i+1:]
+	}
+	switch name := strings.ToLower(service); name {
+	case subsystemIdentity, subsystemController, subsystemNode:
+		return name
+	}
+	return ""
+}
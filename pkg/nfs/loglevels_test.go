@@ -0,0 +1,113 @@
+package nfs
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"k8s.io/klog/v2"
+)
+
+func TestParseLogLevels(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    map[string]klog.Level
+		wantErr bool
+	}{
+		{value: "", want: map[string]klog.Level{}},
+		{value: "node=5,controller=2", want: map[string]klog.Level{"node": 5, "controller": 2}},
+		{value: " identity = 0 , node=4,", want: map[string]klog.Level{"identity": 0, "node": 4}},
+		{value: "node", wantErr: true},
+		{value: "mounter=5", wantErr: true},
+		{value: "node=-1", wantErr: true},
+		{value: "node=high", wantErr: true},
+		{value: "node=5,node=2", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseLogLevels(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLogLevels(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseLogLevels(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogV(t *testing.T) {
+	driver := &Driver{logLevels: map[string]klog.Level{subsystemNode: 5, subsystemController: 0}}
+
+	tests := []struct {
+		subsystem string
+		level     klog.Level
+		want      bool
+	}{
+		{subsystem: subsystemNode, level: 5, want: true},
+		{subsystem: subsystemNode, level: 6, want: false},
+		{subsystem: subsystemController, level: 0, want: true},
+		{subsystem: subsystemController, level: 2, want: false},
+		// Falls back to -v, which is 0 in tests
+		{subsystem: subsystemIdentity, level: 0, want: true},
+		{subsystem: subsystemIdentity, level: 4, want: false},
+	}
+
+	for _, tt := range tests {
+		if got := driver.logV(context.Background(), tt.subsystem, tt.level).Enabled(); got != tt.want {
+			t.Errorf("logV(%s, %d) = %v, want %v", tt.subsystem, tt.level, got, tt.want)
+		}
+	}
+}
+
+func TestMethodSubsystem(t *testing.T) {
+	for method, want := range map[string]string{
+		"/csi.v1.Node/NodePublishVolume":  subsystemNode,
+		"/csi.v1.Controller/CreateVolume": subsystemController,
+		"/csi.v1.Identity/Probe":          subsystemIdentity,
+		"/grpc.health.v1.Health/Check":    "",
+		"":                                "",
+	} {
+		if got := methodSubsystem(method); got != want {
+			t.Errorf("methodSubsystem(%q) = %q, want %q", method, got, want)
+		}
+	}
+}
+
+func TestLogV_RequestID(t *testing.T) {
+	var buf bytes.Buffer
+	klog.LogToStderr(false)
+	klog.SetOutput(&buf)
+	t.Cleanup(func() {
+		klog.SetOutput(os.Stderr)
+		klog.LogToStderr(true)
+	})
+
+	driver := &Driver{name: DefaultDriverName, logLevels: map[string]klog.Level{subsystemIdentity: 4}}
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Identity/GetPluginInfo"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return driver.GetPluginInfo(ctx, req.(*csi.GetPluginInfoRequest))
+	}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(RequestIDMetadataKey, "trace-4321"))
+	if _, err := driver.logGRPC(ctx, &csi.GetPluginInfoRequest{}, info, handler); err != nil {
+		t.Fatalf("GetPluginInfo failed: %v", err)
+	}
+	klog.Flush()
+
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.Contains(line, "GetPluginInfo called") {
+			if !strings.Contains(line, "(request ID trace-4321)") {
+				t.Errorf("Expected the handler log line to carry the request ID, got %q", line)
+			}
+			return
+		}
+	}
+	t.Errorf("Expected a handler log line, got %q", buf.String())
+}
@@ -0,0 +1,71 @@
+package nfs
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	mount "k8s.io/mount-utils"
+)
+
+// mountWaitDelay bounds how long a canceled mount waits for its output pipes
+// to close after the mount helper was killed
+const mountWaitDelay = 5 * time.Second
+
+// ContextMounter is implemented by mounters that stop a mount in progress
+// when its context ends. Mounters that do not implement it, such as the
+// fake mounter in tests, mount without a deadline.
+type ContextMounter interface {
+	MountContext(ctx context.Context, source, target, fstype string, options []string) error
+}
+
+// execMounter runs NFS mounts as a mount(8) child process that is killed,
+// together with the mount.nfs helper it starts, when the context ends. Every
+// other operation, bind mounts included, goes to the wrapped mounter.
+type execMounter struct {
+	mount.Interface
+
+	// command builds the mount command; tests replace it
+	command func(ctx context.Context, name string, args ...string) *exec.Cmd
+}
+
+// newExecMounter wraps mounter so NFS mounts follow the request context
+func newExecMounter(mounter mount.Interface) *execMounter {
+	return &execMounter{Interface: mounter, command: exec.CommandContext}
+}
+
+// MountContext mounts source at target, killing the mount process group if
+// ctx ends first. The error keeps the format of the mount-utils mounter so
+// classifyMountError reads it the same way.
+func (m *execMounter) MountContext(ctx context.Context, source, target, fstype string, options []string) error {
+	args := mount.MakeMountArgs(source, target, fstype, options)
+	cmd := m.command(ctx, "mount", args...)
+	// mount(8) forks mount.nfs, which is what hangs on an unreachable server,
+	// so the whole process group has to go
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = mountWaitDelay
+
+	output, err := cmd.CombinedOutput()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("mount of %s at %s stopped: %w", source, target, ctxErr)
+	}
+	if err != nil {
+		return fmt.Errorf("mount failed: %v\nMounting command: mount\nMounting arguments: %s\nOutput: %s", err, strings.Join(args, " "), string(output))
+	}
+	return nil
+}
+
+// mountContext mounts source at target with the driver's mounter, stopping
+// the mount when ctx ends if the mounter supports it
+func (d *Driver) mountContext(ctx context.Context, source, target, fstype string, options []string) error {
+	if m, ok := d.mounter.(ContextMounter); ok {
+		return m.MountContext(ctx, source, target, fstype, options)
+	}
+	return d.mounter.Mount(source, target, fstype, options)
+}
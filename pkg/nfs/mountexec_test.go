@@ -0,0 +1,156 @@
+package nfs
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/mount-utils"
+)
+
+// scriptMounter returns an execMounter that runs script with sh instead of
+// mount, recording the arguments mount would have been given
+func scriptMounter(script string, gotArgs *[]string) *execMounter {
+	m := newExecMounter(mount.NewFakeMounter([]mount.MountPoint{}))
+	m.command = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		if gotArgs != nil {
+			*gotArgs = append([]string{name}, args...)
+		}
+		return exec.CommandContext(ctx, "sh", "-c", script)
+	}
+	return m
+}
+
+func TestExecMounter_MountContext(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		wantErr  string
+		wantCode codes.Code
+	}{
+		{name: "mount succeeds", script: "exit 0", wantCode: codes.OK},
+		{
+			name:     "mount helper output is kept",
+			script:   "echo 'mount.nfs: access denied by server while mounting 192.168.1.1:/data'; exit 32",
+			wantErr:  "access denied by server",
+			wantCode: codes.PermissionDenied,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var args []string
+			m := scriptMounter(tt.script, &args)
+
+			err := m.MountContext(context.Background(), "192.168.1.1:/data", "/mnt/target", "nfs", []string{"nolock", "nfsvers=3"})
+			wantArgs := []string{"mount", "-t", "nfs", "-o", "nolock,nfsvers=3", "192.168.1.1:/data", "/mnt/target"}
+			if !reflect.DeepEqual(args, wantArgs) {
+				t.Errorf("Expected command %v, got %v", wantArgs, args)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+			if code := classifyMountError(err); code != tt.wantCode {
+				t.Errorf("Expected code %v, got %v (%v)", tt.wantCode, code, err)
+			}
+		})
+	}
+}
+
+func TestExecMounter_CancelStopsMount(t *testing.T) {
+	tests := []struct {
+		name     string
+		cancel   func(context.Context) (context.Context, context.CancelFunc)
+		wantErr  error
+		wantCode codes.Code
+	}{
+		{
+			name: "deadline",
+			cancel: func(ctx context.Context) (context.Context, context.CancelFunc) {
+				return context.WithTimeout(ctx, 100*time.Millisecond)
+			},
+			wantErr:  context.DeadlineExceeded,
+			wantCode: codes.DeadlineExceeded,
+		},
+		{
+			name: "cancel",
+			cancel: func(ctx context.Context) (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(ctx)
+				time.AfterFunc(100*time.Millisecond, cancel)
+				return ctx, cancel
+			},
+			wantErr:  context.Canceled,
+			wantCode: codes.Canceled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The background sleep stands in for mount.nfs: it holds the
+			// output pipe open unless the whole process group is killed
+			m := scriptMounter("sleep 60 & wait", nil)
+			ctx, cancel := tt.cancel(context.Background())
+			defer cancel()
+
+			start := time.Now()
+			err := m.MountContext(ctx, "192.168.1.1:/data", "/mnt/target", "nfs", nil)
+			if elapsed := time.Since(start); elapsed >= mountWaitDelay {
+				t.Errorf("Expected the mount to stop with its context, took %v", elapsed)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Expected %v, got %v", tt.wantErr, err)
+			}
+			if code := classifyMountError(err); code != tt.wantCode {
+				t.Errorf("Expected code %v, got %v", tt.wantCode, code)
+			}
+		})
+	}
+}
+
+func TestNodePublishVolume_MountDeadline(t *testing.T) {
+	var args []string
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithMounter(scriptMounter("sleep 60 & wait", &args)))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	target := filepath.Join(t.TempDir(), "target")
+	start := time.Now()
+	_, err = driver.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+		VolumeId:   "test-volume",
+		TargetPath: target,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+			},
+		},
+		// The second server must not be tried once the deadline has passed
+		VolumeContext: map[string]string{
+			"server": "192.168.1.1,192.168.1.2",
+			"share":  "/data",
+		},
+	})
+	if elapsed := time.Since(start); elapsed >= mountWaitDelay {
+		t.Errorf("Expected NodePublishVolume to return at its deadline, took %v", elapsed)
+	}
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("Expected DeadlineExceeded, got %v", err)
+	}
+	if len(args) == 0 || args[len(args)-2] != "192.168.1.1:/data" {
+		t.Errorf("Expected only the first server to be mounted, last command %v", args)
+	}
+}
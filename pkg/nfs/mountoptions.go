@@ -0,0 +1,697 @@
+package nfs
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"k8s.io/klog/v2"
+)
+
+// mountOptionName returns the option name without its value, e.g. "nfsvers" for "nfsvers=4.1"
+func mountOptionName(option string) string {
+	name, _, _ := strings.Cut(strings.TrimSpace(option), "=")
+	return name
+}
+
+// validateMountOptions checks user-supplied mount options against the configured
+// allowlist and denylist. Options are matched by name, so "nfsvers" covers "nfsvers=4.1".
+// When neither list is configured every option is permitted.
+func (d *Driver) validateMountOptions(options []string) error {
+	for _, option := range options {
+		name := mountOptionName(option)
+		if name == "" {
+			continue
+		}
+		if len(d.allowedMountOptions) > 0 && !containsString(d.allowedMountOptions, name) {
+			return fmt.Errorf("mount option %q is not in the allowed list", option)
+		}
+		if containsString(d.deniedMountOptions, name) {
+			return fmt.Errorf("mount option %q is denied", option)
+		}
+	}
+	return nil
+}
+
+// accessModeMountOptions maps access modes to the parameter holding their mount options
+var accessModeMountOptions = map[csi.VolumeCapability_AccessMode_Mode]string{
+	csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER: ParamMountOptionsRWX,
+	csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY:  ParamMountOptionsROX,
+}
+
+// defaultMountOptions are added to every NFS mount unless the volume sets
+// mountOptionsMode: replace.
+// nolock: disable NFS locking (avoids rpc.statd requirement in containers)
+var defaultMountOptions = []string{"nolock"}
+
+// getMountOptionsMode returns the mountOptionsMode parameter, defaulting to append
+func getMountOptionsMode(params map[string]string) (string, error) {
+	switch mode := params[ParamMountOptionsMode]; mode {
+	case "":
+		return MountOptionsModeAppend, nil
+	case MountOptionsModeAppend, MountOptionsModeReplace:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid %s %q: must be %s or %s", ParamMountOptionsMode, mode, MountOptionsModeAppend, MountOptionsModeReplace)
+	}
+}
+
+// capabilityMountFlags returns the user-supplied mount options for cap: the
+// options set for its access mode in the volume context, falling back to the
+// mount flags from the StorageClass mountOptions followed by the mountOptions
+// parameter. The SELinux context option kubelet passes in the mount flags is
+// kept either way, so it is validated like any other option.
+func capabilityMountFlags(cap *csi.VolumeCapability, volumeContext map[string]string) []string {
+	flags := cap.GetMount().GetMountFlags()
+	if key, ok := accessModeMountOptions[cap.GetAccessMode().GetMode()]; ok {
+		if value := volumeContext[key]; value != "" {
+			var selinuxFlags []string
+			for _, flag := range flags {
+				if mountOptionName(flag) == selinuxContextOption {
+					selinuxFlags = append(selinuxFlags, flag)
+				}
+			}
+			return mergeMountOptions(selinuxFlags, splitMountOptions(value))
+		}
+	}
+	return mergeMountOptions(append([]string(nil), flags...), splitMountOptions(volumeContext[ParamMountOptions]))
+}
+
+// volumeMountFlags returns the user mount options of a volume: those from the
+// capability or access mode parameter, then the PVC annotation, then the
+// mount profile, each overriding the ones after it
+func volumeMountFlags(cap *csi.VolumeCapability, volumeContext map[string]string) []string {
+	mountFlags := mergeMountOptions(capabilityMountFlags(cap, volumeContext),
+		splitMountOptions(volumeContext[ParamAnnotationMountOptions]))
+	return mergeMountOptions(mountFlags, splitMountOptions(volumeContext[ParamProfileMountOptions]))
+}
+
+// mergeMountOptions adds lower-priority mount options, such as those from the
+// PVC annotation, to flags. flags take priority: a lower option is dropped when
+// flags already set the same option, or the other half of a conflicting pair.
+func mergeMountOptions(flags, lowerOptions []string) []string {
+	set := make(map[string]bool)
+	for _, flag := range flags {
+		set[mountOptionName(flag)] = true
+	}
+
+	merged := append([]string(nil), flags...)
+	for _, option := range lowerOptions {
+		name := mountOptionName(option)
+		overridden := set[name]
+		for _, pair := range conflictingMountOptions {
+			if (name == pair[0] && set[pair[1]]) || (name == pair[1] && set[pair[0]]) {
+				overridden = true
+			}
+		}
+		if overridden {
+			klog.V(4).Infof("Ignoring mount option %q, it conflicts with a higher-priority mount option", option)
+			continue
+		}
+		merged = append(merged, option)
+	}
+	return merged
+}
+
+// selinuxContextOption is the mount option labelling every file on the mount
+const selinuxContextOption = "context"
+
+// selinuxContextPattern matches an SELinux context user:role:type[:level],
+// where the MLS/MCS level may itself hold colons, commas and ranges
+var selinuxContextPattern = regexp.MustCompile(`^[A-Za-z0-9_]+:[A-Za-z0-9_]+:[A-Za-z0-9_]+(:[A-Za-z0-9_.,:-]+)?$`)
+
+// validateSELinuxContext checks the format of an SELinux context
+func validateSELinuxContext(label string) error {
+	if !selinuxContextPattern.MatchString(label) {
+		return fmt.Errorf("invalid SELinux context %q: must be user:role:type[:level]", label)
+	}
+	return nil
+}
+
+// selinuxMountOption returns the context= mount option for the volume: the one
+// in the user mount flags, such as the label kubelet passes when the CSIDriver
+// enables seLinuxMount, otherwise one built from the selinuxContext
+// parameter. The value is quoted because MCS levels such as s0:c1,c2 contain
+// commas.
+func selinuxMountOption(mountFlags []string, volumeContext map[string]string) (string, error) {
+	label := volumeContext[ParamSELinuxContext]
+	for _, flag := range mountFlags {
+		if name, value, _ := strings.Cut(strings.TrimSpace(flag), "="); name == selinuxContextOption {
+			label = strings.Trim(value, `"`)
+		}
+	}
+	if label == "" {
+		return "", nil
+	}
+
+	if err := validateSELinuxContext(label); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s=%q", selinuxContextOption, label), nil
+}
+
+// splitMountOptions splits a comma-separated list of mount options
+func splitMountOptions(value string) []string {
+	var options []string
+	for _, option := range strings.Split(value, ",") {
+		if option = strings.TrimSpace(option); option != "" {
+			options = append(options, option)
+		}
+	}
+	return options
+}
+
+// containsString reports whether s is present in list
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// recoveryMountOptions translates the mountRecovery, retrans and timeo parameters
+// into NFS mount options. soft mounts can return I/O errors (and lose writes)
+// when the server is unreachable, so they are refused for multi-writer volumes
+// unless forceSoftMount is set.
+func recoveryMountOptions(params map[string]string, mode csi.VolumeCapability_AccessMode_Mode) ([]string, error) {
+	recovery := params[ParamMountRecovery]
+	retrans := params[ParamRetrans]
+	timeo := params[ParamTimeo]
+
+	var options []string
+	switch recovery {
+	case "":
+	case MountRecoveryHard:
+		options = append(options, "hard")
+	case MountRecoverySoft:
+		options = append(options, "soft")
+	case MountRecoverySoftReval:
+		// softreval implies soft and serves cached attributes while the server is down
+		options = append(options, "soft", "softreval")
+	default:
+		return nil, fmt.Errorf("invalid %s %q: must be %s, %s or %s",
+			ParamMountRecovery, recovery, MountRecoveryHard, MountRecoverySoft, MountRecoverySoftReval)
+	}
+
+	isSoft := recovery == MountRecoverySoft || recovery == MountRecoverySoftReval
+	if (retrans != "" || timeo != "") && !isSoft {
+		return nil, fmt.Errorf("%s and %s require %s to be %s or %s",
+			ParamRetrans, ParamTimeo, ParamMountRecovery, MountRecoverySoft, MountRecoverySoftReval)
+	}
+	if isSoft && mode == csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER && params[ParamForceSoftMount] != "true" {
+		return nil, fmt.Errorf("%s=%s risks silent data loss on ReadWriteMany volumes; set %s=\"true\" to use it anyway",
+			ParamMountRecovery, recovery, ParamForceSoftMount)
+	}
+
+	if retrans != "" {
+		if n, err := strconv.Atoi(retrans); err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid %s %q: must be a non-negative integer", ParamRetrans, retrans)
+		}
+		options = append(options, "retrans="+retrans)
+	}
+	if timeo != "" {
+		if n, err := strconv.Atoi(timeo); err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid %s %q: must be a positive number of deciseconds", ParamTimeo, timeo)
+		}
+		options = append(options, "timeo="+timeo)
+	}
+
+	return options, nil
+}
+
+// fscacheMountOptions translates enableFscache into the fsc mount option.
+// The local cache is only coherent with the server on close-to-open
+// boundaries, so writable access modes need forceFscache as well.
+func fscacheMountOptions(params map[string]string, mode csi.VolumeCapability_AccessMode_Mode) ([]string, error) {
+	value := params[ParamEnableFscache]
+	if value == "" {
+		return nil, nil
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q: must be true or false", ParamEnableFscache, value)
+	}
+	if !enabled {
+		return nil, nil
+	}
+
+	if !isReadOnlyMode(mode) && params[ParamForceFscache] != "true" {
+		return nil, fmt.Errorf("%s is only allowed for read-only access modes, got %v; set %s=\"true\" to use it anyway",
+			ParamEnableFscache, mode, ParamForceFscache)
+	}
+	return []string{"fsc"}, nil
+}
+
+// flagMountParameters are boolean volume context parameters that map to the
+// mount option of the same name
+var flagMountParameters = []string{ParamNoatime, ParamNodiratime, ParamSync}
+
+// transports are the values accepted for the transport parameter
+var transports = []string{"tcp", "udp", "rdma"}
+
+// transportMountOptions translates the transport parameter into a proto= mount
+// option. Nothing is added if the user already passed the same proto=, and a
+// different one is rejected rather than leaving the result to option order.
+func transportMountOptions(params map[string]string, existing []string) ([]string, error) {
+	transport := params[ParamTransport]
+	if transport == "" {
+		return nil, nil
+	}
+	if !containsString(transports, transport) {
+		return nil, fmt.Errorf("invalid %s %q: must be one of %s", ParamTransport, transport, strings.Join(transports, ", "))
+	}
+
+	for _, option := range existing {
+		if name, value, _ := strings.Cut(strings.TrimSpace(option), "="); name == "proto" {
+			if value != transport {
+				return nil, fmt.Errorf("%s %q conflicts with mount option %q", ParamTransport, transport, option)
+			}
+			return nil, nil
+		}
+	}
+	return []string{"proto=" + transport}, nil
+}
+
+// localLockModes are the values accepted by the localLock parameter
+var localLockModes = []string{"none", "all", "flock", "posix"}
+
+// localLockMountOptions translates the localLock parameter into a local_lock=
+// mount option, with the same handling of an existing local_lock= as
+// transportMountOptions. A user nolock is rejected, since it would turn
+// locking off again.
+func localLockMountOptions(params map[string]string, existing []string) ([]string, error) {
+	localLock := params[ParamLocalLock]
+	if localLock == "" {
+		return nil, nil
+	}
+	if !containsString(localLockModes, localLock) {
+		return nil, fmt.Errorf("invalid %s %q: must be one of %s", ParamLocalLock, localLock, strings.Join(localLockModes, ", "))
+	}
+
+	for _, option := range existing {
+		name, value, _ := strings.Cut(strings.TrimSpace(option), "=")
+		switch name {
+		case "nolock":
+			return nil, fmt.Errorf("%s %q conflicts with mount option %q", ParamLocalLock, localLock, option)
+		case "local_lock":
+			if value != localLock {
+				return nil, fmt.Errorf("%s %q conflicts with mount option %q", ParamLocalLock, localLock, option)
+			}
+			return nil, nil
+		}
+	}
+	return []string{"local_lock=" + localLock}, nil
+}
+
+// attributeCachePresets maps attributeCache presets to their mount options.
+// default keeps the kernel timeouts (3-60s for files, 30-60s for
+// directories); disabled is the same as actimeo=0.
+var attributeCachePresets = map[string][]string{
+	AttributeCacheDefault:    nil,
+	AttributeCacheAggressive: {"acregmin=60", "acregmax=600", "acdirmin=60", "acdirmax=600"},
+	AttributeCacheDisabled:   {"acregmin=0", "acregmax=0", "acdirmin=0", "acdirmax=0"},
+}
+
+// attributeCacheMountOptions translates the attributeCache parameter into
+// attribute cache timeouts. User options win: a timeout already in existing
+// is kept, and actimeo, ac or noac leave the preset out entirely.
+func attributeCacheMountOptions(params map[string]string, existing []string) ([]string, error) {
+	preset := params[ParamAttributeCache]
+	if preset == "" {
+		return nil, nil
+	}
+	presetOptions, ok := attributeCachePresets[preset]
+	if !ok {
+		return nil, fmt.Errorf("invalid %s %q: must be %s, %s or %s", ParamAttributeCache, preset,
+			AttributeCacheDefault, AttributeCacheAggressive, AttributeCacheDisabled)
+	}
+
+	set := make(map[string]bool)
+	for _, option := range existing {
+		set[mountOptionName(option)] = true
+	}
+	if set["actimeo"] || set["ac"] || set["noac"] {
+		return nil, nil
+	}
+
+	var options []string
+	for _, option := range presetOptions {
+		if !set[mountOptionName(option)] {
+			options = append(options, option)
+		}
+	}
+	return options, nil
+}
+
+const (
+	// maxNconnect is the most connections the kernel opens per server
+	maxNconnect = 16
+
+	// nconnectKernelMajor and nconnectKernelMinor are the first upstream
+	// kernel version supporting nconnect
+	nconnectKernelMajor = 5
+	nconnectKernelMinor = 3
+)
+
+// nconnectMountOptions translates the nconnect parameter into an nconnect=
+// mount option, with the same handling of an existing nconnect= as
+// transportMountOptions
+func nconnectMountOptions(params map[string]string, existing []string) ([]string, error) {
+	value := params[ParamNconnect]
+	if value == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > maxNconnect {
+		return nil, fmt.Errorf("invalid %s %q: must be a number between 1 and %d", ParamNconnect, value, maxNconnect)
+	}
+
+	for _, option := range existing {
+		if name, existingValue, _ := strings.Cut(strings.TrimSpace(option), "="); name == "nconnect" {
+			if existingValue != strconv.Itoa(n) {
+				return nil, fmt.Errorf("%s %q conflicts with mount option %q", ParamNconnect, value, option)
+			}
+			return nil, nil
+		}
+	}
+	return []string{"nconnect=" + strconv.Itoa(n)}, nil
+}
+
+// reservedPortMountOptions translates the reservedPort parameter into
+// resvport or noresvport. A user option asking for the same is kept, and
+// the opposite one is rejected.
+func reservedPortMountOptions(params map[string]string, existing []string) ([]string, error) {
+	value := params[ParamReservedPort]
+	if value == "" {
+		return nil, nil
+	}
+	reserved, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q: must be true or false", ParamReservedPort, value)
+	}
+	want, other := "resvport", "noresvport"
+	if !reserved {
+		want, other = other, want
+	}
+
+	for _, option := range existing {
+		switch strings.TrimSpace(option) {
+		case want:
+			return nil, nil
+		case other:
+			return nil, fmt.Errorf("%s %q conflicts with mount option %q", ParamReservedPort, value, option)
+		}
+	}
+	return []string{want}, nil
+}
+
+// kernelRelease returns the running kernel version, e.g. 5.15.0-91-generic.
+// It is replaced in tests.
+var kernelRelease = func() (string, error) {
+	release, err := os.ReadFile("/proc/sys/kernel/osrelease")
+	return strings.TrimSpace(string(release)), err
+}
+
+// supportsNconnect reports whether a kernel release is at least 5.3, which
+// added nconnect. Releases it cannot parse are assumed to support it.
+func supportsNconnect(release string) bool {
+	majorValue, rest, _ := strings.Cut(release, ".")
+	minorValue, _, _ := strings.Cut(rest, ".")
+	minorValue = strings.TrimRightFunc(minorValue, func(r rune) bool { return r < '0' || r > '9' })
+	major, err := strconv.Atoi(majorValue)
+	if err != nil {
+		return true
+	}
+	minor, err := strconv.Atoi(minorValue)
+	if err != nil {
+		return true
+	}
+	return major > nconnectKernelMajor || (major == nconnectKernelMajor && minor >= nconnectKernelMinor)
+}
+
+// checkNconnect warns once when options use nconnect but the node's kernel
+// predates it. Such kernels ignore or reject the option unless their
+// distribution backported it, so the mount may use a single connection.
+func (d *Driver) checkNconnect(options []string) {
+	used := false
+	for _, option := range options {
+		if mountOptionName(option) == "nconnect" {
+			used = true
+		}
+	}
+	if !used || d.nconnectWarned.Load() {
+		return
+	}
+	release, err := kernelRelease()
+	if err != nil || supportsNconnect(release) {
+		return
+	}
+	if !d.nconnectWarned.Swap(true) {
+		klog.Warningf("Volumes are mounted with nconnect, but kernel %s predates it (%d.%d); unless backported, mounts use a single connection or fail", release, nconnectKernelMajor, nconnectKernelMinor)
+	}
+}
+
+// getClientAddr returns the validated clientAddr parameter: empty, auto or
+// an IP address. The unspecified address (0.0.0.0 or ::) tells the server
+// not to hand out delegations.
+func getClientAddr(params map[string]string) (string, error) {
+	value := params[ParamClientAddr]
+	if value == "" || value == ClientAddrAuto {
+		return value, nil
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return "", fmt.Errorf("invalid %s %q: must be an IP address or %s", ParamClientAddr, value, ClientAddrAuto)
+	}
+	return ip.String(), nil
+}
+
+// localAddrTo returns the local address the node uses to reach address. A
+// UDP dial only looks up the route, so nothing is sent. It is replaced in
+// tests.
+var localAddrTo = func(address string) (net.IP, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// clientAddrMountOptions translates the clientAddr parameter into a
+// clientaddr= mount option, with the same handling of an existing clientaddr=
+// as transportMountOptions. auto uses the address of the interface the node
+// reaches the first server through, which a user clientaddr= overrides.
+func clientAddrMountOptions(params map[string]string, existing []string) ([]string, error) {
+	clientAddr, err := getClientAddr(params)
+	if err != nil || clientAddr == "" {
+		return nil, err
+	}
+
+	for _, option := range existing {
+		if name, value, _ := strings.Cut(strings.TrimSpace(option), "="); name == "clientaddr" {
+			if clientAddr != ClientAddrAuto && value != clientAddr {
+				return nil, fmt.Errorf("%s %q conflicts with mount option %q", ParamClientAddr, clientAddr, option)
+			}
+			return nil, nil
+		}
+	}
+
+	if clientAddr == ClientAddrAuto {
+		servers := getServers(params)
+		if len(servers) == 0 {
+			return nil, fmt.Errorf("%s %s needs a server", ParamClientAddr, ClientAddrAuto)
+		}
+		// validateVolumeParameters has checked the port already
+		port, _ := parsePort(params[ParamPort])
+		if port == 0 {
+			port = defaultNFSPort
+		}
+		host := strings.TrimSuffix(strings.TrimPrefix(servers[0], "["), "]")
+		ip, err := localAddrTo(net.JoinHostPort(host, strconv.Itoa(port)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to find the local address towards %s for %s %s: %v", servers[0], ParamClientAddr, ClientAddrAuto, err)
+		}
+		clientAddr = ip.String()
+	}
+	return []string{"clientaddr=" + clientAddr}, nil
+}
+
+// securityFlavors are the values accepted by the security parameter
+var securityFlavors = []string{"sys", "krb5", "krb5i", "krb5p"}
+
+// securityMountOptions translates the security parameter into a sec= mount
+// option, with the same handling of an existing sec= as transportMountOptions
+func securityMountOptions(params map[string]string, existing []string) ([]string, error) {
+	security := params[ParamSecurity]
+	if security == "" {
+		return nil, nil
+	}
+	if !containsString(securityFlavors, security) {
+		return nil, fmt.Errorf("invalid %s %q: must be one of %s", ParamSecurity, security, strings.Join(securityFlavors, ", "))
+	}
+
+	for _, option := range existing {
+		if name, value, _ := strings.Cut(strings.TrimSpace(option), "="); name == "sec" {
+			if value != security {
+				return nil, fmt.Errorf("%s %q conflicts with mount option %q", ParamSecurity, security, option)
+			}
+			return nil, nil
+		}
+	}
+	return []string{"sec=" + security}, nil
+}
+
+// flagMountOptions translates the boolean noatime, nodiratime and sync
+// parameters into mount options, skipping any already present in existing
+func flagMountOptions(params map[string]string, existing []string) ([]string, error) {
+	var options []string
+	for _, param := range flagMountParameters {
+		value := params[param]
+		if value == "" {
+			continue
+		}
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: must be true or false", param, value)
+		}
+		if !enabled {
+			continue
+		}
+		duplicate := false
+		for _, option := range existing {
+			if mountOptionName(option) == param {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			options = append(options, param)
+		}
+	}
+	return options, nil
+}
+
+// conflictingMountOptions lists mutually exclusive options; within each pair
+// only one may remain after normalization
+var conflictingMountOptions = [][2]string{
+	{"ro", "rw"},
+	{"hard", "soft"},
+	{"ac", "noac"},
+}
+
+// negotiatedMountOptions are reported by the kernel with values that differ
+// from the requested ones even when nothing changed, so they are not compared
+var negotiatedMountOptions = map[string]bool{
+	"rsize":              true,
+	"wsize":              true,
+	"addr":               true,
+	"clientaddr":         true,
+	selinuxContextOption: true,
+}
+
+// mountOptionMismatches returns the requested options that contradict the
+// options of an existing mount. Order does not matter, and options the
+// kernel does not report are assumed to match, since /proc/mounts leaves out
+// defaults and spells some options differently.
+func mountOptionMismatches(requested, actual []string) []string {
+	actualValues := make(map[string]string)
+	actualFlags := make(map[string]bool)
+	for _, option := range actual {
+		name, value, hasValue := strings.Cut(strings.TrimSpace(option), "=")
+		if hasValue {
+			actualValues[kernelMountOptionName(name)] = value
+		} else {
+			actualFlags[name] = true
+		}
+	}
+	// Mounts are read-write unless marked ro
+	if !actualFlags["ro"] {
+		actualFlags["rw"] = true
+	}
+
+	var mismatches []string
+	for _, option := range requested {
+		name, value, hasValue := strings.Cut(strings.TrimSpace(option), "=")
+		if !hasValue {
+			for _, pair := range conflictingMountOptions {
+				if (name == pair[0] && actualFlags[pair[1]]) || (name == pair[1] && actualFlags[pair[0]]) {
+					mismatches = append(mismatches, fmt.Sprintf("%s (mounted with %s)", option, otherOption(pair, name)))
+				}
+			}
+			continue
+		}
+
+		// The kernel reports vers=4 as the minor version the server negotiated
+		name = kernelMountOptionName(name)
+		if negotiatedMountOptions[name] {
+			continue
+		}
+		got, ok := actualValues[name]
+		if !ok || got == value || (name == "vers" && strings.HasPrefix(got, value+".")) {
+			continue
+		}
+		mismatches = append(mismatches, fmt.Sprintf("%s (mounted with %s=%s)", option, name, got))
+	}
+	return mismatches
+}
+
+// kernelMountOptionName returns the name /proc/mounts uses for an option
+func kernelMountOptionName(name string) string {
+	if name == "nfsvers" {
+		return "vers"
+	}
+	return name
+}
+
+// otherOption returns the member of pair that is not name
+func otherOption(pair [2]string, name string) string {
+	if pair[0] == name {
+		return pair[1]
+	}
+	return pair[0]
+}
+
+// normalizeMountOptions removes duplicate options and resolves conflicting
+// pairs such as ro/rw. The last option of a pair wins, like the kernel would
+// do, and keeps the position of the first one. Callers append "ro" for
+// read-only volumes, so a read-only request always wins over a user "rw".
+func normalizeMountOptions(options []string) []string {
+	group := make(map[string]int)
+	for i, pair := range conflictingMountOptions {
+		group[pair[0]] = i
+		group[pair[1]] = i
+	}
+
+	// Last member seen of each conflicting pair
+	winner := make(map[int]string)
+	for _, option := range options {
+		if i, ok := group[option]; ok {
+			winner[i] = option
+		}
+	}
+
+	var normalized []string
+	seen := make(map[string]bool)
+	seenGroup := make(map[int]bool)
+	for _, option := range options {
+		if i, ok := group[option]; ok {
+			if !seenGroup[i] {
+				seenGroup[i] = true
+				normalized = append(normalized, winner[i])
+			}
+			continue
+		}
+		if !seen[option] {
+			seen[option] = true
+			normalized = append(normalized, option)
+		}
+	}
+	return normalized
+}
@@ -0,0 +1,1096 @@
+package nfs
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+func TestValidateMountOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		denied  []string
+		options []string
+		wantErr string
+	}{
+		{
+			name:    "permissive by default",
+			options: []string{"nfsvers=4.1", "hard", "noresvport"},
+		},
+		{
+			name:    "allow-only permits listed options",
+			allowed: []string{"nfsvers", "hard"},
+			options: []string{"nfsvers=4.1", "hard"},
+		},
+		{
+			name:    "allow-only rejects unlisted option",
+			allowed: []string{"nfsvers", "hard"},
+			options: []string{"nfsvers=4.1", "soft"},
+			wantErr: `"soft"`,
+		},
+		{
+			name:    "deny-only permits other options",
+			denied:  []string{"suid"},
+			options: []string{"nfsvers=4.1", "nosuid"},
+		},
+		{
+			name:    "deny-only rejects listed option",
+			denied:  []string{"suid"},
+			options: []string{"nfsvers=4.1", "suid"},
+			wantErr: `"suid"`,
+		},
+		{
+			name:    "deny matches option with value",
+			denied:  []string{"sec"},
+			options: []string{"sec=sys"},
+			wantErr: `"sec=sys"`,
+		},
+		{
+			name:    "combined permits allowed and not denied",
+			allowed: []string{"nfsvers", "hard", "soft"},
+			denied:  []string{"soft"},
+			options: []string{"nfsvers=4.1", "hard"},
+		},
+		{
+			name:    "combined deny wins over allow",
+			allowed: []string{"nfsvers", "hard", "soft"},
+			denied:  []string{"soft"},
+			options: []string{"soft"},
+			wantErr: `"soft"`,
+		},
+		{
+			name:    "combined rejects unlisted option",
+			allowed: []string{"nfsvers"},
+			denied:  []string{"soft"},
+			options: []string{"noac"},
+			wantErr: `"noac"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock",
+				WithAllowedMountOptions(tt.allowed),
+				WithDeniedMountOptions(tt.denied),
+			)
+			if err != nil {
+				t.Fatalf("Failed to create driver: %v", err)
+			}
+
+			err = driver.validateMountOptions(tt.options)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateMountOptions() unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("validateMountOptions() expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateMountOptions() error = %v, want it to name %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRecoveryMountOptions(t *testing.T) {
+	rwx := csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER
+	rox := csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY
+
+	tests := []struct {
+		name    string
+		params  map[string]string
+		mode    csi.VolumeCapability_AccessMode_Mode
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:   "unset leaves kernel default",
+			params: map[string]string{},
+			mode:   rwx,
+			want:   nil,
+		},
+		{
+			name:   "hard",
+			params: map[string]string{"mountRecovery": "hard"},
+			mode:   rwx,
+			want:   []string{"hard"},
+		},
+		{
+			name:   "soft on read-only volume",
+			params: map[string]string{"mountRecovery": "soft"},
+			mode:   rox,
+			want:   []string{"soft"},
+		},
+		{
+			name:   "softreval with retrans and timeo",
+			params: map[string]string{"mountRecovery": "softreval", "retrans": "3", "timeo": "600"},
+			mode:   rox,
+			want:   []string{"soft", "softreval", "retrans=3", "timeo=600"},
+		},
+		{
+			name:    "soft rejected on RWX",
+			params:  map[string]string{"mountRecovery": "soft"},
+			mode:    rwx,
+			wantErr: true,
+		},
+		{
+			name:   "soft on RWX when forced",
+			params: map[string]string{"mountRecovery": "soft", "forceSoftMount": "true"},
+			mode:   rwx,
+			want:   []string{"soft"},
+		},
+		{
+			name:    "retrans without soft",
+			params:  map[string]string{"mountRecovery": "hard", "retrans": "3"},
+			mode:    rox,
+			wantErr: true,
+		},
+		{
+			name:    "invalid timeo",
+			params:  map[string]string{"mountRecovery": "soft", "timeo": "0"},
+			mode:    rox,
+			wantErr: true,
+		},
+		{
+			name:    "invalid retrans",
+			params:  map[string]string{"mountRecovery": "soft", "retrans": "many"},
+			mode:    rox,
+			wantErr: true,
+		},
+		{
+			name:    "unknown mode",
+			params:  map[string]string{"mountRecovery": "intr"},
+			mode:    rox,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := recoveryMountOptions(tt.params, tt.mode)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("recoveryMountOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("recoveryMountOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlagMountOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		params   map[string]string
+		existing []string
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name:   "none set",
+			params: map[string]string{},
+		},
+		{
+			name:   "all enabled",
+			params: map[string]string{"noatime": "true", "nodiratime": "true", "sync": "true"},
+			want:   []string{"noatime", "nodiratime", "sync"},
+		},
+		{
+			name:   "false is ignored",
+			params: map[string]string{"noatime": "false", "sync": "true"},
+			want:   []string{"sync"},
+		},
+		{
+			name:     "not duplicated with user options",
+			params:   map[string]string{"noatime": "true", "nodiratime": "true"},
+			existing: []string{"nolock", "noatime", "nfsvers=4.1"},
+			want:     []string{"nodiratime"},
+		},
+		{
+			name:    "invalid value",
+			params:  map[string]string{"sync": "always"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := flagMountOptions(tt.params, tt.existing)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("flagMountOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("flagMountOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeMountOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []string
+		want    []string
+	}{
+		{
+			name:    "no conflicts",
+			options: []string{"nolock", "nfsvers=4.1", "hard"},
+			want:    []string{"nolock", "nfsvers=4.1", "hard"},
+		},
+		{
+			name:    "read-only wins over user rw",
+			options: []string{"nolock", "rw", "nfsvers=4.1", "ro"},
+			want:    []string{"nolock", "ro", "nfsvers=4.1"},
+		},
+		{
+			name:    "last of hard and soft wins",
+			options: []string{"nolock", "hard", "soft", "softreval"},
+			want:    []string{"nolock", "soft", "softreval"},
+		},
+		{
+			name:    "noac after ac",
+			options: []string{"ac", "nolock", "noac"},
+			want:    []string{"noac", "nolock"},
+		},
+		{
+			name:    "duplicates removed",
+			options: []string{"nolock", "noatime", "nolock", "noatime"},
+			want:    []string{"nolock", "noatime"},
+		},
+		{
+			name:    "several pairs at once",
+			options: []string{"rw", "soft", "noac", "ro", "hard", "ac", "ro"},
+			want:    []string{"ro", "hard", "ac"},
+		},
+		{
+			name:    "empty",
+			options: nil,
+			want:    nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeMountOptions(tt.options); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("normalizeMountOptions(%v) = %v, want %v", tt.options, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildMountOptions_AccessMode(t *testing.T) {
+	volumeContext := map[string]string{
+		"mountOptionsRWX": "hard, nfsvers=4.1",
+		"mountOptionsROX": "soft,timeo=50",
+	}
+
+	tests := []struct {
+		name          string
+		mode          csi.VolumeCapability_AccessMode_Mode
+		volumeContext map[string]string
+		want          []string
+	}{
+		{
+			name:          "ReadWriteMany uses mountOptionsRWX",
+			mode:          csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+			volumeContext: volumeContext,
+			want:          []string{"nolock", "hard", "nfsvers=4.1"},
+		},
+		{
+			name:          "ReadOnlyMany uses mountOptionsROX",
+			mode:          csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+			volumeContext: volumeContext,
+			want:          []string{"nolock", "soft", "timeo=50"},
+		},
+		{
+			name:          "ReadWriteOnce falls back to mountOptions",
+			mode:          csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			volumeContext: volumeContext,
+			want:          []string{"nolock", "nfsvers=3"},
+		},
+		{
+			name:          "ReadWriteMany without mountOptionsRWX falls back to mountOptions",
+			mode:          csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+			volumeContext: map[string]string{"mountOptionsROX": "soft"},
+			want:          []string{"nolock", "nfsvers=3"},
+		},
+	}
+
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock")
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cap := &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{MountFlags: []string{"nfsvers=3"}},
+				},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: tt.mode},
+			}
+
+			got, err := driver.buildMountOptions(cap, tt.volumeContext)
+			if err != nil {
+				t.Fatalf("buildMountOptions() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("buildMountOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildMountOptions_AccessModeDenied(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithDeniedMountOptions([]string{"nolock"}))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	cap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+	}
+	if _, err := driver.buildMountOptions(cap, map[string]string{"mountOptionsRWX": "nolock"}); err == nil {
+		t.Error("Expected denied option in mountOptionsRWX to be rejected")
+	}
+}
+
+func TestSELinuxMountOption(t *testing.T) {
+	tests := []struct {
+		name          string
+		mountFlags    []string
+		volumeContext map[string]string
+		want          string
+		wantErr       bool
+	}{
+		{name: "none"},
+		{
+			name:          "from parameter",
+			volumeContext: map[string]string{"selinuxContext": "system_u:object_r:container_file_t:s0:c123,c456"},
+			want:          `context="system_u:object_r:container_file_t:s0:c123,c456"`,
+		},
+		{
+			name:       "from kubelet mount flags",
+			mountFlags: []string{"nfsvers=4.1", `context="system_u:object_r:container_file_t:s0:c1,c2"`},
+			want:       `context="system_u:object_r:container_file_t:s0:c1,c2"`,
+		},
+		{
+			name:          "kubelet wins over parameter",
+			mountFlags:    []string{`context="system_u:object_r:container_file_t:s0:c1,c2"`},
+			volumeContext: map[string]string{"selinuxContext": "system_u:object_r:nfs_t:s0"},
+			want:          `context="system_u:object_r:container_file_t:s0:c1,c2"`,
+		},
+		{
+			name:          "without level",
+			volumeContext: map[string]string{"selinuxContext": "system_u:object_r:nfs_t"},
+			want:          `context="system_u:object_r:nfs_t"`,
+		},
+		{
+			name:          "missing type",
+			volumeContext: map[string]string{"selinuxContext": "system_u:object_r"},
+			wantErr:       true,
+		},
+		{
+			name:          "injected option",
+			volumeContext: map[string]string{"selinuxContext": `system_u:object_r:nfs_t:s0",rw`},
+			wantErr:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := selinuxMountOption(tt.mountFlags, tt.volumeContext)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("selinuxMountOption() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("selinuxMountOption() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildMountOptions_SELinuxContext(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock")
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	cap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{
+				MountFlags: []string{"nfsvers=4.1", `context="system_u:object_r:container_file_t:s0:c1,c2"`},
+			},
+		},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+	}
+	got, err := driver.buildMountOptions(cap, map[string]string{"mountOptionsRWX": "hard"})
+	if err != nil {
+		t.Fatalf("buildMountOptions() error = %v", err)
+	}
+	want := []string{"nolock", "hard", `context="system_u:object_r:container_file_t:s0:c1,c2"`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("buildMountOptions() = %v, want %v", got, want)
+	}
+}
+
+func TestBuildMountOptions_SELinuxContextOnce(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock")
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	cap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{
+				MountFlags: []string{"context=system_u:object_r:nfs_t:s0"},
+			},
+		},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+	got, err := driver.buildMountOptions(cap, map[string]string{
+		"mountOptions":   "hard,context=system_u:object_r:container_file_t:s0",
+		"selinuxContext": "system_u:object_r:public_content_t:s0",
+	})
+	if err != nil {
+		t.Fatalf("buildMountOptions() error = %v", err)
+	}
+	want := []string{"nolock", "hard", `context="system_u:object_r:nfs_t:s0"`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("buildMountOptions() = %v, want %v", got, want)
+	}
+}
+
+func TestBuildMountOptions_SELinuxContextAllowList(t *testing.T) {
+	cap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{
+				MountFlags: []string{`context="system_u:object_r:container_file_t:s0:c1,c2"`},
+			},
+		},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+	}
+
+	tests := []struct {
+		name    string
+		opts    []DriverOption
+		wantErr bool
+	}{
+		{name: "allowed", opts: []DriverOption{WithAllowedMountOptions([]string{"hard", "context"})}},
+		{name: "not in the allowed list", opts: []DriverOption{WithAllowedMountOptions([]string{"hard"})}, wantErr: true},
+		{name: "denied", opts: []DriverOption{WithDeniedMountOptions([]string{"context"})}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", tt.opts...)
+			if err != nil {
+				t.Fatalf("Failed to create driver: %v", err)
+			}
+			_, err = driver.buildMountOptions(cap, map[string]string{"mountOptionsRWX": "hard"})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("buildMountOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTransportMountOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		transport string
+		existing  []string
+		want      []string
+		wantErr   bool
+	}{
+		{name: "not set"},
+		{name: "tcp", transport: "tcp", want: []string{"proto=tcp"}},
+		{name: "udp", transport: "udp", want: []string{"proto=udp"}},
+		{name: "rdma", transport: "rdma", existing: []string{"nolock", "nfsvers=4.1"}, want: []string{"proto=rdma"}},
+		{name: "same proto already set", transport: "udp", existing: []string{"nolock", "proto=udp"}},
+		{name: "conflicting proto", transport: "tcp", existing: []string{"proto=udp"}, wantErr: true},
+		{name: "unknown transport", transport: "sctp", wantErr: true},
+		{name: "case sensitive", transport: "TCP", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]string{}
+			if tt.transport != "" {
+				params["transport"] = tt.transport
+			}
+			got, err := transportMountOptions(params, tt.existing)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("transportMountOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("transportMountOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSecurityMountOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		security string
+		existing []string
+		want     []string
+		wantErr  bool
+	}{
+		{name: "not set"},
+		{name: "sys", security: "sys", existing: []string{"nolock"}, want: []string{"sec=sys"}},
+		{name: "krb5p", security: "krb5p", want: []string{"sec=krb5p"}},
+		{name: "same sec already set", security: "krb5", existing: []string{"sec=krb5"}},
+		{name: "conflicting sec", security: "sys", existing: []string{"sec=krb5i"}, wantErr: true},
+		{name: "unknown flavor", security: "none", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]string{}
+			if tt.security != "" {
+				params["security"] = tt.security
+			}
+			got, err := securityMountOptions(params, tt.existing)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("securityMountOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("securityMountOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalLockMountOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		localLock string
+		existing  []string
+		want      []string
+		wantErr   bool
+	}{
+		{name: "not set", existing: []string{"nolock"}},
+		{name: "flock", localLock: "flock", want: []string{"local_lock=flock"}},
+		{name: "none", localLock: "none", existing: []string{"nfsvers=3"}, want: []string{"local_lock=none"}},
+		{name: "same local_lock already set", localLock: "all", existing: []string{"local_lock=all"}},
+		{name: "conflicting local_lock", localLock: "posix", existing: []string{"local_lock=flock"}, wantErr: true},
+		{name: "user nolock", localLock: "flock", existing: []string{"nolock"}, wantErr: true},
+		{name: "unknown mode", localLock: "fcntl", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]string{}
+			if tt.localLock != "" {
+				params["localLock"] = tt.localLock
+			}
+			got, err := localLockMountOptions(params, tt.existing)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("localLockMountOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("localLockMountOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAttributeCacheMountOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		preset   string
+		existing []string
+		want     []string
+		wantErr  bool
+	}{
+		{name: "not set", existing: []string{"nolock"}},
+		{name: "default keeps the kernel timeouts", preset: "default"},
+		{name: "aggressive", preset: "aggressive", want: []string{"acregmin=60", "acregmax=600", "acdirmin=60", "acdirmax=600"}},
+		{name: "disabled", preset: "disabled", existing: []string{"nfsvers=4.1"}, want: []string{"acregmin=0", "acregmax=0", "acdirmin=0", "acdirmax=0"}},
+		{name: "user timeout wins", preset: "aggressive", existing: []string{"acdirmax=120"}, want: []string{"acregmin=60", "acregmax=600", "acdirmin=60"}},
+		{name: "user actimeo wins", preset: "aggressive", existing: []string{"actimeo=30"}},
+		{name: "user noac wins", preset: "aggressive", existing: []string{"noac"}},
+		{name: "unknown preset", preset: "fast", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]string{}
+			if tt.preset != "" {
+				params["attributeCache"] = tt.preset
+			}
+			got, err := attributeCacheMountOptions(params, tt.existing)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("attributeCacheMountOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("attributeCacheMountOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildMountOptions_AttributeCache(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock")
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	cap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{MountFlags: []string{"acregmax=300"}},
+		},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY},
+	}
+	got, err := driver.buildMountOptions(cap, map[string]string{"attributeCache": "aggressive"})
+	if err != nil {
+		t.Fatalf("buildMountOptions() error = %v", err)
+	}
+	want := []string{"nolock", "acregmax=300", "acregmin=60", "acdirmin=60", "acdirmax=600"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("buildMountOptions() = %v, want %v", got, want)
+	}
+}
+
+func TestNconnectMountOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		nconnect string
+		existing []string
+		want     []string
+		wantErr  bool
+	}{
+		{name: "not set", existing: []string{"nolock"}},
+		{name: "lower bound", nconnect: "1", want: []string{"nconnect=1"}},
+		{name: "upper bound", nconnect: "16", existing: []string{"nfsvers=4.1"}, want: []string{"nconnect=16"}},
+		{name: "same nconnect already set", nconnect: "8", existing: []string{"nconnect=8"}},
+		{name: "conflicting nconnect", nconnect: "8", existing: []string{"nconnect=4"}, wantErr: true},
+		{name: "zero", nconnect: "0", wantErr: true},
+		{name: "above 16", nconnect: "17", wantErr: true},
+		{name: "not a number", nconnect: "many", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]string{}
+			if tt.nconnect != "" {
+				params["nconnect"] = tt.nconnect
+			}
+			got, err := nconnectMountOptions(params, tt.existing)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("nconnectMountOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("nconnectMountOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildMountOptions_Nconnect(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock")
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	cap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{MountFlags: []string{"nfsvers=4.1"}},
+		},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+	}
+	got, err := driver.buildMountOptions(cap, map[string]string{"nconnect": "8", "transport": "tcp", "noatime": "true"})
+	if err != nil {
+		t.Fatalf("buildMountOptions() error = %v", err)
+	}
+	want := []string{"nolock", "nfsvers=4.1", "proto=tcp", "nconnect=8", "noatime"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("buildMountOptions() = %v, want %v", got, want)
+	}
+}
+
+func TestSupportsNconnect(t *testing.T) {
+	tests := []struct {
+		release string
+		want    bool
+	}{
+		{release: "5.3.0", want: true},
+		{release: "5.15.0-91-generic", want: true},
+		{release: "6.1.0", want: true},
+		{release: "5.2.21", want: false},
+		{release: "4.18.0-513.el8.x86_64", want: false},
+		{release: "5.10+", want: true},
+		{release: "unknown", want: true},
+	}
+
+	for _, tt := range tests {
+		if got := supportsNconnect(tt.release); got != tt.want {
+			t.Errorf("supportsNconnect(%q) = %v, want %v", tt.release, got, tt.want)
+		}
+	}
+}
+
+func TestReservedPortMountOptions(t *testing.T) {
+	tests := []struct {
+		name         string
+		reservedPort string
+		existing     []string
+		want         []string
+		wantErr      bool
+	}{
+		{name: "not set", existing: []string{"nolock"}},
+		{name: "true", reservedPort: "true", existing: []string{"nfsvers=4.1"}, want: []string{"resvport"}},
+		{name: "false", reservedPort: "false", want: []string{"noresvport"}},
+		{name: "same option already set", reservedPort: "false", existing: []string{"noresvport"}},
+		{name: "conflicting option", reservedPort: "true", existing: []string{"noresvport"}, wantErr: true},
+		{name: "conflicting option for false", reservedPort: "false", existing: []string{"resvport"}, wantErr: true},
+		{name: "not a bool", reservedPort: "privileged", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]string{}
+			if tt.reservedPort != "" {
+				params["reservedPort"] = tt.reservedPort
+			}
+			got, err := reservedPortMountOptions(params, tt.existing)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("reservedPortMountOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("reservedPortMountOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildMountOptions_ReservedPort(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock")
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	cap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{MountFlags: []string{"nfsvers=4.1"}},
+		},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+	}
+	for value, option := range map[string]string{"true": "resvport", "false": "noresvport"} {
+		got, err := driver.buildMountOptions(cap, map[string]string{"reservedPort": value})
+		if err != nil {
+			t.Fatalf("buildMountOptions(reservedPort=%s) error = %v", value, err)
+		}
+		want := []string{"nolock", "nfsvers=4.1", option}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("buildMountOptions(reservedPort=%s) = %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestBuildMountOptions_LocalLock(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock")
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		volumeContext map[string]string
+		want          []string
+	}{
+		{name: "default keeps nolock", volumeContext: map[string]string{}, want: []string{"nolock", "nfsvers=3"}},
+		{name: "localLock drops nolock", volumeContext: map[string]string{"localLock": "flock"}, want: []string{"nfsvers=3", "local_lock=flock"}},
+		{name: "localLock none", volumeContext: map[string]string{"localLock": "none"}, want: []string{"nfsvers=3", "local_lock=none"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cap := &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{MountFlags: []string{"nfsvers=3"}},
+				},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+			}
+			got, err := driver.buildMountOptions(cap, tt.volumeContext)
+			if err != nil {
+				t.Fatalf("buildMountOptions() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("buildMountOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildMountOptions_MountOptionsParameter(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock")
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		mode          csi.VolumeCapability_AccessMode_Mode
+		volumeContext map[string]string
+		want          []string
+	}{
+		{name: "added after the capability flags", mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			volumeContext: map[string]string{"mountOptions": "hard,noatime"}, want: []string{"nolock", "nfsvers=3", "hard", "noatime"}},
+		{name: "capability flags win", mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			volumeContext: map[string]string{"mountOptions": "nfsvers=4.1,hard"}, want: []string{"nolock", "nfsvers=3", "hard"}},
+		{name: "access mode options replace both", mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+			volumeContext: map[string]string{"mountOptions": "noatime", "mountOptionsRWX": "nfsvers=4.1"}, want: []string{"nolock", "nfsvers=4.1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cap := &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{MountFlags: []string{"nfsvers=3"}},
+				},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: tt.mode},
+			}
+			got, err := driver.buildMountOptions(cap, tt.volumeContext)
+			if err != nil {
+				t.Fatalf("buildMountOptions() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("buildMountOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeMountOptions(t *testing.T) {
+	tests := []struct {
+		name       string
+		flags      []string
+		annotation []string
+		want       []string
+	}{
+		{name: "no annotation", flags: []string{"nfsvers=4.1"}, want: []string{"nfsvers=4.1"}},
+		{name: "annotation only", annotation: []string{"noatime", "rsize=1048576"}, want: []string{"noatime", "rsize=1048576"}},
+		{
+			name:       "StorageClass value wins",
+			flags:      []string{"nfsvers=4.1"},
+			annotation: []string{"nfsvers=3", "noatime"},
+			want:       []string{"nfsvers=4.1", "noatime"},
+		},
+		{
+			name:       "StorageClass wins a conflicting pair",
+			flags:      []string{"hard"},
+			annotation: []string{"soft", "timeo=50"},
+			want:       []string{"hard", "timeo=50"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mergeMountOptions(tt.flags, tt.annotation)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeMountOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildMountOptions_AnnotationMountOptions(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock")
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	cap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{MountFlags: []string{"nfsvers=4.1", "hard"}},
+		},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+	got, err := driver.buildMountOptions(cap, map[string]string{"annotationMountOptions": "nfsvers=3,soft,noatime"})
+	if err != nil {
+		t.Fatalf("buildMountOptions() error = %v", err)
+	}
+	want := []string{"nolock", "nfsvers=4.1", "hard", "noatime"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("buildMountOptions() = %v, want %v", got, want)
+	}
+}
+
+func TestBuildMountOptions_ProfileMountOptions(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock")
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	cap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{MountFlags: []string{"nfsvers=4.2"}},
+		},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+	got, err := driver.buildMountOptions(cap, map[string]string{
+		"annotationMountOptions": "soft",
+		"profileMountOptions":    "nfsvers=4.1,hard,rsize=1048576,noatime",
+	})
+	if err != nil {
+		t.Fatalf("buildMountOptions() error = %v", err)
+	}
+	want := []string{"nolock", "nfsvers=4.2", "soft", "rsize=1048576", "noatime"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("buildMountOptions() = %v, want %v", got, want)
+	}
+}
+
+func TestMountOptionMismatches(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested []string
+		actual    []string
+		want      []string
+	}{
+		{name: "same options", requested: []string{"nolock", "hard"}, actual: []string{"nolock", "hard"}},
+		{name: "different order", requested: []string{"nolock", "hard", "ro"}, actual: []string{"ro", "hard", "nolock"}},
+		{name: "unreported options", requested: []string{"nolock", "noatime"}, actual: []string{"rw", "vers=4.2"}},
+		{name: "negotiated version", requested: []string{"nfsvers=4"}, actual: []string{"vers=4.2"}},
+		{name: "negotiated sizes", requested: []string{"rsize=1048576"}, actual: []string{"rsize=524288"}},
+		{name: "read-only changed", requested: []string{"nolock", "ro"}, actual: []string{"rw", "nolock"}, want: []string{"ro (mounted with rw)"}},
+		{name: "recovery changed", requested: []string{"soft"}, actual: []string{"hard"}, want: []string{"soft (mounted with hard)"}},
+		{name: "version changed", requested: []string{"nfsvers=3"}, actual: []string{"vers=4.1"}, want: []string{"nfsvers=3 (mounted with vers=4.1)"}},
+		{name: "value changed", requested: []string{"timeo=100"}, actual: []string{"timeo=600"}, want: []string{"timeo=100 (mounted with timeo=600)"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mountOptionMismatches(tt.requested, tt.actual); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mountOptionMismatches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFscacheMountOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  map[string]string
+		mode    csi.VolumeCapability_AccessMode_Mode
+		want    []string
+		wantErr bool
+	}{
+		{name: "not set", mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+		{name: "disabled", params: map[string]string{"enableFscache": "false"}, mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+		{name: "ReadOnlyMany", params: map[string]string{"enableFscache": "true"}, mode: csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY, want: []string{"fsc"}},
+		{name: "single node read-only", params: map[string]string{"enableFscache": "true"}, mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY, want: []string{"fsc"}},
+		{name: "ReadWriteMany refused", params: map[string]string{"enableFscache": "true"}, mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER, wantErr: true},
+		{name: "ReadWriteOnce refused", params: map[string]string{"enableFscache": "true"}, mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER, wantErr: true},
+		{name: "ReadWriteMany forced", params: map[string]string{"enableFscache": "true", "forceFscache": "true"}, mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER, want: []string{"fsc"}},
+		{name: "invalid value", params: map[string]string{"enableFscache": "yes please"}, mode: csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := fscacheMountOptions(tt.params, tt.mode)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("fscacheMountOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fscacheMountOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildMountOptions_Fscache(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock")
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	cap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{},
+		},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY},
+	}
+	got, err := driver.buildMountOptions(cap, map[string]string{"enableFscache": "true"})
+	if err != nil {
+		t.Fatalf("buildMountOptions() error = %v", err)
+	}
+	if want := []string{"nolock", "fsc"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("buildMountOptions() = %v, want %v", got, want)
+	}
+	if !driver.fscacheUsed.Load() {
+		t.Error("Expected fsc use to be recorded for Probe")
+	}
+}
+
+func TestClientAddrMountOptions(t *testing.T) {
+	tests := []struct {
+		name       string
+		params     map[string]string
+		existing   []string
+		want       []string
+		wantDialed string
+		wantErr    bool
+	}{
+		{name: "not set", params: map[string]string{}},
+		{name: "IPv4", params: map[string]string{"clientAddr": "10.0.1.5"}, want: []string{"clientaddr=10.0.1.5"}},
+		{name: "IPv6", params: map[string]string{"clientAddr": "fd00:0::5"}, want: []string{"clientaddr=fd00::5"}},
+		{name: "no delegations", params: map[string]string{"clientAddr": "0.0.0.0"}, want: []string{"clientaddr=0.0.0.0"}},
+		{name: "same clientaddr already set", params: map[string]string{"clientAddr": "10.0.1.5"}, existing: []string{"clientaddr=10.0.1.5"}},
+		{name: "conflicting clientaddr", params: map[string]string{"clientAddr": "10.0.1.5"}, existing: []string{"clientaddr=10.0.2.5"}, wantErr: true},
+		{name: "host name", params: map[string]string{"clientAddr": "node-1.example.com"}, wantErr: true},
+		{name: "invalid IPv4", params: map[string]string{"clientAddr": "10.0.1.256"}, wantErr: true},
+		{name: "bracketed IPv6", params: map[string]string{"clientAddr": "[fd00::5]"}, wantErr: true},
+		{
+			name:       "auto",
+			params:     map[string]string{"clientAddr": "auto", "server": "nfs-a.example.com,nfs-b.example.com"},
+			want:       []string{"clientaddr=10.0.1.5"},
+			wantDialed: "nfs-a.example.com:2049",
+		},
+		{
+			name:       "auto with port and IPv6 server",
+			params:     map[string]string{"clientAddr": "auto", "server": "[fd00::1]", "port": "20049"},
+			want:       []string{"clientaddr=10.0.1.5"},
+			wantDialed: "[fd00::1]:20049",
+		},
+		{name: "auto with clientaddr already set", params: map[string]string{"clientAddr": "auto", "server": "192.168.1.1"}, existing: []string{"clientaddr=10.0.2.5"}},
+		{name: "auto without a route", params: map[string]string{"clientAddr": "auto", "server": "unreachable.example.com"}, wantDialed: "unreachable.example.com:2049", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dialed string
+			orig := localAddrTo
+			t.Cleanup(func() { localAddrTo = orig })
+			localAddrTo = func(address string) (net.IP, error) {
+				dialed = address
+				if strings.HasPrefix(address, "unreachable") {
+					return nil, errors.New("network is unreachable")
+				}
+				return net.ParseIP("10.0.1.5"), nil
+			}
+
+			got, err := clientAddrMountOptions(tt.params, tt.existing)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("clientAddrMountOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("clientAddrMountOptions() = %v, want %v", got, tt.want)
+			}
+			if dialed != tt.wantDialed {
+				t.Errorf("Expected the route to %q to be looked up, got %q", tt.wantDialed, dialed)
+			}
+		})
+	}
+}
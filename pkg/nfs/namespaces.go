@@ -0,0 +1,74 @@
+package nfs
+
+import (
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// pvcNamespaceKey carries the namespace of the PVC (external-provisioner --extra-create-metadata)
+	pvcNamespaceKey = "csi.storage.k8s.io/pvc/namespace"
+
+	// anyNamespace is the namespace server map entry for namespaces without one of their own
+	anyNamespace = "*"
+)
+
+// WithNamespaceServers restricts the NFS servers volumes of each namespace
+// may use. Each key is a namespace, or "*" for every namespace not listed,
+// and its value the comma-separated servers allowed there. Namespaces
+// without an entry may not use any server.
+func WithNamespaceServers(servers map[string]string) DriverOption {
+	return func(d *Driver) {
+		// An empty ConfigMap still denies everything rather than nothing
+		if servers == nil {
+			servers = map[string]string{}
+		}
+		d.namespaceServers = servers
+	}
+}
+
+// checkNamespaceServers returns a PermissionDenied error unless every server
+// is allowed for namespace. The check passes when no namespace server map is
+// configured.
+func (d *Driver) checkNamespaceServers(namespace string, servers []string) error {
+	if d.namespaceServers == nil {
+		return nil
+	}
+	if namespace == "" {
+		return status.Error(codes.PermissionDenied, "NFS servers are restricted per namespace, but the request does not name its namespace")
+	}
+
+	value, ok := d.namespaceServers[namespace]
+	if !ok {
+		value, ok = d.namespaceServers[anyNamespace]
+	}
+	var allowed []string
+	for _, server := range strings.Split(value, ",") {
+		if server = strings.TrimSpace(server); server != "" {
+			allowed = append(allowed, server)
+		}
+	}
+	if !ok || len(allowed) == 0 {
+		return status.Errorf(codes.PermissionDenied, "namespace %q may not use any NFS server", namespace)
+	}
+
+	for _, server := range servers {
+		if !containsServer(allowed, server) {
+			return status.Errorf(codes.PermissionDenied, "namespace %q may not use NFS server %q (allowed: %s)", namespace, server, strings.Join(allowed, ", "))
+		}
+	}
+	return nil
+}
+
+// containsServer reports whether servers holds server, ignoring case since
+// host names are case-insensitive
+func containsServer(servers []string, server string) bool {
+	for _, s := range servers {
+		if strings.EqualFold(s, server) {
+			return true
+		}
+	}
+	return false
+}
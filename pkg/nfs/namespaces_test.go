@@ -0,0 +1,90 @@
+package nfs
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCreateVolume_NamespaceServers(t *testing.T) {
+	namespaceServers := map[string]string{
+		"team-a": "nfs-a.example.com, 192.168.1.100",
+		"team-b": "nfs-b.example.com",
+		"locked": "",
+	}
+
+	tests := []struct {
+		name      string
+		servers   map[string]string
+		params    map[string]string
+		wantCode  codes.Code
+		wantError string
+	}{
+		{name: "no restriction", params: map[string]string{"server": "nfs-b.example.com"}, wantCode: codes.OK},
+		{name: "allowed server", servers: namespaceServers,
+			params: map[string]string{"server": "nfs-a.example.com", pvcNamespaceKey: "team-a"}, wantCode: codes.OK},
+		{name: "allowed server in another case", servers: namespaceServers,
+			params: map[string]string{"server": "NFS-A.example.com", pvcNamespaceKey: "team-a"}, wantCode: codes.OK},
+		{name: "all failover servers allowed", servers: namespaceServers,
+			params: map[string]string{"servers": "nfs-a.example.com,192.168.1.100", pvcNamespaceKey: "team-a"}, wantCode: codes.OK},
+		{name: "server of another namespace", servers: namespaceServers,
+			params:   map[string]string{"server": "nfs-b.example.com", pvcNamespaceKey: "team-a"},
+			wantCode: codes.PermissionDenied, wantError: `namespace "team-a" may not use NFS server "nfs-b.example.com"`},
+		{name: "one failover server denied", servers: namespaceServers,
+			params:   map[string]string{"servers": "nfs-a.example.com,nfs-b.example.com", pvcNamespaceKey: "team-a"},
+			wantCode: codes.PermissionDenied, wantError: `"nfs-b.example.com"`},
+		{name: "namespace not listed", servers: namespaceServers,
+			params:   map[string]string{"server": "nfs-a.example.com", pvcNamespaceKey: "team-c"},
+			wantCode: codes.PermissionDenied, wantError: `namespace "team-c" may not use any NFS server`},
+		{name: "namespace without servers", servers: namespaceServers,
+			params: map[string]string{"server": "nfs-a.example.com", pvcNamespaceKey: "locked"}, wantCode: codes.PermissionDenied},
+		{name: "wildcard for other namespaces", servers: map[string]string{"team-a": "nfs-a.example.com", anyNamespace: "nfs-shared.example.com"},
+			params: map[string]string{"server": "nfs-shared.example.com", pvcNamespaceKey: "team-c"}, wantCode: codes.OK},
+		{name: "wildcard does not widen listed namespaces", servers: map[string]string{"team-a": "nfs-a.example.com", anyNamespace: "nfs-shared.example.com"},
+			params: map[string]string{"server": "nfs-shared.example.com", pvcNamespaceKey: "team-a"}, wantCode: codes.PermissionDenied},
+		{name: "namespace unknown", servers: namespaceServers,
+			params:   map[string]string{"server": "nfs-a.example.com"},
+			wantCode: codes.PermissionDenied, wantError: "does not name its namespace"},
+		{name: "empty map denies everything", servers: map[string]string{},
+			params: map[string]string{"server": "nfs-a.example.com", pvcNamespaceKey: "team-a"}, wantCode: codes.PermissionDenied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts []DriverOption
+			if tt.servers != nil {
+				opts = append(opts, WithNamespaceServers(tt.servers))
+			}
+			driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", opts...)
+			if err != nil {
+				t.Fatalf("Failed to create driver: %v", err)
+			}
+
+			req := createVolumeRequest("pvc-1", 0)
+			req.Parameters = map[string]string{"share": "/exports/data"}
+			for key, value := range tt.params {
+				req.Parameters[key] = value
+			}
+			_, err = driver.CreateVolume(context.Background(), req)
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("Expected code %v, got %v", tt.wantCode, err)
+			}
+			if tt.wantError != "" && !strings.Contains(err.Error(), tt.wantError) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantError, err)
+			}
+		})
+	}
+}
+
+func TestWithNamespaceServers_Nil(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithNamespaceServers(nil))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+	if err := driver.checkNamespaceServers("default", []string{"nfs.example.com"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected an empty ConfigMap to deny every server, got %v", err)
+	}
+}
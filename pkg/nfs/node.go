@@ -2,8 +2,15 @@ package nfs
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"math/rand"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc/codes"
@@ -12,13 +19,20 @@ import (
 	"k8s.io/mount-utils"
 )
 
-// NodePublishVolume mounts the NFS share at the target path
+// NodePublishVolume mounts the NFS share at the target path. Errors carry
+// their failure category, see withErrorDetails.
 func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	resp, err := d.nodePublishVolume(ctx, req)
+	return resp, d.withErrorDetails(err)
+}
+
+func (d *Driver) nodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
 	volumeID := req.GetVolumeId()
 	targetPath := req.GetTargetPath()
-	volumeContext := req.GetVolumeContext()
+	// A forced server overrides whatever server a hand-made PV names
+	volumeContext := d.withForcedServer(req.GetVolumeContext(), req.GetVolumeId())
 
-	klog.V(2).Infof("NodePublishVolume: volumeID=%s, targetPath=%s", volumeID, targetPath)
+	d.logV(ctx, subsystemNode, 2).Infof("NodePublishVolume: volumeID=%s, targetPath=%s", volumeID, targetPath)
 
 	if volumeID == "" {
 		return nil, status.Error(codes.InvalidArgument, "volume ID is required")
@@ -32,22 +46,134 @@ func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolu
 		return nil, err
 	}
 
-	server, share, err := getVolumeSource(volumeContext)
+	// Inline volumes come straight from the pod spec without CreateVolume,
+	// so their attributes get the controller's checks here
+	if isEphemeralVolume(volumeContext) {
+		d.logV(ctx, subsystemNode, 2).Infof("NodePublishVolume: %s is an inline ephemeral volume", volumeID)
+		if err := d.validateEphemeralContext(ctx, volumeContext); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := d.validateVolumeParameters(volumeContext, capabilityMountFlags(cap, volumeContext)); err != nil {
+		return nil, err
+	}
+
+	servers, share, err := getVolumeSource(volumeContext, d.subPathAnnotationKeys()...)
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "failed to get volume source: %v", err)
 	}
+	// validateVolumeParameters has checked the shares already. Volumes with
+	// several shares are never staged.
+	shares, _ := getShares(volumeContext)
+
+	fsGroupPolicy := volumeContext[ParamFSGroupPolicy]
+	if err := validateFSGroupPolicy(fsGroupPolicy); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// Volume mount group is set by kubelet from the pod fsGroup
+	fsGroup := -1
+	if group := cap.GetMount().GetVolumeMountGroup(); group != "" {
+		gid, err := strconv.Atoi(group)
+		if err != nil || gid < 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid volume mount group %q", group)
+		}
+		fsGroup = gid
+	}
+
+	// The PV can pin the volume read-only through its volume attributes, since
+	// kubelet does not reliably propagate the PV readOnly flag into req.Readonly
+	readOnly := req.GetReadonly()
+	if value := volumeContext[ParamReadOnly]; value != "" {
+		pinned, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %s %q: must be true or false", ParamReadOnly, value)
+		}
+		readOnly = readOnly || pinned
+	}
+
+	fsType, err := getFSType(volumeContext)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	targetMode, err := getTargetMode(volumeContext, d.targetPathMode)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	createTarget, err := getCreateTarget(volumeContext)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	propagation, err := getMountPropagation(volumeContext)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	mountOptions, err := d.buildMountOptions(cap, volumeContext)
+	if err != nil {
+		return nil, err
+	}
+
+	// Log subPath if specified. getVolumeSource has already validated it.
+	subPath, _ := resolveSubPath(volumeContext, d.subPathAnnotationKeys()...)
+	if subPath != "" {
+		d.logV(ctx, subsystemNode, 2).Infof("Using subPath: %s", subPath)
+	}
+
+	// The writable directory is bind-mounted from the read-write staged
+	// mount, so it needs staging
+	writable, err := getWritableSubPath(volumeContext)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if writable != "" && (!d.staging || req.GetStagingTargetPath() == "") {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s requires the driver to run with --enable-staging", ParamWritableSubPath)
+	}
+
+	sources := nfsSources(servers, share)
+	source := strings.Join(sources, ",")
+	if shares != nil {
+		source = fmt.Sprintf("%s:{%s}", strings.Join(servers, ","), strings.Join(shares, ","))
+	}
+
+	// Handle read-only mount; normalizing drops any user-supplied "rw"
+	if readOnly {
+		mountOptions = normalizeMountOptions(append(mountOptions, "ro"))
+	}
 
-	// Log subPath if specified
-	if subPath := getSubPath(volumeContext); subPath != "" {
-		klog.V(2).Infof("Using subPath: %s", subPath)
+	d.logV(ctx, subsystemNode, 4).Infof("Mount options: %v", mountOptions)
+
+	// In validate-only mode everything above has been checked; stop before
+	// touching the target path or calling the mounter
+	if volumeContext[ParamValidateOnly] == "true" {
+		d.logV(ctx, subsystemNode, 2).Infof("Validate-only: would mount NFS %s at %s with options %v", source, targetPath, mountOptions)
+		return &csi.NodePublishVolumeResponse{}, nil
 	}
 
-	source := fmt.Sprintf("%s:%s", server, share)
-	klog.V(4).Infof("Mounting NFS: source=%s, target=%s", source, targetPath)
+	d.logV(ctx, subsystemNode, 4).Infof("Mounting NFS: source=%s, target=%s", source, targetPath)
 
-	// Create target directory if it doesn't exist
-	if err := os.MkdirAll(targetPath, 0750); err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to create target path %s: %v", targetPath, err)
+	// A target left mounted by an ungraceful reboot can be stale. The shares
+	// of a volume with several are below the target, which is a tmpfs.
+	if d.remountStaleOnPublish && shares == nil {
+		if err := d.unmountStaleTarget(ctx, targetPath); err != nil {
+			return nil, err
+		}
+	}
+
+	// Create target directory if it doesn't exist, unless the CO manages it
+	if createTarget {
+		if err := d.filesystem.MkdirAll(targetPath, targetMode); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to create target path %s: %v", targetPath, err)
+		}
+	} else if _, err := d.filesystem.Stat(targetPath); err != nil {
+		if os.IsNotExist(err) {
+			return nil, status.Errorf(codes.FailedPrecondition, "target path %s does not exist and %s is false", targetPath, ParamCreateTarget)
+		}
+		return nil, status.Errorf(codes.Internal, "failed to check target path %s: %v", targetPath, err)
 	}
 
 	// Check if already mounted
@@ -60,33 +186,87 @@ func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolu
 		}
 	}
 
+	stagingPath := req.GetStagingTargetPath()
+	useStaging := d.staging && stagingPath != "" && shares == nil
+
 	if !notMnt {
-		klog.V(2).Infof("Target path %s is already mounted", targetPath)
+		d.logV(ctx, subsystemNode, 2).Infof("Target path %s is already mounted", targetPath)
+		// The target of a volume with several shares is the tmpfs holding them
+		if !useStaging && shares == nil {
+			if err := d.checkMountedOptions(targetPath, mountOptions); err != nil {
+				return nil, err
+			}
+		}
+		if useStaging {
+			d.staged.mu.Lock()
+			d.staged.addLocked(stagingPath, targetPath)
+			d.staged.mu.Unlock()
+		}
+		if propagation != "" {
+			if err := d.setMountPropagation(ctx, targetPath, propagation); err != nil {
+				return nil, err
+			}
+		}
+		d.recordCapacity(targetPath, volumeContext)
 		return &csi.NodePublishVolumeResponse{}, nil
 	}
 
-	// Prepare mount options with default NFS options
-	// nolock: disable NFS locking (avoids rpc.statd requirement in containers)
-	mountOptions := []string{"nolock"}
+	if useStaging {
+		if err := d.bindFromStaging(ctx, stagingPath, subPath, targetPath, readOnly || writable != ""); err != nil {
+			return nil, err
+		}
+		if writable != "" {
+			if err := d.bindWritableSubPath(ctx, stagingPath, subPath, writable, targetPath); err != nil {
+				if unmountErr := d.mounter.Unmount(targetPath); unmountErr != nil {
+					klog.Warningf("Failed to unmount %s after a failed writable bind: %v", targetPath, unmountErr)
+				}
+				d.staged.remove(targetPath)
+				return nil, err
+			}
+		}
+	} else if shares != nil {
+		if err := d.mountShares(ctx, servers, shares, targetPath, fsType, mountOptions, readOnly); err != nil {
+			d.recordMountFailure(volumeID, volumeContext, err)
+			return nil, err
+		}
+		d.logV(ctx, subsystemNode, 2).Infof("Successfully mounted NFS %s at %s", source, targetPath)
+	} else {
+		if err := d.waitForMount(ctx); err != nil {
+			return nil, err
+		}
 
-	// Get mount options from volume capability
-	if mountCap := cap.GetMount(); mountCap != nil {
-		mountOptions = append(mountOptions, mountCap.GetMountFlags()...)
-	}
+		// Mount NFS, failing over to the next server if one cannot be mounted
+		mounted, err := d.mountSources(ctx, sources, targetPath, fsType, mountOptions)
+		if err != nil {
+			d.recordMountFailure(volumeID, volumeContext, err)
+			return nil, mountFailedError(err, source, targetPath)
+		}
+
+		d.published.add(targetPath, publishedVolume{
+			volumeID: volumeID,
+			sources:  sources,
+			fsType:   fsType,
+			options:  mountOptions,
+		})
 
-	// Handle read-only mount
-	if req.GetReadonly() {
-		mountOptions = append(mountOptions, "ro")
+		d.logV(ctx, subsystemNode, 2).Infof("Successfully mounted NFS %s at %s", mounted, targetPath)
 	}
 
-	klog.V(4).Infof("Mount options: %v", mountOptions)
+	// A failure leaves the target mounted; kubelet retries the publish,
+	// which sets the propagation on the mounted target
+	if propagation != "" {
+		if err := d.setMountPropagation(ctx, targetPath, propagation); err != nil {
+			return nil, err
+		}
+	}
+	d.recordCapacity(targetPath, volumeContext)
 
-	// Mount NFS
-	if err := d.mounter.Mount(source, targetPath, "nfs", mountOptions); err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to mount NFS %s at %s: %v", source, targetPath, err)
+	if fsGroup >= 0 && fsGroupPolicy == FSGroupPolicyFile && !readOnly {
+		if err := d.applyVolumeMountGroup(targetPath, fsGroup, cap.GetAccessMode().GetMode()); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to apply fsGroup %d to %s: %v", fsGroup, targetPath, err)
+		}
 	}
 
-	klog.V(2).Infof("Successfully mounted NFS %s at %s", source, targetPath)
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
@@ -95,7 +275,7 @@ func (d *Driver) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublish
 	volumeID := req.GetVolumeId()
 	targetPath := req.GetTargetPath()
 
-	klog.V(2).Infof("NodeUnpublishVolume: volumeID=%s, targetPath=%s", volumeID, targetPath)
+	d.logV(ctx, subsystemNode, 2).Infof("NodeUnpublishVolume: volumeID=%s, targetPath=%s", volumeID, targetPath)
 
 	if volumeID == "" {
 		return nil, status.Error(codes.InvalidArgument, "volume ID is required")
@@ -104,68 +284,688 @@ func (d *Driver) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublish
 		return nil, status.Error(codes.InvalidArgument, "target path is required")
 	}
 
+	// Stop the remount scan from touching the target before tearing it down
+	d.published.remove(targetPath)
+	d.capacities.remove(targetPath)
+
 	// Check if mounted
 	notMnt, err := d.mounter.IsLikelyNotMountPoint(targetPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			klog.V(4).Infof("Target path %s does not exist, nothing to unmount", targetPath)
+			d.logV(ctx, subsystemNode, 4).Infof("Target path %s does not exist, nothing to unmount", targetPath)
 			return &csi.NodeUnpublishVolumeResponse{}, nil
 		}
 		return nil, status.Errorf(codes.Internal, "failed to check mount point: %v", err)
 	}
 
 	if notMnt {
-		klog.V(4).Infof("Target path %s is not mounted", targetPath)
+		d.logV(ctx, subsystemNode, 4).Infof("Target path %s is not mounted", targetPath)
 		// Clean up directory
-		if err := os.Remove(targetPath); err != nil && !os.IsNotExist(err) {
-			klog.Warningf("Failed to remove target path %s: %v", targetPath, err)
+		if !d.keepTargetPath {
+			if err := d.filesystem.Remove(targetPath); err != nil && !os.IsNotExist(err) {
+				klog.Warningf("Failed to remove target path %s: %v", targetPath, err)
+			}
 		}
+		d.releaseTarget(ctx, targetPath)
 		return &csi.NodeUnpublishVolumeResponse{}, nil
 	}
 
-	// Unmount
-	if err := mount.CleanupMountPoint(targetPath, d.mounter, true); err != nil {
+	// A writableSubPath, or the shares of a volume with several, are
+	// mounted inside the target and go first
+	if err := d.unmountNested(ctx, targetPath); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to unmount below %s: %v", targetPath, err)
+	}
+
+	// Unmount, retrying briefly in case the server is only blipping
+	if err := d.unmountWithRetry(ctx, targetPath); err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to unmount %s: %v", targetPath, err)
 	}
+	d.releaseTarget(ctx, targetPath)
 
-	klog.V(2).Infof("Successfully unmounted %s", targetPath)
+	d.logV(ctx, subsystemNode, 2).Infof("Successfully unmounted %s", targetPath)
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
 
+// buildMountOptions validates the user-supplied mount options and returns the
+// full option list for an NFS mount, without the read-only flag
+func (d *Driver) buildMountOptions(cap *csi.VolumeCapability, volumeContext map[string]string) ([]string, error) {
+	recoveryOptions, err := recoveryMountOptions(volumeContext, cap.GetAccessMode().GetMode())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// Reject disallowed mount options before touching the target path. The
+	// StorageClass options win over the PVC annotation, which wins over the
+	// mount profile.
+	mountFlags := volumeMountFlags(cap, volumeContext)
+	if err := d.validateMountOptions(mountFlags); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := checkParameterConflicts(volumeContext, mountFlags, cap.GetAccessMode().GetMode()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// Start from the driver defaults unless the volume replaces them
+	optionsMode, err := getMountOptionsMode(volumeContext)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	var mountOptions []string
+	if optionsMode == MountOptionsModeAppend {
+		for _, option := range defaultMountOptions {
+			// localLock asks for locking, which nolock would turn off
+			if option == "nolock" && volumeContext[ParamLocalLock] != "" {
+				continue
+			}
+			mountOptions = append(mountOptions, option)
+		}
+	}
+
+	// Get mount options from volume capability, or the access mode specific
+	// parameter, the PVC annotation and the mount profile
+	mountOptions = append(mountOptions, mountFlags...)
+
+	port, err := parsePort(volumeContext[ParamPort])
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if port != 0 {
+		mountOptions = append(mountOptions, fmt.Sprintf("port=%d", port))
+	}
+
+	transportOptions, err := transportMountOptions(volumeContext, mountOptions)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	mountOptions = append(mountOptions, transportOptions...)
+
+	securityOptions, err := securityMountOptions(volumeContext, mountOptions)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	mountOptions = append(mountOptions, securityOptions...)
+
+	localLockOptions, err := localLockMountOptions(volumeContext, mountOptions)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	mountOptions = append(mountOptions, localLockOptions...)
+
+	attributeCacheOptions, err := attributeCacheMountOptions(volumeContext, mountOptions)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	mountOptions = append(mountOptions, attributeCacheOptions...)
+
+	nconnectOptions, err := nconnectMountOptions(volumeContext, mountOptions)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	mountOptions = append(mountOptions, nconnectOptions...)
+	d.checkNconnect(mountOptions)
+
+	reservedPortOptions, err := reservedPortMountOptions(volumeContext, mountOptions)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	mountOptions = append(mountOptions, reservedPortOptions...)
+
+	clientAddrOptions, err := clientAddrMountOptions(volumeContext, mountOptions)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	mountOptions = append(mountOptions, clientAddrOptions...)
+
+	flagOptions, err := flagMountOptions(volumeContext, mountOptions)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	mountOptions = append(mountOptions, flagOptions...)
+	mountOptions = append(mountOptions, recoveryOptions...)
+
+	fscacheOptions, err := fscacheMountOptions(volumeContext, cap.GetAccessMode().GetMode())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if len(fscacheOptions) > 0 {
+		d.fscacheUsed.Store(true)
+	}
+	mountOptions = append(mountOptions, fscacheOptions...)
+
+	// The user's context= option is replaced by its validated, quoted form,
+	// so the label is never passed twice
+	selinuxOption, err := selinuxMountOption(mountFlags, volumeContext)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	options := mountOptions[:0]
+	for _, option := range mountOptions {
+		if mountOptionName(option) != selinuxContextOption {
+			options = append(options, option)
+		}
+	}
+	mountOptions = options
+	if selinuxOption != "" {
+		mountOptions = append(mountOptions, selinuxOption)
+	}
+
+	return normalizeMountOptions(mountOptions), nil
+}
+
+// checkMountedOptions returns AlreadyExists if the mount at targetPath was
+// made with options that contradict mountOptions, e.g. after the StorageClass
+// changed, since republishing would silently keep the old ones
+func (d *Driver) checkMountedOptions(targetPath string, mountOptions []string) error {
+	mountPoints, err := d.mounter.List()
+	if err != nil {
+		klog.Warningf("Failed to list mounts to compare options of %s: %v", targetPath, err)
+		return nil
+	}
+
+	// The last entry for a path is the one visible there
+	var actual []string
+	found := false
+	for _, mp := range mountPoints {
+		if mp.Path == targetPath {
+			actual = mp.Opts
+			found = true
+		}
+	}
+	if !found {
+		return nil
+	}
+
+	if mismatches := mountOptionMismatches(mountOptions, actual); len(mismatches) > 0 {
+		return status.Errorf(codes.AlreadyExists, "%s is already mounted with different options: %s", targetPath, strings.Join(mismatches, ", "))
+	}
+	return nil
+}
+
+// unmountWithRetry unmounts and removes target. Failed attempts are retried up
+// to unmountRetries times with jittered exponential backoff, unless ctx ends.
+func (d *Driver) unmountWithRetry(ctx context.Context, target string) error {
+	backoff := d.unmountBackoff
+	for attempt := 1; ; attempt++ {
+		err := d.unmountTarget(target)
+		if err == nil {
+			return nil
+		}
+		if attempt > d.unmountRetries {
+			return err
+		}
+
+		// Up to 50% jitter so targets failing together do not retry in lockstep
+		delay := backoff + time.Duration(rand.Int63n(int64(backoff)/2+1))
+		klog.Warningf("Unmount of %s failed (attempt %d of %d), retrying in %v: %v", target, attempt, d.unmountRetries+1, delay, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w (stopped retrying: %v)", err, ctx.Err())
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// unmountTarget unmounts target and removes its directory, unless the
+// directory should be kept for the next publish
+func (d *Driver) unmountTarget(target string) error {
+	if d.keepTargetPath {
+		return d.mounter.Unmount(target)
+	}
+	return mount.CleanupMountPoint(target, d.mounter, true)
+}
+
+// nfsSources returns the NFS source for share on each server
+func nfsSources(servers []string, share string) []string {
+	sources := make([]string, 0, len(servers))
+	for _, server := range servers {
+		sources = append(sources, fmt.Sprintf("%s:%s", server, share))
+	}
+	return sources
+}
+
+// waitForMount blocks until the mount rate limit allows another NFS mount,
+// or fails once ctx ends (or would end before then)
+func (d *Driver) waitForMount(ctx context.Context) error {
+	if d.mountLimiter == nil {
+		return nil
+	}
+
+	start := time.Now()
+	if err := d.mountLimiter.Wait(ctx); err != nil {
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return status.Errorf(codes.Canceled, "canceled while waiting for the mount rate limit: %v", err)
+		}
+		return status.Errorf(codes.DeadlineExceeded, "timed out waiting for the mount rate limit: %v", err)
+	}
+	if waited := time.Since(start); waited >= time.Millisecond {
+		d.logV(ctx, subsystemNode, 4).Infof("Waited %v for the mount rate limit", waited)
+	}
+	return nil
+}
+
+// mountSources mounts each source at target in turn until one succeeds and
+// returns it. If every source fails, or ctx ends, the last error is returned.
+func (d *Driver) mountSources(ctx context.Context, sources []string, target, fsType string, options []string) (string, error) {
+	var err error
+	for _, source := range sources {
+		if err = d.mountContext(ctx, source, target, fsType, options); err == nil {
+			return source, nil
+		}
+		if ctx.Err() != nil {
+			return "", err
+		}
+		if len(sources) > 1 {
+			klog.Warningf("Failed to mount NFS %s at %s, trying the next server: %v", source, target, err)
+		}
+	}
+	return "", err
+}
+
+// bindFromStaging bind-mounts the subPath of a staged share into the target path
+// and records the reference so the staged mount outlives this target
+func (d *Driver) bindFromStaging(ctx context.Context, stagingPath, subPath, targetPath string, readOnly bool) error {
+	d.staged.mu.Lock()
+	defer d.staged.mu.Unlock()
+
+	notMnt, err := d.mounter.IsLikelyNotMountPoint(stagingPath)
+	if err != nil && !os.IsNotExist(err) {
+		return status.Errorf(codes.Internal, "failed to check staging path %s: %v", stagingPath, err)
+	}
+	if err != nil || notMnt {
+		return status.Errorf(codes.FailedPrecondition, "volume is not staged at %s", stagingPath)
+	}
+
+	source := stagingPath
+	if subPath != "" {
+		source = filepath.Join(stagingPath, subPath)
+	}
+	if _, err := d.filesystem.Stat(source); err != nil {
+		if os.IsNotExist(err) {
+			return status.Errorf(codes.NotFound, "subPath %s does not exist on the staged share", subPath)
+		}
+		return status.Errorf(codes.Internal, "failed to stat %s: %v", source, err)
+	}
+
+	options := []string{"bind"}
+	if readOnly {
+		options = append(options, "ro")
+	}
+	if err := d.mounter.Mount(source, targetPath, "", options); err != nil {
+		return status.Errorf(codes.Internal, "failed to bind mount %s at %s: %v", source, targetPath, err)
+	}
+	d.staged.addLocked(stagingPath, targetPath)
+
+	d.logV(ctx, subsystemNode, 2).Infof("Successfully bind mounted %s at %s", source, targetPath)
+	return nil
+}
+
+// stageFromSharedMount bind-mounts the node-wide mount of source into the
+// staging path, mounting the share first if no other volume uses it yet.
+// The caller must hold d.staged.mu.
+func (d *Driver) stageFromSharedMount(ctx context.Context, volumeID string, volumeContext map[string]string, fsType, source string, mountOptions []string, stagingPath string) error {
+	sharedPath := sharedMountPath(d.sharedMountDir, fsType, source, mountOptions)
+	if err := d.filesystem.MkdirAll(sharedPath, 0750); err != nil {
+		return status.Errorf(codes.Internal, "failed to create shared mount path %s: %v", sharedPath, err)
+	}
+
+	notMnt, err := d.mounter.IsLikelyNotMountPoint(sharedPath)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to check mount point: %v", err)
+	}
+	if notMnt {
+		if err := d.waitForMount(ctx); err != nil {
+			return err
+		}
+		if err := d.mountContext(ctx, source, sharedPath, fsType, mountOptions); err != nil {
+			d.recordMountFailure(volumeID, volumeContext, err)
+			return mountFailedError(err, source, sharedPath)
+		}
+		d.logV(ctx, subsystemNode, 2).Infof("Mounted shared NFS %s at %s", source, sharedPath)
+	} else {
+		d.logV(ctx, subsystemNode, 4).Infof("Reusing shared NFS mount %s (%d reference(s))", sharedPath, d.staged.shared.count(sharedPath))
+	}
+
+	if err := d.mounter.Mount(sharedPath, stagingPath, "", []string{"bind"}); err != nil {
+		if d.staged.shared.count(sharedPath) == 0 {
+			if cleanupErr := mount.CleanupMountPoint(sharedPath, d.mounter, true); cleanupErr != nil {
+				klog.Warningf("Failed to unmount unused shared mount %s: %v", sharedPath, cleanupErr)
+			}
+		}
+		return status.Errorf(codes.Internal, "failed to bind mount %s at %s: %v", sharedPath, stagingPath, err)
+	}
+	d.staged.shared.add(sharedPath, stagingPath)
+
+	return nil
+}
+
+// applyVolumeMountGroup recursively hands the mounted tree to the pod's fsGroup.
+// This is only done for single-writer access modes: on RWX volumes pods with
+// different fsGroups would keep re-owning the same shared files.
+func (d *Driver) applyVolumeMountGroup(targetPath string, gid int, mode csi.VolumeCapability_AccessMode_Mode) error {
+	switch mode {
+	case csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER:
+	default:
+		klog.Warningf("Skipping fsGroup %d on %s: access mode %v is not single-writer", gid, targetPath, mode)
+		return nil
+	}
+
+	klog.V(2).Infof("Applying fsGroup %d to %s", gid, targetPath)
+
+	return walkDir(d.filesystem, targetPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := d.filesystem.Lchown(path, -1, gid); err != nil {
+			return err
+		}
+		if entry.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		// Same permission bits kubelet applies for fsGroup: group rw, plus
+		// setgid and group execute on directories so new files inherit the group
+		mode := info.Mode() | 0060
+		if entry.IsDir() {
+			mode |= os.ModeSetgid | 0010
+		}
+		return d.filesystem.Chmod(path, mode)
+	})
+}
+
 // NodeGetCapabilities returns the capabilities of the node service
 func (d *Driver) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
-	klog.V(4).Infof("NodeGetCapabilities called")
+	d.logV(ctx, subsystemNode, 4).Infof("NodeGetCapabilities called")
+
+	capabilities := []*csi.NodeServiceCapability{
+		{
+			Type: &csi.NodeServiceCapability_Rpc{
+				Rpc: &csi.NodeServiceCapability_RPC{
+					Type: csi.NodeServiceCapability_RPC_VOLUME_MOUNT_GROUP,
+				},
+			},
+		},
+	}
+	if d.staging {
+		capabilities = append(capabilities, &csi.NodeServiceCapability{
+			Type: &csi.NodeServiceCapability_Rpc{
+				Rpc: &csi.NodeServiceCapability_RPC{
+					Type: csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
+				},
+			},
+		})
+	}
+	if d.volumeStats {
+		capabilities = append(capabilities, &csi.NodeServiceCapability{
+			Type: &csi.NodeServiceCapability_Rpc{
+				Rpc: &csi.NodeServiceCapability_RPC{
+					Type: csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
+				},
+			},
+		})
+	}
+	if d.volumeExpansion {
+		capabilities = append(capabilities, &csi.NodeServiceCapability{
+			Type: &csi.NodeServiceCapability_Rpc{
+				Rpc: &csi.NodeServiceCapability_RPC{
+					Type: csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
+				},
+			},
+		})
+	}
 
 	return &csi.NodeGetCapabilitiesResponse{
-		Capabilities: []*csi.NodeServiceCapability{},
+		Capabilities: capabilities,
 	}, nil
 }
 
 // NodeGetInfo returns information about the node
 func (d *Driver) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
-	klog.V(4).Infof("NodeGetInfo called")
+	d.logV(ctx, subsystemNode, 4).Infof("NodeGetInfo called")
 
 	return &csi.NodeGetInfoResponse{
-		NodeId: d.nodeID,
+		NodeId:            d.nodeID,
+		MaxVolumesPerNode: d.maxVolumesPerNode,
 	}, nil
 }
 
-// NodeStageVolume is not implemented for NFS
+// NodeStageVolume mounts the whole NFS share at the staging path so that
+// NodePublishVolume can bind-mount subPaths from it. Only used with staging enabled.
 func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "NodeStageVolume is not implemented")
+	if !d.staging {
+		return nil, status.Error(codes.Unimplemented, "NodeStageVolume is not implemented")
+	}
+
+	volumeID := req.GetVolumeId()
+	stagingPath := req.GetStagingTargetPath()
+	// A forced server overrides whatever server a hand-made PV names
+	volumeContext := d.withForcedServer(req.GetVolumeContext(), req.GetVolumeId())
+
+	d.logV(ctx, subsystemNode, 2).Infof("NodeStageVolume: volumeID=%s, stagingPath=%s", volumeID, stagingPath)
+
+	if volumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume ID is required")
+	}
+	if stagingPath == "" {
+		return nil, status.Error(codes.InvalidArgument, "staging target path is required")
+	}
+
+	cap := req.GetVolumeCapability()
+	if err := validateVolumeCapability(cap); err != nil {
+		return nil, err
+	}
+
+	// Validates the subPath as well, even though only the base share is staged
+	if err := d.validateVolumeParameters(volumeContext, capabilityMountFlags(cap, volumeContext)); err != nil {
+		return nil, err
+	}
+	// NodePublishVolume mounts each of several shares itself
+	if volumeContext[ParamShares] != "" {
+		d.logV(ctx, subsystemNode, 2).Infof("NodeStageVolume: %s has several shares, which are not staged", volumeID)
+		return &csi.NodeStageVolumeResponse{}, nil
+	}
+	sources, err := getBaseSources(volumeContext)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "failed to get volume source: %v", err)
+	}
+
+	fsType, err := getFSType(volumeContext)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	mountOptions, err := d.buildMountOptions(cap, volumeContext)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.filesystem.MkdirAll(stagingPath, 0750); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to create staging path %s: %v", stagingPath, err)
+	}
+
+	d.staged.mu.Lock()
+	defer d.staged.mu.Unlock()
+
+	notMnt, err := d.mounter.IsLikelyNotMountPoint(stagingPath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to check mount point: %v", err)
+	}
+	if !notMnt {
+		d.logV(ctx, subsystemNode, 2).Infof("Staging path %s is already mounted", stagingPath)
+		return &csi.NodeStageVolumeResponse{}, nil
+	}
+
+	var source string
+	if d.sharedMountDir != "" {
+		// Each server has its own shared mount, so fail over one source at a time
+		for _, source = range sources {
+			if err = d.stageFromSharedMount(ctx, volumeID, volumeContext, fsType, source, mountOptions, stagingPath); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if err := d.waitForMount(ctx); err != nil {
+			return nil, err
+		}
+		if source, err = d.mountSources(ctx, sources, stagingPath, fsType, mountOptions); err != nil {
+			d.recordMountFailure(volumeID, volumeContext, err)
+			return nil, mountFailedError(err, strings.Join(sources, ","), stagingPath)
+		}
+	}
+
+	d.logV(ctx, subsystemNode, 2).Infof("Successfully staged NFS %s at %s", source, stagingPath)
+	return &csi.NodeStageVolumeResponse{}, nil
 }
 
-// NodeUnstageVolume is not implemented for NFS
+// NodeUnstageVolume unmounts the staged share once no publish target uses it
 func (d *Driver) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "NodeUnstageVolume is not implemented")
+	if !d.staging {
+		return nil, status.Error(codes.Unimplemented, "NodeUnstageVolume is not implemented")
+	}
+
+	volumeID := req.GetVolumeId()
+	stagingPath := req.GetStagingTargetPath()
+
+	d.logV(ctx, subsystemNode, 2).Infof("NodeUnstageVolume: volumeID=%s, stagingPath=%s", volumeID, stagingPath)
+
+	if volumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume ID is required")
+	}
+	if stagingPath == "" {
+		return nil, status.Error(codes.InvalidArgument, "staging target path is required")
+	}
+
+	// Hold the lock across the unmount so no publish can bind from the
+	// staged share while it is being torn down
+	d.staged.mu.Lock()
+	defer d.staged.mu.Unlock()
+
+	if n := d.staged.countLocked(stagingPath); n > 0 {
+		return nil, status.Errorf(codes.FailedPrecondition, "staged volume %s is still published at %d target(s)", volumeID, n)
+	}
+
+	if err := mount.CleanupMountPoint(stagingPath, d.mounter, true); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to unmount %s: %v", stagingPath, err)
+	}
+
+	// The last volume on a shared mount takes the NFS mount down with it
+	if sharedPath, ok := d.staged.shared.remove(stagingPath); ok && d.staged.shared.count(sharedPath) == 0 {
+		if err := mount.CleanupMountPoint(sharedPath, d.mounter, true); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to unmount shared mount %s: %v", sharedPath, err)
+		}
+		d.logV(ctx, subsystemNode, 2).Infof("Unmounted shared mount %s", sharedPath)
+	}
+
+	d.logV(ctx, subsystemNode, 2).Infof("Successfully unstaged %s", stagingPath)
+	return &csi.NodeUnstageVolumeResponse{}, nil
 }
 
-// NodeGetVolumeStats is not implemented
+// NodeGetVolumeStats reports the usage of a published volume. statfs on NFS
+// only sees the whole export, so volumes created with a capacity report that
+// capacity as their size instead, and with du enabled the space used below
+// the volume path.
 func (d *Driver) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "NodeGetVolumeStats is not implemented")
+	if !d.volumeStats {
+		return nil, status.Error(codes.Unimplemented, "NodeGetVolumeStats is not implemented")
+	}
+
+	volumeID := req.GetVolumeId()
+	volumePath := req.GetVolumePath()
+
+	if volumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume ID is required")
+	}
+	if volumePath == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume path is required")
+	}
+
+	if _, err := d.filesystem.Stat(volumePath); err != nil {
+		if os.IsNotExist(err) {
+			return nil, status.Errorf(codes.NotFound, "volume path %s does not exist", volumePath)
+		}
+		return nil, status.Errorf(codes.Internal, "failed to stat volume path %s: %v", volumePath, err)
+	}
+
+	usage, err := statfsUsage(volumePath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to statfs %s: %v", volumePath, err)
+	}
+
+	bytes := &csi.VolumeUsage{
+		Unit:      csi.VolumeUsage_BYTES,
+		Total:     usage.totalBytes,
+		Available: usage.availableBytes,
+		Used:      usage.usedBytes,
+	}
+	if capacity, ok := d.capacities.get(volumePath); ok {
+		// Without du the used space is unknown; the export may also have
+		// less room left than the capacity
+		bytes = &csi.VolumeUsage{
+			Unit:      csi.VolumeUsage_BYTES,
+			Total:     capacity,
+			Available: min(capacity, usage.availableBytes),
+		}
+		if d.volumeStatsDU {
+			used, err := diskUsage(ctx, volumePath)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to compute disk usage of %s: %v", volumePath, err)
+			}
+			bytes.Used = used
+			bytes.Available = max(0, min(capacity-used, usage.availableBytes))
+		}
+	}
+
+	usages := []*csi.VolumeUsage{bytes}
+	// Some servers report no inode counts at all; zeros would read as an
+	// export that has run out of inodes
+	if usage.totalInodes > 0 {
+		usages = append(usages, &csi.VolumeUsage{
+			Unit:      csi.VolumeUsage_INODES,
+			Total:     usage.totalInodes,
+			Available: usage.availableInodes,
+			Used:      usage.usedInodes,
+		})
+	}
+	return &csi.NodeGetVolumeStatsResponse{Usage: usages}, nil
 }
 
-// NodeExpandVolume is not implemented
+// NodeExpandVolume confirms the volume is published at the given path and
+// reports the requested size; NFS volumes need no node-side resize
 func (d *Driver) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "NodeExpandVolume is not implemented")
+	volumeID := req.GetVolumeId()
+	volumePath := req.GetVolumePath()
+
+	if volumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume ID is required")
+	}
+	if volumePath == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume path is required")
+	}
+
+	if _, err := d.filesystem.Stat(volumePath); err != nil {
+		if os.IsNotExist(err) {
+			return nil, status.Errorf(codes.NotFound, "volume path %s does not exist", volumePath)
+		}
+		return nil, status.Errorf(codes.Internal, "failed to stat volume path %s: %v", volumePath, err)
+	}
+
+	// The size of an NFS share is managed on the server, so there is nothing
+	// to resize on the node
+	capacity := req.GetCapacityRange().GetRequiredBytes()
+	d.logV(ctx, subsystemNode, 2).Infof("NodeExpandVolume: volumeID=%s, volumePath=%s, capacity=%d (no-op)", volumeID, volumePath, capacity)
+	if capacity > 0 {
+		d.capacities.set(volumePath, capacity)
+	}
+
+	return &csi.NodeExpandVolumeResponse{CapacityBytes: capacity}, nil
 }
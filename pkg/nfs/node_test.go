@@ -2,13 +2,20 @@ package nfs
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"k8s.io/mount-utils"
 )
 
 func TestNodePublishVolume_Validation(t *testing.T) {
@@ -99,6 +106,57 @@ func TestNodePublishVolume_Validation(t *testing.T) {
 	}
 }
 
+func TestNodePublishVolume_DeniedMountOption(t *testing.T) {
+	fakeMounter := mount.NewFakeMounter([]mount.MountPoint{})
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock",
+		WithMounter(fakeMounter),
+		WithDeniedMountOptions([]string{"suid"}),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "csi-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	targetPath := filepath.Join(tmpDir, "target")
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:   "test-volume",
+		TargetPath: targetPath,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{
+					MountFlags: []string{"nfsvers=4.1", "suid"},
+				},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+			},
+		},
+		VolumeContext: map[string]string{
+			"server": "192.168.1.1",
+			"share":  "/data",
+		},
+	}
+
+	_, err = driver.NodePublishVolume(context.Background(), req)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "suid") {
+		t.Errorf("Expected error to name the denied option, got %v", err)
+	}
+	if len(fakeMounter.GetLog()) != 0 {
+		t.Errorf("Expected no mount calls, got %v", fakeMounter.GetLog())
+	}
+	if _, err := os.Stat(targetPath); !os.IsNotExist(err) {
+		t.Errorf("Expected target path to not be created, got %v", err)
+	}
+}
+
 func TestNodeUnpublishVolume_Validation(t *testing.T) {
 	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock")
 	if err != nil {
@@ -184,6 +242,28 @@ func TestNodeGetInfo(t *testing.T) {
 	if resp.NodeId != nodeID {
 		t.Errorf("Expected node ID %s, got %s", nodeID, resp.NodeId)
 	}
+	if resp.MaxVolumesPerNode != 0 {
+		t.Errorf("Expected unlimited volumes by default, got %d", resp.MaxVolumesPerNode)
+	}
+}
+
+func TestNodeGetInfo_MaxVolumesPerNode(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithMaxVolumesPerNode(64))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	resp, err := driver.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{})
+	if err != nil {
+		t.Fatalf("NodeGetInfo failed: %v", err)
+	}
+	if resp.MaxVolumesPerNode != 64 {
+		t.Errorf("Expected max volumes per node 64, got %d", resp.MaxVolumesPerNode)
+	}
+
+	if _, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithMaxVolumesPerNode(-1)); err == nil {
+		t.Error("Expected a negative limit to be rejected")
+	}
 }
 
 func TestNodeGetCapabilities(t *testing.T) {
@@ -197,8 +277,1346 @@ func TestNodeGetCapabilities(t *testing.T) {
 		t.Fatalf("NodeGetCapabilities failed: %v", err)
 	}
 
-	// Our driver doesn't advertise any special capabilities
-	if len(resp.Capabilities) != 0 {
-		t.Errorf("Expected 0 capabilities, got %d", len(resp.Capabilities))
+	if len(resp.Capabilities) != 1 {
+		t.Fatalf("Expected 1 capability, got %d", len(resp.Capabilities))
+	}
+	if resp.Capabilities[0].GetRpc().GetType() != csi.NodeServiceCapability_RPC_VOLUME_MOUNT_GROUP {
+		t.Errorf("Expected VOLUME_MOUNT_GROUP capability, got %v", resp.Capabilities[0].GetRpc().GetType())
+	}
+}
+
+func TestNodePublishVolume_VolumeMountGroup(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing group ownership requires root")
+	}
+
+	const fsGroup = 12345
+
+	tests := []struct {
+		name      string
+		policy    string
+		group     string
+		mode      csi.VolumeCapability_AccessMode_Mode
+		wantCode  codes.Code
+		wantOwned bool
+	}{
+		{
+			name:      "File policy applies group for single writer",
+			policy:    FSGroupPolicyFile,
+			group:     strconv.Itoa(fsGroup),
+			mode:      csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			wantOwned: true,
+		},
+		{
+			name:   "File policy skips RWX",
+			policy: FSGroupPolicyFile,
+			group:  strconv.Itoa(fsGroup),
+			mode:   csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+		},
+		{
+			name:   "None policy leaves ownership alone",
+			policy: FSGroupPolicyNone,
+			group:  strconv.Itoa(fsGroup),
+			mode:   csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		},
+		{
+			name:     "invalid group",
+			policy:   FSGroupPolicyFile,
+			group:    "wheel",
+			mode:     csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "invalid policy",
+			policy:   "Recursive",
+			group:    strconv.Itoa(fsGroup),
+			mode:     csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			wantCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock",
+				WithMounter(mount.NewFakeMounter([]mount.MountPoint{})),
+			)
+			if err != nil {
+				t.Fatalf("Failed to create driver: %v", err)
+			}
+
+			targetPath := t.TempDir()
+			filePath := filepath.Join(targetPath, "data")
+			if err := os.WriteFile(filePath, []byte("data"), 0600); err != nil {
+				t.Fatalf("Failed to create file: %v", err)
+			}
+
+			_, err = driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:   "test-volume",
+				TargetPath: targetPath,
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{
+							VolumeMountGroup: tt.group,
+						},
+					},
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: tt.mode,
+					},
+				},
+				VolumeContext: map[string]string{
+					"server":        "192.168.1.1",
+					"share":         "/data",
+					"fsGroupPolicy": tt.policy,
+				},
+			})
+			if tt.wantCode != codes.OK {
+				if status.Code(err) != tt.wantCode {
+					t.Fatalf("Expected error code %v, got %v", tt.wantCode, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NodePublishVolume failed: %v", err)
+			}
+
+			info, err := os.Stat(filePath)
+			if err != nil {
+				t.Fatalf("Failed to stat file: %v", err)
+			}
+			owned := info.Sys().(*syscall.Stat_t).Gid == fsGroup
+			if owned != tt.wantOwned {
+				t.Errorf("Expected group ownership applied = %v, got %v", tt.wantOwned, owned)
+			}
+			if tt.wantOwned && info.Mode().Perm()&0060 != 0060 {
+				t.Errorf("Expected group read/write permission, got %v", info.Mode().Perm())
+			}
+		})
+	}
+}
+
+func TestNodePublishVolume_ValidateOnly(t *testing.T) {
+	fakeMounter := mount.NewFakeMounter([]mount.MountPoint{})
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock",
+		WithMounter(fakeMounter),
+		WithDeniedMountOptions([]string{"suid"}),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	capability := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{
+				MountFlags: []string{"nfsvers=4.1"},
+			},
+		},
+		AccessMode: &csi.VolumeCapability_AccessMode{
+			Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+		},
+	}
+
+	tests := []struct {
+		name     string
+		ctx      map[string]string
+		flags    []string
+		wantCode codes.Code
+	}{
+		{
+			name: "valid request is not mounted",
+			ctx: map[string]string{
+				"server":       "192.168.1.1",
+				"share":        "/data",
+				"subPath":      "app1",
+				"validateOnly": "true",
+			},
+		},
+		{
+			name: "invalid subPath is still rejected",
+			ctx: map[string]string{
+				"server":       "192.168.1.1",
+				"share":        "/data",
+				"subPath":      "../etc",
+				"validateOnly": "true",
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "denied mount option is still rejected",
+			ctx: map[string]string{
+				"server":       "192.168.1.1",
+				"share":        "/data",
+				"validateOnly": "true",
+			},
+			flags:    []string{"suid"},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targetPath := filepath.Join(t.TempDir(), "target")
+
+			capability.GetMount().MountFlags = append([]string{"nfsvers=4.1"}, tt.flags...)
+			_, err := driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:         "test-volume",
+				TargetPath:       targetPath,
+				VolumeCapability: capability,
+				VolumeContext:    tt.ctx,
+			})
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("Expected code %v, got %v", tt.wantCode, err)
+			}
+			if len(fakeMounter.GetLog()) != 0 {
+				t.Errorf("Expected no mount calls, got %v", fakeMounter.GetLog())
+			}
+			if _, err := os.Stat(targetPath); !os.IsNotExist(err) {
+				t.Errorf("Expected target path to not be created, got %v", err)
+			}
+		})
+	}
+}
+
+// errorMounter is a fake mounter whose Mount calls fail with err
+type errorMounter struct {
+	*mount.FakeMounter
+	err error
+}
+
+func (m *errorMounter) Mount(source, target, fstype string, options []string) error {
+	return m.err
+}
+
+func TestNodePublishVolume_ReadOnly(t *testing.T) {
+	tests := []struct {
+		name     string
+		readonly bool
+		ctx      map[string]string
+		wantRO   bool
+		wantCode codes.Code
+	}{
+		{
+			name:   "read-write by default",
+			ctx:    map[string]string{},
+			wantRO: false,
+		},
+		{
+			name:     "pod requests read-only",
+			readonly: true,
+			ctx:      map[string]string{},
+			wantRO:   true,
+		},
+		{
+			name:   "PV pins read-only",
+			ctx:    map[string]string{"readOnly": "true"},
+			wantRO: true,
+		},
+		{
+			name:   "PV readOnly false does not override pod",
+			ctx:    map[string]string{"readOnly": "false"},
+			wantRO: false,
+		},
+		{
+			name:     "invalid readOnly value",
+			ctx:      map[string]string{"readOnly": "yes please"},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeMounter := mount.NewFakeMounter([]mount.MountPoint{})
+			driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithMounter(fakeMounter))
+			if err != nil {
+				t.Fatalf("Failed to create driver: %v", err)
+			}
+
+			volumeContext := map[string]string{
+				"server": "192.168.1.1",
+				"share":  "/data",
+			}
+			for k, v := range tt.ctx {
+				volumeContext[k] = v
+			}
+
+			_, err = driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:   "test-volume",
+				TargetPath: filepath.Join(t.TempDir(), "target"),
+				Readonly:   tt.readonly,
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{},
+					},
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+					},
+				},
+				VolumeContext: volumeContext,
+			})
+			if tt.wantCode != codes.OK {
+				if status.Code(err) != tt.wantCode {
+					t.Fatalf("Expected error code %v, got %v", tt.wantCode, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NodePublishVolume failed: %v", err)
+			}
+
+			if len(fakeMounter.MountPoints) != 1 {
+				t.Fatalf("Expected 1 mount, got %d", len(fakeMounter.MountPoints))
+			}
+			gotRO := containsString(fakeMounter.MountPoints[0].Opts, "ro")
+			if gotRO != tt.wantRO {
+				t.Errorf("Expected ro=%v, got mount options %v", tt.wantRO, fakeMounter.MountPoints[0].Opts)
+			}
+		})
+	}
+}
+
+func TestNodeStageVolume_Disabled(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock")
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	_, err = driver.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{})
+	if status.Code(err) != codes.Unimplemented {
+		t.Errorf("Expected Unimplemented, got %v", err)
+	}
+
+	resp, err := driver.NodeGetCapabilities(context.Background(), &csi.NodeGetCapabilitiesRequest{})
+	if err != nil {
+		t.Fatalf("NodeGetCapabilities failed: %v", err)
+	}
+	for _, cap := range resp.GetCapabilities() {
+		if cap.GetRpc().GetType() == csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME {
+			t.Errorf("STAGE_UNSTAGE_VOLUME advertised with staging disabled")
+		}
+	}
+}
+
+func TestNodeStageVolume_BindMountsSubPath(t *testing.T) {
+	fakeMounter := mount.NewFakeMounter([]mount.MountPoint{})
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithMounter(fakeMounter), WithStaging(true))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+	ctx := context.Background()
+
+	resp, err := driver.NodeGetCapabilities(ctx, &csi.NodeGetCapabilitiesRequest{})
+	if err != nil {
+		t.Fatalf("NodeGetCapabilities failed: %v", err)
+	}
+	found := false
+	for _, cap := range resp.GetCapabilities() {
+		if cap.GetRpc().GetType() == csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected STAGE_UNSTAGE_VOLUME capability")
+	}
+
+	dir := t.TempDir()
+	stagingPath := filepath.Join(dir, "staging")
+	volumeCap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{},
+		},
+		AccessMode: &csi.VolumeCapability_AccessMode{
+			Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+		},
+	}
+	volumeContext := map[string]string{
+		"server":  "192.168.1.1",
+		"share":   "/data",
+		"subPath": "team-a",
+	}
+
+	_, err = driver.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+		VolumeId:          "test-volume",
+		StagingTargetPath: stagingPath,
+		VolumeCapability:  volumeCap,
+		VolumeContext:     volumeContext,
+	})
+	if err != nil {
+		t.Fatalf("NodeStageVolume failed: %v", err)
+	}
+	if len(fakeMounter.MountPoints) != 1 || fakeMounter.MountPoints[0].Device != "192.168.1.1:/data" {
+		t.Fatalf("Expected base share staged, got %+v", fakeMounter.MountPoints)
+	}
+
+	// The fake mounter does not expose the share contents, so create the subPath by hand
+	if err := os.Mkdir(filepath.Join(stagingPath, "team-a"), 0755); err != nil {
+		t.Fatalf("Failed to create subPath: %v", err)
+	}
+
+	targets := []string{filepath.Join(dir, "pod-1"), filepath.Join(dir, "pod-2")}
+	for _, target := range targets {
+		_, err := driver.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+			VolumeId:          "test-volume",
+			StagingTargetPath: stagingPath,
+			TargetPath:        target,
+			VolumeCapability:  volumeCap,
+			VolumeContext:     volumeContext,
+		})
+		if err != nil {
+			t.Fatalf("NodePublishVolume(%s) failed: %v", target, err)
+		}
+	}
+
+	log := fakeMounter.GetLog()
+	if len(log) != 3 {
+		t.Fatalf("Expected 1 NFS mount and 2 bind mounts, got %+v", log)
+	}
+	for _, action := range log[1:] {
+		if action.FSType != "" {
+			t.Errorf("Expected bind mount, got %+v", action)
+		}
+	}
+
+	unstage := &csi.NodeUnstageVolumeRequest{VolumeId: "test-volume", StagingTargetPath: stagingPath}
+
+	// Still published, so the staged mount must stay
+	if _, err := driver.NodeUnstageVolume(ctx, unstage); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("Expected FailedPrecondition while published, got %v", err)
+	}
+
+	for _, target := range targets {
+		if _, err := driver.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: "test-volume", TargetPath: target}); err != nil {
+			t.Fatalf("NodeUnpublishVolume(%s) failed: %v", target, err)
+		}
+	}
+
+	// Drop the hand-made subPath again so the staging dir can be removed
+	if err := os.Remove(filepath.Join(stagingPath, "team-a")); err != nil {
+		t.Fatalf("Failed to remove subPath: %v", err)
+	}
+	if _, err := driver.NodeUnstageVolume(ctx, unstage); err != nil {
+		t.Fatalf("NodeUnstageVolume failed: %v", err)
+	}
+	if len(fakeMounter.MountPoints) != 0 {
+		t.Errorf("Expected all mounts removed, got %+v", fakeMounter.MountPoints)
+	}
+}
+
+func TestNodePublishVolume_NotStaged(t *testing.T) {
+	fakeMounter := mount.NewFakeMounter([]mount.MountPoint{})
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithMounter(fakeMounter), WithStaging(true))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	dir := t.TempDir()
+	_, err = driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:          "test-volume",
+		StagingTargetPath: dir,
+		TargetPath:        filepath.Join(dir, "target"),
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+			},
+		},
+		VolumeContext: map[string]string{
+			"server": "192.168.1.1",
+			"share":  "/data",
+		},
+	})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition, got %v", err)
+	}
+}
+
+func TestNodePublishVolume_FSType(t *testing.T) {
+	tests := []struct {
+		name     string
+		fsType   string
+		want     string
+		wantCode codes.Code
+	}{
+		{name: "default", want: "nfs"},
+		{name: "nfs4", fsType: "nfs4", want: "nfs4"},
+		{name: "invalid", fsType: "cifs", wantCode: codes.InvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeMounter := mount.NewFakeMounter([]mount.MountPoint{})
+			driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithMounter(fakeMounter))
+			if err != nil {
+				t.Fatalf("Failed to create driver: %v", err)
+			}
+
+			volumeContext := map[string]string{
+				"server": "192.168.1.1",
+				"share":  "/data",
+			}
+			if tt.fsType != "" {
+				volumeContext["fsType"] = tt.fsType
+			}
+
+			_, err = driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:   "test-volume",
+				TargetPath: filepath.Join(t.TempDir(), "target"),
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{},
+					},
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+					},
+				},
+				VolumeContext: volumeContext,
+			})
+			if tt.wantCode != codes.OK {
+				if status.Code(err) != tt.wantCode {
+					t.Fatalf("Expected error code %v, got %v", tt.wantCode, err)
+				}
+				if len(fakeMounter.GetLog()) != 0 {
+					t.Errorf("Expected no mount, got %+v", fakeMounter.GetLog())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NodePublishVolume failed: %v", err)
+			}
+
+			log := fakeMounter.GetLog()
+			if len(log) != 1 || log[0].FSType != tt.want {
+				t.Errorf("Expected one %s mount, got %+v", tt.want, log)
+			}
+		})
+	}
+}
+
+// failingMounter is a fake mounter that fails to mount the given sources
+type failingMounter struct {
+	*mount.FakeMounter
+	failing map[string]bool
+}
+
+func (m *failingMounter) Mount(source, target, fstype string, options []string) error {
+	if m.failing[source] {
+		return fmt.Errorf("mount of %s failed", source)
+	}
+	return m.FakeMounter.Mount(source, target, fstype, options)
+}
+
+func TestNodePublishVolume_ServerFailover(t *testing.T) {
+	tests := []struct {
+		name        string
+		failing     []string
+		wantMounted string
+		wantErr     string
+	}{
+		{name: "first server mounts", wantMounted: "192.168.1.1:/data"},
+		{name: "falls over to the second server", failing: []string{"192.168.1.1:/data"}, wantMounted: "192.168.1.2:/data"},
+		{
+			name:    "all servers fail",
+			failing: []string{"192.168.1.1:/data", "192.168.1.2:/data"},
+			wantErr: "mount of 192.168.1.2:/data failed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeMounter := &failingMounter{FakeMounter: mount.NewFakeMounter([]mount.MountPoint{}), failing: map[string]bool{}}
+			for _, source := range tt.failing {
+				fakeMounter.failing[source] = true
+			}
+			driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithMounter(fakeMounter))
+			if err != nil {
+				t.Fatalf("Failed to create driver: %v", err)
+			}
+
+			target := filepath.Join(t.TempDir(), "target")
+			_, err = driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:   "test-volume",
+				TargetPath: target,
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{},
+					},
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+					},
+				},
+				VolumeContext: map[string]string{
+					"server": "192.168.1.1,192.168.1.2",
+					"share":  "/data",
+				},
+			})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NodePublishVolume failed: %v", err)
+			}
+
+			log := fakeMounter.GetLog()
+			if len(log) != 1 || log[0].Source != tt.wantMounted || log[0].Target != target {
+				t.Errorf("Expected %s mounted at %s, got %+v", tt.wantMounted, target, log)
+			}
+		})
+	}
+}
+
+func TestNodePublishVolume_TargetPathMode(t *testing.T) {
+	fakeMounter := mount.NewFakeMounter([]mount.MountPoint{})
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithMounter(fakeMounter), WithTargetPathMode(0700))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	target := filepath.Join(t.TempDir(), "target")
+	_, err = driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:   "test-volume",
+		TargetPath: target,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+			},
+		},
+		VolumeContext: map[string]string{
+			"server": "192.168.1.1",
+			"share":  "/data",
+		},
+	})
+	if err != nil {
+		t.Fatalf("NodePublishVolume failed: %v", err)
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		t.Fatalf("Failed to stat target: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0700 {
+		t.Errorf("Expected target mode 0700, got %#o", perm)
+	}
+}
+
+func TestNodePublishVolume_TargetModeParameter(t *testing.T) {
+	tests := []struct {
+		name       string
+		targetMode string
+		wantCode   codes.Code
+		wantMode   os.FileMode
+	}{
+		{name: "default", wantMode: 0750},
+		{name: "override", targetMode: "0700", wantMode: 0700},
+		{name: "without leading zero", targetMode: "755", wantMode: 0755},
+		{name: "not octal", targetMode: "0759", wantCode: codes.InvalidArgument},
+		{name: "owner cannot write", targetMode: "0500", wantCode: codes.InvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeMounter := mount.NewFakeMounter([]mount.MountPoint{})
+			driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithMounter(fakeMounter))
+			if err != nil {
+				t.Fatalf("Failed to create driver: %v", err)
+			}
+
+			target := filepath.Join(t.TempDir(), "target")
+			volumeContext := map[string]string{
+				"server": "192.168.1.1",
+				"share":  "/data",
+			}
+			if tt.targetMode != "" {
+				volumeContext["targetMode"] = tt.targetMode
+			}
+			_, err = driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:   "test-volume",
+				TargetPath: target,
+				Readonly:   true,
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{},
+					},
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+					},
+				},
+				VolumeContext: volumeContext,
+			})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("Expected %v, got %v", tt.wantCode, err)
+			}
+			if tt.wantCode != codes.OK {
+				if _, err := os.Stat(target); !os.IsNotExist(err) {
+					t.Errorf("Expected no target directory, got %v", err)
+				}
+				return
+			}
+
+			info, err := os.Stat(target)
+			if err != nil {
+				t.Fatalf("Failed to stat target: %v", err)
+			}
+			if perm := info.Mode().Perm(); perm != tt.wantMode {
+				t.Errorf("Expected target mode %#o, got %#o", tt.wantMode, perm)
+			}
+		})
+	}
+}
+
+func TestNodePublishVolume_CreateTargetParameter(t *testing.T) {
+	tests := []struct {
+		name         string
+		createTarget string
+		targetExists bool
+		wantCode     codes.Code
+	}{
+		{name: "default creates the target"},
+		{name: "true creates the target", createTarget: "true"},
+		{name: "false with the target in place", createTarget: "false", targetExists: true},
+		{name: "false without the target", createTarget: "false", wantCode: codes.FailedPrecondition},
+		{name: "not a boolean", createTarget: "sometimes", wantCode: codes.InvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeFS := newFakeFilesystem()
+			fakeMounter := mount.NewFakeMounter([]mount.MountPoint{})
+			driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithMounter(fakeMounter), WithFS(fakeFS))
+			if err != nil {
+				t.Fatalf("Failed to create driver: %v", err)
+			}
+
+			target := filepath.Join(t.TempDir(), "target")
+			if tt.targetExists {
+				if err := fakeFS.MkdirAll(target, 0750); err != nil {
+					t.Fatalf("MkdirAll failed: %v", err)
+				}
+			}
+			volumeContext := map[string]string{
+				"server": "192.168.1.1",
+				"share":  "/data",
+			}
+			if tt.createTarget != "" {
+				volumeContext["createTarget"] = tt.createTarget
+			}
+			_, err = driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:   "test-volume",
+				TargetPath: target,
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{},
+					},
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+					},
+				},
+				VolumeContext: volumeContext,
+			})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("Expected %v, got %v", tt.wantCode, err)
+			}
+
+			mounted := len(fakeMounter.GetLog()) > 0
+			_, statErr := fakeFS.Stat(target)
+			if tt.wantCode != codes.OK {
+				if mounted {
+					t.Errorf("Expected no mount, got %v", fakeMounter.GetLog())
+				}
+				if !os.IsNotExist(statErr) {
+					t.Errorf("Expected the target not to be created, got %v", statErr)
+				}
+				return
+			}
+			if !mounted {
+				t.Error("Expected the volume to be mounted")
+			}
+			if statErr != nil {
+				t.Errorf("Expected the target to exist, got %v", statErr)
+			}
+		})
+	}
+}
+
+func TestNodeGetCapabilities_VolumeExpansion(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithVolumeExpansion(true))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	resp, err := driver.NodeGetCapabilities(context.Background(), &csi.NodeGetCapabilitiesRequest{})
+	if err != nil {
+		t.Fatalf("NodeGetCapabilities failed: %v", err)
+	}
+
+	found := false
+	for _, cap := range resp.Capabilities {
+		if cap.GetRpc().GetType() == csi.NodeServiceCapability_RPC_EXPAND_VOLUME {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("Expected EXPAND_VOLUME capability when volume expansion is enabled")
+	}
+}
+
+func TestNodeExpandVolume(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithVolumeExpansion(true))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		volumePath string
+		wantCode   codes.Code
+	}{
+		{name: "published volume", volumePath: t.TempDir()},
+		{name: "missing volume path", volumePath: filepath.Join(t.TempDir(), "missing"), wantCode: codes.NotFound},
+		{name: "empty volume path", wantCode: codes.InvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := driver.NodeExpandVolume(context.Background(), &csi.NodeExpandVolumeRequest{
+				VolumeId:      "test-volume",
+				VolumePath:    tt.volumePath,
+				CapacityRange: &csi.CapacityRange{RequiredBytes: 10 << 30},
+			})
+			if tt.wantCode != codes.OK {
+				if status.Code(err) != tt.wantCode {
+					t.Fatalf("Expected error code %v, got %v", tt.wantCode, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NodeExpandVolume failed: %v", err)
+			}
+			if resp.CapacityBytes != 10<<30 {
+				t.Errorf("Expected capacity %d, got %d", int64(10<<30), resp.CapacityBytes)
+			}
+		})
+	}
+}
+
+func TestNodePublishVolume_ForceServer(t *testing.T) {
+	fakeMounter := mount.NewFakeMounter([]mount.MountPoint{})
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithMounter(fakeMounter), WithForceServer("10.0.0.1"))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	// A hand-made PV pointing at an external server
+	_, err = driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:   "test-volume",
+		TargetPath: filepath.Join(t.TempDir(), "target"),
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+			},
+		},
+		VolumeContext: map[string]string{
+			"server":  "203.0.113.7",
+			"servers": "203.0.113.7,203.0.113.8",
+			"share":   "/data",
+		},
+	})
+	if err != nil {
+		t.Fatalf("NodePublishVolume failed: %v", err)
+	}
+
+	log := fakeMounter.GetLog()
+	if len(log) != 1 || log[0].Source != "10.0.0.1:/data" {
+		t.Errorf("Expected mount from the forced server, got %+v", log)
+	}
+}
+
+func TestNodePublishVolume_RawShare(t *testing.T) {
+	fakeMounter := mount.NewFakeMounter([]mount.MountPoint{})
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithMounter(fakeMounter))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	_, err = driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:   "test-volume",
+		TargetPath: filepath.Join(t.TempDir(), "target"),
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+			},
+		},
+		VolumeContext: map[string]string{
+			"server":   "192.168.1.1",
+			"share":    "vol0",
+			"rawShare": "true",
+		},
+	})
+	if err != nil {
+		t.Fatalf("NodePublishVolume failed: %v", err)
+	}
+
+	log := fakeMounter.GetLog()
+	if len(log) != 1 || log[0].Source != "192.168.1.1:vol0" {
+		t.Errorf("Expected the share passed through verbatim, got %+v", log)
+	}
+}
+
+func TestNodeUnpublishVolume_UnmountRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		retries  int
+		wantErr  bool
+	}{
+		{name: "succeeds after transient failures", failures: 2, retries: 3},
+		{name: "gives up after the retries", failures: 5, retries: 2, wantErr: true},
+		{name: "no retries by default", failures: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := filepath.Join(t.TempDir(), "target")
+			if err := os.MkdirAll(target, 0750); err != nil {
+				t.Fatalf("Failed to create target: %v", err)
+			}
+			fakeMounter := mount.NewFakeMounter([]mount.MountPoint{{Device: "192.168.1.1:/data", Path: target, Type: "nfs"}})
+			attempts := 0
+			fakeMounter.UnmountFunc = func(path string) error {
+				attempts++
+				if attempts <= tt.failures {
+					return fmt.Errorf("server not responding")
+				}
+				return nil
+			}
+
+			driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock",
+				WithMounter(fakeMounter), WithUnmountRetry(tt.retries, time.Millisecond))
+			if err != nil {
+				t.Fatalf("Failed to create driver: %v", err)
+			}
+
+			_, err = driver.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{VolumeId: "test-volume", TargetPath: target})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NodeUnpublishVolume() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if want := min(tt.failures+1, tt.retries+1); attempts != want {
+				t.Errorf("Expected %d unmount attempts, got %d", want, attempts)
+			}
+		})
+	}
+}
+
+func TestNodeUnpublishVolume_UnmountRetryContext(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "target")
+	if err := os.MkdirAll(target, 0750); err != nil {
+		t.Fatalf("Failed to create target: %v", err)
+	}
+	fakeMounter := mount.NewFakeMounter([]mount.MountPoint{{Device: "192.168.1.1:/data", Path: target, Type: "nfs"}})
+	fakeMounter.UnmountFunc = func(path string) error {
+		return fmt.Errorf("server not responding")
+	}
+
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock",
+		WithMounter(fakeMounter), WithUnmountRetry(10, time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := driver.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: "test-volume", TargetPath: target}); err == nil {
+		t.Fatal("Expected NodeUnpublishVolume to fail once the context ends")
+	}
+}
+
+func TestNodeUnpublishVolume_KeepTarget(t *testing.T) {
+	tests := []struct {
+		name    string
+		keep    bool
+		mounted bool
+	}{
+		{name: "removes unmounted target", keep: false},
+		{name: "keeps unmounted target", keep: true},
+		{name: "removes mounted target", keep: false, mounted: true},
+		{name: "keeps mounted target", keep: true, mounted: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := filepath.Join(t.TempDir(), "target")
+			if err := os.MkdirAll(target, 0750); err != nil {
+				t.Fatalf("Failed to create target: %v", err)
+			}
+			var mountPoints []mount.MountPoint
+			if tt.mounted {
+				mountPoints = append(mountPoints, mount.MountPoint{Device: "192.168.1.1:/data", Path: target, Type: "nfs"})
+			}
+			fakeMounter := mount.NewFakeMounter(mountPoints)
+
+			driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock",
+				WithMounter(fakeMounter), WithKeepTargetOnUnpublish(tt.keep))
+			if err != nil {
+				t.Fatalf("Failed to create driver: %v", err)
+			}
+
+			if _, err := driver.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{VolumeId: "test-volume", TargetPath: target}); err != nil {
+				t.Fatalf("NodeUnpublishVolume failed: %v", err)
+			}
+			if len(fakeMounter.MountPoints) != 0 {
+				t.Errorf("Expected target to be unmounted, got %+v", fakeMounter.MountPoints)
+			}
+			_, err = os.Stat(target)
+			if tt.keep && err != nil {
+				t.Errorf("Expected target to be kept, got %v", err)
+			}
+			if !tt.keep && !os.IsNotExist(err) {
+				t.Errorf("Expected target to be removed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestNodePublishVolume_Security(t *testing.T) {
+	tests := []struct {
+		name     string
+		security string
+		wantCode codes.Code
+		wantOpt  string
+	}{
+		{name: "sys", security: "sys", wantCode: codes.OK, wantOpt: "sec=sys"},
+		// Tickets come from rpc.gssd on the host, not from secrets
+		{name: "krb5 without secrets", security: "krb5", wantCode: codes.OK, wantOpt: "sec=krb5"},
+		{name: "unknown flavor", security: "krb4", wantCode: codes.InvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeMounter := mount.NewFakeMounter([]mount.MountPoint{})
+			driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithMounter(fakeMounter))
+			if err != nil {
+				t.Fatalf("Failed to create driver: %v", err)
+			}
+
+			_, err = driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:   "test-volume",
+				TargetPath: filepath.Join(t.TempDir(), "target"),
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{},
+					},
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+					},
+				},
+				VolumeContext: map[string]string{
+					"server":   "192.168.1.1",
+					"share":    "/data",
+					"security": tt.security,
+				},
+			})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("Expected %v, got %v", tt.wantCode, err)
+			}
+			if tt.wantCode != codes.OK {
+				if log := fakeMounter.GetLog(); len(log) != 0 {
+					t.Errorf("Expected no mount calls, got %+v", log)
+				}
+				return
+			}
+
+			log := fakeMounter.GetLog()
+			if len(log) != 1 {
+				t.Fatalf("Expected 1 mount, got %+v", log)
+			}
+			if !strings.Contains(strings.Join(fakeMounter.MountPoints[0].Opts, ","), tt.wantOpt) {
+				t.Errorf("Expected %s in mount options, got %v", tt.wantOpt, fakeMounter.MountPoints[0].Opts)
+			}
+		})
+	}
+}
+
+func TestNodePublishVolume_AlreadyMountedOptions(t *testing.T) {
+	fakeMounter := mount.NewFakeMounter([]mount.MountPoint{})
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithMounter(fakeMounter))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+	target := filepath.Join(t.TempDir(), "target")
+
+	publish := func(readOnly bool, flags ...string) error {
+		_, err := driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+			VolumeId:   "test-volume",
+			TargetPath: target,
+			Readonly:   readOnly,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{MountFlags: flags},
+				},
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+				},
+			},
+			VolumeContext: map[string]string{"server": "192.168.1.1", "share": "/data"},
+		})
+		return err
+	}
+
+	if err := publish(false, "hard", "nfsvers=4.1"); err != nil {
+		t.Fatalf("NodePublishVolume failed: %v", err)
+	}
+	if err := publish(false, "nfsvers=4.1", "hard"); err != nil {
+		t.Errorf("Expected republish with reordered options to succeed, got %v", err)
+	}
+	if err := publish(true, "hard", "nfsvers=4.1"); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("Expected AlreadyExists when republishing read-only, got %v", err)
+	}
+	if err := publish(false, "hard", "nfsvers=3"); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("Expected AlreadyExists when republishing with another version, got %v", err)
+	}
+	if n := len(fakeMounter.GetLog()); n != 1 {
+		t.Errorf("Expected a single mount, got %d", n)
+	}
+}
+
+func TestNodePublishVolume_MountRateLimit(t *testing.T) {
+	fakeMounter := mount.NewFakeMounter([]mount.MountPoint{})
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock",
+		WithMounter(fakeMounter), WithMountRateLimit(20, 1))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+	dir := t.TempDir()
+
+	publish := func(ctx context.Context, i int) error {
+		_, err := driver.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+			VolumeId:   fmt.Sprintf("vol-%d", i),
+			TargetPath: filepath.Join(dir, fmt.Sprintf("target-%d", i)),
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{},
+				},
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+				},
+			},
+			VolumeContext: map[string]string{"server": "192.168.1.1", "share": "/data"},
+		})
+		return err
+	}
+
+	// One mount every 50ms after the first
+	const mounts = 5
+	start := time.Now()
+	for i := 0; i < mounts; i++ {
+		if err := publish(context.Background(), i); err != nil {
+			t.Fatalf("NodePublishVolume(%d) failed: %v", i, err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("Expected %d mounts to be paced over at least 150ms, took %v", mounts, elapsed)
+	}
+
+	// A request whose deadline ends before its turn fails without mounting
+	driver.mountLimiter.SetLimit(0.1)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := publish(ctx, mounts); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("Expected DeadlineExceeded, got %v", err)
+	}
+	if n := len(fakeMounter.GetLog()); n != mounts {
+		t.Errorf("Expected %d mounts, got %d", mounts, n)
+	}
+}
+
+func TestNodePublishVolume_Ephemeral(t *testing.T) {
+	inline := func(extra map[string]string) map[string]string {
+		volumeContext := map[string]string{
+			"server":                           "192.168.1.1",
+			"share":                            "/data",
+			"subPath":                          "app1",
+			"csi.storage.k8s.io/ephemeral":     "true",
+			"csi.storage.k8s.io/pod.name":      "app",
+			"csi.storage.k8s.io/pod.namespace": "default",
+		}
+		for key, value := range extra {
+			volumeContext[key] = value
+		}
+		return volumeContext
+	}
+
+	tests := []struct {
+		name          string
+		volumeContext map[string]string
+		opts          []DriverOption
+		noServerMap   bool
+		wantCode      codes.Code
+	}{
+		{name: "inline volume", volumeContext: inline(nil), wantCode: codes.OK},
+		{name: "no namespace server map", volumeContext: inline(nil), noServerMap: true, wantCode: codes.FailedPrecondition},
+		{name: "missing share", volumeContext: inline(map[string]string{"share": ""}), wantCode: codes.InvalidArgument},
+		{name: "parameter not allowed", volumeContext: inline(map[string]string{"noatime": "true"}),
+			opts: []DriverOption{WithAllowedParameters([]string{"server", "share", "subPath"})}, wantCode: codes.InvalidArgument},
+		{name: "mount profile", volumeContext: inline(map[string]string{"mountProfile": "bulk"}), wantCode: codes.InvalidArgument},
+		{name: "profile options", volumeContext: inline(map[string]string{"profileMountOptions": "hard"}), wantCode: codes.InvalidArgument},
+		{name: "server allowed for the pod namespace", volumeContext: inline(nil),
+			opts: []DriverOption{WithNamespaceServers(map[string]string{"default": "192.168.1.1"})}, wantCode: codes.OK},
+		{name: "server denied for the pod namespace", volumeContext: inline(nil),
+			opts: []DriverOption{WithNamespaceServers(map[string]string{"default": "192.168.1.2"})}, wantCode: codes.PermissionDenied},
+		{name: "persistent volume keeps controller keys", volumeContext: map[string]string{
+			"server": "192.168.1.1", "share": "/data", "profileMountOptions": "hard",
+		}, noServerMap: true, wantCode: codes.OK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeMounter := mount.NewFakeMounter([]mount.MountPoint{})
+			opts := []DriverOption{WithMounter(fakeMounter)}
+			if !tt.noServerMap {
+				opts = append(opts, WithNamespaceServers(map[string]string{"*": "192.168.1.1"}))
+			}
+			driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", append(opts, tt.opts...)...)
+			if err != nil {
+				t.Fatalf("Failed to create driver: %v", err)
+			}
+
+			_, err = driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:   "csi-0123456789abcdef",
+				TargetPath: filepath.Join(t.TempDir(), "target"),
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{},
+					},
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+					},
+				},
+				VolumeContext: tt.volumeContext,
+			})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("Expected %v, got %v", tt.wantCode, err)
+			}
+			if tt.wantCode != codes.OK {
+				if log := fakeMounter.GetLog(); len(log) != 0 {
+					t.Errorf("Expected no mount calls, got %+v", log)
+				}
+				return
+			}
+
+			if len(fakeMounter.MountPoints) != 1 {
+				t.Fatalf("Expected 1 mount, got %+v", fakeMounter.MountPoints)
+			}
+			want := "192.168.1.1:/data"
+			if tt.volumeContext["subPath"] != "" {
+				want += "/" + tt.volumeContext["subPath"]
+			}
+			if got := fakeMounter.MountPoints[0].Device; got != want {
+				t.Errorf("Expected source %s, got %s", want, got)
+			}
+		})
+	}
+}
+
+func TestNodePublishVolume_SpecialCharacters(t *testing.T) {
+	fakeMounter := mount.NewFakeMounter([]mount.MountPoint{})
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithMounter(fakeMounter))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	_, err = driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:   "test-volume",
+		TargetPath: filepath.Join(t.TempDir(), "target"),
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+			},
+		},
+		VolumeContext: map[string]string{
+			"server":  "192.168.1.1",
+			"share":   "/exports/my data",
+			"subPath": "チーム/it's $HOME",
+		},
+	})
+	if err != nil {
+		t.Fatalf("NodePublishVolume failed: %v", err)
+	}
+
+	// The source is handed to the mounter as one argument, unquoted
+	want := "192.168.1.1:/exports/my data/チーム/it's $HOME"
+	if len(fakeMounter.MountPoints) != 1 || fakeMounter.MountPoints[0].Device != want {
+		t.Errorf("Expected source %q, got %+v", want, fakeMounter.MountPoints)
+	}
+}
+
+func TestNodePublishVolume_MountOptionsMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		wantCode codes.Code
+		wantOpts []string
+	}{
+		{name: "default appends", wantOpts: []string{"nolock", "nfsvers=3", "lock"}},
+		{name: "append", mode: "append", wantOpts: []string{"nolock", "nfsvers=3", "lock"}},
+		{name: "replace", mode: "replace", wantOpts: []string{"nfsvers=3", "lock"}},
+		{name: "invalid", mode: "merge", wantCode: codes.InvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeMounter := mount.NewFakeMounter([]mount.MountPoint{})
+			driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithMounter(fakeMounter))
+			if err != nil {
+				t.Fatalf("Failed to create driver: %v", err)
+			}
+
+			volumeContext := map[string]string{
+				"server": "192.168.1.1",
+				"share":  "/data",
+			}
+			if tt.mode != "" {
+				volumeContext["mountOptionsMode"] = tt.mode
+			}
+			_, err = driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:   "test-volume",
+				TargetPath: filepath.Join(t.TempDir(), "target"),
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{MountFlags: []string{"nfsvers=3", "lock"}},
+					},
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+					},
+				},
+				VolumeContext: volumeContext,
+			})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("Expected %v, got %v", tt.wantCode, err)
+			}
+			if tt.wantCode != codes.OK {
+				return
+			}
+
+			if len(fakeMounter.MountPoints) != 1 {
+				t.Fatalf("Expected 1 mount, got %+v", fakeMounter.MountPoints)
+			}
+			if got := fakeMounter.MountPoints[0].Opts; !reflect.DeepEqual(got, tt.wantOpts) {
+				t.Errorf("Expected mount options %v, got %v", tt.wantOpts, got)
+			}
+		})
 	}
 }
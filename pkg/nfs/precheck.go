@@ -0,0 +1,78 @@
+package nfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// defaultNFSPort is the port the precheck dials when the volume sets none
+const defaultNFSPort = 2049
+
+// dialServer opens a TCP connection to an NFS server. It is replaced in tests.
+var dialServer = func(ctx context.Context, network, address string) (net.Conn, error) {
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, address)
+}
+
+// WithPrecheckServer makes CreateVolume dial the NFS server before it
+// returns, waiting at most timeout per server, so provisioning fails while
+// the server is down instead of creating a PV that cannot be mounted
+func WithPrecheckServer(timeout time.Duration) DriverOption {
+	return func(d *Driver) {
+		d.precheckServer = true
+		d.precheckTimeout = timeout
+	}
+}
+
+// precheckServers returns nil once one of servers accepts a TCP connection
+// on the NFS port, and an Unavailable error if none does. Like the mount,
+// the check passes as soon as one server of a failover list is reachable.
+func (d *Driver) precheckServers(ctx context.Context, servers []string, params map[string]string) error {
+	// validateVolumeParameters has checked the port already
+	port, _ := parsePort(params[ParamPort])
+	if err := dialServers(ctx, servers, port, d.precheckTimeout); err != nil {
+		if ctx.Err() != nil {
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		return status.Errorf(codes.Unavailable, "NFS server not reachable: %v", err)
+	}
+	return nil
+}
+
+// dialServers returns nil once one of servers accepts a TCP connection on
+// port, or the NFS port if port is 0, waiting at most timeout per server.
+// Otherwise the error lists why each server failed.
+func dialServers(ctx context.Context, servers []string, port int, timeout time.Duration) error {
+	if port == 0 {
+		port = defaultNFSPort
+	}
+
+	var failures []string
+	for _, server := range servers {
+		host := strings.TrimSuffix(strings.TrimPrefix(server, "["), "]")
+		address := net.JoinHostPort(host, strconv.Itoa(port))
+
+		dialCtx, cancel := context.WithTimeout(ctx, timeout)
+		conn, err := dialServer(dialCtx, "tcp", address)
+		cancel()
+		if err == nil {
+			_ = conn.Close()
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		klog.Warningf("NFS server %s is not reachable: %v", address, err)
+		failures = append(failures, fmt.Sprintf("%s: %v", address, err))
+	}
+	return errors.New(strings.Join(failures, "; "))
+}
@@ -0,0 +1,159 @@
+package nfs
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// stubDialServer makes dialServer succeed for the addresses in reachable,
+// block until its context ends for those in hanging, and refuse the rest.
+// It returns the addresses dialed.
+func stubDialServer(t *testing.T, reachable, hanging []string) *[]string {
+	t.Helper()
+	var dialed []string
+	orig := dialServer
+	dialServer = func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed = append(dialed, address)
+		for _, a := range reachable {
+			if a == address {
+				client, server := net.Pipe()
+				_ = server.Close()
+				return client, nil
+			}
+		}
+		for _, a := range hanging {
+			if a == address {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+		}
+		return nil, &net.OpError{Op: "dial", Net: network, Err: syscall.ECONNREFUSED}
+	}
+	t.Cleanup(func() { dialServer = orig })
+	return &dialed
+}
+
+func TestCreateVolume_PrecheckServer(t *testing.T) {
+	tests := []struct {
+		name       string
+		params     map[string]string
+		reachable  []string
+		hanging    []string
+		wantCode   codes.Code
+		wantDialed []string
+	}{
+		{
+			name:       "server reachable",
+			params:     map[string]string{"server": "192.168.1.100", "share": "/exports/data"},
+			reachable:  []string{"192.168.1.100:2049"},
+			wantCode:   codes.OK,
+			wantDialed: []string{"192.168.1.100:2049"},
+		},
+		{
+			name:       "server down",
+			params:     map[string]string{"server": "192.168.1.100", "share": "/exports/data"},
+			wantCode:   codes.Unavailable,
+			wantDialed: []string{"192.168.1.100:2049"},
+		},
+		{
+			name:       "server hangs until the timeout",
+			params:     map[string]string{"server": "192.168.1.100", "share": "/exports/data"},
+			hanging:    []string{"192.168.1.100:2049"},
+			wantCode:   codes.Unavailable,
+			wantDialed: []string{"192.168.1.100:2049"},
+		},
+		{
+			name:       "port parameter",
+			params:     map[string]string{"server": "nfs.example.com", "share": "/exports/data", "port": "20049"},
+			reachable:  []string{"nfs.example.com:20049"},
+			wantCode:   codes.OK,
+			wantDialed: []string{"nfs.example.com:20049"},
+		},
+		{
+			name:       "IPv6 server",
+			params:     map[string]string{"server": "[fd00::1]", "share": "/exports/data"},
+			reachable:  []string{"[fd00::1]:2049"},
+			wantCode:   codes.OK,
+			wantDialed: []string{"[fd00::1]:2049"},
+		},
+		{
+			name:       "failover server reachable",
+			params:     map[string]string{"servers": "nfs-a.example.com,nfs-b.example.com", "share": "/exports/data"},
+			reachable:  []string{"nfs-b.example.com:2049"},
+			wantCode:   codes.OK,
+			wantDialed: []string{"nfs-a.example.com:2049", "nfs-b.example.com:2049"},
+		},
+		{
+			name:     "invalid parameters are not dialed",
+			params:   map[string]string{"server": "192.168.1.100"},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialed := stubDialServer(t, tt.reachable, tt.hanging)
+			driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithPrecheckServer(100*time.Millisecond))
+			if err != nil {
+				t.Fatalf("Failed to create driver: %v", err)
+			}
+
+			req := createVolumeRequest("pvc-1", 0)
+			req.Parameters = tt.params
+			start := time.Now()
+			_, err = driver.CreateVolume(context.Background(), req)
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("Expected the precheck to stop at its timeout, took %v", elapsed)
+			}
+			if status.Code(err) != tt.wantCode {
+				t.Errorf("Expected %v, got %v", tt.wantCode, err)
+			}
+			if !reflect.DeepEqual(*dialed, tt.wantDialed) {
+				t.Errorf("Expected dials to %v, got %v", tt.wantDialed, *dialed)
+			}
+		})
+	}
+}
+
+func TestCreateVolume_PrecheckServerDisabled(t *testing.T) {
+	dialed := stubDialServer(t, nil, nil)
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock")
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+	if _, err := driver.CreateVolume(context.Background(), createVolumeRequest("pvc-1", 0)); err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+	if len(*dialed) != 0 {
+		t.Errorf("Expected no dial without the precheck, got %v", *dialed)
+	}
+}
+
+func TestCreateVolume_PrecheckServerCanceled(t *testing.T) {
+	stubDialServer(t, nil, []string{"192.168.1.100:2049"})
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithPrecheckServer(time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = driver.CreateVolume(ctx, createVolumeRequest("pvc-1", 0))
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("Expected DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestNewDriver_InvalidPrecheckTimeout(t *testing.T) {
+	_, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithPrecheckServer(0))
+	if err == nil {
+		t.Error("Expected a zero precheck timeout to be rejected")
+	}
+}
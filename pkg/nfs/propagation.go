@@ -0,0 +1,40 @@
+package nfs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// mountPropagationModes are the values accepted for mountPropagation. Each is
+// also the mount option that sets it.
+var mountPropagationModes = []string{"private", "rprivate", "slave", "rslave", "shared", "rshared"}
+
+// getMountPropagation returns the propagation the target mount is given, or
+// "" to leave it to kubelet
+func getMountPropagation(params map[string]string) (string, error) {
+	mode := params[ParamMountPropagation]
+	if mode == "" {
+		return "", nil
+	}
+	if !containsString(mountPropagationModes, mode) {
+		return "", fmt.Errorf("invalid %s %q: must be one of %s", ParamMountPropagation, mode, strings.Join(mountPropagationModes, ", "))
+	}
+	return mode, nil
+}
+
+// setMountPropagation changes the propagation of the mount at targetPath.
+// With a propagation flag as the only option and no source, mount(8) changes
+// the existing mount like mount --make-<mode> instead of mounting anything.
+// It is idempotent, so a retried publish finding the target mounted sets it
+// again.
+func (d *Driver) setMountPropagation(ctx context.Context, targetPath, mode string) error {
+	if err := d.mounter.Mount("", targetPath, "", []string{mode}); err != nil {
+		return status.Errorf(codes.Internal, "failed to make %s %s: %v", targetPath, mode, err)
+	}
+	d.logV(ctx, subsystemNode, 2).Infof("Set %s mount propagation on %s", mode, targetPath)
+	return nil
+}
@@ -0,0 +1,116 @@
+package nfs
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/mount-utils"
+)
+
+func TestGetMountPropagation(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "", want: ""},
+		{value: "rshared", want: "rshared"},
+		{value: "rslave", want: "rslave"},
+		{value: "shared", want: "shared"},
+		{value: "slave", want: "slave"},
+		{value: "private", want: "private"},
+		{value: "rprivate", want: "rprivate"},
+		{value: "Bidirectional", wantErr: true},
+		{value: "runbindable", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := getMountPropagation(map[string]string{ParamMountPropagation: tt.value})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getMountPropagation(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("getMountPropagation(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNodePublishVolume_MountPropagation(t *testing.T) {
+	for _, mode := range []string{"", "rshared", "rslave"} {
+		t.Run(mode, func(t *testing.T) {
+			fakeMounter := mount.NewFakeMounter([]mount.MountPoint{})
+			driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithMounter(fakeMounter))
+			if err != nil {
+				t.Fatalf("Failed to create driver: %v", err)
+			}
+
+			target := filepath.Join(t.TempDir(), "target")
+			req := &csi.NodePublishVolumeRequest{
+				VolumeId:   "test-volume",
+				TargetPath: target,
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{},
+					},
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+					},
+				},
+				VolumeContext: map[string]string{"server": "192.168.1.1", "share": "/data"},
+			}
+			if mode != "" {
+				req.VolumeContext[ParamMountPropagation] = mode
+			}
+			if _, err := driver.NodePublishVolume(context.Background(), req); err != nil {
+				t.Fatalf("NodePublishVolume failed: %v", err)
+			}
+
+			want := []mount.FakeAction{{Action: mount.FakeActionMount, Target: target, Source: "192.168.1.1:/data", FSType: "nfs"}}
+			if mode != "" {
+				// The propagation change has no source and no type
+				want = append(want, mount.FakeAction{Action: mount.FakeActionMount, Target: target})
+			}
+			if log := fakeMounter.GetLog(); !reflect.DeepEqual(log, want) {
+				t.Fatalf("Expected mounts %+v, got %+v", want, log)
+			}
+			if mode != "" && !reflect.DeepEqual(fakeMounter.MountPoints[1].Opts, []string{mode}) {
+				t.Errorf("Expected the propagation change to pass only %q, got %v", mode, fakeMounter.MountPoints[1].Opts)
+			}
+		})
+	}
+}
+
+func TestNodePublishVolume_InvalidMountPropagation(t *testing.T) {
+	fakeMounter := mount.NewFakeMounter([]mount.MountPoint{})
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithMounter(fakeMounter))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	_, err = driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:   "test-volume",
+		TargetPath: filepath.Join(t.TempDir(), "target"),
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+			},
+		},
+		VolumeContext: map[string]string{"server": "192.168.1.1", "share": "/data", ParamMountPropagation: "Bidirectional"},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument, got %v", err)
+	}
+	if log := fakeMounter.GetLog(); len(log) != 0 {
+		t.Errorf("Expected no mounts, got %+v", log)
+	}
+}
@@ -0,0 +1,134 @@
+package nfs
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// WithEphemeralProvisioning makes the controller remember the volumes it
+// creates in memory, so CreateVolume is idempotent per name, DeleteVolume
+// forgets them and ListVolumes reports them. The store is lost on restart,
+// so this is meant for conformance tests such as csi-sanity, not production.
+func WithEphemeralProvisioning(enabled bool) DriverOption {
+	return func(d *Driver) {
+		d.ephemeralProvisioning = enabled
+	}
+}
+
+// volumeStore holds the volumes created in ephemeral provisioning mode,
+// keyed by the name passed to CreateVolume
+type volumeStore struct {
+	mu      sync.Mutex
+	volumes map[string]*csi.Volume
+}
+
+// create stores volume under name, or returns the volume already stored
+// there. A stored volume with another capacity is an AlreadyExists error.
+func (s *volumeStore) create(name string, volume *csi.Volume) (*csi.Volume, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.volumes[name]; ok {
+		if existing.GetCapacityBytes() != volume.GetCapacityBytes() {
+			return nil, status.Errorf(codes.AlreadyExists, "volume %q already exists with capacity %d", name, existing.GetCapacityBytes())
+		}
+		return existing, nil
+	}
+	if s.volumes == nil {
+		s.volumes = make(map[string]*csi.Volume)
+	}
+	s.volumes[name] = volume
+	return volume, nil
+}
+
+// lookup returns the volume with volumeID
+func (s *volumeStore) lookup(volumeID string) (*csi.Volume, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, volume := range s.volumes {
+		if volume.GetVolumeId() == volumeID {
+			return volume, true
+		}
+	}
+	return nil, false
+}
+
+// resize sets the capacity of the volume with volumeID
+func (s *volumeStore) resize(volumeID string, capacity int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, volume := range s.volumes {
+		if volume.GetVolumeId() == volumeID {
+			volume.CapacityBytes = capacity
+			return true
+		}
+	}
+	return false
+}
+
+// delete forgets the volume with volumeID. Unknown IDs are ignored.
+func (s *volumeStore) delete(volumeID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, volume := range s.volumes {
+		if volume.GetVolumeId() == volumeID {
+			delete(s.volumes, name)
+		}
+	}
+}
+
+// list returns up to maxEntries volumes ordered by ID, starting at the
+// offset in startingToken, and the token for the next page ("" at the end)
+func (s *volumeStore) list(maxEntries int32, startingToken string) ([]*csi.Volume, string, error) {
+	s.mu.Lock()
+	volumes := make([]*csi.Volume, 0, len(s.volumes))
+	seen := make(map[string]bool, len(s.volumes))
+	for _, volume := range s.volumes {
+		// Names deduplicated by path share one volume
+		if !seen[volume.GetVolumeId()] {
+			seen[volume.GetVolumeId()] = true
+			volumes = append(volumes, volume)
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i].GetVolumeId() < volumes[j].GetVolumeId()
+	})
+
+	start, end, nextToken, err := page(len(volumes), maxEntries, startingToken)
+	if err != nil {
+		return nil, "", err
+	}
+	return volumes[start:end], nextToken, nil
+}
+
+// page returns the bounds of the page of up to maxEntries out of total
+// entries starting at the offset in startingToken, and the token for the
+// next page ("" at the end)
+func page(total int, maxEntries int32, startingToken string) (int, int, string, error) {
+	start := 0
+	if startingToken != "" {
+		var err error
+		start, err = strconv.Atoi(startingToken)
+		if err != nil || start < 0 || start > total {
+			return 0, 0, "", status.Errorf(codes.Aborted, "invalid starting token %q", startingToken)
+		}
+	}
+	if maxEntries < 0 {
+		return 0, 0, "", status.Error(codes.InvalidArgument, "max entries must not be negative")
+	}
+
+	end := total
+	if maxEntries > 0 && start+int(maxEntries) < end {
+		end = start + int(maxEntries)
+	}
+	nextToken := ""
+	if end < total {
+		nextToken = strconv.Itoa(end)
+	}
+	return start, end, nextToken, nil
+}
@@ -0,0 +1,161 @@
+package nfs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func createVolumeRequest(name string, requiredBytes int64) *csi.CreateVolumeRequest {
+	return &csi.CreateVolumeRequest{
+		Name:          name,
+		CapacityRange: &csi.CapacityRange{RequiredBytes: requiredBytes},
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{},
+				},
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+				},
+			},
+		},
+		Parameters: map[string]string{"server": "192.168.1.100", "share": "/exports/data"},
+	}
+}
+
+func TestEphemeralProvisioning_CreateVolume(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithEphemeralProvisioning(true))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+	ctx := context.Background()
+
+	first, err := driver.CreateVolume(ctx, createVolumeRequest("pvc-1", 1<<30))
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+	if first.Volume.CapacityBytes != 1<<30 {
+		t.Errorf("Expected capacity %d, got %d", 1<<30, first.Volume.CapacityBytes)
+	}
+
+	again, err := driver.CreateVolume(ctx, createVolumeRequest("pvc-1", 1<<30))
+	if err != nil {
+		t.Fatalf("Repeated CreateVolume failed: %v", err)
+	}
+	if again.Volume.VolumeId != first.Volume.VolumeId {
+		t.Errorf("Expected volume ID %q, got %q", first.Volume.VolumeId, again.Volume.VolumeId)
+	}
+
+	_, err = driver.CreateVolume(ctx, createVolumeRequest("pvc-1", 2<<30))
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("Expected AlreadyExists for another capacity, got %v", err)
+	}
+
+	if _, err := driver.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: first.Volume.VolumeId}); err != nil {
+		t.Fatalf("DeleteVolume failed: %v", err)
+	}
+	if _, err := driver.CreateVolume(ctx, createVolumeRequest("pvc-1", 2<<30)); err != nil {
+		t.Errorf("CreateVolume after delete failed: %v", err)
+	}
+}
+
+func TestEphemeralProvisioning_NotFound(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithEphemeralProvisioning(true))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+	ctx := context.Background()
+
+	_, err = driver.ValidateVolumeCapabilities(ctx, &csi.ValidateVolumeCapabilitiesRequest{
+		VolumeId:           "missing",
+		VolumeCapabilities: createVolumeRequest("missing", 0).VolumeCapabilities,
+	})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("ValidateVolumeCapabilities: expected NotFound, got %v", err)
+	}
+
+	_, err = driver.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+		VolumeId:      "missing",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 1 << 30},
+	})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("ControllerExpandVolume: expected NotFound, got %v", err)
+	}
+
+	if _, err := driver.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "missing"}); err != nil {
+		t.Errorf("DeleteVolume of an unknown volume should succeed, got %v", err)
+	}
+}
+
+func TestEphemeralProvisioning_ListVolumes(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithEphemeralProvisioning(true))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+	ctx := context.Background()
+
+	for _, name := range []string{"pvc-c", "pvc-a", "pvc-b"} {
+		if _, err := driver.CreateVolume(ctx, createVolumeRequest(name, 0)); err != nil {
+			t.Fatalf("CreateVolume(%s) failed: %v", name, err)
+		}
+	}
+
+	var ids []string
+	token := ""
+	for {
+		resp, err := driver.ListVolumes(ctx, &csi.ListVolumesRequest{MaxEntries: 2, StartingToken: token})
+		if err != nil {
+			t.Fatalf("ListVolumes failed: %v", err)
+		}
+		if len(resp.Entries) > 2 {
+			t.Fatalf("Expected at most 2 entries, got %d", len(resp.Entries))
+		}
+		for _, entry := range resp.Entries {
+			ids = append(ids, entry.Volume.VolumeId)
+		}
+		if token = resp.NextToken; token == "" {
+			break
+		}
+	}
+	want := []string{"pvc-a", "pvc-b", "pvc-c"}
+	if len(ids) != len(want) {
+		t.Fatalf("Expected volumes %v, got %v", want, ids)
+	}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Errorf("Expected volumes %v, got %v", want, ids)
+			break
+		}
+	}
+
+	_, err = driver.ListVolumes(ctx, &csi.ListVolumesRequest{StartingToken: "bogus"})
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("Expected Aborted for an invalid token, got %v", err)
+	}
+}
+
+func TestListVolumes_Disabled(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock")
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	_, err = driver.ListVolumes(context.Background(), &csi.ListVolumesRequest{})
+	if status.Code(err) != codes.Unimplemented {
+		t.Errorf("Expected Unimplemented, got %v", err)
+	}
+
+	resp, err := driver.ControllerGetCapabilities(context.Background(), &csi.ControllerGetCapabilitiesRequest{})
+	if err != nil {
+		t.Fatalf("ControllerGetCapabilities failed: %v", err)
+	}
+	for _, cap := range resp.Capabilities {
+		if cap.GetRpc().GetType() == csi.ControllerServiceCapability_RPC_LIST_VOLUMES {
+			t.Error("LIST_VOLUMES should not be advertised without ephemeral provisioning")
+		}
+	}
+}
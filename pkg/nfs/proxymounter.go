@@ -0,0 +1,137 @@
+package nfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	mount "k8s.io/mount-utils"
+)
+
+const (
+	// MounterDefault mounts with mount(8) in the driver's container
+	MounterDefault = "default"
+	// MounterProxy sends mounts and unmounts to a host-level mount proxy
+	MounterProxy = "proxy"
+
+	// proxyTimeout bounds a proxy request without a context deadline
+	proxyTimeout = 2 * time.Minute
+)
+
+// NewMounter returns the mounter selected with --mounter. The proxy mounter
+// needs the unix socket of the mount proxy.
+func NewMounter(kind, proxySocket string) (mount.Interface, error) {
+	switch kind {
+	case MounterDefault, "":
+		return newExecMounter(mount.New("")), nil
+	case MounterProxy:
+		if proxySocket == "" {
+			return nil, fmt.Errorf("the %s mounter needs a proxy socket", MounterProxy)
+		}
+		return newProxyMounter(proxySocket, mount.New("")), nil
+	default:
+		return nil, fmt.Errorf("invalid mounter %q: must be %s or %s", kind, MounterDefault, MounterProxy)
+	}
+}
+
+// proxyRequest is a mount or unmount sent to the mount proxy, one JSON
+// object per connection
+type proxyRequest struct {
+	Op               string   `json:"op"`
+	Source           string   `json:"source,omitempty"`
+	Target           string   `json:"target"`
+	FSType           string   `json:"fstype,omitempty"`
+	Options          []string `json:"options,omitempty"`
+	SensitiveOptions []string `json:"sensitiveOptions,omitempty"`
+	MountFlags       []string `json:"mountFlags,omitempty"`
+}
+
+// proxyResponse is the mount proxy's answer; an empty Error is success
+type proxyResponse struct {
+	Error string `json:"error,omitempty"`
+}
+
+// proxyMounter mounts and unmounts through a mount proxy listening on a unix
+// socket, for clusters where the driver's container may not mount itself.
+// The mount table is still read locally through the wrapped mounter, so the
+// proxy's mounts must propagate into the container.
+type proxyMounter struct {
+	mount.Interface
+
+	socket string
+}
+
+// newProxyMounter sends mounts to the proxy at socket and everything else to mounter
+func newProxyMounter(socket string, mounter mount.Interface) *proxyMounter {
+	return &proxyMounter{Interface: mounter, socket: socket}
+}
+
+// Mount mounts source at target through the proxy
+func (m *proxyMounter) Mount(source, target, fstype string, options []string) error {
+	return m.MountContext(context.Background(), source, target, fstype, options)
+}
+
+// MountContext mounts source at target through the proxy, giving up on the
+// proxy's answer when ctx ends
+func (m *proxyMounter) MountContext(ctx context.Context, source, target, fstype string, options []string) error {
+	return m.send(ctx, proxyRequest{Op: "mount", Source: source, Target: target, FSType: fstype, Options: options})
+}
+
+// MountSensitive mounts source at target through the proxy
+func (m *proxyMounter) MountSensitive(source, target, fstype string, options, sensitiveOptions []string) error {
+	return m.send(context.Background(), proxyRequest{Op: "mount", Source: source, Target: target, FSType: fstype, Options: options, SensitiveOptions: sensitiveOptions})
+}
+
+// MountSensitiveWithoutSystemd mounts source at target through the proxy
+func (m *proxyMounter) MountSensitiveWithoutSystemd(source, target, fstype string, options, sensitiveOptions []string) error {
+	return m.MountSensitive(source, target, fstype, options, sensitiveOptions)
+}
+
+// MountSensitiveWithoutSystemdWithMountFlags mounts source at target through the proxy
+func (m *proxyMounter) MountSensitiveWithoutSystemdWithMountFlags(source, target, fstype string, options, sensitiveOptions, mountFlags []string) error {
+	return m.send(context.Background(), proxyRequest{Op: "mount", Source: source, Target: target, FSType: fstype, Options: options, SensitiveOptions: sensitiveOptions, MountFlags: mountFlags})
+}
+
+// Unmount unmounts target through the proxy
+func (m *proxyMounter) Unmount(target string) error {
+	return m.send(context.Background(), proxyRequest{Op: "unmount", Target: target})
+}
+
+// send makes one request to the proxy. The proxy's error is returned as is,
+// so classifyMountError reads it like the output of mount(8).
+func (m *proxyMounter) send(ctx context.Context, req proxyRequest) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, proxyTimeout)
+		defer cancel()
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", m.socket)
+	if err != nil {
+		return fmt.Errorf("failed to reach mount proxy at %s: %w", m.socket, err)
+	}
+	defer conn.Close()
+	// Closing the connection unblocks the exchange below when ctx ends
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	var resp proxyResponse
+	err = json.NewEncoder(conn).Encode(req)
+	if err == nil {
+		err = json.NewDecoder(conn).Decode(&resp)
+	}
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return fmt.Errorf("%s of %s stopped: %w", req.Op, req.Target, ctxErr)
+		}
+		return fmt.Errorf("mount proxy request failed: %w", err)
+	}
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+	return nil
+}
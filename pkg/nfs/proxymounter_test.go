@@ -0,0 +1,145 @@
+package nfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mount "k8s.io/mount-utils"
+)
+
+// stubMountProxy serves mount proxy requests on a unix socket, recording
+// them and failing those for the given targets
+type stubMountProxy struct {
+	mu       sync.Mutex
+	requests []proxyRequest
+	failing  map[string]string
+	hang     bool
+}
+
+func startMountProxy(t *testing.T, proxy *stubMountProxy) string {
+	socket := filepath.Join(t.TempDir(), "proxy.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Failed to listen on %s: %v", socket, err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var req proxyRequest
+				if err := json.NewDecoder(conn).Decode(&req); err != nil {
+					return
+				}
+				proxy.mu.Lock()
+				proxy.requests = append(proxy.requests, req)
+				proxy.mu.Unlock()
+				if proxy.hang {
+					// Wait for the client to give up
+					_, _ = conn.Read(make([]byte, 1))
+					return
+				}
+				_ = json.NewEncoder(conn).Encode(proxyResponse{Error: proxy.failing[req.Target]})
+			}()
+		}
+	}()
+	return socket
+}
+
+func TestNewMounter(t *testing.T) {
+	tests := []struct {
+		name    string
+		kind    string
+		socket  string
+		want    interface{}
+		wantErr string
+	}{
+		{name: "default", kind: MounterDefault, want: &execMounter{}},
+		{name: "empty is the default", want: &execMounter{}},
+		{name: "proxy", kind: MounterProxy, socket: "/run/mount-proxy.sock", want: &proxyMounter{}},
+		{name: "proxy without a socket", kind: MounterProxy, wantErr: "needs a proxy socket"},
+		{name: "unknown", kind: "fuse", wantErr: `invalid mounter "fuse"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewMounter(tt.kind, tt.socket)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewMounter failed: %v", err)
+			}
+			if reflect.TypeOf(got) != reflect.TypeOf(tt.want) {
+				t.Errorf("Expected a %T, got %T", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestProxyMounter(t *testing.T) {
+	proxy := &stubMountProxy{failing: map[string]string{"/mnt/denied": "mount.nfs: access denied by server while mounting 192.168.1.1:/data"}}
+	m := newProxyMounter(startMountProxy(t, proxy), mount.NewFakeMounter([]mount.MountPoint{}))
+
+	if err := m.Mount("192.168.1.1:/data", "/mnt/a", "nfs", []string{"vers=4.1"}); err != nil {
+		t.Fatalf("Mount failed: %v", err)
+	}
+	if err := m.MountSensitive("192.168.1.1:/data", "/mnt/b", "nfs", nil, []string{"sec=krb5"}); err != nil {
+		t.Fatalf("MountSensitive failed: %v", err)
+	}
+	if err := m.Unmount("/mnt/a"); err != nil {
+		t.Fatalf("Unmount failed: %v", err)
+	}
+	err := m.Mount("192.168.1.1:/data", "/mnt/denied", "nfs", nil)
+	if err == nil || !strings.Contains(err.Error(), "access denied by server") {
+		t.Fatalf("Expected the proxy's error, got %v", err)
+	}
+
+	want := []proxyRequest{
+		{Op: "mount", Source: "192.168.1.1:/data", Target: "/mnt/a", FSType: "nfs", Options: []string{"vers=4.1"}},
+		{Op: "mount", Source: "192.168.1.1:/data", Target: "/mnt/b", FSType: "nfs", SensitiveOptions: []string{"sec=krb5"}},
+		{Op: "unmount", Target: "/mnt/a"},
+		{Op: "mount", Source: "192.168.1.1:/data", Target: "/mnt/denied", FSType: "nfs"},
+	}
+	proxy.mu.Lock()
+	defer proxy.mu.Unlock()
+	if !reflect.DeepEqual(proxy.requests, want) {
+		t.Errorf("Expected requests %+v, got %+v", want, proxy.requests)
+	}
+}
+
+func TestProxyMounter_Errors(t *testing.T) {
+	t.Run("proxy not running", func(t *testing.T) {
+		m := newProxyMounter(filepath.Join(t.TempDir(), "missing.sock"), mount.NewFakeMounter([]mount.MountPoint{}))
+		err := m.Mount("192.168.1.1:/data", "/mnt/a", "nfs", nil)
+		if err == nil || !strings.Contains(err.Error(), "failed to reach mount proxy") {
+			t.Errorf("Expected a connection error, got %v", err)
+		}
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		m := newProxyMounter(startMountProxy(t, &stubMountProxy{hang: true}), mount.NewFakeMounter([]mount.MountPoint{}))
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err := m.MountContext(ctx, "192.168.1.1:/data", "/mnt/a", "nfs", nil)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected DeadlineExceeded, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,151 @@
+package nfs
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const (
+	// redactedValue replaces sensitive values in logged requests
+	redactedValue = "***redacted***"
+
+	// pvcAnnotationsKey carries JSON-encoded PVC annotations (external-provisioner --extra-create-metadata)
+	pvcAnnotationsKey = "csi.storage.k8s.io/pvc/annotations"
+)
+
+// sensitiveAnnotationPattern matches PVC annotation keys whose values may hold credentials
+var sensitiveAnnotationPattern = regexp.MustCompile(`(?i)(token|secret|password|passwd|credential|api[-_]?key)`)
+
+// redactForLog returns a copy of a gRPC message that is safe to log: fields
+// marked csi_secret in the CSI spec are blanked, and PVC annotation values
+// whose keys look sensitive are replaced. Non-proto values are returned as is.
+func redactForLog(msg interface{}) interface{} {
+	m, ok := msg.(proto.Message)
+	if !ok || m == nil {
+		return msg
+	}
+
+	clone := proto.Clone(m)
+	redactMessage(clone.ProtoReflect())
+	return clone
+}
+
+// redactMessage walks a message in place, redacting secret fields and annotations
+func redactMessage(m protoreflect.Message) {
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case isSecretField(fd):
+			redactSecretField(m, fd, v)
+		case fd.IsMap():
+			if fd.MapValue().Kind() == protoreflect.StringKind {
+				redactAnnotations(v.Map())
+			} else if fd.MapValue().Kind() == protoreflect.MessageKind {
+				v.Map().Range(func(_ protoreflect.MapKey, mv protoreflect.Value) bool {
+					redactMessage(mv.Message())
+					return true
+				})
+			}
+		case fd.IsList() && fd.Kind() == protoreflect.MessageKind:
+			list := v.List()
+			for i := 0; i < list.Len(); i++ {
+				redactMessage(list.Get(i).Message())
+			}
+		case fd.Kind() == protoreflect.MessageKind:
+			redactMessage(v.Message())
+		}
+		return true
+	})
+}
+
+// isSecretField reports whether the CSI spec marks the field as carrying secrets
+func isSecretField(fd protoreflect.FieldDescriptor) bool {
+	opts := fd.Options()
+	if opts == nil {
+		return false
+	}
+	secret, ok := proto.GetExtension(opts, csi.E_CsiSecret).(bool)
+	return ok && secret
+}
+
+// redactSecretField blanks the values of a secret field while keeping map keys,
+// which are useful for debugging and not sensitive
+func redactSecretField(m protoreflect.Message, fd protoreflect.FieldDescriptor, v protoreflect.Value) {
+	if fd.IsMap() && fd.MapValue().Kind() == protoreflect.StringKind {
+		secrets := v.Map()
+		secrets.Range(func(k protoreflect.MapKey, _ protoreflect.Value) bool {
+			secrets.Set(k, protoreflect.ValueOfString(redactedValue))
+			return true
+		})
+		return
+	}
+	if fd.Kind() == protoreflect.StringKind && !fd.IsList() {
+		m.Set(fd, protoreflect.ValueOfString(redactedValue))
+		return
+	}
+	m.Clear(fd)
+}
+
+// redactAnnotations replaces sensitive values inside the JSON-encoded PVC annotations entry of a string map
+func redactAnnotations(values protoreflect.Map) {
+	key := protoreflect.ValueOfString(pvcAnnotationsKey).MapKey()
+	if values.Has(key) {
+		values.Set(key, protoreflect.ValueOfString(redactAnnotationsJSON(values.Get(key).String())))
+	}
+}
+
+// redactParameters returns a copy of a parameters or volume context map that is safe to log
+func redactParameters(params map[string]string) map[string]string {
+	redacted := make(map[string]string, len(params))
+	for k, v := range params {
+		redacted[k] = v
+	}
+	if annotations, ok := redacted[pvcAnnotationsKey]; ok {
+		redacted[pvcAnnotationsKey] = redactAnnotationsJSON(annotations)
+	}
+	return redacted
+}
+
+// redactMountOptions returns a copy of mount options with the values of
+// sensitive-looking options replaced
+func redactMountOptions(options []string) []string {
+	redacted := make([]string, len(options))
+	for i, option := range options {
+		name, _, hasValue := strings.Cut(option, "=")
+		if hasValue && sensitiveAnnotationPattern.MatchString(name) {
+			option = name + "=" + redactedValue
+		}
+		redacted[i] = option
+	}
+	return redacted
+}
+
+// redactAnnotationsJSON replaces the values of sensitive-looking keys in JSON-encoded annotations
+func redactAnnotationsJSON(encoded string) string {
+	var annotations map[string]string
+	if err := json.Unmarshal([]byte(encoded), &annotations); err != nil {
+		// Can't tell what is inside, so don't log any of it
+		return redactedValue
+	}
+
+	changed := false
+	for k := range annotations {
+		if sensitiveAnnotationPattern.MatchString(k) {
+			annotations[k] = redactedValue
+			changed = true
+		}
+	}
+	if !changed {
+		return encoded
+	}
+
+	out, err := json.Marshal(annotations)
+	if err != nil {
+		return redactedValue
+	}
+	return string(out)
+}
@@ -0,0 +1,110 @@
+package nfs
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+func TestRedactForLog(t *testing.T) {
+	const secretValue = "s3cr3t-value"
+	const tokenValue = "tok-12345"
+
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:   "test-volume",
+		TargetPath: "/var/lib/kubelet/pods/uid/volumes/x/mount",
+		Secrets: map[string]string{
+			"password": secretValue,
+		},
+		VolumeContext: map[string]string{
+			"server":          "192.168.1.1",
+			"share":           "/data",
+			pvcAnnotationsKey: `{"nfs.csi.takutakahashi.dev/subPath":"app1","example.com/api-token":"` + tokenValue + `"}`,
+		},
+	}
+
+	out := fmt.Sprintf("%+v", redactForLog(req))
+
+	for _, sensitive := range []string{secretValue, tokenValue} {
+		if strings.Contains(out, sensitive) {
+			t.Errorf("Formatted request leaks %q: %s", sensitive, out)
+		}
+	}
+	for _, kept := range []string{"test-volume", "192.168.1.1", "password", "app1"} {
+		if !strings.Contains(out, kept) {
+			t.Errorf("Formatted request is missing %q: %s", kept, out)
+		}
+	}
+
+	// The original request must be left untouched
+	if req.Secrets["password"] != secretValue {
+		t.Error("redactForLog modified the original request")
+	}
+}
+
+func TestRedactForLog_NestedSecrets(t *testing.T) {
+	const secretValue = "nested-secret"
+
+	req := &csi.CreateVolumeRequest{
+		Name: "test-volume",
+		Secrets: map[string]string{
+			"key": secretValue,
+		},
+	}
+
+	if out := fmt.Sprintf("%+v", redactForLog(req)); strings.Contains(out, secretValue) {
+		t.Errorf("Formatted request leaks secret: %s", out)
+	}
+}
+
+func TestRedactParameters(t *testing.T) {
+	params := map[string]string{
+		"server":          "192.168.1.1",
+		pvcAnnotationsKey: `{"vault.example.com/secret":"hunter2"}`,
+	}
+
+	redacted := redactParameters(params)
+	if strings.Contains(redacted[pvcAnnotationsKey], "hunter2") {
+		t.Errorf("Expected annotation value to be redacted, got %s", redacted[pvcAnnotationsKey])
+	}
+	if redacted["server"] != "192.168.1.1" {
+		t.Errorf("Expected server to be kept, got %s", redacted["server"])
+	}
+	if !strings.Contains(params[pvcAnnotationsKey], "hunter2") {
+		t.Error("redactParameters modified the original map")
+	}
+}
+
+func TestRedactAnnotationsJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "nothing sensitive",
+			input: `{"nfs.csi.takutakahashi.dev/subPath":"app1"}`,
+			want:  `{"nfs.csi.takutakahashi.dev/subPath":"app1"}`,
+		},
+		{
+			name:  "password key",
+			input: `{"db-password":"hunter2"}`,
+			want:  `{"db-password":"***redacted***"}`,
+		},
+		{
+			name:  "invalid JSON",
+			input: `{not json`,
+			want:  redactedValue,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redactAnnotationsJSON(tt.input); got != tt.want {
+				t.Errorf("redactAnnotationsJSON() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package nfs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// publishedVolume records how a target was mounted so it can be mounted again
+type publishedVolume struct {
+	volumeID string
+	sources  []string
+	fsType   string
+	options  []string
+}
+
+// publishedTargets tracks the NFS mounts made directly at pod target paths
+type publishedTargets struct {
+	mu      sync.Mutex
+	targets map[string]publishedVolume
+}
+
+// remountTimeout bounds a remount by the background scan
+const remountTimeout = 2 * time.Minute
+
+// statTarget is replaced in tests to simulate stale mounts
+var statTarget = os.Stat
+
+// WithRemountInterval enables a background scan for stale published mounts,
+// which are unmounted and mounted again. Zero disables the scan.
+func WithRemountInterval(interval time.Duration) DriverOption {
+	return func(d *Driver) {
+		d.remountInterval = interval
+	}
+}
+
+// WithRemountStaleOnPublish controls whether NodePublishVolume mounts a
+// target again when the mount already there is stale (ESTALE), e.g. after an
+// ungraceful node reboot. It is enabled by default.
+func WithRemountStaleOnPublish(enabled bool) DriverOption {
+	return func(d *Driver) {
+		d.remountStaleOnPublish = enabled
+	}
+}
+
+// add records a published target
+func (p *publishedTargets) add(target string, vol publishedVolume) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.targets == nil {
+		p.targets = make(map[string]publishedVolume)
+	}
+	p.targets[target] = vol
+}
+
+// get returns how target was published, if it still is
+func (p *publishedTargets) get(target string) (publishedVolume, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	vol, ok := p.targets[target]
+	return vol, ok
+}
+
+// remove forgets a published target. A remount of the target in progress
+// notices and unmounts what it mounted.
+func (p *publishedTargets) remove(target string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.targets, target)
+}
+
+// snapshot returns the currently published target paths
+func (p *publishedTargets) snapshot() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	targets := make([]string, 0, len(p.targets))
+	for target := range p.targets {
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+// runRemountLoop scans published targets every remountInterval until stop is closed
+func (d *Driver) runRemountLoop(stop <-chan struct{}) {
+	klog.Infof("Checking published mounts for stale file handles every %v", d.remountInterval)
+
+	ticker := time.NewTicker(d.remountInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			d.remountStaleTargets()
+		}
+	}
+}
+
+// remountStaleTargets unmounts and mounts again every published target whose
+// stat fails with ESTALE, which happens after an NFS server failover. Targets
+// whose mount has gone missing, e.g. after a failed remount, are mounted again.
+func (d *Driver) remountStaleTargets() {
+	for _, target := range d.published.snapshot() {
+		// Stat outside the lock so a hung server cannot block publish/unpublish
+		_, err := statTarget(target)
+		switch {
+		case errors.Is(err, syscall.ESTALE):
+			d.remountTarget(target, true)
+		case err == nil:
+			if notMnt, err := d.mounter.IsLikelyNotMountPoint(target); err == nil && notMnt {
+				d.remountTarget(target, false)
+			}
+		}
+	}
+}
+
+// remountTarget mounts the volume at target again, unmounting the stale mount
+// first, unless kubelet has unpublished the target in the meantime. The mount
+// runs outside d.published.mu and for at most remountTimeout, so a dead server
+// cannot block NodePublishVolume and NodeUnpublishVolume.
+func (d *Driver) remountTarget(target string, stale bool) {
+	vol, ok := d.published.get(target)
+	if !ok {
+		return
+	}
+
+	if stale {
+		klog.Warningf("Stale file handle on %s (volume %s), remounting %s", target, vol.volumeID, strings.Join(vol.sources, ","))
+		if err := d.mounter.Unmount(target); err != nil {
+			klog.Errorf("Failed to unmount stale %s: %v", target, err)
+			return
+		}
+	} else {
+		klog.Warningf("Mount of %s at %s (volume %s) is missing, mounting it again", strings.Join(vol.sources, ","), target, vol.volumeID)
+	}
+
+	// Servers are tried in order again, so a failed-over volume moves back to
+	// the first server once it is reachable
+	ctx, cancel := context.WithTimeout(context.Background(), remountTimeout)
+	defer cancel()
+	source, err := d.mountSources(ctx, vol.sources, target, vol.fsType, vol.options)
+	if err != nil {
+		klog.Errorf("Failed to remount %s at %s: %v", strings.Join(vol.sources, ","), target, err)
+		return
+	}
+
+	// kubelet may have unpublished the target while it was being mounted,
+	// and must not find the new mount left behind
+	if _, ok := d.published.get(target); !ok {
+		klog.Infof("Target %s was unpublished during the remount, unmounting it", target)
+		if err := d.mounter.Unmount(target); err != nil {
+			klog.Errorf("Failed to unmount %s after it was unpublished: %v", target, err)
+		}
+		return
+	}
+
+	klog.Infof("Remounted %s at %s", source, target)
+}
+
+// unmountStaleTarget unmounts targetPath if stat fails on it with ESTALE, so
+// NodePublishVolume mounts it again instead of reporting the dead mount as
+// published. A healthy or missing target is left alone.
+func (d *Driver) unmountStaleTarget(ctx context.Context, targetPath string) error {
+	if _, err := statTarget(targetPath); !errors.Is(err, syscall.ESTALE) {
+		return nil
+	}
+
+	klog.Warningf("Stale file handle on %s, unmounting it to mount the volume again", targetPath)
+	// Stop the remount scan from racing with the mount below
+	d.published.remove(targetPath)
+	if err := d.unmountNested(ctx, targetPath); err != nil {
+		return status.Errorf(codes.Internal, "failed to unmount below stale %s: %v", targetPath, err)
+	}
+	if err := d.mounter.Unmount(targetPath); err != nil {
+		return status.Errorf(codes.Internal, "failed to unmount stale %s: %v", targetPath, err)
+	}
+	d.staged.remove(targetPath)
+	return nil
+}
@@ -0,0 +1,199 @@
+package nfs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"k8s.io/mount-utils"
+)
+
+func publishForRemount(t *testing.T, driver *Driver, target string) {
+	_, err := driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:   "test-volume",
+		TargetPath: target,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+			},
+		},
+		VolumeContext: map[string]string{
+			"server": "192.168.1.1",
+			"share":  "/data",
+		},
+	})
+	if err != nil {
+		t.Fatalf("NodePublishVolume failed: %v", err)
+	}
+}
+
+// staleStat makes stat fail with ESTALE for the given paths
+func staleStat(t *testing.T, stale ...string) {
+	orig := statTarget
+	t.Cleanup(func() { statTarget = orig })
+
+	statTarget = func(path string) (os.FileInfo, error) {
+		for _, s := range stale {
+			if path == s {
+				return nil, &os.PathError{Op: "stat", Path: path, Err: syscall.ESTALE}
+			}
+		}
+		return orig(path)
+	}
+}
+
+func countActions(fakeMounter *mount.FakeMounter, action, target string) int {
+	n := 0
+	for _, a := range fakeMounter.GetLog() {
+		if a.Action == action && a.Target == target {
+			n++
+		}
+	}
+	return n
+}
+
+func TestRemountStaleTargets(t *testing.T) {
+	fakeMounter := mount.NewFakeMounter([]mount.MountPoint{})
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithMounter(fakeMounter))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	dir := t.TempDir()
+	staleTarget := filepath.Join(dir, "stale")
+	healthyTarget := filepath.Join(dir, "healthy")
+	publishForRemount(t, driver, staleTarget)
+	publishForRemount(t, driver, healthyTarget)
+	staleStat(t, staleTarget)
+
+	driver.remountStaleTargets()
+
+	if n := countActions(fakeMounter, mount.FakeActionUnmount, staleTarget); n != 1 {
+		t.Errorf("Expected stale target unmounted once, got %d", n)
+	}
+	if n := countActions(fakeMounter, mount.FakeActionMount, staleTarget); n != 2 {
+		t.Errorf("Expected stale target mounted again, got %d mounts", n)
+	}
+	if n := countActions(fakeMounter, mount.FakeActionMount, healthyTarget); n != 1 {
+		t.Errorf("Expected healthy target left alone, got %d mounts", n)
+	}
+	for _, mp := range fakeMounter.MountPoints {
+		if mp.Path == staleTarget && mp.Device != "192.168.1.1:/data" {
+			t.Errorf("Expected remount of 192.168.1.1:/data, got %+v", mp)
+		}
+	}
+}
+
+func TestRemountStaleTargets_SkipsUnpublished(t *testing.T) {
+	fakeMounter := mount.NewFakeMounter([]mount.MountPoint{})
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithMounter(fakeMounter))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	target := filepath.Join(t.TempDir(), "target")
+	publishForRemount(t, driver, target)
+	if _, err := driver.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{VolumeId: "test-volume", TargetPath: target}); err != nil {
+		t.Fatalf("NodeUnpublishVolume failed: %v", err)
+	}
+	staleStat(t, target)
+
+	driver.remountStaleTargets()
+
+	if n := countActions(fakeMounter, mount.FakeActionMount, target); n != 1 {
+		t.Errorf("Expected no remount after unpublish, got %d mounts", n)
+	}
+}
+
+// hookMounter runs onMount before each mount, while it is in progress
+type hookMounter struct {
+	*mount.FakeMounter
+	onMount func(target string)
+}
+
+func (m *hookMounter) Mount(source, target, fstype string, options []string) error {
+	if m.onMount != nil {
+		m.onMount(target)
+	}
+	return m.FakeMounter.Mount(source, target, fstype, options)
+}
+
+func TestRemountStaleTargets_UnpublishedDuringMount(t *testing.T) {
+	fakeMounter := &hookMounter{FakeMounter: mount.NewFakeMounter([]mount.MountPoint{})}
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithMounter(fakeMounter))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	target := filepath.Join(t.TempDir(), "target")
+	publishForRemount(t, driver, target)
+	staleStat(t, target)
+
+	// Unpublishing takes the published lock, so this would deadlock if the
+	// remount held it across the mount
+	fakeMounter.onMount = func(target string) {
+		driver.published.remove(target)
+	}
+	driver.remountStaleTargets()
+
+	if n := countActions(fakeMounter.FakeMounter, mount.FakeActionMount, target); n != 2 {
+		t.Errorf("Expected the target mounted again, got %d mounts", n)
+	}
+	if n := countActions(fakeMounter.FakeMounter, mount.FakeActionUnmount, target); n != 2 {
+		t.Errorf("Expected the stale mount and the new one unmounted, got %d unmounts", n)
+	}
+	if len(fakeMounter.MountPoints) != 0 {
+		t.Errorf("Expected no mount left behind, got %+v", fakeMounter.MountPoints)
+	}
+}
+
+func TestNodePublishVolume_StaleTarget(t *testing.T) {
+	tests := []struct {
+		name        string
+		enabled     bool
+		wantActions []string
+	}{
+		{name: "remounts a stale target", enabled: true, wantActions: []string{mount.FakeActionUnmount, mount.FakeActionMount}},
+		{name: "disabled keeps the stale mount", enabled: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := filepath.Join(t.TempDir(), "target")
+			if err := os.MkdirAll(target, 0750); err != nil {
+				t.Fatal(err)
+			}
+			// Left behind by a node that went down without unpublishing
+			fakeMounter := mount.NewFakeMounter([]mount.MountPoint{{Device: "192.168.1.1:/data", Path: target, Type: "nfs"}})
+			driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock",
+				WithMounter(fakeMounter), WithRemountStaleOnPublish(tt.enabled))
+			if err != nil {
+				t.Fatalf("Failed to create driver: %v", err)
+			}
+			staleStat(t, target)
+
+			publishForRemount(t, driver, target)
+
+			var actions []string
+			for _, action := range fakeMounter.GetLog() {
+				if action.Target != target {
+					t.Errorf("Unexpected %s of %s", action.Action, action.Target)
+				}
+				actions = append(actions, action.Action)
+			}
+			if !reflect.DeepEqual(actions, tt.wantActions) {
+				t.Errorf("Expected %v, got %v", tt.wantActions, actions)
+			}
+			if mps, _ := fakeMounter.List(); len(mps) != 1 {
+				t.Errorf("Expected the target mounted once, got %+v", mps)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package nfs
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// sharesTmpfsOptions are the options of the tmpfs holding the directories of
+// a volume with several shares; it only ever holds those directories
+var sharesTmpfsOptions = []string{"mode=0755", "size=64k"}
+
+// getShares returns the exports of a volume with several shares, from the
+// comma-separated shares parameter, or nil for a volume with a single share.
+// Each export is normalized like share, and repeats are dropped.
+func getShares(params map[string]string) ([]string, error) {
+	value := params[ParamShares]
+	if value == "" {
+		return nil, nil
+	}
+	if params[ParamShare] != "" {
+		return nil, fmt.Errorf("%s and %s cannot both be set", ParamShare, ParamShares)
+	}
+	for _, key := range []string{ParamSubPath, ParamSubPathPrefix, ParamWritableSubPath} {
+		if params[key] != "" {
+			return nil, fmt.Errorf("%s cannot be combined with %s", ParamShares, key)
+		}
+	}
+
+	var shares []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		share, err := getShare(map[string]string{ParamShare: entry, ParamRawShare: params[ParamRawShare]})
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", ParamShares, err)
+		}
+		if !containsString(shares, share) {
+			shares = append(shares, share)
+		}
+	}
+	if len(shares) == 0 {
+		return nil, fmt.Errorf("%s names no export", ParamShares)
+	}
+	return shares, nil
+}
+
+// shareDirName returns the directory of the i-th share below the target
+func shareDirName(i int) string {
+	return fmt.Sprintf("share%d", i)
+}
+
+// mountShares publishes a volume with several shares. A small tmpfs at the
+// target holds a directory per share, share0, share1 and so on in the order
+// of the shares parameter, and each share is mounted on its directory. The
+// tmpfs makes the target a mount point, so an already published target is
+// recognized as usual, and NodeUnpublishVolume unmounts the shares below it
+// before the tmpfs. If any share fails to mount, everything mounted so far
+// is unmounted again.
+func (d *Driver) mountShares(ctx context.Context, servers, shares []string, targetPath, fsType string, options []string, readOnly bool) error {
+	if err := d.mounter.Mount("tmpfs", targetPath, "tmpfs", sharesTmpfsOptions); err != nil {
+		return status.Errorf(codes.Internal, "failed to mount tmpfs at %s: %v", targetPath, err)
+	}
+
+	if err := d.mountSharesInto(ctx, servers, shares, targetPath, fsType, options, readOnly); err != nil {
+		if cleanupErr := d.unmountNested(ctx, targetPath); cleanupErr != nil {
+			klog.Warningf("Failed to unmount the shares below %s after a failed mount: %v", targetPath, cleanupErr)
+		} else if cleanupErr := d.mounter.Unmount(targetPath); cleanupErr != nil {
+			klog.Warningf("Failed to unmount tmpfs at %s after a failed mount: %v", targetPath, cleanupErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// mountSharesInto creates the share directories in the tmpfs at targetPath
+// and mounts each share on its directory
+func (d *Driver) mountSharesInto(ctx context.Context, servers, shares []string, targetPath, fsType string, options []string, readOnly bool) error {
+	for i := range shares {
+		dir := filepath.Join(targetPath, shareDirName(i))
+		if err := d.filesystem.MkdirAll(dir, 0755); err != nil {
+			return status.Errorf(codes.Internal, "failed to create %s: %v", dir, err)
+		}
+	}
+	// Pods of a read-only volume may not write next to the shares either
+	if readOnly {
+		if err := d.mounter.Mount("tmpfs", targetPath, "tmpfs", []string{"remount", "ro"}); err != nil {
+			return status.Errorf(codes.Internal, "failed to make tmpfs at %s read-only: %v", targetPath, err)
+		}
+	}
+
+	for i, share := range shares {
+		if err := d.waitForMount(ctx); err != nil {
+			return err
+		}
+		dir := filepath.Join(targetPath, shareDirName(i))
+		sources := nfsSources(servers, share)
+		mounted, err := d.mountSources(ctx, sources, dir, fsType, options)
+		if err != nil {
+			return mountFailedError(err, strings.Join(sources, ","), dir)
+		}
+		d.logV(ctx, subsystemNode, 2).Infof("Successfully mounted NFS %s at %s", mounted, dir)
+	}
+	return nil
+}
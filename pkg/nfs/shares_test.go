@@ -0,0 +1,202 @@
+package nfs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"k8s.io/mount-utils"
+)
+
+func TestGetShares(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  map[string]string
+		want    []string
+		wantErr string
+	}{
+		{name: "unset", params: map[string]string{"share": "/data"}},
+		{name: "list", params: map[string]string{"shares": "/a,/b"}, want: []string{"/a", "/b"}},
+		{name: "trims and drops repeats", params: map[string]string{"shares": " /a , ,/b,/a "}, want: []string{"/a", "/b"}},
+		{name: "raw shares", params: map[string]string{"shares": "vol0,vol1", "rawShare": "true"}, want: []string{"vol0", "vol1"}},
+		{name: "with share", params: map[string]string{"shares": "/a", "share": "/b"}, wantErr: "share and shares cannot both be set"},
+		{name: "with subPath", params: map[string]string{"shares": "/a", "subPath": "x"}, wantErr: "shares cannot be combined with subPath"},
+		{name: "with writableSubPath", params: map[string]string{"shares": "/a", "writableSubPath": "x"}, wantErr: "shares cannot be combined with writableSubPath"},
+		{name: "no export", params: map[string]string{"shares": " , "}, wantErr: "shares names no export"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getShares(tt.params)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getShares failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+// tmpfsMounter is a fake mounter that empties a directory when a tmpfs is
+// unmounted from it, as the share directories vanish with the real tmpfs
+type tmpfsMounter struct {
+	*mount.FakeMounter
+}
+
+func (m *tmpfsMounter) Unmount(target string) error {
+	tmpfs := false
+	for _, mp := range m.FakeMounter.MountPoints {
+		if mp.Path == target && mp.Type == "tmpfs" {
+			tmpfs = true
+		}
+	}
+	if err := m.FakeMounter.Unmount(target); err != nil {
+		return err
+	}
+	if tmpfs {
+		entries, err := os.ReadDir(target)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := os.RemoveAll(filepath.Join(target, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func sharesPublishRequest(target string) *csi.NodePublishVolumeRequest {
+	return &csi.NodePublishVolumeRequest{
+		VolumeId:   "test-volume",
+		TargetPath: target,
+		Readonly:   true,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+			},
+		},
+		VolumeContext: map[string]string{
+			"server": "192.168.1.1",
+			"shares": "/exports/a,/exports/b",
+		},
+	}
+}
+
+func TestNodePublishVolume_Shares(t *testing.T) {
+	fakeMounter := &tmpfsMounter{FakeMounter: mount.NewFakeMounter([]mount.MountPoint{})}
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithMounter(fakeMounter))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	target := filepath.Join(t.TempDir(), "target")
+	for i := 0; i < 2; i++ {
+		if _, err := driver.NodePublishVolume(context.Background(), sharesPublishRequest(target)); err != nil {
+			t.Fatalf("NodePublishVolume failed: %v", err)
+		}
+	}
+
+	want := []mount.FakeAction{
+		{Action: mount.FakeActionMount, Target: target, Source: "tmpfs", FSType: "tmpfs"},
+		{Action: mount.FakeActionMount, Target: target, Source: "tmpfs", FSType: "tmpfs"},
+		{Action: mount.FakeActionMount, Target: filepath.Join(target, "share0"), Source: "192.168.1.1:/exports/a", FSType: "nfs"},
+		{Action: mount.FakeActionMount, Target: filepath.Join(target, "share1"), Source: "192.168.1.1:/exports/b", FSType: "nfs"},
+	}
+	if log := fakeMounter.GetLog(); !reflect.DeepEqual(log, want) {
+		t.Fatalf("Expected mounts %+v, got %+v", want, log)
+	}
+	for _, mp := range fakeMounter.MountPoints {
+		if mp.Type == "tmpfs" && mp.Opts[0] == "remount" && !reflect.DeepEqual(mp.Opts, []string{"remount", "ro"}) {
+			t.Errorf("Expected the tmpfs remounted read-only, got %v", mp.Opts)
+		}
+		if mp.Type == "nfs" && !containsString(mp.Opts, "ro") {
+			t.Errorf("Expected %s mounted read-only, got %v", mp.Path, mp.Opts)
+		}
+	}
+
+	if _, err := driver.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+		VolumeId:   "test-volume",
+		TargetPath: target,
+	}); err != nil {
+		t.Fatalf("NodeUnpublishVolume failed: %v", err)
+	}
+
+	log := fakeMounter.GetLog()[len(want):]
+	if len(log) != 3 {
+		t.Fatalf("Expected three unmounts, got %+v", log)
+	}
+	unmounted := map[string]bool{}
+	for _, action := range log[:2] {
+		unmounted[action.Target] = action.Action == mount.FakeActionUnmount
+	}
+	if !unmounted[filepath.Join(target, "share0")] || !unmounted[filepath.Join(target, "share1")] {
+		t.Errorf("Expected the shares unmounted first, got %+v", log)
+	}
+	if log[2].Action != mount.FakeActionUnmount || log[2].Target != target {
+		t.Errorf("Expected the tmpfs unmounted last, got %+v", log[2])
+	}
+	if len(fakeMounter.MountPoints) != 0 {
+		t.Errorf("Expected no mounts left, got %+v", fakeMounter.MountPoints)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Errorf("Expected the target removed, got %v", err)
+	}
+}
+
+func TestNodePublishVolume_SharesMountFailure(t *testing.T) {
+	fakeMounter := &failingMounter{
+		FakeMounter: mount.NewFakeMounter([]mount.MountPoint{}),
+		failing:     map[string]bool{"192.168.1.1:/exports/b": true},
+	}
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithMounter(fakeMounter))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	target := filepath.Join(t.TempDir(), "target")
+	_, err = driver.NodePublishVolume(context.Background(), sharesPublishRequest(target))
+	if err == nil || !strings.Contains(err.Error(), "mount of 192.168.1.1:/exports/b failed") {
+		t.Fatalf("Expected the second share to fail, got %v", err)
+	}
+	if len(fakeMounter.MountPoints) != 0 {
+		t.Errorf("Expected everything unmounted after the failure, got %+v", fakeMounter.MountPoints)
+	}
+}
+
+func TestNodeStageVolume_Shares(t *testing.T) {
+	fakeMounter := mount.NewFakeMounter([]mount.MountPoint{})
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithMounter(fakeMounter), WithStaging(true))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	publish := sharesPublishRequest("")
+	_, err = driver.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          "test-volume",
+		StagingTargetPath: filepath.Join(t.TempDir(), "staging"),
+		VolumeCapability:  publish.VolumeCapability,
+		VolumeContext:     publish.VolumeContext,
+	})
+	if err != nil {
+		t.Fatalf("NodeStageVolume failed: %v", err)
+	}
+	if log := fakeMounter.GetLog(); len(log) != 0 {
+		t.Errorf("Expected nothing staged, got %+v", log)
+	}
+}
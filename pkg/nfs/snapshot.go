@@ -0,0 +1,512 @@
+package nfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"k8s.io/klog/v2"
+)
+
+// snapshotsDir is the directory at the root of a share that holds the
+// snapshots of its volumes
+const snapshotsDir = ".snapshots"
+
+// partialSuffix marks a snapshot copy in progress; it is renamed into place
+// once complete
+const partialSuffix = ".partial"
+
+// WithSnapshots enables directory-copy snapshots: CreateSnapshot copies the
+// volume's directory to .snapshots/<name> on the same share. Copies are
+// neither atomic nor cheap, see the README.
+func WithSnapshots(enabled bool) DriverOption {
+	return func(d *Driver) {
+		d.snapshotsEnabled = enabled
+	}
+}
+
+// snapshotStore holds the snapshots created since the controller started,
+// keyed by the name passed to CreateSnapshot
+type snapshotStore struct {
+	mu        sync.Mutex
+	snapshots map[string]*csi.Snapshot
+}
+
+// add stores snapshot under name, or returns the snapshot already stored
+// there. A stored snapshot of another volume is an AlreadyExists error.
+func (s *snapshotStore) add(name string, snapshot *csi.Snapshot) (*csi.Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.snapshots[name]; ok {
+		if existing.GetSourceVolumeId() != snapshot.GetSourceVolumeId() {
+			return nil, status.Errorf(codes.AlreadyExists, "snapshot %q already exists for volume %s", name, existing.GetSourceVolumeId())
+		}
+		return existing, nil
+	}
+	if s.snapshots == nil {
+		s.snapshots = make(map[string]*csi.Snapshot)
+	}
+	s.snapshots[name] = snapshot
+	return snapshot, nil
+}
+
+// lookup returns the snapshot stored under name
+func (s *snapshotStore) lookup(name string) (*csi.Snapshot, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snapshot, ok := s.snapshots[name]
+	return snapshot, ok
+}
+
+// delete forgets the snapshot with snapshotID
+func (s *snapshotStore) delete(snapshotID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, snapshot := range s.snapshots {
+		if snapshot.GetSnapshotId() == snapshotID {
+			delete(s.snapshots, name)
+		}
+	}
+}
+
+// list returns the stored snapshots matching the optional snapshot and
+// source volume IDs, ordered by ID and paged like volumeStore.list
+func (s *snapshotStore) list(snapshotID, sourceVolumeID string, maxEntries int32, startingToken string) ([]*csi.Snapshot, string, error) {
+	s.mu.Lock()
+	var snapshots []*csi.Snapshot
+	for _, snapshot := range s.snapshots {
+		if snapshotID != "" && snapshot.GetSnapshotId() != snapshotID {
+			continue
+		}
+		if sourceVolumeID != "" && snapshot.GetSourceVolumeId() != sourceVolumeID {
+			continue
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	s.mu.Unlock()
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].GetSnapshotId() < snapshots[j].GetSnapshotId()
+	})
+
+	start, end, nextToken, err := page(len(snapshots), maxEntries, startingToken)
+	if err != nil {
+		return nil, "", err
+	}
+	return snapshots[start:end], nextToken, nil
+}
+
+// validateSnapshotName checks that name can be used as a single directory
+// below .snapshots
+func validateSnapshotName(name string) error {
+	if err := checkPathCharacters("snapshot name", name); err != nil {
+		return err
+	}
+	if name == "." || name == ".." || strings.ContainsAny(name, "/\\") || strings.HasSuffix(name, partialSuffix) {
+		return fmt.Errorf("invalid snapshot name %q: must be a single path component", name)
+	}
+	return nil
+}
+
+// snapshotID returns the ID of the snapshot name of a volume at parts. It
+// has the structured volume ID format, with the snapshot directory as the
+// subPath, so the snapshot can be found again after a restart.
+func snapshotID(parts volumeIDParts, name string) string {
+	return encodeVolumeID(volumeIDParts{
+		server:  parts.server,
+		share:   parts.share,
+		subPath: snapshotsDir + "/" + name,
+		name:    name,
+	})
+}
+
+// decodeSnapshotID parses a snapshot ID made by snapshotID
+func decodeSnapshotID(id string) (volumeIDParts, error) {
+	parts, err := decodeVolumeID(id)
+	if err != nil {
+		return volumeIDParts{}, fmt.Errorf("invalid snapshot ID %q", id)
+	}
+	if parts.subPath != snapshotsDir+"/"+parts.name || parts.onDelete != "" || validateSnapshotName(parts.name) != nil {
+		return volumeIDParts{}, fmt.Errorf("invalid snapshot ID %q", id)
+	}
+	return parts, nil
+}
+
+// shareSource returns the server:share to mount for parts, using the first
+// of its servers
+func shareSource(parts volumeIDParts) string {
+	server, _, _ := strings.Cut(parts.server, ",")
+	return fmt.Sprintf("%s:%s", server, parts.share)
+}
+
+// mountShare mounts source with mountOptions in a temporary directory on the
+// controller and returns the directory and a function that unmounts it
+// again. It is replaced in tests.
+var mountShare = func(ctx context.Context, d *Driver, source string, mountOptions []string) (string, func(), error) {
+	dir, err := d.filesystem.MkdirTemp("", "nfs-snapshot-")
+	if err != nil {
+		return "", nil, err
+	}
+	removeDir := func() {
+		// Remove, not RemoveAll: if the unmount failed the export must not be emptied
+		if err := d.filesystem.Remove(dir); err != nil {
+			klog.Warningf("Failed to remove %s: %v", dir, err)
+		}
+	}
+
+	if err := d.mountContext(ctx, source, dir, FSTypeNFS, mountOptions); err != nil {
+		removeDir()
+		return "", nil, err
+	}
+	return dir, func() {
+		if err := d.mounter.Unmount(dir); err != nil {
+			klog.Warningf("Failed to unmount %s: %v", dir, err)
+			return
+		}
+		removeDir()
+	}, nil
+}
+
+// defaultMountFailedError reports a share the controller could not mount
+// with the default options. Volume IDs do not record the port, security
+// flavor, transport or mount options of a volume's class, so the volumes of
+// classes that need them cannot be reached this way.
+func defaultMountFailedError(ctx context.Context, err error, source string) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return status.FromContextError(ctxErr).Err()
+	}
+	return status.Errorf(codes.FailedPrecondition, "failed to mount NFS %s with the default mount options %v: %v; the volume ID does not record the port, security, transport or mount options of the volume's class, so volumes that need them are not supported here", source, defaultMountOptions, err)
+}
+
+// copyTree copies the directory src to dst, which must not exist, keeping
+// modes and, where permitted, owners. exclude, if set, is a path below src
+// that is not copied. It returns the bytes of file data copied and stops
+// when ctx ends.
+func (d *Driver) copyTree(ctx context.Context, src, dst, exclude string) (int64, error) {
+	var copied int64
+	// Directory modes are applied last, so read-only directories can be filled
+	var dirs []string
+	var dirModes []os.FileMode
+
+	err := walkDir(d.filesystem, src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if exclude != "" && path == exclude {
+			return filepath.SkipDir
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		switch mode := info.Mode(); {
+		case mode.IsDir():
+			if err := d.filesystem.Mkdir(target, 0700); err != nil {
+				return err
+			}
+			dirs = append(dirs, target)
+			dirModes = append(dirModes, mode.Perm())
+		case mode.IsRegular():
+			n, err := d.copyFile(path, target)
+			if err != nil {
+				return err
+			}
+			copied += n
+			// OpenFile applies the umask
+			if err := d.filesystem.Chmod(target, mode.Perm()); err != nil {
+				return err
+			}
+		case mode&fs.ModeSymlink != 0:
+			link, err := d.filesystem.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := d.filesystem.Symlink(link, target); err != nil {
+				return err
+			}
+		default:
+			klog.Warningf("Not copying %s: unsupported file type %v", path, mode.Type())
+			return nil
+		}
+
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			if err := d.filesystem.Lchown(target, int(st.Uid), int(st.Gid)); err != nil {
+				klog.V(4).Infof("Failed to keep the owner of %s: %v", target, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return copied, err
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := d.filesystem.Chmod(dirs[i], dirModes[i]); err != nil {
+			return copied, err
+		}
+	}
+	return copied, nil
+}
+
+// copyFile copies the regular file src to the new file dst
+func (d *Driver) copyFile(src, dst string) (int64, error) {
+	in, err := d.filesystem.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	out, err := d.filesystem.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return n, err
+}
+
+// copyAside copies src to dst through dst.partial, which is renamed once the
+// copy is complete, so dst never holds a partial copy. A failed copy is
+// removed again.
+func (d *Driver) copyAside(ctx context.Context, src, dst, exclude string) (int64, error) {
+	partial := dst + partialSuffix
+	if err := d.filesystem.RemoveAll(partial); err != nil {
+		return 0, fmt.Errorf("failed to remove the partial copy %s: %w", partial, err)
+	}
+
+	size, err := d.copyTree(ctx, src, partial, exclude)
+	if err == nil {
+		err = d.filesystem.Rename(partial, dst)
+	}
+	if err != nil {
+		if removeErr := d.filesystem.RemoveAll(partial); removeErr != nil {
+			klog.Warningf("Failed to remove the partial copy %s: %v", partial, removeErr)
+		}
+		return 0, err
+	}
+	return size, nil
+}
+
+// CreateSnapshot copies the volume's directory to .snapshots/<name> at the
+// root of its share
+func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
+	if !d.snapshotsEnabled {
+		return nil, status.Error(codes.Unimplemented, "CreateSnapshot is not implemented")
+	}
+
+	name := req.GetName()
+	if name == "" {
+		return nil, status.Error(codes.InvalidArgument, "snapshot name is required")
+	}
+	if err := validateSnapshotName(name); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	volumeID := req.GetSourceVolumeId()
+	if volumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "source volume ID is required")
+	}
+
+	if existing, ok := d.snapshots.lookup(name); ok {
+		if existing.GetSourceVolumeId() != volumeID {
+			return nil, status.Errorf(codes.AlreadyExists, "snapshot %q already exists for volume %s", name, existing.GetSourceVolumeId())
+		}
+		return &csi.CreateSnapshotResponse{Snapshot: existing}, nil
+	}
+
+	// Only structured IDs say where the volume's data is
+	parts, err := decodeVolumeID(volumeID)
+	if err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "cannot locate volume %s: snapshots need volumes created with --volume-id-format=%s", volumeID, VolumeIDFormatStructured)
+	}
+	// The ID of a volume with several shares lists them all, see getShares
+	if strings.Contains(parts.share, ",") {
+		return nil, status.Errorf(codes.FailedPrecondition, "volume %s has several shares and cannot be snapshotted", volumeID)
+	}
+
+	source := shareSource(parts)
+	root, unmount, err := mountShare(ctx, d, source, defaultMountOptions)
+	if err != nil {
+		return nil, defaultMountFailedError(ctx, err, source)
+	}
+	defer unmount()
+
+	volumeDir := filepath.Join(root, parts.subPath)
+	if _, err := d.filesystem.Stat(volumeDir); err != nil {
+		if os.IsNotExist(err) {
+			return nil, status.Errorf(codes.NotFound, "volume %s does not exist: %s is missing on %s", volumeID, parts.subPath, source)
+		}
+		return nil, status.Errorf(codes.Internal, "failed to stat %s: %v", volumeDir, err)
+	}
+
+	snapshotDir := filepath.Join(root, snapshotsDir, name)
+	var size int64
+	if _, err := d.filesystem.Stat(snapshotDir); err == nil {
+		// Left by an earlier request whose result was lost, e.g. in a restart
+		d.logV(ctx, subsystemController, 2).Infof("CreateSnapshot: %s already exists on %s, reusing it", filepath.Join(snapshotsDir, name), source)
+	} else {
+		if err := d.filesystem.MkdirAll(filepath.Join(root, snapshotsDir), 0700); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to create %s: %v", snapshotsDir, err)
+		}
+
+		exclude := ""
+		if parts.subPath == "" {
+			exclude = filepath.Join(root, snapshotsDir)
+		}
+		d.logV(ctx, subsystemController, 2).Infof("CreateSnapshot: copying %s on %s to %s", volumeDir, source, filepath.Join(snapshotsDir, name))
+		if size, err = d.copyAside(ctx, volumeDir, snapshotDir, exclude); err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, status.FromContextError(ctxErr).Err()
+			}
+			return nil, status.Errorf(codes.Internal, "failed to copy volume %s to snapshot %s: %v", volumeID, name, err)
+		}
+	}
+
+	snapshot, err := d.snapshots.add(name, &csi.Snapshot{
+		SnapshotId:     snapshotID(parts, name),
+		SourceVolumeId: volumeID,
+		SizeBytes:      size,
+		CreationTime:   timestamppb.Now(),
+		ReadyToUse:     true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	d.logV(ctx, subsystemController, 2).Infof("CreateSnapshot: created snapshot %s of volume %s (%d bytes)", snapshot.GetSnapshotId(), volumeID, size)
+	return &csi.CreateSnapshotResponse{Snapshot: snapshot}, nil
+}
+
+// restoreSnapshot seeds subPath, the directory of a new volume on share,
+// with a copy of the snapshot. The share is mounted read-write with the
+// options the volume itself is mounted with, from its volume context. An
+// existing directory is left as it is, so a retried CreateVolume does not
+// copy twice.
+func (d *Driver) restoreSnapshot(ctx context.Context, id string, servers []string, share, subPath string, volumeContext map[string]string) error {
+	if !d.snapshotsEnabled {
+		return status.Error(codes.InvalidArgument, "restoring from a snapshot needs --enable-snapshots")
+	}
+	parts, err := decodeSnapshotID(id)
+	if err != nil {
+		return status.Errorf(codes.NotFound, "snapshot %s does not exist: %v", id, err)
+	}
+
+	// Both directories have to be reachable through one mount
+	source := shareSource(volumeIDParts{server: strings.Join(servers, ","), share: share})
+	if snapshotSource := shareSource(parts); snapshotSource != source {
+		return status.Errorf(codes.InvalidArgument, "snapshot %s is on %s, not on the volume's share %s", id, snapshotSource, source)
+	}
+	if subPath == "" || subPath == snapshotsDir || strings.HasPrefix(subPath, snapshotsDir+"/") {
+		return status.Errorf(codes.InvalidArgument, "a volume restored from a snapshot needs its own subPath outside %s, got %q", snapshotsDir, subPath)
+	}
+	mountOptions, err := d.shareMountOptions(volumeContext, "rw")
+	if err != nil {
+		return err
+	}
+
+	root, unmount, err := mountShare(ctx, d, source, mountOptions)
+	if err != nil {
+		return mountFailedError(err, source, "a temporary directory")
+	}
+	defer unmount()
+
+	snapshotDir := filepath.Join(root, snapshotsDir, parts.name)
+	if _, err := d.filesystem.Stat(snapshotDir); err != nil {
+		if os.IsNotExist(err) {
+			return status.Errorf(codes.NotFound, "snapshot %s does not exist on %s", id, source)
+		}
+		return status.Errorf(codes.Internal, "failed to stat %s: %v", snapshotDir, err)
+	}
+
+	target := filepath.Join(root, subPath)
+	if _, err := d.filesystem.Stat(target); err == nil {
+		d.logV(ctx, subsystemController, 2).Infof("CreateVolume: %s already exists on %s, not restoring snapshot %s into it", subPath, source, id)
+		return nil
+	} else if !os.IsNotExist(err) {
+		return status.Errorf(codes.Internal, "failed to stat %s: %v", target, err)
+	}
+	if err := d.filesystem.MkdirAll(filepath.Dir(target), 0750); err != nil {
+		return status.Errorf(codes.Internal, "failed to create the parent of %s: %v", subPath, err)
+	}
+
+	d.logV(ctx, subsystemController, 2).Infof("CreateVolume: restoring snapshot %s to %s on %s", id, subPath, source)
+	if _, err := d.copyAside(ctx, snapshotDir, target, ""); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return status.FromContextError(ctxErr).Err()
+		}
+		return status.Errorf(codes.Internal, "failed to restore snapshot %s to %s: %v", id, subPath, err)
+	}
+	return nil
+}
+
+// DeleteSnapshot removes the snapshot directory from the share
+func (d *Driver) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
+	if !d.snapshotsEnabled {
+		return nil, status.Error(codes.Unimplemented, "DeleteSnapshot is not implemented")
+	}
+
+	id := req.GetSnapshotId()
+	if id == "" {
+		return nil, status.Error(codes.InvalidArgument, "snapshot ID is required")
+	}
+	parts, err := decodeSnapshotID(id)
+	if err != nil {
+		// Not one of ours, so there is nothing to delete
+		d.logV(ctx, subsystemController, 2).Infof("DeleteSnapshot: %v", err)
+		return &csi.DeleteSnapshotResponse{}, nil
+	}
+
+	source := shareSource(parts)
+	root, unmount, err := mountShare(ctx, d, source, defaultMountOptions)
+	if err != nil {
+		return nil, defaultMountFailedError(ctx, err, source)
+	}
+	defer unmount()
+
+	snapshotDir := filepath.Join(root, snapshotsDir, parts.name)
+	if err := d.filesystem.RemoveAll(snapshotDir); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to remove snapshot %s: %v", id, err)
+	}
+	d.snapshots.delete(id)
+
+	d.logV(ctx, subsystemController, 2).Infof("DeleteSnapshot: deleted snapshot %s", id)
+	return &csi.DeleteSnapshotResponse{}, nil
+}
+
+// ListSnapshots lists the snapshots created since the controller started
+func (d *Driver) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
+	if !d.snapshotsEnabled {
+		return nil, status.Error(codes.Unimplemented, "ListSnapshots is not implemented")
+	}
+
+	snapshots, nextToken, err := d.snapshots.list(req.GetSnapshotId(), req.GetSourceVolumeId(), req.GetMaxEntries(), req.GetStartingToken())
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*csi.ListSnapshotsResponse_Entry, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		entries = append(entries, &csi.ListSnapshotsResponse_Entry{Snapshot: snapshot})
+	}
+	return &csi.ListSnapshotsResponse{Entries: entries, NextToken: nextToken}, nil
+}
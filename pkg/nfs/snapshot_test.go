@@ -0,0 +1,480 @@
+package nfs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// mountShareCall records one call of a stubbed mountShare
+type mountShareCall struct {
+	source       string
+	mountOptions []string
+}
+
+// stubMountShare makes mountShare return root, standing in for the mounted
+// share, and records the shares mounted
+func stubMountShare(t *testing.T, root string) *[]mountShareCall {
+	t.Helper()
+	var calls []mountShareCall
+	orig := mountShare
+	mountShare = func(_ context.Context, _ *Driver, source string, mountOptions []string) (string, func(), error) {
+		calls = append(calls, mountShareCall{source: source, mountOptions: mountOptions})
+		return root, func() {}, nil
+	}
+	t.Cleanup(func() { mountShare = orig })
+	return &calls
+}
+
+// writeTestFiles creates files (path relative to dir -> content) below dir
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for path, content := range files {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0640); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+}
+
+// checkTestFiles fails unless every file in files exists below dir with its content
+func checkTestFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for path, want := range files {
+		got, err := os.ReadFile(filepath.Join(dir, path))
+		if err != nil {
+			t.Errorf("Failed to read %s: %v", path, err)
+			continue
+		}
+		if string(got) != want {
+			t.Errorf("Expected %s to contain %q, got %q", path, want, got)
+		}
+	}
+}
+
+func newSnapshotDriver(t *testing.T) *Driver {
+	t.Helper()
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock",
+		WithSnapshots(true), WithVolumeIDFormat(VolumeIDFormatStructured))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+	return driver
+}
+
+func TestCreateSnapshot(t *testing.T) {
+	root := t.TempDir()
+	calls := stubMountShare(t, root)
+	files := map[string]string{"data.txt": "hello", "nested/deep/file": "world"}
+	writeTestFiles(t, filepath.Join(root, "tenants/a"), files)
+
+	driver := newSnapshotDriver(t)
+	volumeID := encodeVolumeID(volumeIDParts{server: "nfs-a.example.com,nfs-b.example.com", share: "/exports", subPath: "tenants/a", name: "pvc-1"})
+
+	resp, err := driver.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{Name: "snap-1", SourceVolumeId: volumeID})
+	if err != nil {
+		t.Fatalf("CreateSnapshot failed: %v", err)
+	}
+	snapshot := resp.GetSnapshot()
+	if !snapshot.GetReadyToUse() || snapshot.GetSourceVolumeId() != volumeID || snapshot.GetSizeBytes() != 10 {
+		t.Errorf("Unexpected snapshot %+v", snapshot)
+	}
+	if len(*calls) != 1 || (*calls)[0].source != "nfs-a.example.com:/exports" {
+		t.Errorf("Expected the first server to be mounted, got %v", *calls)
+	}
+	checkTestFiles(t, filepath.Join(root, ".snapshots/snap-1"), files)
+	if _, err := os.Stat(filepath.Join(root, ".snapshots/snap-1.partial")); !os.IsNotExist(err) {
+		t.Errorf("Expected no partial copy to be left, got %v", err)
+	}
+
+	parts, err := decodeSnapshotID(snapshot.GetSnapshotId())
+	if err != nil || parts.name != "snap-1" || parts.share != "/exports" {
+		t.Errorf("Expected a snapshot ID naming the share and snapshot, got %+v (%v)", parts, err)
+	}
+
+	// The same request again returns the same snapshot without copying
+	again, err := driver.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{Name: "snap-1", SourceVolumeId: volumeID})
+	if err != nil || again.GetSnapshot().GetSnapshotId() != snapshot.GetSnapshotId() {
+		t.Errorf("Expected the existing snapshot, got %v (%v)", again, err)
+	}
+	if len(*calls) != 1 {
+		t.Errorf("Expected no second mount, got %v", *calls)
+	}
+
+	// The name is taken for another volume
+	other := encodeVolumeID(volumeIDParts{server: "nfs-a.example.com", share: "/exports", subPath: "tenants/b", name: "pvc-2"})
+	_, err = driver.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{Name: "snap-1", SourceVolumeId: other})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("Expected AlreadyExists, got %v", err)
+	}
+}
+
+func TestCreateSnapshot_ShareRoot(t *testing.T) {
+	root := t.TempDir()
+	stubMountShare(t, root)
+	writeTestFiles(t, root, map[string]string{"data.txt": "hello", ".snapshots/older/data.txt": "old"})
+
+	driver := newSnapshotDriver(t)
+	volumeID := encodeVolumeID(volumeIDParts{server: "nfs.example.com", share: "/exports", name: "pvc-1"})
+	if _, err := driver.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{Name: "snap-1", SourceVolumeId: volumeID}); err != nil {
+		t.Fatalf("CreateSnapshot failed: %v", err)
+	}
+
+	checkTestFiles(t, filepath.Join(root, ".snapshots/snap-1"), map[string]string{"data.txt": "hello"})
+	if _, err := os.Stat(filepath.Join(root, ".snapshots/snap-1/.snapshots")); !os.IsNotExist(err) {
+		t.Errorf("Expected other snapshots to be left out, got %v", err)
+	}
+}
+
+func TestCreateSnapshot_Errors(t *testing.T) {
+	root := t.TempDir()
+	stubMountShare(t, root)
+	structured := encodeVolumeID(volumeIDParts{server: "nfs.example.com", share: "/exports", subPath: "missing", name: "pvc-1"})
+	shares := encodeVolumeID(volumeIDParts{server: "nfs.example.com", share: "/a,/b", name: "pvc-1"})
+
+	tests := []struct {
+		name     string
+		disabled bool
+		req      *csi.CreateSnapshotRequest
+		wantCode codes.Code
+	}{
+		{name: "disabled", disabled: true, req: &csi.CreateSnapshotRequest{Name: "snap", SourceVolumeId: structured}, wantCode: codes.Unimplemented},
+		{name: "missing name", req: &csi.CreateSnapshotRequest{SourceVolumeId: structured}, wantCode: codes.InvalidArgument},
+		{name: "name with a slash", req: &csi.CreateSnapshotRequest{Name: "../snap", SourceVolumeId: structured}, wantCode: codes.InvalidArgument},
+		{name: "missing source", req: &csi.CreateSnapshotRequest{Name: "snap"}, wantCode: codes.InvalidArgument},
+		{name: "name format volume ID", req: &csi.CreateSnapshotRequest{Name: "snap", SourceVolumeId: "pvc-1"}, wantCode: codes.FailedPrecondition},
+		{name: "several shares", req: &csi.CreateSnapshotRequest{Name: "snap", SourceVolumeId: shares}, wantCode: codes.FailedPrecondition},
+		{name: "volume directory missing", req: &csi.CreateSnapshotRequest{Name: "snap", SourceVolumeId: structured}, wantCode: codes.NotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithSnapshots(!tt.disabled))
+			if err != nil {
+				t.Fatalf("Failed to create driver: %v", err)
+			}
+			_, err = driver.CreateSnapshot(context.Background(), tt.req)
+			if status.Code(err) != tt.wantCode {
+				t.Errorf("Expected %v, got %v", tt.wantCode, err)
+			}
+		})
+	}
+}
+
+func TestSnapshot_MountFails(t *testing.T) {
+	orig := mountShare
+	mountShare = func(context.Context, *Driver, string, []string) (string, func(), error) {
+		return "", nil, fmt.Errorf("mount.nfs: Operation not permitted")
+	}
+	t.Cleanup(func() { mountShare = orig })
+
+	driver := newSnapshotDriver(t)
+	volumeID := encodeVolumeID(volumeIDParts{server: "nfs.example.com", share: "/exports", subPath: "app", name: "pvc-1"})
+
+	_, err := driver.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{Name: "snap-1", SourceVolumeId: volumeID})
+	if status.Code(err) != codes.FailedPrecondition || !strings.Contains(err.Error(), "default mount options") {
+		t.Errorf("Expected FailedPrecondition naming the default mount options from CreateSnapshot, got %v", err)
+	}
+
+	id := snapshotID(volumeIDParts{server: "nfs.example.com", share: "/exports"}, "snap-1")
+	_, err = driver.DeleteSnapshot(context.Background(), &csi.DeleteSnapshotRequest{SnapshotId: id})
+	if status.Code(err) != codes.FailedPrecondition || !strings.Contains(err.Error(), "default mount options") {
+		t.Errorf("Expected FailedPrecondition naming the default mount options from DeleteSnapshot, got %v", err)
+	}
+}
+
+func TestCreateSnapshot_CanceledCopy(t *testing.T) {
+	root := t.TempDir()
+	stubMountShare(t, root)
+	writeTestFiles(t, filepath.Join(root, "app"), map[string]string{"data.txt": "hello"})
+
+	driver := newSnapshotDriver(t)
+	volumeID := encodeVolumeID(volumeIDParts{server: "nfs.example.com", share: "/exports", subPath: "app", name: "pvc-1"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := driver.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{Name: "snap-1", SourceVolumeId: volumeID})
+	if status.Code(err) != codes.Canceled {
+		t.Fatalf("Expected Canceled, got %v", err)
+	}
+	for _, path := range []string{".snapshots/snap-1", ".snapshots/snap-1.partial"} {
+		if _, err := os.Stat(filepath.Join(root, path)); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed, got %v", path, err)
+		}
+	}
+}
+
+func TestDeleteSnapshot(t *testing.T) {
+	root := t.TempDir()
+	stubMountShare(t, root)
+	writeTestFiles(t, filepath.Join(root, "app"), map[string]string{"data.txt": "hello"})
+
+	driver := newSnapshotDriver(t)
+	volumeID := encodeVolumeID(volumeIDParts{server: "nfs.example.com", share: "/exports", subPath: "app", name: "pvc-1"})
+	resp, err := driver.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{Name: "snap-1", SourceVolumeId: volumeID})
+	if err != nil {
+		t.Fatalf("CreateSnapshot failed: %v", err)
+	}
+	id := resp.GetSnapshot().GetSnapshotId()
+
+	if _, err := driver.DeleteSnapshot(context.Background(), &csi.DeleteSnapshotRequest{SnapshotId: id}); err != nil {
+		t.Fatalf("DeleteSnapshot failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, ".snapshots/snap-1")); !os.IsNotExist(err) {
+		t.Errorf("Expected the snapshot directory to be removed, got %v", err)
+	}
+	checkTestFiles(t, filepath.Join(root, "app"), map[string]string{"data.txt": "hello"})
+
+	list, err := driver.ListSnapshots(context.Background(), &csi.ListSnapshotsRequest{})
+	if err != nil || len(list.GetEntries()) != 0 {
+		t.Errorf("Expected no snapshots after delete, got %v (%v)", list, err)
+	}
+
+	// Deleting again, or an ID the driver never made, succeeds
+	for _, id := range []string{id, "not-a-snapshot", volumeID} {
+		if _, err := driver.DeleteSnapshot(context.Background(), &csi.DeleteSnapshotRequest{SnapshotId: id}); err != nil {
+			t.Errorf("DeleteSnapshot(%q) failed: %v", id, err)
+		}
+	}
+	checkTestFiles(t, filepath.Join(root, "app"), map[string]string{"data.txt": "hello"})
+}
+
+func TestListSnapshots(t *testing.T) {
+	root := t.TempDir()
+	stubMountShare(t, root)
+	writeTestFiles(t, root, map[string]string{"a/data.txt": "a", "b/data.txt": "b"})
+
+	driver := newSnapshotDriver(t)
+	volumeA := encodeVolumeID(volumeIDParts{server: "nfs.example.com", share: "/exports", subPath: "a", name: "pvc-a"})
+	volumeB := encodeVolumeID(volumeIDParts{server: "nfs.example.com", share: "/exports", subPath: "b", name: "pvc-b"})
+	ids := map[string]string{}
+	for name, volumeID := range map[string]string{"snap-a1": volumeA, "snap-a2": volumeA, "snap-b1": volumeB} {
+		resp, err := driver.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{Name: name, SourceVolumeId: volumeID})
+		if err != nil {
+			t.Fatalf("CreateSnapshot(%s) failed: %v", name, err)
+		}
+		ids[name] = resp.GetSnapshot().GetSnapshotId()
+	}
+
+	tests := []struct {
+		name      string
+		req       *csi.ListSnapshotsRequest
+		wantCount int
+		wantNext  bool
+		wantCode  codes.Code
+	}{
+		{name: "all", req: &csi.ListSnapshotsRequest{}, wantCount: 3},
+		{name: "by source volume", req: &csi.ListSnapshotsRequest{SourceVolumeId: volumeA}, wantCount: 2},
+		{name: "by snapshot ID", req: &csi.ListSnapshotsRequest{SnapshotId: ids["snap-b1"]}, wantCount: 1},
+		{name: "unknown snapshot ID", req: &csi.ListSnapshotsRequest{SnapshotId: "unknown"}, wantCount: 0},
+		{name: "first page", req: &csi.ListSnapshotsRequest{MaxEntries: 2}, wantCount: 2, wantNext: true},
+		{name: "last page", req: &csi.ListSnapshotsRequest{MaxEntries: 2, StartingToken: "2"}, wantCount: 1},
+		{name: "invalid token", req: &csi.ListSnapshotsRequest{StartingToken: "x"}, wantCode: codes.Aborted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := driver.ListSnapshots(context.Background(), tt.req)
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("Expected %v, got %v", tt.wantCode, err)
+			}
+			if err != nil {
+				return
+			}
+			if len(resp.GetEntries()) != tt.wantCount || (resp.GetNextToken() != "") != tt.wantNext {
+				t.Errorf("Expected %d entries (next page %v), got %d with token %q", tt.wantCount, tt.wantNext, len(resp.GetEntries()), resp.GetNextToken())
+			}
+		})
+	}
+}
+
+func TestControllerGetCapabilities_Snapshots(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithSnapshots(enabled))
+		if err != nil {
+			t.Fatalf("Failed to create driver: %v", err)
+		}
+		resp, err := driver.ControllerGetCapabilities(context.Background(), &csi.ControllerGetCapabilitiesRequest{})
+		if err != nil {
+			t.Fatalf("ControllerGetCapabilities failed: %v", err)
+		}
+
+		found := map[csi.ControllerServiceCapability_RPC_Type]bool{}
+		for _, capability := range resp.GetCapabilities() {
+			found[capability.GetRpc().GetType()] = true
+		}
+		for _, capability := range []csi.ControllerServiceCapability_RPC_Type{
+			csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+			csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+		} {
+			if found[capability] != enabled {
+				t.Errorf("Expected %v advertised=%v with snapshots enabled=%v", capability, enabled, enabled)
+			}
+		}
+	}
+}
+
+// failingRenameFilesystem is the real filesystem with Rename failing
+type failingRenameFilesystem struct {
+	osFilesystem
+}
+
+func (failingRenameFilesystem) Rename(oldPath, newPath string) error {
+	return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: syscall.EIO}
+}
+
+// restoreRequest returns a CreateVolume request for subPath on the test
+// share, restored from snapshotID
+func restoreRequest(subPath, snapshotID string) *csi.CreateVolumeRequest {
+	req := createVolumeRequest("pvc-restored", 0)
+	req.Parameters = map[string]string{"server": "nfs.example.com", "share": "/exports", "subPath": subPath}
+	req.VolumeContentSource = &csi.VolumeContentSource{
+		Type: &csi.VolumeContentSource_Snapshot{
+			Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: snapshotID},
+		},
+	}
+	return req
+}
+
+func TestCreateVolume_RestoreSnapshot(t *testing.T) {
+	root := t.TempDir()
+	stubMountShare(t, root)
+	files := map[string]string{"data.txt": "hello", "nested/file": "world"}
+	writeTestFiles(t, filepath.Join(root, "app"), files)
+
+	driver := newSnapshotDriver(t)
+	volumeID := encodeVolumeID(volumeIDParts{server: "nfs.example.com", share: "/exports", subPath: "app", name: "pvc-1"})
+	snap, err := driver.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{Name: "snap-1", SourceVolumeId: volumeID})
+	if err != nil {
+		t.Fatalf("CreateSnapshot failed: %v", err)
+	}
+	snapshotID := snap.GetSnapshot().GetSnapshotId()
+
+	resp, err := driver.CreateVolume(context.Background(), restoreRequest("restored/app", snapshotID))
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+	if got := resp.GetVolume().GetContentSource().GetSnapshot().GetSnapshotId(); got != snapshotID {
+		t.Errorf("Expected the snapshot as content source, got %q", got)
+	}
+	checkTestFiles(t, filepath.Join(root, "restored/app"), files)
+	checkTestFiles(t, filepath.Join(root, ".snapshots/snap-1"), files)
+
+	// A retried request leaves the restored directory alone
+	writeTestFiles(t, filepath.Join(root, "restored/app"), map[string]string{"data.txt": "changed"})
+	if _, err := driver.CreateVolume(context.Background(), restoreRequest("restored/app", snapshotID)); err != nil {
+		t.Fatalf("Retried CreateVolume failed: %v", err)
+	}
+	checkTestFiles(t, filepath.Join(root, "restored/app"), map[string]string{"data.txt": "changed"})
+}
+
+func TestCreateVolume_RestoreSnapshotMountOptions(t *testing.T) {
+	root := t.TempDir()
+	calls := stubMountShare(t, root)
+	writeTestFiles(t, filepath.Join(root, ".snapshots/snap-1"), map[string]string{"data.txt": "hello"})
+	id := snapshotID(volumeIDParts{server: "nfs.example.com", share: "/exports"}, "snap-1")
+
+	driver := newSnapshotDriver(t)
+	req := restoreRequest("restored", id)
+	req.Parameters[ParamPort] = "2050"
+	req.Parameters[ParamSecurity] = "krb5"
+	req.Parameters[ParamTransport] = "udp"
+	req.Parameters[ParamMountOptions] = "nfsvers=3"
+	req.Parameters[ParamReadOnly] = "true"
+	if _, err := driver.CreateVolume(context.Background(), req); err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+
+	if len(*calls) != 1 {
+		t.Fatalf("Expected one mount, got %v", *calls)
+	}
+	options := (*calls)[0].mountOptions
+	for _, want := range []string{"port=2050", "sec=krb5", "proto=udp", "nfsvers=3", "rw"} {
+		if !containsString(options, want) {
+			t.Errorf("Expected %s in the restore mount options, got %v", want, options)
+		}
+	}
+	// The restore writes, whatever the volume is published as
+	if containsString(options, "ro") {
+		t.Errorf("Expected a read-write restore mount, got %v", options)
+	}
+	checkTestFiles(t, filepath.Join(root, "restored"), map[string]string{"data.txt": "hello"})
+}
+
+func TestCreateVolume_RestoreSnapshotErrors(t *testing.T) {
+	root := t.TempDir()
+	stubMountShare(t, root)
+	writeTestFiles(t, filepath.Join(root, ".snapshots/snap-1"), map[string]string{"data.txt": "hello"})
+	id := snapshotID(volumeIDParts{server: "nfs.example.com", share: "/exports"}, "snap-1")
+
+	tests := []struct {
+		name     string
+		opts     []DriverOption
+		req      *csi.CreateVolumeRequest
+		wantCode codes.Code
+	}{
+		{name: "snapshots disabled", req: restoreRequest("restored", id), wantCode: codes.InvalidArgument},
+		{name: "not a snapshot ID", opts: []DriverOption{WithSnapshots(true)},
+			req: restoreRequest("restored", "snap-1"), wantCode: codes.NotFound},
+		{name: "snapshot missing on the share", opts: []DriverOption{WithSnapshots(true)},
+			req:      restoreRequest("restored", snapshotID(volumeIDParts{server: "nfs.example.com", share: "/exports"}, "snap-2")),
+			wantCode: codes.NotFound},
+		{name: "snapshot on another share", opts: []DriverOption{WithSnapshots(true)},
+			req:      restoreRequest("restored", snapshotID(volumeIDParts{server: "nfs.example.com", share: "/other"}, "snap-1")),
+			wantCode: codes.InvalidArgument},
+		{name: "volume at the share root", opts: []DriverOption{WithSnapshots(true)},
+			req: restoreRequest("", id), wantCode: codes.InvalidArgument},
+		{name: "volume inside .snapshots", opts: []DriverOption{WithSnapshots(true)},
+			req: restoreRequest(".snapshots/restored", id), wantCode: codes.InvalidArgument},
+		{name: "copy fails", opts: []DriverOption{WithSnapshots(true), WithFS(failingRenameFilesystem{})},
+			req: restoreRequest("restored", id), wantCode: codes.Internal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", tt.opts...)
+			if err != nil {
+				t.Fatalf("Failed to create driver: %v", err)
+			}
+			_, err = driver.CreateVolume(context.Background(), tt.req)
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("Expected %v, got %v", tt.wantCode, err)
+			}
+			for _, path := range []string{"restored", "restored.partial"} {
+				if _, err := os.Stat(filepath.Join(root, path)); !os.IsNotExist(err) {
+					t.Errorf("Expected %s not to exist, got %v", path, err)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateVolume_RestoreSnapshotCanceled(t *testing.T) {
+	root := t.TempDir()
+	stubMountShare(t, root)
+	writeTestFiles(t, filepath.Join(root, ".snapshots/snap-1"), map[string]string{"data.txt": "hello"})
+
+	driver := newSnapshotDriver(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := driver.CreateVolume(ctx, restoreRequest("restored", snapshotID(volumeIDParts{server: "nfs.example.com", share: "/exports"}, "snap-1")))
+	if status.Code(err) != codes.Canceled {
+		t.Fatalf("Expected Canceled, got %v", err)
+	}
+	for _, path := range []string{"restored", "restored.partial"} {
+		if _, err := os.Stat(filepath.Join(root, path)); !os.IsNotExist(err) {
+			t.Errorf("Expected %s not to exist, got %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,396 @@
+package nfs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"k8s.io/klog/v2"
+	"k8s.io/mount-utils"
+)
+
+// DefaultKubeletDir is the kubelet root directory
+const DefaultKubeletDir = "/var/lib/kubelet"
+
+// volDataFile is the file kubelet keeps next to the mount of a CSI volume,
+// naming its driver and volume handle
+const volDataFile = "vol_data.json"
+
+// listMountInfo returns the mount table of the node with the device and root
+// of each mount. It is replaced in tests.
+var listMountInfo = func() ([]mount.MountInfo, error) {
+	return mount.ParseMountInfo("/proc/self/mountinfo")
+}
+
+// kubeletVolume is what the driver reads from a vol_data.json
+type kubeletVolume struct {
+	DriverName   string `json:"driverName"`
+	VolumeHandle string `json:"volumeHandle"`
+}
+
+// stagedMounts tracks which publish targets are bind-mounted from each staged
+// share, so a staged mount is only torn down once no pod uses it
+type stagedMounts struct {
+	mu      sync.Mutex
+	refs    map[string]map[string]struct{} // staging path -> target paths
+	targets map[string]string              // target path -> staging path
+
+	shared sharedMounts
+}
+
+// sharedMounts reference-counts the NFS mounts under the shared mount directory.
+// Every staging path and pod target bind-mounted from a shared mount holds a
+// reference.
+// It is guarded by stagedMounts.mu.
+type sharedMounts struct {
+	refs  map[string]map[string]struct{} // shared mount path -> referencing paths
+	paths map[string]string              // referencing path -> shared mount path
+}
+
+// WithStaging mounts each share once per volume in NodeStageVolume and
+// bind-mounts it into pod targets in NodePublishVolume
+func WithStaging(enabled bool) DriverOption {
+	return func(d *Driver) {
+		d.staging = enabled
+	}
+}
+
+// addLocked records that target is bind-mounted from stagingPath.
+// The caller must hold s.mu.
+func (s *stagedMounts) addLocked(stagingPath, target string) {
+	if s.refs == nil {
+		s.refs = make(map[string]map[string]struct{})
+		s.targets = make(map[string]string)
+	}
+	if s.refs[stagingPath] == nil {
+		s.refs[stagingPath] = make(map[string]struct{})
+	}
+	s.refs[stagingPath][target] = struct{}{}
+	s.targets[target] = stagingPath
+
+	if sharedPath, ok := s.shared.paths[stagingPath]; ok {
+		s.shared.add(sharedPath, target)
+	}
+}
+
+// remove drops the reference held by target, if any
+func (s *stagedMounts) remove(target string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.removeLocked(target)
+}
+
+// removeLocked is remove for callers already holding s.mu
+func (s *stagedMounts) removeLocked(target string) {
+	s.shared.remove(target)
+
+	stagingPath, ok := s.targets[target]
+	if !ok {
+		return
+	}
+	delete(s.targets, target)
+	delete(s.refs[stagingPath], target)
+	if len(s.refs[stagingPath]) == 0 {
+		delete(s.refs, stagingPath)
+	}
+}
+
+// countLocked returns the number of targets using stagingPath.
+// The caller must hold s.mu.
+func (s *stagedMounts) countLocked(stagingPath string) int {
+	return len(s.refs[stagingPath])
+}
+
+// releaseTarget drops the references held by an unmounted publish target,
+// and unmounts the shared mount it used should that have been the last
+// reference, instead of keeping it mounted until the next restart.
+func (d *Driver) releaseTarget(ctx context.Context, targetPath string) {
+	d.staged.mu.Lock()
+	defer d.staged.mu.Unlock()
+
+	sharedPath, ok := d.staged.shared.paths[targetPath]
+	d.staged.removeLocked(targetPath)
+	if !ok || d.staged.shared.count(sharedPath) > 0 {
+		return
+	}
+	if err := mount.CleanupMountPoint(sharedPath, d.mounter, true); err != nil {
+		// rebuildSharedMounts retries unreferenced shared mounts on restart
+		klog.Warningf("Failed to unmount unused shared mount %s: %v", sharedPath, err)
+		return
+	}
+	d.logV(ctx, subsystemNode, 2).Infof("Unmounted shared mount %s after unpublishing %s", sharedPath, targetPath)
+}
+
+// add records that path is bind-mounted from sharedPath
+func (s *sharedMounts) add(sharedPath, path string) {
+	if s.refs == nil {
+		s.refs = make(map[string]map[string]struct{})
+		s.paths = make(map[string]string)
+	}
+	if s.refs[sharedPath] == nil {
+		s.refs[sharedPath] = make(map[string]struct{})
+	}
+	s.refs[sharedPath][path] = struct{}{}
+	s.paths[path] = sharedPath
+}
+
+// remove drops the reference held by path and returns the shared mount it
+// referenced, if any
+func (s *sharedMounts) remove(path string) (string, bool) {
+	sharedPath, ok := s.paths[path]
+	if !ok {
+		return "", false
+	}
+	delete(s.paths, path)
+	delete(s.refs[sharedPath], path)
+	if len(s.refs[sharedPath]) == 0 {
+		delete(s.refs, sharedPath)
+	}
+	return sharedPath, true
+}
+
+// count returns the number of paths referencing sharedPath
+func (s *sharedMounts) count(sharedPath string) int {
+	return len(s.refs[sharedPath])
+}
+
+// WithSharedMountDir mounts each distinct share once per node under dir and
+// bind-mounts it into staging paths. Only used with staging enabled.
+func WithSharedMountDir(dir string) DriverOption {
+	return func(d *Driver) {
+		d.sharedMountDir = dir
+	}
+}
+
+// WithKubeletDir sets the kubelet root directory, under which staging paths
+// and pod targets are looked up when shared mounts are restored
+func WithKubeletDir(dir string) DriverOption {
+	return func(d *Driver) {
+		d.kubeletDir = dir
+	}
+}
+
+// stagingKey identifies a node-wide mount of a whole export. source must be
+// the server:share of the export without any subPath, so every volume on
+// that export with the same type and options maps to the same key.
+func stagingKey(fsType, source string, options []string) string {
+	return fsType + "\x00" + source + "\x00" + strings.Join(options, ",")
+}
+
+// sharedMountPath returns where source is mounted with the given type and options.
+// Volumes on the same share with different options get separate mounts.
+func sharedMountPath(dir, fsType, source string, options []string) string {
+	sum := sha256.Sum256([]byte(stagingKey(fsType, source, options)))
+	return filepath.Join(dir, hex.EncodeToString(sum[:8]))
+}
+
+// rebuildSharedMounts restores the shared mount reference counts and the
+// targets using each staging path from the mount table after a restart, and
+// unmounts shared mounts nothing refers to.
+//
+// Only the staging paths and pod targets kubelet keeps for this driver's
+// volumes are considered, and only if they bind-mount a shared mount: same
+// filesystem, same source and a root inside the shared mount's. Any other
+// mount of the same export, e.g. a direct publish or another driver's, is
+// its own NFS mount and does not hold a shared mount.
+func (d *Driver) rebuildSharedMounts() error {
+	infos, err := listMountInfo()
+	if err != nil {
+		return fmt.Errorf("failed to read the mount table: %w", err)
+	}
+
+	var shared []mount.MountInfo
+	for _, info := range infos {
+		if filepath.Dir(info.MountPoint) == d.sharedMountDir {
+			shared = append(shared, info)
+		}
+	}
+
+	var stagingBinds, targetBinds []kubeletBind
+	for _, info := range infos {
+		volumeDir, staging, ok := d.kubeletVolumeDir(info.MountPoint)
+		if !ok {
+			continue
+		}
+		volume, err := d.readKubeletVolume(volumeDir)
+		if err != nil {
+			klog.V(4).Infof("Skipping mount %s: %v", info.MountPoint, err)
+			continue
+		}
+		if volume.DriverName != d.name {
+			continue
+		}
+		bind := kubeletBind{info: info, volumeHandle: volume.VolumeHandle}
+		if staging {
+			stagingBinds = append(stagingBinds, bind)
+		} else {
+			targetBinds = append(targetBinds, bind)
+		}
+	}
+
+	d.staged.mu.Lock()
+	defer d.staged.mu.Unlock()
+
+	// Shared mounts an ambiguous bind may come from are kept
+	keep := make(map[string]bool)
+
+	// A staging path binds the whole shared mount
+	staged := make(map[string][]kubeletBind) // volume handle -> staging paths
+	for _, bind := range stagingBinds {
+		matches := bindSources(shared, bind.info, true)
+		switch len(matches) {
+		case 0:
+			continue
+		case 1:
+			d.staged.shared.add(matches[0].MountPoint, bind.info.MountPoint)
+			staged[bind.volumeHandle] = append(staged[bind.volumeHandle], bind)
+		default:
+			klog.Warningf("Staging path %s matches %d shared mounts of %s, not counting it", bind.info.MountPoint, len(matches), bind.info.Source)
+			for _, match := range matches {
+				keep[match.MountPoint] = true
+			}
+		}
+	}
+
+	// A pod target binds a directory of its volume's staging path
+	for _, bind := range targetBinds {
+		var stagingPaths []string
+		for _, staging := range staged[bind.volumeHandle] {
+			if isBindOf(staging.info, bind.info, false) {
+				stagingPaths = append(stagingPaths, staging.info.MountPoint)
+			}
+		}
+		switch len(stagingPaths) {
+		case 0:
+			// Published directly, or its staging path is gone
+			continue
+		case 1:
+			d.staged.addLocked(stagingPaths[0], bind.info.MountPoint)
+		default:
+			klog.Warningf("Target %s matches %d staging paths of volume %s, not counting it", bind.info.MountPoint, len(stagingPaths), bind.volumeHandle)
+			for _, stagingPath := range stagingPaths {
+				keep[d.staged.shared.paths[stagingPath]] = true
+			}
+		}
+	}
+
+	ctx := context.Background()
+	for stagingPath := range d.staged.refs {
+		d.logV(ctx, subsystemNode, 2).Infof("Restored staging path %s with %d target(s)", stagingPath, d.staged.countLocked(stagingPath))
+	}
+	for _, info := range shared {
+		if n := d.staged.shared.count(info.MountPoint); n > 0 {
+			d.logV(ctx, subsystemNode, 2).Infof("Restored shared mount %s of %s with %d reference(s)", info.MountPoint, info.Source, n)
+			continue
+		}
+		if keep[info.MountPoint] {
+			continue
+		}
+		klog.Infof("Unmounting unreferenced shared mount %s of %s", info.MountPoint, info.Source)
+		if err := mount.CleanupMountPoint(info.MountPoint, d.mounter, true); err != nil {
+			klog.Warningf("Failed to unmount %s: %v", info.MountPoint, err)
+		}
+	}
+
+	return nil
+}
+
+// kubeletBind is a mount of one of this driver's volumes in the kubelet directory
+type kubeletBind struct {
+	info         mount.MountInfo
+	volumeHandle string
+}
+
+// bindSources returns the shared mounts info may be a bind mount of.
+// whole only matches binds of the entire shared mount.
+func bindSources(shared []mount.MountInfo, info mount.MountInfo, whole bool) []mount.MountInfo {
+	var matches []mount.MountInfo
+	for _, source := range shared {
+		if isBindOf(source, info, whole) {
+			matches = append(matches, source)
+		}
+	}
+	return matches
+}
+
+// isBindOf reports whether info may be a bind mount of source, or of a
+// directory in it unless whole is set. Bind mounts keep the device and source
+// of the mount they come from and show the directory they expose as their root.
+func isBindOf(source, info mount.MountInfo, whole bool) bool {
+	if info.Major != source.Major || info.Minor != source.Minor || info.Source != source.Source {
+		return false
+	}
+	if info.Root == source.Root {
+		return true
+	}
+	return !whole && strings.HasPrefix(info.Root, strings.TrimSuffix(source.Root, "/")+"/")
+}
+
+// kubeletVolumeDir returns the directory kubelet keeps for the CSI volume
+// path is mounted for, and whether path is its staging path rather than a
+// pod target or a mount below one. ok is false for any other path.
+func (d *Driver) kubeletVolumeDir(path string) (volumeDir string, staging bool, ok bool) {
+	rel, err := filepath.Rel(d.kubeletDir, path)
+	if err != nil {
+		return "", false, false
+	}
+	parts := strings.Split(rel, string(filepath.Separator))
+
+	// plugins/kubernetes.io/csi/<driver>/<hash>/globalmount, or
+	// plugins/kubernetes.io/csi/pv/<pv>/globalmount on older kubelets
+	if len(parts) == 6 && parts[0] == "plugins" && parts[1] == "kubernetes.io" && parts[2] == "csi" && parts[5] == "globalmount" {
+		return filepath.Dir(path), true, true
+	}
+	// pods/<uid>/volumes/kubernetes.io~csi/<pv>/mount[/<writableSubPath>]
+	if len(parts) >= 6 && parts[0] == "pods" && parts[2] == "volumes" && parts[3] == "kubernetes.io~csi" && parts[5] == "mount" {
+		return filepath.Join(d.kubeletDir, filepath.Join(parts[:5]...)), false, true
+	}
+	return "", false, false
+}
+
+// readKubeletVolume reads the vol_data.json kubelet keeps in volumeDir
+func (d *Driver) readKubeletVolume(volumeDir string) (kubeletVolume, error) {
+	var volume kubeletVolume
+	f, err := d.filesystem.Open(filepath.Join(volumeDir, volDataFile))
+	if err != nil {
+		return volume, err
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(&volume); err != nil {
+		return volume, fmt.Errorf("failed to decode %s in %s: %w", volDataFile, volumeDir, err)
+	}
+	return volume, nil
+}
+
+// getBaseSource returns the NFS source for the whole share on the first
+// server, ignoring any subPath
+func getBaseSource(volumeContext map[string]string) (string, error) {
+	sources, err := getBaseSources(volumeContext)
+	if err != nil {
+		return "", err
+	}
+	return sources[0], nil
+}
+
+// getBaseSources returns the NFS sources for the whole share, one per server
+// in the order they should be tried
+func getBaseSources(volumeContext map[string]string) ([]string, error) {
+	servers := getServers(volumeContext)
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("server parameter is required")
+	}
+
+	share, err := getShare(volumeContext)
+	if err != nil {
+		return nil, err
+	}
+
+	return nfsSources(servers, share), nil
+}
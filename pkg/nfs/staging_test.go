@@ -0,0 +1,348 @@
+package nfs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/mount-utils"
+)
+
+func newStagingDriver(t *testing.T, fakeMounter *mount.FakeMounter) *Driver {
+	sharedDir := filepath.Join(t.TempDir(), "shared")
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock",
+		WithMounter(fakeMounter), WithStaging(true), WithSharedMountDir(sharedDir))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+	return driver
+}
+
+func stageRequest(volumeID, stagingPath string) *csi.NodeStageVolumeRequest {
+	return &csi.NodeStageVolumeRequest{
+		VolumeId:          volumeID,
+		StagingTargetPath: stagingPath,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+			},
+		},
+		VolumeContext: map[string]string{
+			"server":  "192.168.1.1",
+			"share":   "/data",
+			"subPath": volumeID,
+		},
+	}
+}
+
+// nfsMounts counts the real NFS mounts, ignoring bind mounts
+func nfsMounts(fakeMounter *mount.FakeMounter) int {
+	mountPoints, _ := fakeMounter.List()
+	n := 0
+	for _, mp := range mountPoints {
+		if mp.Type == "nfs" {
+			n++
+		}
+	}
+	return n
+}
+
+func TestNodeStageVolume_SharesMount(t *testing.T) {
+	fakeMounter := mount.NewFakeMounter([]mount.MountPoint{})
+	driver := newStagingDriver(t, fakeMounter)
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	for _, volumeID := range []string{"vol-a", "vol-b"} {
+		if _, err := driver.NodeStageVolume(ctx, stageRequest(volumeID, filepath.Join(dir, volumeID))); err != nil {
+			t.Fatalf("NodeStageVolume(%s) failed: %v", volumeID, err)
+		}
+	}
+	if n := nfsMounts(fakeMounter); n != 1 {
+		t.Fatalf("Expected 1 shared NFS mount, got %d: %+v", n, fakeMounter.MountPoints)
+	}
+
+	if _, err := driver.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{VolumeId: "vol-a", StagingTargetPath: filepath.Join(dir, "vol-a")}); err != nil {
+		t.Fatalf("NodeUnstageVolume(vol-a) failed: %v", err)
+	}
+	if n := nfsMounts(fakeMounter); n != 1 {
+		t.Fatalf("Expected shared NFS mount to stay while vol-b is staged, got %d", n)
+	}
+
+	if _, err := driver.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{VolumeId: "vol-b", StagingTargetPath: filepath.Join(dir, "vol-b")}); err != nil {
+		t.Fatalf("NodeUnstageVolume(vol-b) failed: %v", err)
+	}
+	if len(fakeMounter.MountPoints) != 0 {
+		t.Errorf("Expected all mounts removed, got %+v", fakeMounter.MountPoints)
+	}
+}
+
+func TestStagingKey(t *testing.T) {
+	keyFor := func(volumeContext map[string]string) string {
+		t.Helper()
+		source, err := getBaseSource(volumeContext)
+		if err != nil {
+			t.Fatalf("getBaseSource(%v) failed: %v", volumeContext, err)
+		}
+		return stagingKey("nfs", source, []string{"nfsvers=4.1"})
+	}
+
+	a := keyFor(map[string]string{"server": "192.168.1.1", "share": "/data", "subPath": "vol-a"})
+	b := keyFor(map[string]string{"server": "192.168.1.1", "share": "/data", "subPath": "vol-b"})
+	if a != b {
+		t.Errorf("Expected subPaths of one export to share a key, got %q and %q", a, b)
+	}
+
+	if other := keyFor(map[string]string{"server": "192.168.1.1", "share": "/other", "subPath": "vol-a"}); other == a {
+		t.Errorf("Expected a different share to get a different key, got %q", other)
+	}
+	if other := keyFor(map[string]string{"server": "192.168.1.2", "share": "/data", "subPath": "vol-a"}); other == a {
+		t.Errorf("Expected a different server to get a different key, got %q", other)
+	}
+	if other := stagingKey("nfs", "192.168.1.1:/data", []string{"nfsvers=3"}); other == a {
+		t.Errorf("Expected different options to get a different key, got %q", other)
+	}
+}
+
+func TestNodeStageVolume_SubPathsReuseStagedMount(t *testing.T) {
+	fakeMounter := mount.NewFakeMounter([]mount.MountPoint{})
+	driver := newStagingDriver(t, fakeMounter)
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	for _, volumeID := range []string{"vol-a", "vol-b"} {
+		if _, err := driver.NodeStageVolume(ctx, stageRequest(volumeID, filepath.Join(dir, volumeID))); err != nil {
+			t.Fatalf("NodeStageVolume(%s) failed: %v", volumeID, err)
+		}
+	}
+
+	log := fakeMounter.GetLog()
+	if len(log) != 3 {
+		t.Fatalf("Expected 1 NFS mount and 2 bind mounts, got %+v", log)
+	}
+	if log[0].Source != "192.168.1.1:/data" {
+		t.Errorf("Expected the base export to be mounted, got %s", log[0].Source)
+	}
+	shared := log[0].Target
+	if n := driver.staged.shared.count(shared); n != 2 {
+		t.Errorf("Expected both volumes to reference %s, got %d", shared, n)
+	}
+	for _, action := range log[1:] {
+		if action.FSType != "" {
+			t.Errorf("Expected bind mount, got %+v", action)
+		}
+	}
+}
+
+func TestNodeStageVolume_ConcurrentStageUnstage(t *testing.T) {
+	fakeMounter := mount.NewFakeMounter([]mount.MountPoint{})
+	driver := newStagingDriver(t, fakeMounter)
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	const volumes = 20
+	run := func(fn func(volumeID string) error) {
+		var wg sync.WaitGroup
+		errs := make(chan error, volumes)
+		for i := 0; i < volumes; i++ {
+			wg.Add(1)
+			go func(volumeID string) {
+				defer wg.Done()
+				if err := fn(volumeID); err != nil {
+					errs <- fmt.Errorf("%s: %w", volumeID, err)
+				}
+			}(fmt.Sprintf("vol-%d", i))
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Error(err)
+		}
+	}
+
+	run(func(volumeID string) error {
+		_, err := driver.NodeStageVolume(ctx, stageRequest(volumeID, filepath.Join(dir, volumeID)))
+		return err
+	})
+	if n := nfsMounts(fakeMounter); n != 1 {
+		t.Fatalf("Expected 1 shared NFS mount, got %d", n)
+	}
+
+	run(func(volumeID string) error {
+		_, err := driver.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{VolumeId: volumeID, StagingTargetPath: filepath.Join(dir, volumeID)})
+		return err
+	})
+	if len(fakeMounter.MountPoints) != 0 {
+		t.Errorf("Expected all mounts removed, got %+v", fakeMounter.MountPoints)
+	}
+}
+
+// stubMountInfo makes listMountInfo return infos
+func stubMountInfo(t *testing.T, infos []mount.MountInfo) {
+	t.Helper()
+	orig := listMountInfo
+	listMountInfo = func() ([]mount.MountInfo, error) { return infos, nil }
+	t.Cleanup(func() { listMountInfo = orig })
+}
+
+// restartedNode creates the mount points and vol_data.json files (volume
+// directory -> driver name and volume handle) a restarted driver finds, and
+// returns a fake mounter holding the mounts
+func restartedNode(t *testing.T, infos []mount.MountInfo, volumes map[string]kubeletVolume) *mount.FakeMounter {
+	t.Helper()
+	stubMountInfo(t, infos)
+
+	var mountPoints []mount.MountPoint
+	for _, info := range infos {
+		if err := os.MkdirAll(info.MountPoint, 0750); err != nil {
+			t.Fatalf("Failed to create %s: %v", info.MountPoint, err)
+		}
+		mountPoints = append(mountPoints, mount.MountPoint{Device: info.Source, Path: info.MountPoint, Type: info.FsType})
+	}
+	for dir, volume := range volumes {
+		data, err := json.Marshal(volume)
+		if err != nil {
+			t.Fatalf("Failed to encode %s: %v", volDataFile, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, volDataFile), data, 0640); err != nil {
+			t.Fatalf("Failed to write %s: %v", volDataFile, err)
+		}
+	}
+	return mount.NewFakeMounter(mountPoints)
+}
+
+// podVolumeDir returns the directory kubelet keeps for a CSI volume of a pod
+func podVolumeDir(kubeletDir, podUID, pvName string) string {
+	return filepath.Join(kubeletDir, "pods", podUID, "volumes", "kubernetes.io~csi", pvName)
+}
+
+// stagingVolumeDir returns the directory kubelet keeps for a staged CSI volume
+func stagingVolumeDir(kubeletDir, volumeHash string) string {
+	return filepath.Join(kubeletDir, "plugins", "kubernetes.io", "csi", DefaultDriverName, volumeHash)
+}
+
+func newRestartedDriver(t *testing.T, kubeletDir string, fakeMounter *mount.FakeMounter) *Driver {
+	t.Helper()
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock",
+		WithMounter(fakeMounter), WithStaging(true), WithKubeletDir(kubeletDir),
+		WithSharedMountDir(filepath.Join(kubeletDir, "plugins", DefaultDriverName, "shared")))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+	if err := driver.rebuildSharedMounts(); err != nil {
+		t.Fatalf("rebuildSharedMounts failed: %v", err)
+	}
+	return driver
+}
+
+func hasMount(fakeMounter *mount.FakeMounter, path string) bool {
+	for _, mp := range fakeMounter.MountPoints {
+		if mp.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRebuildSharedMounts(t *testing.T) {
+	kubeletDir := t.TempDir()
+	sharedDir := filepath.Join(kubeletDir, "plugins", DefaultDriverName, "shared")
+	usedPath := filepath.Join(sharedDir, "used")
+	orphanPath := filepath.Join(sharedDir, "orphan")
+	stagingDir := stagingVolumeDir(kubeletDir, "a1")
+	stagingPath := filepath.Join(stagingDir, "globalmount")
+	targetDir := podVolumeDir(kubeletDir, "pod-1", "pv-a")
+	targetPath := filepath.Join(targetDir, "mount")
+
+	fakeMounter := restartedNode(t, []mount.MountInfo{
+		{Major: 0, Minor: 50, Root: "/data", Source: "192.168.1.1:/data", MountPoint: usedPath, FsType: "nfs"},
+		{Major: 0, Minor: 51, Root: "/other", Source: "192.168.1.2:/other", MountPoint: orphanPath, FsType: "nfs"},
+		{Major: 0, Minor: 50, Root: "/data", Source: "192.168.1.1:/data", MountPoint: stagingPath, FsType: "nfs"},
+		{Major: 0, Minor: 50, Root: "/data/vol-a", Source: "192.168.1.1:/data", MountPoint: targetPath, FsType: "nfs"},
+		{Major: 8, Minor: 1, Root: "/", Source: "/dev/sda1", MountPoint: "/", FsType: "ext4"},
+	}, map[string]kubeletVolume{
+		stagingDir: {DriverName: DefaultDriverName, VolumeHandle: "vol-a"},
+		targetDir:  {DriverName: DefaultDriverName, VolumeHandle: "vol-a"},
+	})
+	driver := newRestartedDriver(t, kubeletDir, fakeMounter)
+
+	if n := driver.staged.shared.count(usedPath); n != 2 {
+		t.Errorf("Expected 2 references to %s, got %d", usedPath, n)
+	}
+	if n := driver.staged.countLocked(stagingPath); n != 1 {
+		t.Errorf("Expected the target to use %s, got %d", stagingPath, n)
+	}
+	if hasMount(fakeMounter, orphanPath) {
+		t.Errorf("Expected unreferenced shared mount %s to be unmounted", orphanPath)
+	}
+
+	// The restored targets keep the volume staged until they are unpublished
+	ctx := context.Background()
+	unstage := &csi.NodeUnstageVolumeRequest{VolumeId: "vol-a", StagingTargetPath: stagingPath}
+	if _, err := driver.NodeUnstageVolume(ctx, unstage); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("Expected FailedPrecondition while the target is published, got %v", err)
+	}
+	if _, err := driver.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: "vol-a", TargetPath: targetPath}); err != nil {
+		t.Fatalf("NodeUnpublishVolume failed: %v", err)
+	}
+	if _, err := driver.NodeUnstageVolume(ctx, unstage); err != nil {
+		t.Fatalf("NodeUnstageVolume failed: %v", err)
+	}
+	if hasMount(fakeMounter, usedPath) {
+		t.Errorf("Expected shared mount %s to be unmounted after the last unstage", usedPath)
+	}
+}
+
+func TestRebuildSharedMounts_OtherMountsOfTheShare(t *testing.T) {
+	kubeletDir := t.TempDir()
+	sharedPath := filepath.Join(kubeletDir, "plugins", DefaultDriverName, "shared", "data")
+	stagingDir := stagingVolumeDir(kubeletDir, "b1")
+	directDir := podVolumeDir(kubeletDir, "pod-1", "pv-b")
+	otherDriverDir := podVolumeDir(kubeletDir, "pod-2", "pv-c")
+	staleStagingDir := stagingVolumeDir(kubeletDir, "c1")
+
+	// The same export with the same options shares the NFS superblock, so
+	// these mounts all have the shared mount's device and source
+	shared := func(root, path string) mount.MountInfo {
+		return mount.MountInfo{Major: 0, Minor: 50, Root: root, Source: "192.168.1.1:/data", MountPoint: path, FsType: "nfs"}
+	}
+	fakeMounter := restartedNode(t, []mount.MountInfo{
+		shared("/data", sharedPath),
+		// vol-b published before staging was turned on
+		shared("/data/vol-b", filepath.Join(directDir, "mount")),
+		// Another driver's volume on the same export
+		shared("/data", filepath.Join(otherDriverDir, "mount")),
+		// A staging path kubelet no longer knows about
+		shared("/data", filepath.Join(staleStagingDir, "globalmount")),
+		// A mount below a staging path and one outside the kubelet directory
+		shared("/data", filepath.Join(stagingDir, "globalmount", "nested")),
+		shared("/data", filepath.Join(t.TempDir(), "mnt")),
+	}, map[string]kubeletVolume{
+		directDir:      {DriverName: DefaultDriverName, VolumeHandle: "vol-b"},
+		otherDriverDir: {DriverName: "nfs.csi.k8s.io", VolumeHandle: "vol-c"},
+	})
+	driver := newRestartedDriver(t, kubeletDir, fakeMounter)
+
+	if n := driver.staged.shared.count(sharedPath); n != 0 {
+		t.Errorf("Expected no references to %s, got %d", sharedPath, n)
+	}
+	if len(driver.staged.refs) != 0 {
+		t.Errorf("Expected no staged targets, got %v", driver.staged.refs)
+	}
+	if hasMount(fakeMounter, sharedPath) {
+		t.Errorf("Expected unreferenced shared mount %s to be unmounted", sharedPath)
+	}
+	if len(fakeMounter.MountPoints) != 5 {
+		t.Errorf("Expected the other mounts of the share to be left alone, got %+v", fakeMounter.MountPoints)
+	}
+}
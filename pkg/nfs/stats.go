@@ -0,0 +1,121 @@
+package nfs
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// WithVolumeStats enables NodeGetVolumeStats. With useDU the used bytes of
+// volumes with a known capacity are computed by walking the volume, which
+// reads every directory and can be slow on large volumes.
+func WithVolumeStats(useDU bool) DriverOption {
+	return func(d *Driver) {
+		d.volumeStats = true
+		d.volumeStatsDU = useDU
+	}
+}
+
+// volumeCapacities remembers the capacity requested for each published
+// target, since NodeGetVolumeStats does not get the volume context
+type volumeCapacities struct {
+	mu      sync.Mutex
+	targets map[string]int64
+}
+
+func (c *volumeCapacities) set(target string, capacity int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.targets == nil {
+		c.targets = make(map[string]int64)
+	}
+	c.targets[target] = capacity
+}
+
+func (c *volumeCapacities) get(target string) (int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	capacity, ok := c.targets[target]
+	return capacity, ok
+}
+
+func (c *volumeCapacities) remove(target string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.targets, target)
+}
+
+// recordCapacity remembers the capacityBytes from the volume context for
+// target. Volumes created without a capacity are not recorded.
+func (d *Driver) recordCapacity(target string, volumeContext map[string]string) {
+	capacity, err := strconv.ParseInt(volumeContext[ParamCapacityBytes], 10, 64)
+	if err != nil || capacity <= 0 {
+		return
+	}
+	d.capacities.set(target, capacity)
+}
+
+// fsUsage is the usage of the filesystem holding a path
+type fsUsage struct {
+	totalBytes, availableBytes, usedBytes    int64
+	totalInodes, availableInodes, usedInodes int64
+}
+
+// statfsUsage returns the usage of the filesystem holding path; for NFS this
+// is the whole export. It is replaced in tests.
+var statfsUsage = func(path string) (fsUsage, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return fsUsage{}, err
+	}
+	bsize := int64(st.Bsize)
+	return fsUsage{
+		totalBytes:      int64(st.Blocks) * bsize,
+		availableBytes:  int64(st.Bavail) * bsize,
+		usedBytes:       int64(st.Blocks-st.Bfree) * bsize,
+		totalInodes:     int64(st.Files),
+		availableInodes: int64(st.Ffree),
+		usedInodes:      int64(st.Files - st.Ffree),
+	}, nil
+}
+
+// diskUsage returns the bytes allocated to the files below path, like du,
+// counting hard-linked files once. It stops when ctx ends. It is replaced
+// in tests.
+var diskUsage = func(ctx context.Context, path string) (int64, error) {
+	type inode struct{ dev, ino uint64 }
+	seen := make(map[inode]bool)
+
+	var used int64
+	err := filepath.WalkDir(path, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		st, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			used += info.Size()
+			return nil
+		}
+		if st.Nlink > 1 && !entry.IsDir() {
+			key := inode{dev: uint64(st.Dev), ino: st.Ino}
+			if seen[key] {
+				return nil
+			}
+			seen[key] = true
+		}
+		// st_blocks is always in 512-byte units
+		used += st.Blocks * 512
+		return nil
+	})
+	return used, err
+}
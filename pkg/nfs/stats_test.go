@@ -0,0 +1,320 @@
+package nfs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/mount-utils"
+)
+
+// stubVolumeStats replaces statfs with usage of a 100 GiB export and du with
+// a fixed used size
+func stubVolumeStats(t *testing.T, used int64) *int {
+	origStatfs, origDU := statfsUsage, diskUsage
+	t.Cleanup(func() { statfsUsage, diskUsage = origStatfs, origDU })
+
+	statfsUsage = func(path string) (fsUsage, error) {
+		return fsUsage{
+			totalBytes: 100 << 30, availableBytes: 60 << 30, usedBytes: 40 << 30,
+			totalInodes: 1000, availableInodes: 900, usedInodes: 100,
+		}, nil
+	}
+	calls := 0
+	diskUsage = func(ctx context.Context, path string) (int64, error) {
+		calls++
+		return used, nil
+	}
+	return &calls
+}
+
+func TestCreateVolume_CapacityBytes(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock")
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		capacityRange *csi.CapacityRange
+		want          int64
+		wantCode      codes.Code
+	}{
+		{name: "no capacity"},
+		{name: "required bytes", capacityRange: &csi.CapacityRange{RequiredBytes: 5 << 30, LimitBytes: 10 << 30}, want: 5 << 30},
+		{name: "limit only", capacityRange: &csi.CapacityRange{LimitBytes: 10 << 30}, want: 10 << 30},
+		{name: "required over limit", capacityRange: &csi.CapacityRange{RequiredBytes: 20 << 30, LimitBytes: 10 << 30}, wantCode: codes.InvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := driver.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name:          "test-volume",
+				CapacityRange: tt.capacityRange,
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+						},
+					},
+				},
+				Parameters: map[string]string{"server": "192.168.1.100", "share": "/exports/data"},
+			})
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("Expected %v, got %v", tt.wantCode, err)
+			}
+			if err != nil {
+				return
+			}
+
+			volume := resp.GetVolume()
+			got, ok := volume.GetVolumeContext()["capacityBytes"]
+			if tt.want == 0 && ok {
+				t.Errorf("Expected no capacityBytes in the volume context, got %q", got)
+			}
+			if tt.want != 0 && got != fmt.Sprint(tt.want) {
+				t.Errorf("Expected capacityBytes %d in the volume context, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNodeGetVolumeStats(t *testing.T) {
+	tests := []struct {
+		name          string
+		useDU         bool
+		capacityBytes string
+		want          *csi.VolumeUsage
+		wantDU        bool
+	}{
+		{
+			name: "export usage without a capacity",
+			want: &csi.VolumeUsage{Unit: csi.VolumeUsage_BYTES, Total: 100 << 30, Available: 60 << 30, Used: 40 << 30},
+		},
+		{
+			name:          "capacity as total",
+			capacityBytes: "10737418240",
+			want:          &csi.VolumeUsage{Unit: csi.VolumeUsage_BYTES, Total: 10 << 30, Available: 10 << 30},
+		},
+		{
+			name:          "capacity larger than the free space",
+			capacityBytes: "107374182400",
+			want:          &csi.VolumeUsage{Unit: csi.VolumeUsage_BYTES, Total: 100 << 30, Available: 60 << 30},
+		},
+		{
+			name:          "used from du",
+			useDU:         true,
+			capacityBytes: "10737418240",
+			want:          &csi.VolumeUsage{Unit: csi.VolumeUsage_BYTES, Total: 10 << 30, Available: 8 << 30, Used: 2 << 30},
+			wantDU:        true,
+		},
+		{
+			name:  "no du without a capacity",
+			useDU: true,
+			want:  &csi.VolumeUsage{Unit: csi.VolumeUsage_BYTES, Total: 100 << 30, Available: 60 << 30, Used: 40 << 30},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			duCalls := stubVolumeStats(t, 2<<30)
+			fakeMounter := mount.NewFakeMounter([]mount.MountPoint{})
+			driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock",
+				WithMounter(fakeMounter), WithVolumeStats(tt.useDU))
+			if err != nil {
+				t.Fatalf("Failed to create driver: %v", err)
+			}
+
+			target := filepath.Join(t.TempDir(), "target")
+			volumeContext := map[string]string{"server": "192.168.1.1", "share": "/data"}
+			if tt.capacityBytes != "" {
+				volumeContext["capacityBytes"] = tt.capacityBytes
+			}
+			_, err = driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:   "test-volume",
+				TargetPath: target,
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{},
+					},
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+					},
+				},
+				VolumeContext: volumeContext,
+			})
+			if err != nil {
+				t.Fatalf("NodePublishVolume failed: %v", err)
+			}
+
+			resp, err := driver.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{VolumeId: "test-volume", VolumePath: target})
+			if err != nil {
+				t.Fatalf("NodeGetVolumeStats failed: %v", err)
+			}
+			usage := resp.GetUsage()
+			if len(usage) != 2 {
+				t.Fatalf("Expected bytes and inodes usage, got %v", usage)
+			}
+			got := usage[0]
+			if got.GetUnit() != tt.want.Unit || got.GetTotal() != tt.want.Total || got.GetAvailable() != tt.want.Available || got.GetUsed() != tt.want.Used {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+			if usage[1].GetUnit() != csi.VolumeUsage_INODES || usage[1].GetTotal() != 1000 {
+				t.Errorf("Expected inodes of the export, got %v", usage[1])
+			}
+			if (*duCalls > 0) != tt.wantDU {
+				t.Errorf("Expected du to run: %v, got %d calls", tt.wantDU, *duCalls)
+			}
+		})
+	}
+}
+
+func TestNodeGetVolumeStats_Inodes(t *testing.T) {
+	tests := []struct {
+		name       string
+		usage      fsUsage
+		wantInodes *csi.VolumeUsage
+	}{
+		{
+			name: "inodes reported",
+			usage: fsUsage{
+				totalBytes: 10 << 30, availableBytes: 9 << 30, usedBytes: 1 << 30,
+				totalInodes: 5000, availableInodes: 1200, usedInodes: 3800,
+			},
+			wantInodes: &csi.VolumeUsage{Unit: csi.VolumeUsage_INODES, Total: 5000, Available: 1200, Used: 3800},
+		},
+		{
+			name:  "no inodes from the server",
+			usage: fsUsage{totalBytes: 10 << 30, availableBytes: 9 << 30, usedBytes: 1 << 30},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orig := statfsUsage
+			t.Cleanup(func() { statfsUsage = orig })
+			statfsUsage = func(path string) (fsUsage, error) { return tt.usage, nil }
+
+			driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithVolumeStats(false))
+			if err != nil {
+				t.Fatalf("Failed to create driver: %v", err)
+			}
+			resp, err := driver.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{VolumeId: "test-volume", VolumePath: t.TempDir()})
+			if err != nil {
+				t.Fatalf("NodeGetVolumeStats failed: %v", err)
+			}
+
+			usage := resp.GetUsage()
+			wantLen := 1
+			if tt.wantInodes != nil {
+				wantLen = 2
+			}
+			if len(usage) != wantLen {
+				t.Fatalf("Expected %d usage entries, got %v", wantLen, usage)
+			}
+			if got := usage[0]; got.GetUnit() != csi.VolumeUsage_BYTES || got.GetTotal() != 10<<30 || got.GetAvailable() != 9<<30 || got.GetUsed() != 1<<30 {
+				t.Errorf("Unexpected bytes usage %v", got)
+			}
+			if tt.wantInodes != nil {
+				got := usage[1]
+				if got.GetUnit() != tt.wantInodes.Unit || got.GetTotal() != tt.wantInodes.Total || got.GetAvailable() != tt.wantInodes.Available || got.GetUsed() != tt.wantInodes.Used {
+					t.Errorf("Expected %v, got %v", tt.wantInodes, got)
+				}
+			}
+		})
+	}
+}
+
+func TestNodeGetVolumeStats_Expansion(t *testing.T) {
+	stubVolumeStats(t, 0)
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithVolumeStats(false), WithVolumeExpansion(true))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+	volumePath := t.TempDir()
+
+	if _, err := driver.NodeExpandVolume(context.Background(), &csi.NodeExpandVolumeRequest{
+		VolumeId:      "test-volume",
+		VolumePath:    volumePath,
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 20 << 30},
+	}); err != nil {
+		t.Fatalf("NodeExpandVolume failed: %v", err)
+	}
+
+	resp, err := driver.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{VolumeId: "test-volume", VolumePath: volumePath})
+	if err != nil {
+		t.Fatalf("NodeGetVolumeStats failed: %v", err)
+	}
+	if total := resp.GetUsage()[0].GetTotal(); total != 20<<30 {
+		t.Errorf("Expected the expanded capacity as total, got %d", total)
+	}
+}
+
+func TestNodeGetVolumeStats_Errors(t *testing.T) {
+	stubVolumeStats(t, 0)
+
+	disabled, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock")
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+	if _, err := disabled.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{VolumeId: "v", VolumePath: t.TempDir()}); status.Code(err) != codes.Unimplemented {
+		t.Errorf("Expected Unimplemented without --enable-volume-stats, got %v", err)
+	}
+
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithVolumeStats(false))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+	for _, tt := range []struct {
+		req  *csi.NodeGetVolumeStatsRequest
+		want codes.Code
+	}{
+		{req: &csi.NodeGetVolumeStatsRequest{VolumePath: t.TempDir()}, want: codes.InvalidArgument},
+		{req: &csi.NodeGetVolumeStatsRequest{VolumeId: "v"}, want: codes.InvalidArgument},
+		{req: &csi.NodeGetVolumeStatsRequest{VolumeId: "v", VolumePath: filepath.Join(t.TempDir(), "missing")}, want: codes.NotFound},
+	} {
+		if _, err := driver.NodeGetVolumeStats(context.Background(), tt.req); status.Code(err) != tt.want {
+			t.Errorf("NodeGetVolumeStats(%v): expected %v, got %v", tt.req, tt.want, err)
+		}
+	}
+}
+
+func TestDiskUsage(t *testing.T) {
+	dir := t.TempDir()
+	data := make([]byte, 64<<10)
+	if err := os.WriteFile(filepath.Join(dir, "a"), data, 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0750); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sub", "b"), data, 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := os.Link(filepath.Join(dir, "sub", "b"), filepath.Join(dir, "c")); err != nil {
+		t.Fatalf("Failed to link file: %v", err)
+	}
+
+	used, err := diskUsage(context.Background(), dir)
+	if err != nil {
+		t.Fatalf("diskUsage failed: %v", err)
+	}
+	// Two files of data; the hard link is not counted again
+	if used < 2*int64(len(data)) || used >= 3*int64(len(data)) {
+		t.Errorf("Expected about %d bytes used, got %d", 2*len(data), used)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := diskUsage(ctx, dir); err == nil {
+		t.Error("Expected diskUsage to stop once the context ends")
+	}
+}
@@ -0,0 +1,60 @@
+package nfs
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"k8s.io/klog/v2"
+)
+
+// testMountVolumeID names the volume in logs and events during TestMount
+const testMountVolumeID = "test-mount"
+
+// TestMount checks that the volume described by volumeContext can be mounted
+// on this node. It runs NodePublishVolume against a temporary target directory
+// and unpublishes it again, so the same validation, mount options and error
+// classification apply; the returned error carries the gRPC code the CO
+// would have seen.
+func (d *Driver) TestMount(ctx context.Context, volumeContext map[string]string, mountFlags []string) error {
+	dir, err := d.filesystem.MkdirTemp("", "nfs-test-mount-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	// Remove, not RemoveAll: if the unmount fails the export must not be emptied
+	defer func() {
+		if err := d.filesystem.Remove(dir); err != nil {
+			klog.Warningf("Failed to remove %s: %v", dir, err)
+		}
+	}()
+	target := filepath.Join(dir, "target")
+
+	_, publishErr := d.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+		VolumeId:   testMountVolumeID,
+		TargetPath: target,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{MountFlags: mountFlags},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			},
+		},
+		VolumeContext: volumeContext,
+	})
+
+	// Unpublish even after a failure so a half-created target is cleaned up
+	_, unpublishErr := d.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{
+		VolumeId:   testMountVolumeID,
+		TargetPath: target,
+	})
+
+	if publishErr != nil {
+		return publishErr
+	}
+	if unpublishErr != nil {
+		return fmt.Errorf("mounted, but failed to unmount %s: %w", target, unpublishErr)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package nfs
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/mount-utils"
+)
+
+func TestTestMount(t *testing.T) {
+	tests := []struct {
+		name     string
+		failing  map[string]bool
+		ctx      map[string]string
+		wantCode codes.Code
+	}{
+		{
+			name: "mounts and unmounts",
+			ctx:  map[string]string{"server": "192.168.1.1", "share": "/data", "subPath": "app"},
+		},
+		{
+			name:     "classified mount failure",
+			failing:  map[string]bool{"192.168.1.1:/data": true},
+			ctx:      map[string]string{"server": "192.168.1.1", "share": "/data"},
+			wantCode: codes.Internal,
+		},
+		{
+			name:     "invalid parameters",
+			ctx:      map[string]string{"share": "/data"},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TMPDIR", t.TempDir())
+			fakeMounter := &failingMounter{FakeMounter: mount.NewFakeMounter([]mount.MountPoint{}), failing: tt.failing}
+			driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithMounter(fakeMounter))
+			if err != nil {
+				t.Fatalf("Failed to create driver: %v", err)
+			}
+
+			err = driver.TestMount(context.Background(), tt.ctx, []string{"nfsvers=4.1"})
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("Expected code %v, got %v", tt.wantCode, err)
+			}
+			if tt.wantCode != codes.OK {
+				return
+			}
+
+			log := fakeMounter.GetLog()
+			if len(log) != 2 || log[0].Action != mount.FakeActionMount || log[1].Action != mount.FakeActionUnmount {
+				t.Errorf("Expected a mount followed by an unmount, got %+v", log)
+			}
+			if len(fakeMounter.MountPoints) != 0 {
+				t.Errorf("Expected nothing left mounted, got %+v", fakeMounter.MountPoints)
+			}
+		})
+	}
+}
+
+func TestTestMount_UnmountFailure(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	fakeMounter := mount.NewFakeMounter([]mount.MountPoint{})
+	fakeMounter.UnmountFunc = func(path string) error {
+		return fmt.Errorf("device is busy")
+	}
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithMounter(fakeMounter))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	err = driver.TestMount(context.Background(), map[string]string{"server": "192.168.1.1", "share": "/data"}, nil)
+	if err == nil {
+		t.Fatal("Expected the unmount failure to be reported")
+	}
+}
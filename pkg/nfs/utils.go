@@ -4,27 +4,85 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net"
+	"net/url"
+	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/google/uuid"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"k8s.io/klog/v2"
 )
 
-func logGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	klog.V(4).Infof("GRPC call: %s", info.FullMethod)
-	klog.V(5).Infof("GRPC request: %+v", req)
+// logGRPC logs every call at the verbosity of its CSI service, tagging the
+// log lines and any error with the request ID
+func (d *Driver) logGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	// Tag every log line for this call with the request ID: handlers log
+	// through d.logV, which reads it from ctx
+	id := requestID(ctx)
+	logger := klog.FromContext(ctx).WithValues("requestID", id)
+	ctx = context.WithValue(klog.NewContext(ctx, logger), requestIDKey{}, id)
+	// Only fails outside a real server stream, e.g. in tests
+	_ = grpc.SetHeader(ctx, metadata.Pairs(RequestIDMetadataKey, id))
 
+	subsystem := methodSubsystem(info.FullMethod)
+	if d.logV(ctx, subsystem, 4).Enabled() {
+		logger.Info("GRPC call", "method", info.FullMethod)
+	}
+	if d.logV(ctx, subsystem, 5).Enabled() {
+		logger.Info("GRPC request", "request", fmt.Sprintf("%+v", redactForLog(req)))
+	}
+
+	start := time.Now()
 	resp, err := handler(ctx, req)
+	if d.logV(ctx, subsystem, 4).Enabled() {
+		logger.Info("GRPC call finished", "method", info.FullMethod, "duration", time.Since(start), "code", status.Code(err))
+	}
 	if err != nil {
-		klog.Errorf("GRPC error: %v", err)
-	} else {
-		klog.V(5).Infof("GRPC response: %+v", resp)
+		logger.Error(err, "GRPC error", "method", info.FullMethod)
+		return resp, withRequestID(err, id)
+	}
+	if d.logV(ctx, subsystem, 5).Enabled() {
+		logger.Info("GRPC response", "response", fmt.Sprintf("%+v", redactForLog(resp)))
+	}
+	return resp, nil
+}
+
+// RequestIDMetadataKey is the gRPC metadata key carrying the ID used to
+// correlate one request across controller and node logs
+const RequestIDMetadataKey = "x-request-id"
+
+// maxRequestIDLength bounds caller-supplied request IDs before they reach the logs
+const maxRequestIDLength = 128
+
+// requestID returns the request ID from the incoming gRPC metadata, or a new
+// UUID if the caller did not send a usable one
+func requestID(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(RequestIDMetadataKey); len(ids) > 0 && ids[0] != "" && len(ids[0]) <= maxRequestIDLength {
+			return ids[0]
+		}
 	}
-	return resp, err
+	return uuid.NewString()
+}
+
+// withRequestID appends the request ID to the message of a gRPC status error,
+// keeping its code and details, so the CO reports it alongside the failure
+func withRequestID(err error, id string) error {
+	st := status.Convert(err).Proto()
+	st.Message = fmt.Sprintf("%s (request ID %s)", st.Message, id)
+	return status.FromProto(st).Err()
 }
 
 // validateVolumeCapability checks if the given capability is supported
@@ -62,6 +120,71 @@ func validateVolumeCapability(cap *csi.VolumeCapability) error {
 	return nil
 }
 
+// validateFSGroupPolicy checks that the fsGroup policy is one of the supported values
+func validateFSGroupPolicy(policy string) error {
+	switch policy {
+	case "", FSGroupPolicyFile, FSGroupPolicyNone:
+		return nil
+	default:
+		return fmt.Errorf("invalid %s %q: must be %s or %s", ParamFSGroupPolicy, policy, FSGroupPolicyFile, FSGroupPolicyNone)
+	}
+}
+
+// getFSType returns the filesystem type to mount with, defaulting to nfs
+func getFSType(volumeContext map[string]string) (string, error) {
+	switch fsType := volumeContext[ParamFSType]; fsType {
+	case "":
+		return FSTypeNFS, nil
+	case FSTypeNFS, FSTypeNFS4:
+		return fsType, nil
+	default:
+		return "", fmt.Errorf("invalid %s %q: must be %s or %s", ParamFSType, fsType, FSTypeNFS, FSTypeNFS4)
+	}
+}
+
+// ParseFileMode parses an octal permission string such as "0750" or "750"
+func ParseFileMode(value string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid file mode %q: must be an octal number", value)
+	}
+	if mode > 0777 {
+		return 0, fmt.Errorf("invalid file mode %q: only permission bits (up to 0777) are allowed", value)
+	}
+	return os.FileMode(mode), nil
+}
+
+// getTargetMode returns the permission of target directories created for a
+// volume: the targetMode parameter if set, otherwise defaultMode
+func getTargetMode(params map[string]string, defaultMode os.FileMode) (os.FileMode, error) {
+	value := params[ParamTargetMode]
+	if value == "" {
+		return defaultMode, nil
+	}
+	mode, err := ParseFileMode(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", ParamTargetMode, err)
+	}
+	if mode&0700 != 0700 {
+		return 0, fmt.Errorf("invalid %s %q: the owner needs read, write and execute permission", ParamTargetMode, value)
+	}
+	return mode, nil
+}
+
+// getCreateTarget reports whether NodePublishVolume creates the target
+// directory, which it does unless createTarget is false
+func getCreateTarget(params map[string]string) (bool, error) {
+	value := params[ParamCreateTarget]
+	if value == "" {
+		return true, nil
+	}
+	create, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q: must be true or false", ParamCreateTarget, value)
+	}
+	return create, nil
+}
+
 const (
 	// Maximum allowed length for subPath to prevent potential issues
 	maxSubPathLength = 4096
@@ -78,6 +201,40 @@ func validateSubPath(subPath string) error {
 		return fmt.Errorf("subPath exceeds maximum length of %d characters", maxSubPathLength)
 	}
 
+	if err := checkSubPathComponents(subPath); err != nil {
+		return err
+	}
+
+	// Another tool may percent-decode the subPath before using it, so the
+	// decoded form must be just as safe. Decode repeatedly to catch %252e.
+	decoded := subPath
+	for i := 0; i < maxSubPathDecodeRounds; i++ {
+		next, err := url.PathUnescape(decoded)
+		if err != nil || next == decoded {
+			break
+		}
+		if err := checkSubPathComponents(next); err != nil {
+			return fmt.Errorf("percent-decoded %w", err)
+		}
+		decoded = next
+	}
+
+	return nil
+}
+
+// maxSubPathDecodeRounds bounds how many layers of percent-encoding
+// validateSubPath unwraps
+const maxSubPathDecodeRounds = 3
+
+// checkSubPathComponents rejects traversal, redundant path components,
+// backslashes and null bytes in subPath
+func checkSubPathComponents(subPath string) error {
+	// Backslashes are a separator on Windows and in some tools; reject them
+	// outright rather than guess how they will be interpreted
+	if strings.Contains(subPath, "\\") {
+		return fmt.Errorf("subPath contains a backslash: %s", subPath)
+	}
+
 	// Clean the path to resolve any .. or . components
 	cleaned := filepath.Clean(subPath)
 
@@ -108,48 +265,341 @@ func validateSubPath(subPath string) error {
 		return fmt.Errorf("subPath contains null byte")
 	}
 
+	return checkPathCharacters(ParamSubPath, subPath)
+}
+
+// checkPathCharacters rejects characters in an export path that the mount
+// helper or /proc/mounts cannot represent. Spaces, quotes and non-ASCII
+// letters are fine: the mounter execs mount with the source as a single
+// argument, without a shell, so they reach mount.nfs unchanged.
+func checkPathCharacters(kind, path string) error {
+	if !utf8.ValidString(path) {
+		return fmt.Errorf("%s %q is not valid UTF-8", kind, path)
+	}
+	for _, r := range path {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("%s %q contains control character %U", kind, path, r)
+		}
+	}
 	return nil
 }
 
-// getVolumeSource extracts server, share and subPath from volume context
+const (
+	// Maximum length of a DNS name, excluding a trailing dot
+	maxHostnameLength = 253
+)
+
+// dnsLabelPattern matches a single RFC 1123 hostname label
+var dnsLabelPattern = regexp.MustCompile(`^[a-zA-Z0-9]([-a-zA-Z0-9]{0,61}[a-zA-Z0-9])?$`)
+
+// validateServer checks that the server is an IPv4/IPv6 address (IPv6 may be
+// bracketed) or a syntactically valid DNS name
+func validateServer(server string) error {
+	if strings.HasPrefix(server, "[") && strings.HasSuffix(server, "]") {
+		if ip := net.ParseIP(server[1 : len(server)-1]); ip == nil || ip.To4() != nil {
+			return fmt.Errorf("invalid server %q: brackets are only allowed around an IPv6 address", server)
+		}
+		return nil
+	}
+	if net.ParseIP(server) != nil {
+		return nil
+	}
+
+	name := strings.TrimSuffix(server, ".")
+	if name == "" || len(name) > maxHostnameLength {
+		return fmt.Errorf("invalid server %q: must be an IP address or a DNS name of at most %d characters", server, maxHostnameLength)
+	}
+	labels := strings.Split(name, ".")
+	for _, label := range labels {
+		if !dnsLabelPattern.MatchString(label) {
+			return fmt.Errorf("invalid server %q: must be an IP address or a DNS name (label %q is not valid)", server, label)
+		}
+	}
+	// Top-level domains are never numeric, so this is a mistyped IPv4 address
+	if _, err := strconv.Atoi(labels[len(labels)-1]); err == nil {
+		return fmt.Errorf("invalid server %q: not a valid IP address", server)
+	}
+
+	return nil
+}
+
+// resolveServer checks that a server name resolves, so typos fail at
+// provisioning time instead of at mount time
+func resolveServer(ctx context.Context, server string) error {
+	host := strings.TrimSuffix(strings.TrimPrefix(server, "["), "]")
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+	if _, err := net.DefaultResolver.LookupHost(ctx, host); err != nil {
+		return fmt.Errorf("server %q does not resolve: %w", server, err)
+	}
+	return nil
+}
+
+// parsePort validates an optional NFS server port
+func parsePort(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid %s %q: must be a number between 1 and 65535", ParamPort, value)
+	}
+	return port, nil
+}
+
+// validateVolumeParameters checks the server, share, subPath, port and mount
+// options together and reports every problem in one InvalidArgument error,
+// so users do not have to fix them one at a time
+func (d *Driver) validateVolumeParameters(params map[string]string, mountFlags []string) error {
+	var problems []string
+
+	servers, err := cleanServers(serverList(params))
+	if err != nil {
+		problems = append(problems, status.Convert(err).Message())
+	}
+	for _, server := range servers {
+		if err := validateServer(server); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if shares, err := getShares(params); err != nil {
+		problems = append(problems, err.Error())
+	} else if shares == nil {
+		if _, err := getShare(params); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if _, err := resolveSubPath(params, d.subPathAnnotationKeys()...); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := parsePort(params[ParamPort]); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if err := d.validateMountOptions(mountFlags); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if label := params[ParamSELinuxContext]; label != "" {
+		if err := validateSELinuxContext(label); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return status.Errorf(codes.InvalidArgument, "invalid volume parameters: %s", strings.Join(problems, "; "))
+}
+
+// csiParameterPrefix marks parameters added by the external-provisioner
+// rather than set by users on the StorageClass
+const csiParameterPrefix = "csi.storage.k8s.io/"
+
+// validateParameterKeys rejects parameters that are not in the configured
+// allowlist. Keys added by the external-provisioner are always accepted.
+func (d *Driver) validateParameterKeys(params map[string]string) error {
+	if len(d.allowedParameters) == 0 {
+		return nil
+	}
+
+	var denied []string
+	for key := range params {
+		if strings.HasPrefix(key, csiParameterPrefix) || containsString(d.allowedParameters, key) {
+			continue
+		}
+		denied = append(denied, key)
+	}
+	if len(denied) == 0 {
+		return nil
+	}
+
+	sort.Strings(denied)
+	return status.Errorf(codes.InvalidArgument, "parameters not allowed by the driver: %s (allowed: %s)",
+		strings.Join(denied, ", "), strings.Join(d.allowedParameters, ", "))
+}
+
+// withForcedServer returns a copy of params pointing at the server set by
+// WithForceServer, without any servers list. params is returned as is when no
+// server is forced.
+func (d *Driver) withForcedServer(params map[string]string, volumeID string) map[string]string {
+	if d.forceServer == "" {
+		return params
+	}
+
+	if requested := strings.Join(getServers(params), ","); requested != "" && requested != d.forceServer {
+		klog.Warningf("Volume %s requested NFS server %q, using forced server %q instead", volumeID, requested, d.forceServer)
+	}
+
+	forced := make(map[string]string, len(params)+1)
+	for key, value := range params {
+		if key != ParamServers {
+			forced[key] = value
+		}
+	}
+	forced[ParamServer] = d.forceServer
+	return forced
+}
+
+// getShare returns the share parameter. A leading slash is added unless
+// rawShare is set, for servers whose export paths do not start with one.
+func getShare(params map[string]string) (string, error) {
+	share := params[ParamShare]
+	if share == "" {
+		return "", fmt.Errorf("share parameter is required")
+	}
+	if err := checkPathCharacters(ParamShare, share); err != nil {
+		return "", err
+	}
+
+	raw := false
+	if value := params[ParamRawShare]; value != "" {
+		var err error
+		if raw, err = strconv.ParseBool(value); err != nil {
+			return "", fmt.Errorf("invalid %s %q: must be true or false", ParamRawShare, value)
+		}
+	}
+	if !raw && !strings.HasPrefix(share, "/") {
+		share = "/" + share
+	}
+
+	return share, nil
+}
+
+// getServers returns the NFS servers to try in order. Both the servers
+// parameter and server accept a comma-separated list; servers wins if set.
+func getServers(params map[string]string) []string {
+	servers, _ := cleanServers(serverList(params))
+	return servers
+}
+
+// serverList returns the raw comma-separated server list of params
+func serverList(params map[string]string) string {
+	if value := params[ParamServers]; value != "" {
+		return value
+	}
+	return params[ParamServer]
+}
+
+// cleanServers splits the comma-separated server list value, trimming each
+// entry and dropping empty ones and repeats of an earlier server, so the
+// mount source never names a host twice. The first occurrence keeps its
+// place in the failover order. It returns an InvalidArgument error if no
+// server is left.
+func cleanServers(value string) ([]string, error) {
+	var servers []string
+	for _, server := range strings.Split(value, ",") {
+		server = strings.TrimSpace(server)
+		if server == "" || containsServer(servers, server) {
+			continue
+		}
+		servers = append(servers, server)
+	}
+	if len(servers) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "server parameter is required")
+	}
+	return servers, nil
+}
+
+// getVolumeSource extracts servers, share and subPath from volume context
 // subPath can be specified via:
 // 1. volumeContext["subPath"] (from PV volumeAttributes)
 // 2. PVC annotation "nfs.csi.takutakahashi.dev/subPath" (passed via csi.storage.k8s.io/pvc/annotations)
-func getVolumeSource(volumeContext map[string]string) (string, string, error) {
-	server := volumeContext[ParamServer]
-	if server == "" {
-		return "", "", fmt.Errorf("server parameter is required")
+func getVolumeSource(volumeContext map[string]string, annotationKeys ...string) ([]string, string, error) {
+	servers := getServers(volumeContext)
+	if len(servers) == 0 {
+		return nil, "", fmt.Errorf("server parameter is required")
 	}
 
-	share := volumeContext[ParamShare]
-	if share == "" {
-		return "", "", fmt.Errorf("share parameter is required")
+	for _, server := range servers {
+		if err := validateServer(server); err != nil {
+			return nil, "", err
+		}
 	}
 
-	// Ensure share starts with /
-	if !strings.HasPrefix(share, "/") {
-		share = "/" + share
+	// A volume with several shares has no single share, see getShares
+	if volumeContext[ParamShares] != "" {
+		return servers, "", nil
 	}
 
-	// Get subPath from volumeContext or PVC annotation
-	subPath := getSubPath(volumeContext)
+	share, err := getShare(volumeContext)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Get subPath from volumeContext or PVC annotation, under the StorageClass prefix
+	subPath, err := resolveSubPath(volumeContext, annotationKeys...)
+	if err != nil {
+		return nil, "", err
+	}
 	if subPath != "" {
+		// Combine share with subPath
+		share = strings.TrimSuffix(share, "/") + "/" + strings.TrimPrefix(subPath, "/")
+		klog.V(2).Infof("Combined NFS path: %s (original share: %s, subPath: %s)",
+			share, volumeContext[ParamShare], subPath)
+	}
+
+	return servers, share, nil
+}
+
+// resolveSubPath returns the validated subPath to use below the share. A
+// subPathPrefix set by the StorageClass is always prepended, and the combined
+// path is validated so a user-supplied subPath cannot escape the prefix.
+func resolveSubPath(volumeContext map[string]string, annotationKeys ...string) (string, error) {
+	subPath := getSubPath(volumeContext, annotationKeys...)
+
+	prefix := volumeContext[ParamSubPathPrefix]
+	if prefix == "" {
 		// Validate subPath to prevent path traversal attacks
 		if err := validateSubPath(subPath); err != nil {
-			return "", "", fmt.Errorf("invalid subPath: %w", err)
+			return "", fmt.Errorf("invalid subPath: %w", err)
 		}
-		// Combine share with subPath
-		share = strings.TrimSuffix(share, "/") + "/" + strings.TrimPrefix(subPath, "/")
-		klog.V(2).Infof("Combined NFS path: %s:%s (original share: %s, subPath: %s)",
-			server, share, volumeContext[ParamShare], subPath)
+		return subPath, nil
+	}
+
+	if err := validateSubPath(prefix); err != nil {
+		return "", fmt.Errorf("invalid %s: %w", ParamSubPathPrefix, err)
+	}
+	combined := joinSubPath(prefix, subPath)
+	if err := validateSubPath(combined); err != nil {
+		return "", fmt.Errorf("invalid subPath under %s %q: %w", ParamSubPathPrefix, prefix, err)
 	}
+	return combined, nil
+}
 
-	return server, share, nil
+// joinSubPath joins a subPath prefix and a subPath without cleaning, so that
+// any ".." in the subPath is still visible to validateSubPath
+func joinSubPath(prefix, subPath string) string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if subPath == "" {
+		return prefix
+	}
+	return prefix + "/" + strings.TrimPrefix(subPath, "/")
+}
+
+// validateVolumeSourceMatch checks that a non-empty volume context describes a
+// valid NFS source and that its server and share agree with the provisioning
+// parameters, when those are supplied
+func validateVolumeSourceMatch(volumeContext, parameters map[string]string, annotationKeys ...string) error {
+	if len(volumeContext) == 0 {
+		return nil
+	}
+
+	if _, _, err := getVolumeSource(volumeContext, annotationKeys...); err != nil {
+		return fmt.Errorf("invalid volume context: %w", err)
+	}
+
+	for _, key := range []string{ParamServer, ParamServers, ParamShare} {
+		if want := parameters[key]; want != "" && want != volumeContext[key] {
+			return fmt.Errorf("volume context %s %q does not match parameter %q", key, volumeContext[key], want)
+		}
+	}
+
+	return nil
 }
 
 // getSubPath extracts subPath from volume context
 // Priority: 1. volumeContext["subPath"], 2. PVC annotation
-func getSubPath(volumeContext map[string]string) string {
+func getSubPath(volumeContext map[string]string, annotationKeys ...string) string {
 	// First, check direct subPath parameter
 	if subPath := volumeContext[ParamSubPath]; subPath != "" {
 		return subPath
@@ -158,9 +608,9 @@ func getSubPath(volumeContext map[string]string) string {
 	// Check PVC annotation (passed by CSI external-provisioner)
 	// The annotation key format is: csi.storage.k8s.io/pvc/annotations
 	// Value is JSON-encoded annotations map
-	if annotations := volumeContext["csi.storage.k8s.io/pvc/annotations"]; annotations != "" {
+	if annotations := volumeContext[pvcAnnotationsKey]; annotations != "" {
 		// Parse JSON annotations and extract subPath
-		subPath := parseAnnotationSubPath(annotations)
+		subPath := parseAnnotationSubPath(annotations, annotationKeys...)
 		if subPath != "" {
 			return subPath
 		}
@@ -169,8 +619,29 @@ func getSubPath(volumeContext map[string]string) string {
 	return ""
 }
 
-// parseAnnotationSubPath extracts subPath from JSON-encoded PVC annotations
-func parseAnnotationSubPath(annotationsJSON string) string {
+// parseAnnotationMountOptions extracts the comma-separated mount options under
+// the first of annotationKeys present in JSON-encoded PVC annotations
+func parseAnnotationMountOptions(annotationsJSON string, annotationKeys ...string) []string {
+	var annotations map[string]string
+	if err := json.Unmarshal([]byte(annotationsJSON), &annotations); err != nil {
+		klog.V(4).Infof("Failed to parse PVC annotations JSON: %v", err)
+		return nil
+	}
+
+	for _, key := range annotationKeys {
+		if options, ok := annotations[key]; ok {
+			return splitMountOptions(options)
+		}
+	}
+
+	return nil
+}
+
+// parseAnnotationSubPath extracts subPath from JSON-encoded PVC annotations.
+// annotationKeys come from the annotation prefix and the driver name, in
+// that order; the legacy AnnotationSubPath key is still accepted so existing
+// PVCs keep working under a custom name or prefix.
+func parseAnnotationSubPath(annotationsJSON string, annotationKeys ...string) string {
 	// Parse JSON-encoded annotations properly
 	// Format: {"nfs.csi.takutakahashi.dev/subPath":"value",...}
 	var annotations map[string]string
@@ -179,10 +650,11 @@ func parseAnnotationSubPath(annotationsJSON string) string {
 		return ""
 	}
 
-	subPath, ok := annotations[AnnotationSubPath]
-	if !ok {
-		return ""
+	for _, key := range append(annotationKeys, AnnotationSubPath) {
+		if subPath, ok := annotations[key]; ok {
+			return subPath
+		}
 	}
 
-	return subPath
+	return ""
 }
@@ -1,10 +1,18 @@
 package nfs
 
 import (
+	"context"
+	"os"
+	"reflect"
 	"strings"
 	"testing"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 func TestValidateVolumeCapability(t *testing.T) {
@@ -158,6 +166,21 @@ func TestValidateSubPath(t *testing.T) {
 			subPath: "app1\x00malicious",
 			wantErr: true,
 		},
+		{
+			name:    "spaces and unicode",
+			subPath: "my data/データ",
+			wantErr: false,
+		},
+		{
+			name:    "newline",
+			subPath: "app1\nmalicious",
+			wantErr: true,
+		},
+		{
+			name:    "invalid UTF-8",
+			subPath: "app1/\xff",
+			wantErr: true,
+		},
 		{
 			name:    "exceeds max length",
 			subPath: strings.Repeat("a", maxSubPathLength+1),
@@ -178,6 +201,56 @@ func TestValidateSubPath(t *testing.T) {
 			subPath: "tenant123/app456",
 			wantErr: false,
 		},
+		{
+			name:    "backslash traversal",
+			subPath: `..\..\etc`,
+			wantErr: true,
+		},
+		{
+			name:    "backslash separator",
+			subPath: `app1\data`,
+			wantErr: true,
+		},
+		{
+			name:    "mixed separators",
+			subPath: `app1/..\../etc`,
+			wantErr: true,
+		},
+		{
+			name:    "percent-encoded traversal",
+			subPath: "%2e%2e%2fetc",
+			wantErr: true,
+		},
+		{
+			name:    "uppercase percent-encoded traversal",
+			subPath: "app1/%2E%2E/%2E%2E/etc",
+			wantErr: true,
+		},
+		{
+			name:    "double percent-encoded traversal",
+			subPath: "%252e%252e%252fetc",
+			wantErr: true,
+		},
+		{
+			name:    "percent-encoded backslash",
+			subPath: "app1%5c..%5cetc",
+			wantErr: true,
+		},
+		{
+			name:    "percent-encoded null byte",
+			subPath: "app1%00",
+			wantErr: true,
+		},
+		{
+			name:    "harmless percent-encoding",
+			subPath: "app%201/data",
+			wantErr: false,
+		},
+		{
+			name:    "literal percent sign",
+			subPath: "100%/data",
+			wantErr: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -237,6 +310,62 @@ func TestGetVolumeSource(t *testing.T) {
 			wantShare:  "/data", // Should add leading slash
 			wantErr:    false,
 		},
+		{
+			name: "rawShare keeps the share verbatim",
+			ctx: map[string]string{
+				"server":   "192.168.1.1",
+				"share":    "vol0",
+				"rawShare": "true",
+			},
+			wantServer: "192.168.1.1",
+			wantShare:  "vol0",
+		},
+		{
+			name: "rawShare false adds the leading slash",
+			ctx: map[string]string{
+				"server":   "192.168.1.1",
+				"share":    "vol0",
+				"rawShare": "false",
+			},
+			wantServer: "192.168.1.1",
+			wantShare:  "/vol0",
+		},
+		{
+			name: "invalid rawShare",
+			ctx: map[string]string{
+				"server":   "192.168.1.1",
+				"share":    "vol0",
+				"rawShare": "yes please",
+			},
+			wantErr: true,
+		},
+		{
+			name: "comma-separated servers",
+			ctx: map[string]string{
+				"server": "192.168.1.1, 192.168.1.2",
+				"share":  "/data",
+			},
+			wantServer: "192.168.1.1,192.168.1.2",
+			wantShare:  "/data",
+		},
+		{
+			name: "servers parameter takes precedence",
+			ctx: map[string]string{
+				"server":  "192.168.1.1",
+				"servers": "nfs-a.example.com,nfs-b.example.com",
+				"share":   "/data",
+			},
+			wantServer: "nfs-a.example.com,nfs-b.example.com",
+			wantShare:  "/data",
+		},
+		{
+			name: "invalid server in list",
+			ctx: map[string]string{
+				"server": "192.168.1.1,bad_host",
+				"share":  "/data",
+			},
+			wantErr: true,
+		},
 		{
 			name: "hostname server",
 			ctx: map[string]string{
@@ -318,18 +447,37 @@ func TestGetVolumeSource(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "spaces and unicode in share and subPath",
+			ctx: map[string]string{
+				"server":  "192.168.1.1",
+				"share":   "/exports/my data",
+				"subPath": "équipe/data 1",
+			},
+			wantServer: "192.168.1.1",
+			wantShare:  "/exports/my data/équipe/data 1",
+			wantErr:    false,
+		},
+		{
+			name: "control character in share",
+			ctx: map[string]string{
+				"server": "192.168.1.1",
+				"share":  "/exports/data\tx",
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			server, share, err := getVolumeSource(tt.ctx)
+			servers, share, err := getVolumeSource(tt.ctx, AnnotationSubPath)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("getVolumeSource() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 			if !tt.wantErr {
-				if server != tt.wantServer {
-					t.Errorf("getVolumeSource() server = %v, want %v", server, tt.wantServer)
+				if server := strings.Join(servers, ","); server != tt.wantServer {
+					t.Errorf("getVolumeSource() servers = %v, want %v", server, tt.wantServer)
 				}
 				if share != tt.wantShare {
 					t.Errorf("getVolumeSource() share = %v, want %v", share, tt.wantShare)
@@ -376,7 +524,7 @@ func TestGetSubPath(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := getSubPath(tt.ctx)
+			got := getSubPath(tt.ctx, AnnotationSubPath)
 			if got != tt.want {
 				t.Errorf("getSubPath() = %v, want %v", got, tt.want)
 			}
@@ -444,10 +592,412 @@ func TestParseAnnotationSubPath(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := parseAnnotationSubPath(tt.annotationsJSON)
+			got := parseAnnotationSubPath(tt.annotationsJSON, AnnotationSubPath)
 			if got != tt.want {
 				t.Errorf("parseAnnotationSubPath() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestValidateFSGroupPolicy(t *testing.T) {
+	tests := []struct {
+		policy  string
+		wantErr bool
+	}{
+		{policy: "", wantErr: false},
+		{policy: "File", wantErr: false},
+		{policy: "None", wantErr: false},
+		{policy: "file", wantErr: true},
+		{policy: "ReadWriteOnceWithFSType", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			err := validateFSGroupPolicy(tt.policy)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateFSGroupPolicy(%q) error = %v, wantErr %v", tt.policy, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseFileMode(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    os.FileMode
+		wantErr bool
+	}{
+		{value: "0750", want: 0750},
+		{value: "660", want: 0660},
+		{value: "0", want: 0},
+		{value: "0777", want: 0777},
+		{value: "01777", wantErr: true},
+		{value: "0980", wantErr: true},
+		{value: "rw-r--r--", wantErr: true},
+		{value: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseFileMode(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseFileMode(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseFileMode(%q) = %#o, want %#o", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetFSType(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "", want: "nfs"},
+		{value: "nfs", want: "nfs"},
+		{value: "nfs4", want: "nfs4"},
+		{value: "NFS4", wantErr: true},
+		{value: "ext4", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := getFSType(map[string]string{"fsType": tt.value})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getFSType(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("getFSType(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveSubPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		ctx     map[string]string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "no prefix",
+			ctx:  map[string]string{"subPath": "app/data"},
+			want: "app/data",
+		},
+		{
+			name: "prefix only",
+			ctx:  map[string]string{"subPathPrefix": "/tenants/acme"},
+			want: "/tenants/acme",
+		},
+		{
+			name: "prefix and subPath",
+			ctx:  map[string]string{"subPathPrefix": "/tenants/acme/", "subPath": "/app"},
+			want: "/tenants/acme/app",
+		},
+		{
+			name: "prefix and annotation subPath",
+			ctx: map[string]string{
+				"subPathPrefix":                      "tenants/acme",
+				"csi.storage.k8s.io/pvc/annotations": `{"nfs.csi.takutakahashi.dev/subPath":"app"}`,
+			},
+			want: "tenants/acme/app",
+		},
+		{
+			name:    "escape prefix with parent dirs",
+			ctx:     map[string]string{"subPathPrefix": "/tenants/acme", "subPath": "../../etc"},
+			wantErr: true,
+		},
+		{
+			name:    "escape prefix in the middle",
+			ctx:     map[string]string{"subPathPrefix": "/tenants/acme", "subPath": "app/../../other"},
+			wantErr: true,
+		},
+		{
+			name: "escape prefix through annotation",
+			ctx: map[string]string{
+				"subPathPrefix":                      "/tenants/acme",
+				"csi.storage.k8s.io/pvc/annotations": `{"nfs.csi.takutakahashi.dev/subPath":"../globex"}`,
+			},
+			wantErr: true,
+		},
+		{
+			name:    "invalid prefix",
+			ctx:     map[string]string{"subPathPrefix": "/tenants/../acme"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveSubPath(tt.ctx, AnnotationSubPath)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveSubPath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("resolveSubPath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateServer(t *testing.T) {
+	tests := []struct {
+		server  string
+		wantErr bool
+	}{
+		{server: "192.168.1.1"},
+		{server: "fd00::1"},
+		{server: "[fd00::1]"},
+		{server: "nfs.example.com"},
+		{server: "nfs.example.com."},
+		{server: "nfs-01"},
+		{server: "localhost"},
+		{server: "192.168.1.300", wantErr: true},
+		{server: "[192.168.1.1]", wantErr: true},
+		{server: "[fd00::zz]", wantErr: true},
+		{server: "nfs_server.example.com", wantErr: true},
+		{server: "-nfs.example.com", wantErr: true},
+		{server: "nfs..example.com", wantErr: true},
+		{server: "nfs.example.com:2049", wantErr: true},
+		{server: "nfs example.com", wantErr: true},
+		{server: strings.Repeat("a", 64) + ".example.com", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.server, func(t *testing.T) {
+			err := validateServer(tt.server)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateServer(%q) error = %v, wantErr %v", tt.server, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateVolumeParameters(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithDeniedMountOptions([]string{"suid"}))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	if err := driver.validateVolumeParameters(map[string]string{
+		"server": "192.168.1.1",
+		"share":  "/data",
+		"port":   "2049",
+	}, []string{"nfsvers=4.1"}); err != nil {
+		t.Errorf("Expected valid parameters, got %v", err)
+	}
+
+	err = driver.validateVolumeParameters(map[string]string{
+		"subPath": "../etc",
+		"port":    "70000",
+	}, []string{"suid"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument, got %v", err)
+	}
+	for _, want := range []string{"server parameter is required", "share parameter is required", "subPath", "port", "suid"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %q, got %v", want, err)
+		}
+	}
+}
+
+func TestCleanServers(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "single server", value: "192.168.1.1", want: []string{"192.168.1.1"}},
+		{name: "whitespace trimmed", value: " nfs-a , nfs-b ", want: []string{"nfs-a", "nfs-b"}},
+		{name: "empty entries dropped", value: "nfs-a,,nfs-b,", want: []string{"nfs-a", "nfs-b"}},
+		{name: "repeats dropped in order", value: "nfs-b,nfs-a,nfs-b, nfs-a", want: []string{"nfs-b", "nfs-a"}},
+		{name: "repeats compared case-insensitively", value: "NFS-A.example.com,nfs-a.example.com", want: []string{"NFS-A.example.com"}},
+		{name: "empty", value: "", wantErr: true},
+		{name: "only separators", value: " , ,", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cleanServers(tt.value)
+			if tt.wantErr {
+				if status.Code(err) != codes.InvalidArgument {
+					t.Errorf("Expected InvalidArgument, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("cleanServers() failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("cleanServers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAnnotationSubPath_DriverNameKey(t *testing.T) {
+	const customKey = "nfs.example.org/subPath"
+
+	tests := []struct {
+		name        string
+		annotations string
+		want        string
+	}{
+		{
+			name:        "driver name key",
+			annotations: `{"nfs.example.org/subPath":"custom"}`,
+			want:        "custom",
+		},
+		{
+			name:        "legacy key",
+			annotations: `{"nfs.csi.takutakahashi.dev/subPath":"legacy"}`,
+			want:        "legacy",
+		},
+		{
+			name:        "driver name key takes priority",
+			annotations: `{"nfs.csi.takutakahashi.dev/subPath":"legacy","nfs.example.org/subPath":"custom"}`,
+			want:        "custom",
+		},
+		{
+			name:        "other driver key ignored",
+			annotations: `{"nfs.other.org/subPath":"other"}`,
+			want:        "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseAnnotationSubPath(tt.annotations, customKey); got != tt.want {
+				t.Errorf("parseAnnotationSubPath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogGRPC_RequestID(t *testing.T) {
+	driver := &Driver{}
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Node/NodePublishVolume"}
+	failing := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.NotFound, "volume path missing")
+	}
+
+	t.Run("from metadata", func(t *testing.T) {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(RequestIDMetadataKey, "trace-1234"))
+		_, err := driver.logGRPC(ctx, nil, info, failing)
+		if status.Code(err) != codes.NotFound {
+			t.Fatalf("Expected code NotFound to be kept, got %v", err)
+		}
+		if want := "volume path missing (request ID trace-1234)"; status.Convert(err).Message() != want {
+			t.Errorf("Expected message %q, got %q", want, status.Convert(err).Message())
+		}
+	})
+
+	t.Run("generated", func(t *testing.T) {
+		_, err := driver.logGRPC(context.Background(), nil, info, failing)
+		message := status.Convert(err).Message()
+		id := strings.TrimSuffix(strings.TrimPrefix(message, "volume path missing (request ID "), ")")
+		if _, parseErr := uuid.Parse(id); parseErr != nil {
+			t.Errorf("Expected a generated UUID in %q: %v", message, parseErr)
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(RequestIDMetadataKey, "trace-5678"))
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			return "ok", nil
+		}
+		resp, err := driver.logGRPC(ctx, nil, info, handler)
+		if err != nil || resp != "ok" {
+			t.Errorf("Expected handler response unchanged, got %v, %v", resp, err)
+		}
+	})
+}
+
+func TestParseAnnotationMountOptions(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations string
+		want        []string
+	}{
+		{name: "present", annotations: `{"nfs.csi.takutakahashi.dev/mountOptions":"nfsvers=4.1, noatime"}`, want: []string{"nfsvers=4.1", "noatime"}},
+		{name: "other driver name", annotations: `{"nfs.example.org/mountOptions":"noatime"}`},
+		{name: "absent", annotations: `{"nfs.csi.takutakahashi.dev/subPath":"app"}`},
+		{name: "invalid JSON", annotations: `{"nfs.csi.takutakahashi.dev/mountOptions":`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseAnnotationMountOptions(tt.annotations, "nfs.csi.takutakahashi.dev/mountOptions")
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("parseAnnotationMountOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnnotationPrefix(t *testing.T) {
+	driver, err := NewDriver("nfs.example.org", "test-node", "unix:///tmp/test.sock", WithAnnotationPrefix("storage.example.com"))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	tests := []struct {
+		name             string
+		annotations      string
+		wantSubPath      string
+		wantMountOptions []string
+	}{
+		{
+			name:             "prefix",
+			annotations:      `{"storage.example.com/subPath":"custom","storage.example.com/mountOptions":"noatime"}`,
+			wantSubPath:      "custom",
+			wantMountOptions: []string{"noatime"},
+		},
+		{
+			name:             "driver name",
+			annotations:      `{"nfs.example.org/subPath":"named","nfs.example.org/mountOptions":"nolock"}`,
+			wantSubPath:      "named",
+			wantMountOptions: []string{"nolock"},
+		},
+		{
+			name:        "legacy",
+			annotations: `{"nfs.csi.takutakahashi.dev/subPath":"legacy"}`,
+			wantSubPath: "legacy",
+		},
+		{
+			name:             "prefix takes priority",
+			annotations:      `{"nfs.csi.takutakahashi.dev/subPath":"legacy","nfs.example.org/subPath":"named","storage.example.com/subPath":"custom","nfs.example.org/mountOptions":"nolock","storage.example.com/mountOptions":"noatime"}`,
+			wantSubPath:      "custom",
+			wantMountOptions: []string{"noatime"},
+		},
+		{
+			name:        "other prefix ignored",
+			annotations: `{"other.example.com/subPath":"other","other.example.com/mountOptions":"noatime"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := map[string]string{pvcAnnotationsKey: tt.annotations}
+			if got := getSubPath(ctx, driver.subPathAnnotationKeys()...); got != tt.wantSubPath {
+				t.Errorf("getSubPath() = %q, want %q", got, tt.wantSubPath)
+			}
+			got := parseAnnotationMountOptions(tt.annotations, driver.mountOptionsAnnotationKeys()...)
+			if strings.Join(got, "|") != strings.Join(tt.wantMountOptions, "|") {
+				t.Errorf("parseAnnotationMountOptions() = %v, want %v", got, tt.wantMountOptions)
+			}
+		})
+	}
+
+	for _, prefix := range []string{"example.com/nfs", "Example.com", "-example.com"} {
+		if _, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithAnnotationPrefix(prefix)); err == nil {
+			t.Errorf("Expected annotation prefix %q to be rejected", prefix)
+		}
+	}
+}
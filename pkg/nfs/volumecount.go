@@ -0,0 +1,73 @@
+package nfs
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// volumeCounts counts the volumes created and deleted since the controller
+// started. The name and ID of each volume created are kept until it is
+// deleted, so retried requests and deletes of volumes it did not create are
+// not counted.
+type volumeCounts struct {
+	mu sync.Mutex
+	// volumes maps the name of each volume to its ID
+	volumes map[string]string
+	created int64
+	deleted int64
+}
+
+// create counts the volume name with volumeID unless it was counted before
+func (c *volumeCounts) create(name, volumeID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.volumes[name]; ok {
+		c.volumes[name] = volumeID
+		return
+	}
+	if c.volumes == nil {
+		c.volumes = make(map[string]string)
+	}
+	c.volumes[name] = volumeID
+	c.created++
+}
+
+// delete counts the volumes with volumeID as deleted. Names deduplicated by
+// path share one ID and are all deleted with it.
+func (c *volumeCounts) delete(volumeID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, id := range c.volumes {
+		if id == volumeID {
+			delete(c.volumes, name)
+			c.deleted++
+		}
+	}
+}
+
+// VolumeCounts is a snapshot of the volumes the controller provisioned since
+// it started. Volumes created before the start are not counted, not even
+// when they are deleted.
+type VolumeCounts struct {
+	Created     int64 `json:"created"`
+	Deleted     int64 `json:"deleted"`
+	Provisioned int64 `json:"provisioned"`
+}
+
+// VolumeCounts returns the current volume counts
+func (d *Driver) VolumeCounts() VolumeCounts {
+	d.volumeCounts.mu.Lock()
+	defer d.volumeCounts.mu.Unlock()
+	return VolumeCounts{
+		Created:     d.volumeCounts.created,
+		Deleted:     d.volumeCounts.deleted,
+		Provisioned: d.volumeCounts.created - d.volumeCounts.deleted,
+	}
+}
+
+// serveVolumeCounts writes the volume counts as JSON
+func (d *Driver) serveVolumeCounts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(d.VolumeCounts())
+}
@@ -0,0 +1,103 @@
+package nfs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+func TestVolumeCounts_Concurrent(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock")
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+	ctx := context.Background()
+
+	const volumes = 100
+	var wg sync.WaitGroup
+	for i := 0; i < volumes; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("pvc-%d", i)
+			if _, err := driver.CreateVolume(ctx, createVolumeRequest(name, 0)); err != nil {
+				t.Errorf("CreateVolume(%s) failed: %v", name, err)
+				return
+			}
+			if i%2 == 0 {
+				if _, err := driver.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: name}); err != nil {
+					t.Errorf("DeleteVolume(%s) failed: %v", name, err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	want := VolumeCounts{Created: volumes, Deleted: volumes / 2, Provisioned: volumes / 2}
+	if got := driver.VolumeCounts(); got != want {
+		t.Errorf("VolumeCounts() = %+v, want %+v", got, want)
+	}
+}
+
+func TestVolumeCounts_Retries(t *testing.T) {
+	for _, ephemeral := range []bool{false, true} {
+		t.Run(fmt.Sprintf("ephemeral=%v", ephemeral), func(t *testing.T) {
+			driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithEphemeralProvisioning(ephemeral))
+			if err != nil {
+				t.Fatalf("Failed to create driver: %v", err)
+			}
+			ctx := context.Background()
+
+			// Retries of the same request and deletes of unknown volumes are not counted
+			for i := 0; i < 3; i++ {
+				if _, err := driver.CreateVolume(ctx, createVolumeRequest("pvc-1", 0)); err != nil {
+					t.Fatalf("CreateVolume failed: %v", err)
+				}
+			}
+			if _, err := driver.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "pvc-unknown"}); err != nil {
+				t.Fatalf("DeleteVolume failed: %v", err)
+			}
+			if want, got := (VolumeCounts{Created: 1, Provisioned: 1}), driver.VolumeCounts(); got != want {
+				t.Errorf("VolumeCounts() = %+v, want %+v", got, want)
+			}
+
+			for i := 0; i < 2; i++ {
+				if _, err := driver.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "pvc-1"}); err != nil {
+					t.Fatalf("DeleteVolume failed: %v", err)
+				}
+			}
+			if want, got := (VolumeCounts{Created: 1, Deleted: 1}), driver.VolumeCounts(); got != want {
+				t.Errorf("VolumeCounts() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestHealthHandler_VolumeCounts(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock")
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+	if _, err := driver.CreateVolume(context.Background(), createVolumeRequest("pvc-1", 0)); err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	driver.HealthHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/volumes", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	var got VolumeCounts
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Failed to decode %q: %v", rec.Body.String(), err)
+	}
+	if want := (VolumeCounts{Created: 1, Provisioned: 1}); got != want {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+}
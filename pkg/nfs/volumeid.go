@@ -0,0 +1,135 @@
+package nfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Volume ID formats
+const (
+	// VolumeIDFormatName uses the volume name as the ID
+	VolumeIDFormatName = "name"
+	// VolumeIDFormatStructured encodes the server, share and subPath in the
+	// ID, so they can be recovered without the volume context
+	VolumeIDFormatStructured = "structured"
+)
+
+// dedupeNamePrefix starts the volume names derived from a path by dedupeByPath
+const dedupeNamePrefix = "path-"
+
+// volumeIDSeparator separates the escaped fields of a structured volume ID.
+// Volume names from the external-provisioner never contain it.
+const volumeIDSeparator = "#"
+
+// maxVolumeIDLength is the longest volume ID the CSI spec allows, in bytes
+const maxVolumeIDLength = 128
+
+// volumeIDFields is the number of fields in a structured volume ID
+const volumeIDFields = 4
+
+// volumeIDFieldsOnDelete is the number of fields in a structured volume ID
+// that also records what DeleteVolume does with the directory
+const volumeIDFieldsOnDelete = 6
+
+// volumeIDParts are the fields of a structured volume ID
+type volumeIDParts struct {
+	// server is the comma-separated list of servers
+	server  string
+	share   string
+	subPath string
+	// name keeps IDs unique when several volumes use the same directory
+	name string
+	// onDelete is the onDelete mode of the volume; empty retains the
+	// directory. It is only encoded when set, with pvcName.
+	onDelete string
+	// pvcName is the name of the PVC, used in the name of the archive
+	pvcName string
+}
+
+// WithVolumeIDFormat selects how CreateVolume builds volume IDs
+func WithVolumeIDFormat(format string) DriverOption {
+	return func(d *Driver) {
+		d.volumeIDFormat = format
+	}
+}
+
+// encodeVolumeID returns the structured ID for parts as
+// server#share#subPath#name, followed by #onDelete#pvcName when an onDelete
+// mode is set, with each field path-escaped
+func encodeVolumeID(parts volumeIDParts) string {
+	fields := []string{parts.server, parts.share, parts.subPath, parts.name}
+	if parts.onDelete != "" {
+		fields = append(fields, parts.onDelete, parts.pvcName)
+	}
+	for i, field := range fields {
+		fields[i] = url.PathEscape(field)
+	}
+	return strings.Join(fields, volumeIDSeparator)
+}
+
+// decodeVolumeID parses a structured volume ID
+func decodeVolumeID(volumeID string) (volumeIDParts, error) {
+	fields := strings.Split(volumeID, volumeIDSeparator)
+	if len(fields) != volumeIDFields && len(fields) != volumeIDFieldsOnDelete {
+		return volumeIDParts{}, fmt.Errorf("volume ID %q is not structured: expected %d or %d fields, got %d", volumeID, volumeIDFields, volumeIDFieldsOnDelete, len(fields))
+	}
+
+	for i, field := range fields {
+		value, err := url.PathUnescape(field)
+		if err != nil {
+			return volumeIDParts{}, fmt.Errorf("invalid volume ID %q: %w", volumeID, err)
+		}
+		fields[i] = value
+	}
+
+	parts := volumeIDParts{server: fields[0], share: fields[1], subPath: fields[2], name: fields[3]}
+	if parts.server == "" || parts.share == "" || parts.name == "" {
+		return volumeIDParts{}, fmt.Errorf("invalid volume ID %q: server, share and name are required", volumeID)
+	}
+	if len(fields) == volumeIDFieldsOnDelete {
+		parts.onDelete, parts.pvcName = fields[4], fields[5]
+		if parts.onDelete == "" || parts.pvcName == "" {
+			return volumeIDParts{}, fmt.Errorf("invalid volume ID %q: onDelete and PVC name are required", volumeID)
+		}
+	}
+	return parts, nil
+}
+
+// volumeID returns the ID for a new volume in the configured format
+func (d *Driver) volumeID(parts volumeIDParts) string {
+	if d.volumeIDFormat != VolumeIDFormatStructured {
+		return parts.name
+	}
+	return encodeVolumeID(parts)
+}
+
+// getDedupeByPath reports whether the dedupeByPath parameter is set
+func getDedupeByPath(params map[string]string) (bool, error) {
+	value := params[ParamDedupeByPath]
+	if value == "" {
+		return false, nil
+	}
+	dedupe, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q: must be true or false", ParamDedupeByPath, value)
+	}
+	return dedupe, nil
+}
+
+// dedupeVolumeName returns the volume name that dedupeByPath uses in place of
+// the requested one. It only depends on the directory: the servers in any
+// order, and the share and subPath joined, so /data with subPath a and
+// /data/a without one get the same name.
+func dedupeVolumeName(servers []string, share, subPath string) string {
+	sorted := append([]string(nil), servers...)
+	sort.Strings(sorted)
+	dir := path.Clean(joinSubPath(share, subPath))
+	sum := sha256.Sum256([]byte(strings.Join(sorted, ",") + "\x00" + dir))
+	return dedupeNamePrefix + hex.EncodeToString(sum[:8])
+}
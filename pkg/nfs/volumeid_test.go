@@ -0,0 +1,233 @@
+package nfs
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestVolumeID_RoundTrip(t *testing.T) {
+	tests := []volumeIDParts{
+		{server: "192.168.1.1", share: "/data", name: "pvc-1"},
+		{server: "nfs.example.com", share: "/exports/data", subPath: "tenants/a/app1", name: "pvc-2"},
+		{server: "2001:db8::1,192.168.1.2", share: "/data", subPath: "dir#with#hashes", name: "pvc-3"},
+		{server: "192.168.1.1", share: "vol0", subPath: "100% done/a b", name: "pvc-4"},
+		{server: "192.168.1.1", share: "/data", subPath: "a", name: "pvc-5", onDelete: OnDeleteArchive, pvcName: "data"},
+	}
+
+	for _, parts := range tests {
+		id := encodeVolumeID(parts)
+		fields := volumeIDFields
+		if parts.onDelete != "" {
+			fields = volumeIDFieldsOnDelete
+		}
+		if strings.Count(id, volumeIDSeparator) != fields-1 {
+			t.Errorf("Expected %d separators in %q", fields-1, id)
+		}
+		if strings.ContainsAny(id, "/ ") {
+			t.Errorf("Expected a URL-safe ID, got %q", id)
+		}
+		got, err := decodeVolumeID(id)
+		if err != nil {
+			t.Fatalf("decodeVolumeID(%q) failed: %v", id, err)
+		}
+		if got != parts {
+			t.Errorf("decodeVolumeID(%q) = %+v, want %+v", id, got, parts)
+		}
+	}
+}
+
+func TestDecodeVolumeID_Invalid(t *testing.T) {
+	for _, id := range []string{
+		"pvc-1",
+		"192.168.1.1#%2Fdata#pvc-1",
+		"192.168.1.1#%2Fdata##pvc-1#extra",
+		"192.168.1.1#%2Fdata#a#pvc-1#archive#",
+		"#%2Fdata##pvc-1",
+		"192.168.1.1#%2Fdata##",
+		"192.168.1.1#%zz##pvc-1",
+	} {
+		if parts, err := decodeVolumeID(id); err == nil {
+			t.Errorf("Expected decodeVolumeID(%q) to fail, got %+v", id, parts)
+		}
+	}
+}
+
+func TestCreateVolume_VolumeIDFormat(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{format: VolumeIDFormatName, want: "test-volume"},
+		{format: VolumeIDFormatStructured, want: "192.168.1.100#%2Fexports%2Fdata#tenants%2Fa%2Fapp1#test-volume"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithVolumeIDFormat(tt.format))
+			if err != nil {
+				t.Fatalf("Failed to create driver: %v", err)
+			}
+
+			resp, err := driver.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name: "test-volume",
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+						},
+					},
+				},
+				Parameters: map[string]string{
+					"server":        "192.168.1.100",
+					"share":         "exports/data",
+					"subPathPrefix": "tenants/a",
+					"subPath":       "app1",
+				},
+			})
+			if err != nil {
+				t.Fatalf("CreateVolume failed: %v", err)
+			}
+			if got := resp.GetVolume().GetVolumeId(); got != tt.want {
+				t.Errorf("Expected volume ID %q, got %q", tt.want, got)
+			}
+
+			if _, err := driver.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: resp.GetVolume().GetVolumeId()}); err != nil {
+				t.Errorf("DeleteVolume failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestCreateVolume_VolumeIDTooLong(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithVolumeIDFormat(VolumeIDFormatStructured))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	_, err = driver.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name: "pvc-0f4c7e4a-5d2b-4b8e-9c3a-2f6d1e8b7a90",
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{},
+				},
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+				},
+			},
+		},
+		Parameters: map[string]string{
+			"server":  "nfs-primary.storage.example.com",
+			"share":   "/exports/department/engineering",
+			"subPath": "projects/long-running-analysis",
+		},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument for a volume ID over %d bytes, got %v", maxVolumeIDLength, err)
+	}
+}
+
+func TestNewDriver_InvalidVolumeIDFormat(t *testing.T) {
+	if _, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithVolumeIDFormat("uuid")); err == nil {
+		t.Error("Expected an unknown volume ID format to be rejected")
+	}
+}
+
+func TestDedupeVolumeName(t *testing.T) {
+	base := dedupeVolumeName([]string{"192.168.1.1", "192.168.1.2"}, "/data", "a")
+	if !strings.HasPrefix(base, dedupeNamePrefix) {
+		t.Errorf("Expected a name starting with %q, got %q", dedupeNamePrefix, base)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		servers []string
+		share   string
+		subPath string
+		same    bool
+	}{
+		{name: "servers in another order", servers: []string{"192.168.1.2", "192.168.1.1"}, share: "/data", subPath: "a", same: true},
+		{name: "subPath in the share", servers: []string{"192.168.1.1", "192.168.1.2"}, share: "/data/a", same: true},
+		{name: "trailing slashes", servers: []string{"192.168.1.1", "192.168.1.2"}, share: "/data/", subPath: "a/", same: true},
+		{name: "other subPath", servers: []string{"192.168.1.1", "192.168.1.2"}, share: "/data", subPath: "b"},
+		{name: "other servers", servers: []string{"192.168.1.1"}, share: "/data", subPath: "a"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dedupeVolumeName(tt.servers, tt.share, tt.subPath); (got == base) != tt.same {
+				t.Errorf("dedupeVolumeName() = %q, base %q, want same %v", got, base, tt.same)
+			}
+		})
+	}
+}
+
+func TestCreateVolume_DedupeByPath(t *testing.T) {
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithEphemeralProvisioning(true))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+	ctx := context.Background()
+
+	create := func(name, subPath, dedupe string) (*csi.CreateVolumeResponse, error) {
+		req := createVolumeRequest(name, 0)
+		req.Parameters["subPath"] = subPath
+		if dedupe != "" {
+			req.Parameters["dedupeByPath"] = dedupe
+		}
+		return driver.CreateVolume(ctx, req)
+	}
+	id := func(name, subPath, dedupe string) string {
+		resp, err := create(name, subPath, dedupe)
+		if err != nil {
+			t.Fatalf("CreateVolume(%s) failed: %v", name, err)
+		}
+		return resp.Volume.VolumeId
+	}
+
+	shared := id("pvc-a", "app", "true")
+	if !strings.HasPrefix(shared, dedupeNamePrefix) {
+		t.Errorf("Expected a volume ID derived from the path, got %q", shared)
+	}
+	if got := id("pvc-b", "app", "true"); got != shared {
+		t.Errorf("Expected pvc-b to share volume ID %q, got %q", shared, got)
+	}
+	if got := id("pvc-c", "other", "true"); got == shared {
+		t.Errorf("Expected another subPath to get its own volume ID, got %q", got)
+	}
+	if got := id("pvc-d", "app", "false"); got != "pvc-d" {
+		t.Errorf("Expected the volume name without dedupe, got %q", got)
+	}
+
+	list, err := driver.ListVolumes(ctx, &csi.ListVolumesRequest{})
+	if err != nil {
+		t.Fatalf("ListVolumes failed: %v", err)
+	}
+	if len(list.Entries) != 3 {
+		t.Errorf("Expected the shared volume listed once among 3, got %+v", list.Entries)
+	}
+
+	// DeleteVolume only gets the ID, so it forgets every name using it
+	if _, err := driver.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: shared}); err != nil {
+		t.Fatalf("DeleteVolume failed: %v", err)
+	}
+	if _, ok := driver.volumes.lookup(shared); ok {
+		t.Errorf("Expected %s to be forgotten", shared)
+	}
+
+	if _, err := create("pvc-e", "app", "yes please"); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument for an invalid dedupeByPath, got %v", err)
+	}
+	req := createVolumeRequest("pvc-f", 0)
+	req.Parameters["dedupeByPath"] = "true"
+	req.VolumeContentSource = &csi.VolumeContentSource{Type: &csi.VolumeContentSource_Snapshot{Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: "snap-1"}}}
+	if _, err := driver.CreateVolume(ctx, req); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument with a content source, got %v", err)
+	}
+}
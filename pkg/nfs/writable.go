@@ -0,0 +1,87 @@
+package nfs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// isReadOnlyMode reports whether mode only lets pods read the volume
+func isReadOnlyMode(mode csi.VolumeCapability_AccessMode_Mode) bool {
+	return mode == csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY ||
+		mode == csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY
+}
+
+// getWritableSubPath returns the writableSubPath parameter relative to the
+// volume root, or "" if it is not set
+func getWritableSubPath(params map[string]string) (string, error) {
+	value := params[ParamWritableSubPath]
+	if value == "" {
+		return "", nil
+	}
+	if err := validateSubPath(value); err != nil {
+		return "", fmt.Errorf("invalid %s: %v", ParamWritableSubPath, err)
+	}
+	writable := strings.Trim(filepath.Clean(value), "/")
+	if writable == "" || writable == "." {
+		return "", fmt.Errorf("invalid %s %q: must name a directory below the volume root", ParamWritableSubPath, value)
+	}
+	return writable, nil
+}
+
+// bindWritableSubPath bind-mounts the writable directory of a staged volume
+// read-write over the same directory in the read-only target. The directory
+// is created through the staged mount, which is mounted read-write.
+func (d *Driver) bindWritableSubPath(ctx context.Context, stagingPath, subPath, writable, targetPath string) error {
+	source := filepath.Join(stagingPath, subPath, writable)
+	if err := d.filesystem.MkdirAll(source, 0750); err != nil {
+		return status.Errorf(codes.Internal, "failed to create %s %s: %v", ParamWritableSubPath, source, err)
+	}
+
+	// The directory is already visible in the read-only bind of the volume
+	target := filepath.Join(targetPath, writable)
+	if err := d.mounter.Mount(source, target, "", []string{"bind"}); err != nil {
+		return status.Errorf(codes.Internal, "failed to bind mount %s at %s: %v", source, target, err)
+	}
+
+	d.staged.mu.Lock()
+	d.staged.addLocked(stagingPath, target)
+	d.staged.mu.Unlock()
+
+	d.logV(ctx, subsystemNode, 2).Infof("Successfully bind mounted writable %s at %s", source, target)
+	return nil
+}
+
+// unmountNested unmounts every mount below targetPath, deepest first, so the
+// target itself can be unmounted
+func (d *Driver) unmountNested(ctx context.Context, targetPath string) error {
+	mountPoints, err := d.mounter.List()
+	if err != nil {
+		return fmt.Errorf("failed to list mounts: %w", err)
+	}
+
+	prefix := strings.TrimSuffix(targetPath, string(os.PathSeparator)) + string(os.PathSeparator)
+	var nested []string
+	for _, mp := range mountPoints {
+		if strings.HasPrefix(mp.Path, prefix) {
+			nested = append(nested, mp.Path)
+		}
+	}
+	sort.Slice(nested, func(i, j int) bool { return len(nested[i]) > len(nested[j]) })
+
+	for _, path := range nested {
+		if err := d.mounter.Unmount(path); err != nil {
+			return fmt.Errorf("failed to unmount %s: %w", path, err)
+		}
+		d.staged.remove(path)
+		d.logV(ctx, subsystemNode, 4).Infof("Unmounted nested mount %s", path)
+	}
+	return nil
+}
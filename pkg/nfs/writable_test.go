@@ -0,0 +1,179 @@
+package nfs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/mount-utils"
+)
+
+func TestGetWritableSubPath(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "", want: ""},
+		{value: "scratch", want: "scratch"},
+		{value: "/cache/tmp/", want: "cache/tmp"},
+		{value: "/", wantErr: true},
+		{value: ".", wantErr: true},
+		{value: "../scratch", wantErr: true},
+		{value: "cache/../../etc", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := getWritableSubPath(map[string]string{"writableSubPath": tt.value})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("getWritableSubPath(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("getWritableSubPath(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestNodePublishVolume_WritableSubPath(t *testing.T) {
+	fakeMounter := mount.NewFakeMounter([]mount.MountPoint{})
+	driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithMounter(fakeMounter), WithStaging(true))
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	stagingPath := filepath.Join(dir, "staging")
+	target := filepath.Join(dir, "pod")
+	volumeCap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{},
+		},
+		AccessMode: &csi.VolumeCapability_AccessMode{
+			Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+		},
+	}
+	volumeContext := map[string]string{
+		"server":          "192.168.1.1",
+		"share":           "/data",
+		"subPath":         "team-a",
+		"writableSubPath": "scratch",
+	}
+
+	_, err = driver.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+		VolumeId:          "test-volume",
+		StagingTargetPath: stagingPath,
+		VolumeCapability:  volumeCap,
+		VolumeContext:     volumeContext,
+	})
+	if err != nil {
+		t.Fatalf("NodeStageVolume failed: %v", err)
+	}
+	if opts := fakeMounter.MountPoints[0].Opts; containsString(opts, "ro") {
+		t.Errorf("Expected the share staged read-write, got %v", opts)
+	}
+
+	// The fake mounter does not expose the share contents, so create the subPath by hand
+	if err := os.Mkdir(filepath.Join(stagingPath, "team-a"), 0755); err != nil {
+		t.Fatalf("Failed to create subPath: %v", err)
+	}
+
+	_, err = driver.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+		VolumeId:          "test-volume",
+		StagingTargetPath: stagingPath,
+		TargetPath:        target,
+		Readonly:          true,
+		VolumeCapability:  volumeCap,
+		VolumeContext:     volumeContext,
+	})
+	if err != nil {
+		t.Fatalf("NodePublishVolume failed: %v", err)
+	}
+
+	mounts := make(map[string][]string)
+	for _, mp := range fakeMounter.MountPoints {
+		mounts[mp.Path] = mp.Opts
+	}
+	if opts, ok := mounts[target]; !ok || !containsString(opts, "ro") {
+		t.Errorf("Expected %s bind-mounted read-only, got %v", target, fakeMounter.MountPoints)
+	}
+	writableTarget := filepath.Join(target, "scratch")
+	if opts, ok := mounts[writableTarget]; !ok || containsString(opts, "ro") {
+		t.Errorf("Expected %s bind-mounted read-write, got %v", writableTarget, fakeMounter.MountPoints)
+	}
+	if _, err := os.Stat(filepath.Join(stagingPath, "team-a", "scratch")); err != nil {
+		t.Errorf("Expected the writable directory created on the share: %v", err)
+	}
+
+	if _, err := driver.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: "test-volume", TargetPath: target}); err != nil {
+		t.Fatalf("NodeUnpublishVolume failed: %v", err)
+	}
+	if len(fakeMounter.MountPoints) != 1 {
+		t.Errorf("Expected only the staged mount left, got %+v", fakeMounter.MountPoints)
+	}
+
+	// Drop the hand-made directories again so the staging dir can be removed
+	if err := os.RemoveAll(filepath.Join(stagingPath, "team-a")); err != nil {
+		t.Fatalf("Failed to remove subPath: %v", err)
+	}
+	if _, err := driver.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{VolumeId: "test-volume", StagingTargetPath: stagingPath}); err != nil {
+		t.Fatalf("NodeUnstageVolume failed: %v", err)
+	}
+}
+
+func TestNodePublishVolume_WritableSubPathInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		staging  bool
+		mode     csi.VolumeCapability_AccessMode_Mode
+		readOnly string
+		wantCode codes.Code
+	}{
+		{name: "without staging", mode: csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY, wantCode: codes.FailedPrecondition},
+		{name: "writable access mode", staging: true, mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER, wantCode: codes.InvalidArgument},
+		{name: "pinned read-only", staging: true, mode: csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY, readOnly: "true", wantCode: codes.InvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeMounter := mount.NewFakeMounter([]mount.MountPoint{})
+			driver, err := NewDriver(DefaultDriverName, "test-node", "unix:///tmp/test.sock", WithMounter(fakeMounter), WithStaging(tt.staging))
+			if err != nil {
+				t.Fatalf("Failed to create driver: %v", err)
+			}
+
+			dir := t.TempDir()
+			volumeContext := map[string]string{
+				"server":          "192.168.1.1",
+				"share":           "/data",
+				"writableSubPath": "scratch",
+			}
+			if tt.readOnly != "" {
+				volumeContext["readOnly"] = tt.readOnly
+			}
+			_, err = driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:          "test-volume",
+				StagingTargetPath: filepath.Join(dir, "staging"),
+				TargetPath:        filepath.Join(dir, "pod"),
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{},
+					},
+					AccessMode: &csi.VolumeCapability_AccessMode{Mode: tt.mode},
+				},
+				VolumeContext: volumeContext,
+			})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("Expected %v, got %v", tt.wantCode, err)
+			}
+			if log := fakeMounter.GetLog(); len(log) != 0 {
+				t.Errorf("Expected no mount calls, got %+v", log)
+			}
+		})
+	}
+}
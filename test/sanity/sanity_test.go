@@ -1,10 +1,9 @@
 // Package sanity provides CSI sanity tests for the NFS driver.
 //
-// NOTE: This driver implements static provisioning only.
-// Many CSI sanity tests will fail because they're designed for
-// dynamic provisioning drivers (CreateVolume, DeleteVolume, Snapshots, etc.).
-// These tests are useful for local development and debugging of Identity
-// and Node service implementations.
+// The driver runs with ephemeral provisioning, which tracks created volumes
+// in memory, so the dynamic provisioning tests (CreateVolume, DeleteVolume,
+// ListVolumes) can check idempotency and NotFound handling. Production
+// deployments do not track volumes this way.
 package sanity
 
 import (
@@ -39,6 +38,7 @@ func TestSanity(t *testing.T) {
 		"test-node",
 		"unix://"+endpoint,
 		nfs.WithMounter(fakeMounter),
+		nfs.WithEphemeralProvisioning(true),
 	)
 	if err != nil {
 		t.Fatalf("Failed to create driver: %v", err)